@@ -2,20 +2,44 @@ package giDevice
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"github.com/SonicCloudOrg/sonic-gidevice/pkg/libimobiledevice"
 	"io"
+	"io/fs"
 	"path"
 	"strconv"
+	"sync"
 	"time"
 )
 
-var ErrAfcStatNotExist = errors.New("afc stat: no such file or directory")
+// ErrAfcStatNotExist matches fs.ErrNotExist with errors.Is
+var ErrAfcStatNotExist error = &afcStatError{
+	msg: "afc stat: no such file or directory",
+	err: libimobiledevice.AfcError(libimobiledevice.AfcErrObjectNotFound),
+}
+
+type afcStatError struct {
+	msg string
+	err error
+}
+
+func (e *afcStatError) Error() string { return e.msg }
+
+func (e *afcStatError) Unwrap() error { return e.err }
 
 var _ Afc = (*afc)(nil)
 
+// contextBinder a connection whose calls a context can interrupt, see
+// libimobiledevice.InnerConn.BindContext
+type contextBinder interface {
+	bindContext(ctx context.Context) (release func())
+}
+
+var _ contextBinder = (*afc)(nil)
+
 func newAfc(client *libimobiledevice.AfcClient) *afc {
 	return &afc{client: client}
 }
@@ -24,13 +48,19 @@ type afc struct {
 	client *libimobiledevice.AfcClient
 }
 
+func (c *afc) bindContext(ctx context.Context) (release func()) {
+	return c.client.InnerConn().BindContext(ctx)
+}
+
+func (c *afc) Close() (err error) {
+	c.client.InnerConn().Close()
+	return nil
+}
+
 func (c *afc) DiskInfo() (info *AfcDiskInfo, err error) {
-	if err = c.client.Send(libimobiledevice.AfcOperationGetDeviceInfo, nil, nil); err != nil {
-		return nil, fmt.Errorf("afc send 'DiskInfo': %w", err)
-	}
 	var respMsg *libimobiledevice.AfcMessage
-	if respMsg, err = c.client.Receive(); err != nil {
-		return nil, fmt.Errorf("afc receive 'DiskInfo': %w", err)
+	if respMsg, err = c.client.Exchange(libimobiledevice.AfcOperationGetDeviceInfo, nil, nil); err != nil {
+		return nil, fmt.Errorf("afc 'DiskInfo': %w", err)
 	}
 
 	m := respMsg.Map()
@@ -51,12 +81,9 @@ func (c *afc) DiskInfo() (info *AfcDiskInfo, err error) {
 }
 
 func (c *afc) ReadDir(dirname string) (names []string, err error) {
-	if err = c.client.Send(libimobiledevice.AfcOperationReadDir, toCString(dirname), nil); err != nil {
-		return nil, fmt.Errorf("afc send 'ReadDir': %w", err)
-	}
 	var respMsg *libimobiledevice.AfcMessage
-	if respMsg, err = c.client.Receive(); err != nil {
-		return nil, fmt.Errorf("afc receive 'ReadDir': %w", err)
+	if respMsg, err = c.client.Exchange(libimobiledevice.AfcOperationReadDir, toCString(dirname), nil); err != nil {
+		return nil, fmt.Errorf("afc 'ReadDir': %w", err)
 	}
 	if err = respMsg.Err(); err != nil {
 		return nil, fmt.Errorf("afc 'ReadDir': %w", err)
@@ -67,12 +94,16 @@ func (c *afc) ReadDir(dirname string) (names []string, err error) {
 }
 
 func (c *afc) Stat(filename string) (info *AfcFileInfo, err error) {
-	if err = c.client.Send(libimobiledevice.AfcOperationGetFileInfo, toCString(filename), nil); err != nil {
-		return nil, fmt.Errorf("afc send 'Stat': %w", err)
-	}
 	var respMsg *libimobiledevice.AfcMessage
-	if respMsg, err = c.client.Receive(); err != nil {
-		return nil, fmt.Errorf("afc receive 'Stat': %w", err)
+	if respMsg, err = c.client.Exchange(libimobiledevice.AfcOperationGetFileInfo, toCString(filename), nil); err != nil {
+		return nil, fmt.Errorf("afc 'Stat': %w", err)
+	}
+
+	if err = respMsg.Err(); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrAfcStatNotExist
+		}
+		return nil, fmt.Errorf("afc 'Stat': %w", err)
 	}
 
 	m := respMsg.Map()
@@ -112,12 +143,9 @@ func (c *afc) Open(filename string, mode AfcFileMode) (file *AfcFile, err error)
 	}
 	buf.Write(toCString(filename))
 
-	if err = c.client.Send(libimobiledevice.AfcOperationFileOpen, buf.Bytes(), nil); err != nil {
-		return nil, fmt.Errorf("afc send 'Open': %w", err)
-	}
 	var respMsg *libimobiledevice.AfcMessage
-	if respMsg, err = c.client.Receive(); err != nil {
-		return nil, fmt.Errorf("afc receive 'Open': %w", err)
+	if respMsg, err = c.client.Exchange(libimobiledevice.AfcOperationFileOpen, buf.Bytes(), nil); err != nil {
+		return nil, fmt.Errorf("afc 'Open': %w", err)
 	}
 	if err = respMsg.Err(); err != nil {
 		return nil, fmt.Errorf("afc 'Open': %w", err)
@@ -128,19 +156,18 @@ func (c *afc) Open(filename string, mode AfcFileMode) (file *AfcFile, err error)
 	}
 
 	file = &AfcFile{
-		client: c.client,
-		fd:     respMsg.Uint64(),
+		client:    c.client,
+		fd:        respMsg.Uint64(),
+		appending: mode == AfcFileModeAppend || mode == AfcFileModeRdAppend,
 	}
+	file.offsetKnown = !file.appending
 	return
 }
 
 func (c *afc) Remove(filePath string) (err error) {
-	if err = c.client.Send(libimobiledevice.AfcOperationRemovePath, toCString(filePath), nil); err != nil {
-		return fmt.Errorf("afc send 'Remove': %w", err)
-	}
 	var respMsg *libimobiledevice.AfcMessage
-	if respMsg, err = c.client.Receive(); err != nil {
-		return fmt.Errorf("afc receive 'Remove': %w", err)
+	if respMsg, err = c.client.Exchange(libimobiledevice.AfcOperationRemovePath, toCString(filePath), nil); err != nil {
+		return fmt.Errorf("afc 'Remove': %w", err)
 	}
 	if err = respMsg.Err(); err != nil {
 		return fmt.Errorf("afc 'Remove': %w", err)
@@ -150,12 +177,9 @@ func (c *afc) Remove(filePath string) (err error) {
 }
 
 func (c *afc) Rename(oldPath string, newPath string) (err error) {
-	if err = c.client.Send(libimobiledevice.AfcOperationRenamePath, toCString(oldPath, newPath), nil); err != nil {
-		return fmt.Errorf("afc send 'Rename': %w", err)
-	}
 	var respMsg *libimobiledevice.AfcMessage
-	if respMsg, err = c.client.Receive(); err != nil {
-		return fmt.Errorf("afc receive 'Rename': %w", err)
+	if respMsg, err = c.client.Exchange(libimobiledevice.AfcOperationRenamePath, toCString(oldPath, newPath), nil); err != nil {
+		return fmt.Errorf("afc 'Rename': %w", err)
 	}
 	if err = respMsg.Err(); err != nil {
 		return fmt.Errorf("afc 'Rename': %w", err)
@@ -165,12 +189,9 @@ func (c *afc) Rename(oldPath string, newPath string) (err error) {
 }
 
 func (c *afc) Mkdir(path string) (err error) {
-	if err = c.client.Send(libimobiledevice.AfcOperationMakeDir, toCString(path), nil); err != nil {
-		return fmt.Errorf("afc send 'Mkdir': %w", err)
-	}
 	var respMsg *libimobiledevice.AfcMessage
-	if respMsg, err = c.client.Receive(); err != nil {
-		return fmt.Errorf("afc receive 'Mkdir': %w", err)
+	if respMsg, err = c.client.Exchange(libimobiledevice.AfcOperationMakeDir, toCString(path), nil); err != nil {
+		return fmt.Errorf("afc 'Mkdir': %w", err)
 	}
 	if err = respMsg.Err(); err != nil {
 		return fmt.Errorf("afc 'Mkdir': %w", err)
@@ -184,12 +205,9 @@ func (c *afc) Link(oldName string, newName string, linkType AfcLinkType) (err er
 	_ = binary.Write(buf, binary.LittleEndian, uint64(linkType))
 	buf.Write(toCString(oldName, newName))
 
-	if err = c.client.Send(libimobiledevice.AfcOperationMakeLink, buf.Bytes(), nil); err != nil {
-		return fmt.Errorf("afc send 'Link': %w", err)
-	}
 	var respMsg *libimobiledevice.AfcMessage
-	if respMsg, err = c.client.Receive(); err != nil {
-		return fmt.Errorf("afc receive 'Link': %w", err)
+	if respMsg, err = c.client.Exchange(libimobiledevice.AfcOperationMakeLink, buf.Bytes(), nil); err != nil {
+		return fmt.Errorf("afc 'Link': %w", err)
 	}
 	if err = respMsg.Err(); err != nil {
 		return fmt.Errorf("afc 'Link': %w", err)
@@ -203,12 +221,9 @@ func (c *afc) Truncate(filePath string, size int64) (err error) {
 	_ = binary.Write(buf, binary.LittleEndian, uint64(size))
 	buf.Write(toCString(filePath))
 
-	if err = c.client.Send(libimobiledevice.AfcOperationTruncateFile, buf.Bytes(), nil); err != nil {
-		return fmt.Errorf("afc send 'Truncate': %w", err)
-	}
 	var respMsg *libimobiledevice.AfcMessage
-	if respMsg, err = c.client.Receive(); err != nil {
-		return fmt.Errorf("afc receive 'Truncate': %w", err)
+	if respMsg, err = c.client.Exchange(libimobiledevice.AfcOperationTruncateFile, buf.Bytes(), nil); err != nil {
+		return fmt.Errorf("afc 'Truncate': %w", err)
 	}
 	if err = respMsg.Err(); err != nil {
 		return fmt.Errorf("afc 'Truncate': %w", err)
@@ -219,15 +234,12 @@ func (c *afc) Truncate(filePath string, size int64) (err error) {
 
 func (c *afc) SetFileModTime(filePath string, modTime time.Time) (err error) {
 	buf := new(bytes.Buffer)
-	_ = binary.Write(buf, binary.LittleEndian, uint64(modTime.Unix()))
+	_ = binary.Write(buf, binary.LittleEndian, uint64(modTime.UnixNano()))
 	buf.Write(toCString(filePath))
 
-	if err = c.client.Send(libimobiledevice.AfcOperationSetFileModTime, buf.Bytes(), nil); err != nil {
-		return fmt.Errorf("afc send 'SetFileModTime': %w", err)
-	}
 	var respMsg *libimobiledevice.AfcMessage
-	if respMsg, err = c.client.Receive(); err != nil {
-		return fmt.Errorf("afc receive 'SetFileModTime': %w", err)
+	if respMsg, err = c.client.Exchange(libimobiledevice.AfcOperationSetFileModTime, buf.Bytes(), nil); err != nil {
+		return fmt.Errorf("afc 'SetFileModTime': %w", err)
 	}
 	if err = respMsg.Err(); err != nil {
 		return fmt.Errorf("afc 'SetFileModTime': %w", err)
@@ -238,12 +250,9 @@ func (c *afc) SetFileModTime(filePath string, modTime time.Time) (err error) {
 
 func (c *afc) Hash(filePath string) ([]byte, error) {
 	var err error
-	if err = c.client.Send(libimobiledevice.AfcOperationGetFileHash, toCString(filePath), nil); err != nil {
-		return nil, fmt.Errorf("afc send 'Hash': %w", err)
-	}
 	var respMsg *libimobiledevice.AfcMessage
-	if respMsg, err = c.client.Receive(); err != nil {
-		return nil, fmt.Errorf("afc receive 'Hash': %w", err)
+	if respMsg, err = c.client.Exchange(libimobiledevice.AfcOperationGetFileHash, toCString(filePath), nil); err != nil {
+		return nil, fmt.Errorf("afc 'Hash': %w", err)
 	}
 	if err = respMsg.Err(); err != nil {
 		return nil, fmt.Errorf("afc 'Hash': %w", err)
@@ -259,12 +268,9 @@ func (c *afc) HashWithRange(filePath string, start, end uint64) ([]byte, error)
 	buf.Write(toCString(filePath))
 
 	var err error
-	if err = c.client.Send(libimobiledevice.AfcOperationGetFileHashRange, buf.Bytes(), nil); err != nil {
-		return nil, fmt.Errorf("afc send 'HashWithRange': %w", err)
-	}
 	var respMsg *libimobiledevice.AfcMessage
-	if respMsg, err = c.client.Receive(); err != nil {
-		return nil, fmt.Errorf("afc receive 'HashWithRange': %w", err)
+	if respMsg, err = c.client.Exchange(libimobiledevice.AfcOperationGetFileHashRange, buf.Bytes(), nil); err != nil {
+		return nil, fmt.Errorf("afc 'HashWithRange': %w", err)
 	}
 	if err = respMsg.Err(); err != nil {
 		return nil, fmt.Errorf("afc 'HashWithRange': %w", err)
@@ -275,12 +281,9 @@ func (c *afc) HashWithRange(filePath string, start, end uint64) ([]byte, error)
 
 // RemoveAll since iOS6+
 func (c *afc) RemoveAll(path string) (err error) {
-	if err = c.client.Send(libimobiledevice.AfcOperationRemovePathAndContents, toCString(path), nil); err != nil {
-		return fmt.Errorf("afc send 'RemoveAll': %w", err)
-	}
 	var respMsg *libimobiledevice.AfcMessage
-	if respMsg, err = c.client.Receive(); err != nil {
-		return fmt.Errorf("afc receive 'RemoveAll': %w", err)
+	if respMsg, err = c.client.Exchange(libimobiledevice.AfcOperationRemovePathAndContents, toCString(path), nil); err != nil {
+		return fmt.Errorf("afc 'RemoveAll': %w", err)
 	}
 	if err = respMsg.Err(); err != nil {
 		return fmt.Errorf("afc 'RemoveAll': %w", err)
@@ -341,9 +344,27 @@ func (f *AfcFileInfo) Size() int64 {
 	return int64(f.size)
 }
 
-// func (f *AfcFileInfo) Mode() os.FileMode {
-// 	return os.ModeType
-// }
+// Mode the file type from st_ifmt, AFC does not report permission bits
+func (f *AfcFileInfo) Mode() fs.FileMode {
+	switch f.ifmt {
+	case "S_IFDIR":
+		return fs.ModeDir
+	case "S_IFLNK":
+		return fs.ModeSymlink
+	case "S_IFCHR":
+		return fs.ModeDevice | fs.ModeCharDevice
+	case "S_IFBLK":
+		return fs.ModeDevice
+	case "S_IFIFO":
+		return fs.ModeNamedPipe
+	case "S_IFSOCK":
+		return fs.ModeSocket
+	case "S_IFREG":
+		return 0
+	default:
+		return fs.ModeIrregular
+	}
+}
 
 func (f *AfcFileInfo) ModTime() time.Time {
 	return time.Unix(0, int64(f.modTime))
@@ -353,9 +374,25 @@ func (f *AfcFileInfo) IsDir() bool {
 	return f.ifmt == "S_IFDIR"
 }
 
-// func (f *AfcFileInfo) Sys() interface{} {
-// 	return f.source
-// }
+// Sys the raw st_* values, a map[string]string
+func (f *AfcFileInfo) Sys() interface{} {
+	return f.source
+}
+
+// Type the type bits of Mode, for fs.DirEntry
+func (f *AfcFileInfo) Type() fs.FileMode {
+	return f.Mode().Type()
+}
+
+// Info itself, for fs.DirEntry
+func (f *AfcFileInfo) Info() (fs.FileInfo, error) {
+	return f, nil
+}
+
+// LinkTarget the target of a symlink, empty for anything else
+func (f *AfcFileInfo) LinkTarget() string {
+	return f.source["st_link_target"]
+}
 
 func (f *AfcFileInfo) CreationTime() time.Time {
 	return time.Unix(0, int64(f.creationTime))
@@ -396,10 +433,23 @@ const (
 	AfcLockTypeUnlock        AfcLockType = 8 | 4
 )
 
+// afcFileChunkSize the bytes a Read asks the device for at least, the rest kept for
+// the next Reads, and the bytes WriteTo and ReadFrom move per request
+const afcFileChunkSize = 1 << 20
+
+// AfcFile a file opened on the device, safe for use from several goroutines
 type AfcFile struct {
 	client *libimobiledevice.AfcClient
 	fd     uint64
-	reader *bytes.Reader
+	// appending the device writes at the end whatever the offset
+	appending bool
+
+	mu sync.Mutex
+	// ahead the bytes read from the device past where Read is at
+	ahead []byte
+	// offset where the device is at in the file, while offsetKnown
+	offset      int64
+	offsetKnown bool
 }
 
 func (f *AfcFile) op(o ...uint64) []byte {
@@ -418,12 +468,12 @@ func (f *AfcFile) op(o ...uint64) []byte {
 }
 
 func (f *AfcFile) Lock(lockType AfcLockType) (err error) {
-	if err = f.client.Send(libimobiledevice.AfcOperationFileRefLock, f.op(uint64(lockType)), nil); err != nil {
-		return fmt.Errorf("afc file send 'Lock': %w", err)
-	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
 	var respMsg *libimobiledevice.AfcMessage
-	if respMsg, err = f.client.Receive(); err != nil {
-		return fmt.Errorf("afc file receive 'Lock': %w", err)
+	if respMsg, err = f.client.Exchange(libimobiledevice.AfcOperationFileRefLock, f.op(uint64(lockType)), nil); err != nil {
+		return fmt.Errorf("afc file 'Lock': %w", err)
 	}
 	if err = respMsg.Err(); err != nil {
 		return fmt.Errorf("afc file 'Lock': %w", err)
@@ -435,111 +485,290 @@ func (f *AfcFile) Unlock() (err error) {
 	return f.Lock(AfcLockTypeUnlock)
 }
 
+// Read reads ahead afcFileChunkSize bytes at least, for the next Reads not to go to the device
 func (f *AfcFile) Read(b []byte) (n int, err error) {
-	if err = f.client.Send(libimobiledevice.AfcOperationFileRead, f.op(uint64(len(b))), nil); err != nil {
-		return -1, fmt.Errorf("afc file send 'Read': %w", err)
-	}
-	var respMsg *libimobiledevice.AfcMessage
-	if respMsg, err = f.client.Receive(); err != nil {
-		return -1, fmt.Errorf("afc file receive 'Read': %w", err)
-	}
-	if err = respMsg.Err(); err != nil {
-		return -1, fmt.Errorf("afc file 'Read': %w", err)
-	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(b) == 0 {
+		return 0, nil
+	}
+	if len(f.ahead) == 0 {
+		size := len(b)
+		if size < afcFileChunkSize {
+			size = afcFileChunkSize
+		}
+		var data []byte
+		if data, err = f.read(size); err != nil {
+			return 0, err
+		}
+		if len(data) == 0 {
+			return 0, io.EOF
+		}
+		f.ahead = data
+	}
+	n = copy(b, f.ahead)
+	f.ahead = f.ahead[n:]
+	return n, nil
+}
+
+// ReadAt reads at off without moving where Read and Write are at
+func (f *AfcFile) ReadAt(b []byte, off int64) (n int, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	err = f.at(off, func() (err error) {
+		for n < len(b) {
+			var data []byte
+			if data, err = f.read(len(b) - n); err != nil {
+				return err
+			}
+			if len(data) == 0 {
+				return io.EOF
+			}
+			n += copy(b[n:], data)
+		}
+		return nil
+	})
+	return
+}
 
-	if respMsg.Payload == nil {
-		return 0, io.EOF
+func (f *AfcFile) Write(b []byte) (n int, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err = f.unread(); err != nil {
+		return 0, err
+	}
+	if err = f.write(b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// WriteAt writes at off without moving where Read and Write are at, but for a
+// file opened to append which writes at the end
+func (f *AfcFile) WriteAt(b []byte, off int64) (n int, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.appending {
+		return 0, errors.New("afc file 'WriteAt': opened to append")
+	}
+	// what was read ahead may be about to change
+	if err = f.unread(); err != nil {
+		return 0, err
+	}
+	if err = f.at(off, func() error {
+		return f.write(b)
+	}); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// WriteTo writes the rest of the file to w, afcFileChunkSize bytes per request
+func (f *AfcFile) WriteTo(w io.Writer) (n int64, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for {
+		if len(f.ahead) == 0 {
+			var data []byte
+			if data, err = f.read(afcFileChunkSize); err != nil {
+				return n, err
+			}
+			if len(data) == 0 {
+				return n, nil
+			}
+			f.ahead = data
+		}
+		var written int
+		written, err = w.Write(f.ahead)
+		n += int64(written)
+		f.ahead = f.ahead[written:]
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
+// ReadFrom writes what r has to the file, afcFileChunkSize bytes per request
+func (f *AfcFile) ReadFrom(r io.Reader) (n int64, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err = f.unread(); err != nil {
+		return 0, err
+	}
+	buf := make([]byte, afcFileChunkSize)
+	for {
+		var read int
+		read, err = io.ReadFull(r, buf)
+		if read > 0 {
+			if writeErr := f.write(buf[:read]); writeErr != nil {
+				return n, writeErr
+			}
+			n += int64(read)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
 	}
+}
 
-	if f.reader == nil {
-		f.reader = bytes.NewReader(respMsg.Payload)
-	} else {
-		f.reader.Reset(respMsg.Payload)
+func (f *AfcFile) Tell() (n uint64, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var offset int64
+	if offset, err = f.tell(); err != nil {
+		return 0, err
 	}
+	return uint64(offset - int64(len(f.ahead))), nil
+}
+
+func (f *AfcFile) Seek(offset int64, whence int) (ret int64, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 
-	return f.reader.Read(b)
+	if whence == io.SeekCurrent {
+		// from where Read is at, not the device
+		offset -= int64(len(f.ahead))
+	}
+	f.ahead = nil
+	if ret, err = f.seek(offset, whence); err != nil {
+		return -1, err
+	}
+	return
 }
 
-func (f *AfcFile) Write(b []byte) (n int, err error) {
-	if err = f.client.Send(libimobiledevice.AfcOperationFileWrite, f.op(), b); err != nil {
-		return -1, fmt.Errorf("afc file send 'Write': %w", err)
+func (f *AfcFile) Truncate(size int64) (err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err = f.unread(); err != nil {
+		return err
 	}
 	var respMsg *libimobiledevice.AfcMessage
-	if respMsg, err = f.client.Receive(); err != nil {
-		return -1, fmt.Errorf("afc file receive 'Write': %w", err)
+	if respMsg, err = f.client.Exchange(libimobiledevice.AfcOperationFileSetSize, f.op(uint64(size)), nil); err != nil {
+		return fmt.Errorf("afc file 'Truncate': %w", err)
 	}
 	if err = respMsg.Err(); err != nil {
-		return -1, fmt.Errorf("afc file 'Write': %w", err)
+		return fmt.Errorf("afc file 'Truncate': %w", err)
 	}
 
-	n = len(b)
 	return
 }
 
-func (f *AfcFile) Tell() (n uint64, err error) {
-	if err = f.client.Send(libimobiledevice.AfcOperationFileTell, f.op(), nil); err != nil {
-		return 0, fmt.Errorf("afc file 'Tell': %w", err)
+func (f *AfcFile) Close() (err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.ahead = nil
+	if _, err = f.client.Exchange(libimobiledevice.AfcOperationFileClose, f.op(), nil); err != nil {
+		return fmt.Errorf("afc file 'Close': %w", err)
 	}
+
+	return
+}
+
+// read asks the device for up to size bytes, none at the end of the file
+func (f *AfcFile) read(size int) (data []byte, err error) {
 	var respMsg *libimobiledevice.AfcMessage
-	if respMsg, err = f.client.Receive(); err != nil {
-		return 0, fmt.Errorf("afc file receive 'Tell': %w", err)
+	if respMsg, err = f.client.Exchange(libimobiledevice.AfcOperationFileRead, f.op(uint64(size)), nil); err != nil {
+		return nil, fmt.Errorf("afc file 'Read': %w", err)
 	}
 	if err = respMsg.Err(); err != nil {
-		return 0, fmt.Errorf("afc file 'Tell': %w", err)
+		return nil, fmt.Errorf("afc file 'Read': %w", err)
 	}
+	f.offset += int64(len(respMsg.Payload))
+	return respMsg.Payload, nil
+}
 
-	if respMsg.Operation != libimobiledevice.AfcOperationFileTellResult {
-		return 0, fmt.Errorf("afc operation mistake 'Tell': '%d'", respMsg.Operation)
+func (f *AfcFile) write(b []byte) (err error) {
+	var respMsg *libimobiledevice.AfcMessage
+	if respMsg, err = f.client.Exchange(libimobiledevice.AfcOperationFileWrite, f.op(), b); err != nil {
+		return fmt.Errorf("afc file 'Write': %w", err)
 	}
-
-	n = respMsg.Uint64()
-	return
+	if err = respMsg.Err(); err != nil {
+		return fmt.Errorf("afc file 'Write': %w", err)
+	}
+	f.offset += int64(len(b))
+	if f.appending {
+		f.offsetKnown = false
+	}
+	return nil
 }
 
-func (f *AfcFile) Seek(offset int64, whence int) (ret int64, err error) {
-	if err = f.client.Send(libimobiledevice.AfcOperationFileSeek, f.op(uint64(whence), uint64(offset)), nil); err != nil {
-		return -1, fmt.Errorf("afc file 'Seek': %w", err)
+// tell where the device is at, asking it only when not known
+func (f *AfcFile) tell() (offset int64, err error) {
+	if f.offsetKnown {
+		return f.offset, nil
 	}
 	var respMsg *libimobiledevice.AfcMessage
-	if respMsg, err = f.client.Receive(); err != nil {
-		return -1, fmt.Errorf("afc file receive 'Seek': %w", err)
+	if respMsg, err = f.client.Exchange(libimobiledevice.AfcOperationFileTell, f.op(), nil); err != nil {
+		return 0, fmt.Errorf("afc file 'Tell': %w", err)
 	}
 	if err = respMsg.Err(); err != nil {
-		return -1, fmt.Errorf("afc file 'Seek': %w", err)
+		return 0, fmt.Errorf("afc file 'Tell': %w", err)
 	}
 
-	var tell uint64
-	if tell, err = f.Tell(); err != nil {
-		return -1, err
+	if respMsg.Operation != libimobiledevice.AfcOperationFileTellResult {
+		return 0, fmt.Errorf("afc operation mistake 'Tell': '%d'", respMsg.Operation)
 	}
 
-	ret = int64(tell)
-	return
+	f.offset, f.offsetKnown = int64(respMsg.Uint64()), true
+	return f.offset, nil
 }
 
-func (f *AfcFile) Truncate(size int64) (err error) {
-	if err = f.client.Send(libimobiledevice.AfcOperationFileSetSize, f.op(uint64(size)), nil); err != nil {
-		return fmt.Errorf("afc file 'Truncate': %w", err)
-	}
+func (f *AfcFile) seek(offset int64, whence int) (ret int64, err error) {
 	var respMsg *libimobiledevice.AfcMessage
-	if respMsg, err = f.client.Receive(); err != nil {
-		return fmt.Errorf("afc file receive 'Truncate': %w", err)
+	if respMsg, err = f.client.Exchange(libimobiledevice.AfcOperationFileSeek, f.op(uint64(whence), uint64(offset)), nil); err != nil {
+		return -1, fmt.Errorf("afc file 'Seek': %w", err)
 	}
 	if err = respMsg.Err(); err != nil {
-		return fmt.Errorf("afc file 'Truncate': %w", err)
+		return -1, fmt.Errorf("afc file 'Seek': %w", err)
 	}
+	switch {
+	case whence == io.SeekStart:
+		f.offset, f.offsetKnown = offset, true
+		return offset, nil
+	case whence == io.SeekCurrent && f.offsetKnown:
+		f.offset += offset
+		return f.offset, nil
+	}
+	f.offsetKnown = false
+	return f.tell()
+}
 
+// unread moves the device back to where Read is at, dropping what was read ahead
+func (f *AfcFile) unread() (err error) {
+	if len(f.ahead) == 0 {
+		return nil
+	}
+	back := int64(len(f.ahead))
+	f.ahead = nil
+	_, err = f.seek(-back, io.SeekCurrent)
 	return
 }
 
-func (f *AfcFile) Close() (err error) {
-	if err = f.client.Send(libimobiledevice.AfcOperationFileClose, f.op(), nil); err != nil {
-		return fmt.Errorf("afc file 'Close': %w", err)
+// at runs fn with the device at off, then moves it back to where it was
+func (f *AfcFile) at(off int64, fn func() error) (err error) {
+	var offset int64
+	if offset, err = f.tell(); err != nil {
+		return err
 	}
-	if _, err = f.client.Receive(); err != nil {
-		return fmt.Errorf("afc file receive 'Close': %w", err)
+	if _, err = f.seek(off, io.SeekStart); err != nil {
+		return err
+	}
+	err = fn()
+	if _, seekErr := f.seek(offset, io.SeekStart); err == nil {
+		err = seekErr
 	}
-
 	return
 }
 
@@ -1,11 +1,19 @@
 package giDevice
 
 import (
+	"bytes"
+	"crypto/rand"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/idevicetest"
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/libimobiledevice"
 )
 
 var afcSrv Afc
@@ -98,3 +106,135 @@ func Test_afc_Open(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func Test_afcFile_simulated(t *testing.T) {
+	setupSimulatedDevice(t)
+	root := t.TempDir()
+	simDev.Handle(libimobiledevice.AfcServiceName, idevicetest.AfcHandler(root))
+
+	data := make([]byte, 3*afcFileChunkSize+321)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	afc, err := dev.AfcService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = afc.WriteFile("data.bin", data, AfcFileModeWr); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := afc.Open("data.bin", AfcFileModeRdOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	// Read, Seek and Tell from where Read is at, whatever was read ahead
+	b := make([]byte, 100)
+	if _, err = io.ReadFull(file, b); err != nil || !bytes.Equal(b, data[:100]) {
+		t.Fatalf("read: %v", err)
+	}
+	if pos, err := file.Tell(); err != nil || pos != 100 {
+		t.Fatalf("tell: %d %v", pos, err)
+	}
+	if pos, err := file.Seek(50, io.SeekCurrent); err != nil || pos != 150 {
+		t.Fatalf("seek: %d %v", pos, err)
+	}
+	if _, err = io.ReadFull(file, b); err != nil || !bytes.Equal(b, data[150:250]) {
+		t.Fatalf("read after seek: %v", err)
+	}
+
+	// ReadAt at once from several goroutines, leaving Read where it was
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			off := int64(i) * int64(len(data)/8)
+			at := make([]byte, 4096)
+			if n, err := file.ReadAt(at, off); err != nil || !bytes.Equal(at[:n], data[off:off+4096]) {
+				errs <- fmt.Errorf("read at %d: %d %v", off, n, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	at := make([]byte, 1000)
+	if n, err := file.ReadAt(at, int64(len(data)-10)); err != io.EOF || n != 10 || !bytes.Equal(at[:n], data[len(data)-10:]) {
+		t.Fatalf("read at the end: %d %v", n, err)
+	}
+	if _, err = io.ReadFull(file, b); err != nil || !bytes.Equal(b, data[250:350]) {
+		t.Fatalf("read after read at: %v", err)
+	}
+
+	// io.Copy goes through WriteTo
+	var rest bytes.Buffer
+	if n, err := io.Copy(&rest, file); err != nil || n != int64(len(data)-350) || !bytes.Equal(rest.Bytes(), data[350:]) {
+		t.Fatalf("write to: %d %v", n, err)
+	}
+
+	// and ReadFrom
+	out, err := afc.Open("out.bin", AfcFileModeWr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Read only, for io.Copy not to use bytes.Reader.WriteTo instead
+	if n, err := io.Copy(out, struct{ io.Reader }{bytes.NewReader(data)}); err != nil || n != int64(len(data)) {
+		t.Fatalf("read from: %d %v", n, err)
+	}
+	if info, err := os.Stat(filepath.Join(root, "out.bin")); err != nil || info.Size() != int64(len(data)) {
+		t.Fatalf("read from: %v %v", info, err)
+	}
+	if _, err = out.WriteAt([]byte("patched"), 10); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = out.Write([]byte("tail")); err != nil {
+		t.Fatal(err)
+	}
+	if err = out.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := append(append([]byte(nil), data...), "tail"...)
+	copy(want[10:], "patched")
+	if got, err := os.ReadFile(filepath.Join(root, "out.bin")); err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("written: %d bytes %v", len(got), err)
+	}
+}
+
+func Test_afc_simulated_SetFileModTime(t *testing.T) {
+	setupSimulatedDevice(t)
+	root := t.TempDir()
+	simDev.Handle(libimobiledevice.AfcServiceName, idevicetest.AfcHandler(root))
+	if err := os.WriteFile(filepath.Join(root, "stamped"), []byte("stamped"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	afc, err := dev.AfcService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// st_mtime is in nanoseconds, as GetFileInfo reports it back
+	mtime := time.Date(2024, 5, 1, 12, 0, 0, 123456789, time.UTC)
+	if err = afc.SetFileModTime("stamped", mtime); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := os.Stat(filepath.Join(root, "stamped"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stored.ModTime().Equal(mtime) {
+		t.Fatalf("stored: %v", stored.ModTime())
+	}
+	info, err := afc.Stat("stamped")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(mtime) {
+		t.Fatalf("stat: %v", info.ModTime())
+	}
+}
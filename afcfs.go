@@ -0,0 +1,199 @@
+package giDevice
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+)
+
+var _ AfcFS = (*afcFS)(nil)
+
+// NewAfcFS the storage behind afc as an io/fs file system, names being slash
+// separated paths from the afc root. Symlinks are reported, not followed, as AFC does
+func NewAfcFS(afc Afc) AfcFS {
+	return &afcFS{afc: afc}
+}
+
+// Walk walks the tree at root on the device with fs.WalkDir, root being
+// a path as NewAfcFS takes it, `.` for the whole of afc
+func Walk(afc Afc, root string, fn fs.WalkDirFunc) error {
+	return fs.WalkDir(NewAfcFS(afc), root, fn)
+}
+
+type afcFS struct {
+	afc Afc
+}
+
+// devicePath maps an io/fs name onto the afc root
+func devicePath(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return "/", nil
+	}
+	return "/" + name, nil
+}
+
+func (a *afcFS) stat(op, name string) (info *AfcFileInfo, err error) {
+	var devPath string
+	if devPath, err = devicePath(op, name); err != nil {
+		return nil, err
+	}
+	if info, err = a.afc.Stat(devPath); err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	info.name = path.Base(name)
+	return info, nil
+}
+
+func (a *afcFS) Open(name string) (fs.File, error) {
+	info, err := a.stat("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return &afcDir{fs: a, name: name, info: info}, nil
+	}
+
+	devPath, _ := devicePath("open", name)
+	var afcFile *AfcFile
+	if afcFile, err = a.afc.Open(devPath, AfcFileModeRdOnly); err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &afcFSFile{file: afcFile, name: name, info: info}, nil
+}
+
+func (a *afcFS) Stat(name string) (fs.FileInfo, error) {
+	info, err := a.stat("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (a *afcFS) ReadDir(name string) (entries []fs.DirEntry, err error) {
+	var devPath string
+	if devPath, err = devicePath("readdir", name); err != nil {
+		return nil, err
+	}
+	var names []string
+	if names, err = a.afc.ReadDir(devPath); err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+
+	entries = make([]fs.DirEntry, 0, len(names))
+	for _, n := range names {
+		if n == "." || n == ".." {
+			continue
+		}
+		var info *AfcFileInfo
+		if info, err = a.stat("readdir", path.Join(name, n)); err != nil {
+			// removed since it was listed
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		entries = append(entries, info)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (a *afcFS) ReadFile(name string) (data []byte, err error) {
+	var file fs.File
+	if file, err = a.Open(name); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	return io.ReadAll(file)
+}
+
+// afcFSFile a regular file opened read only, an io.ReadSeeker as http.FileServer expects
+// and an io.ReaderAt
+type afcFSFile struct {
+	file *AfcFile
+	name string
+	info *AfcFileInfo
+}
+
+func (f *afcFSFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *afcFSFile) Read(b []byte) (n int, err error) {
+	if n, err = f.file.Read(b); err != nil && err != io.EOF {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: err}
+	}
+	return
+}
+
+func (f *afcFSFile) ReadAt(b []byte, off int64) (n int, err error) {
+	if n, err = f.file.ReadAt(b, off); err != nil && err != io.EOF {
+		return n, &fs.PathError{Op: "read", Path: f.name, Err: err}
+	}
+	return
+}
+
+func (f *afcFSFile) Seek(offset int64, whence int) (ret int64, err error) {
+	if ret, err = f.file.Seek(offset, whence); err != nil {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: err}
+	}
+	return
+}
+
+func (f *afcFSFile) Close() (err error) {
+	if err = f.file.Close(); err != nil {
+		return &fs.PathError{Op: "close", Path: f.name, Err: err}
+	}
+	return nil
+}
+
+// afcDir a directory, listed on the first call to ReadDir
+type afcDir struct {
+	fs      *afcFS
+	name    string
+	info    *AfcFileInfo
+	entries []fs.DirEntry
+	listed  bool
+}
+
+func (d *afcDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *afcDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+func (d *afcDir) ReadDir(n int) (entries []fs.DirEntry, err error) {
+	if !d.listed {
+		if d.entries, err = d.fs.ReadDir(d.name); err != nil {
+			return nil, err
+		}
+		d.listed = true
+	}
+	if n <= 0 {
+		entries, d.entries = d.entries, nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	entries, d.entries = d.entries[:n], d.entries[n:]
+	return entries, nil
+}
+
+func (d *afcDir) Close() error {
+	return nil
+}
@@ -0,0 +1,81 @@
+package giDevice
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/idevicetest"
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/libimobiledevice"
+)
+
+func Test_afcFS_simulated(t *testing.T) {
+	setupSimulatedDevice(t)
+	root := t.TempDir()
+	simDev.Handle(libimobiledevice.AfcServiceName, idevicetest.AfcHandler(root))
+
+	for name, data := range map[string]string{
+		"Downloads/hello.txt":          "hello",
+		"Downloads/empty":              "",
+		"DCIM/100APPLE/IMG_0001.HEIC":  "heic",
+		"Library/Logs/CrashReporter/a": "crash",
+	} {
+		hostPath := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(hostPath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(hostPath, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	afc, err := dev.AfcService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys := NewAfcFS(afc)
+	if err = fstest.TestFS(fsys, "Downloads/hello.txt", "Downloads/empty", "DCIM/100APPLE/IMG_0001.HEIC"); err != nil {
+		t.Fatal(err)
+	}
+
+	matches, err := fs.Glob(fsys, "*/*/IMG_*")
+	if err != nil || len(matches) != 1 || matches[0] != "DCIM/100APPLE/IMG_0001.HEIC" {
+		t.Fatalf("glob: %v %v", matches, err)
+	}
+	if _, err = fs.Stat(fsys, "Downloads/missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected fs.ErrNotExist, got %v", err)
+	}
+	if _, err = fsys.Open("/Downloads"); !errors.Is(err, fs.ErrInvalid) {
+		t.Fatalf("expected fs.ErrInvalid, got %v", err)
+	}
+
+	if err = os.Symlink("hello.txt", filepath.Join(root, "Downloads", "link")); err != nil {
+		t.Fatal(err)
+	}
+	info, err := afc.Stat("Downloads/link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&fs.ModeSymlink == 0 || info.LinkTarget() != "hello.txt" {
+		t.Fatalf("symlink: %v %q", info.Mode(), info.LinkTarget())
+	}
+
+	var walked []string
+	if err = Walk(afc, "Library", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() {
+			walked = append(walked, name)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(walked) != 1 || walked[0] != "Library/Logs/CrashReporter/a" {
+		t.Fatalf("walked: %v", walked)
+	}
+}
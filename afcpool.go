@@ -0,0 +1,294 @@
+package giDevice
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// ErrAfcPoolClosed the pool was closed with AfcPool.Close
+var ErrAfcPoolClosed = errors.New("afc pool closed")
+
+type AfcCopyDirection int
+
+const (
+	// AfcCopyToHost from a directory on the device to one on the host
+	AfcCopyToHost AfcCopyDirection = iota
+	// AfcCopyToDevice from a directory on the host to one on the device
+	AfcCopyToDevice
+)
+
+// AfcBatchError the paths a batch call failed on, along with why. It matches
+// with errors.Is whatever one of them does
+type AfcBatchError struct {
+	Errs map[string]error
+}
+
+func (e *AfcBatchError) Error() string {
+	names := make([]string, 0, len(e.Errs))
+	for name := range e.Errs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	msg := fmt.Sprintf("afc: %d failed", len(names))
+	for i, name := range names {
+		if i == 3 {
+			msg += fmt.Sprintf("; and %d more", len(names)-i)
+			break
+		}
+		msg += fmt.Sprintf("; %s: %s", name, e.Errs[name])
+	}
+	return msg
+}
+
+func (e *AfcBatchError) Is(target error) bool {
+	for _, err := range e.Errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+var _ AfcPool = (*afcPool)(nil)
+
+// NewAfcPool opens n connections with open, for the batch calls to run up to n
+// requests at once. open may be Device.StartAfc, or start a house_arrest service
+// and vend Documents or Container
+func NewAfcPool(n int, open func() (Afc, error)) (pool AfcPool, err error) {
+	if n < 1 {
+		n = 1
+	}
+	p := newAfcPool(n)
+	for i := 0; i < n; i++ {
+		var afc Afc
+		if afc, err = open(); err != nil {
+			_ = p.Close()
+			return nil, fmt.Errorf("afc pool: %w", err)
+		}
+		p.add(afc, true)
+	}
+	return p, nil
+}
+
+func newAfcPool(size int) *afcPool {
+	return &afcPool{free: make(chan Afc, size)}
+}
+
+type afcPool struct {
+	// free the connections no call is using
+	free chan Afc
+
+	mu     sync.Mutex
+	closed bool
+	// owned the connections to close along with the pool
+	owned []Afc
+	// running the calls Close waits for
+	running sync.WaitGroup
+}
+
+// add puts afc in the pool, up to the size it was made with, closed along with it if owned
+func (p *afcPool) add(afc Afc, owned bool) {
+	if owned {
+		p.mu.Lock()
+		p.owned = append(p.owned, afc)
+		p.mu.Unlock()
+	}
+	p.free <- afc
+}
+
+// begin counts a call running until p.running.Done, ErrAfcPoolClosed once the pool is closed
+func (p *afcPool) begin() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return ErrAfcPoolClosed
+	}
+	p.running.Add(1)
+	return nil
+}
+
+func (p *afcPool) Do(fn func(afc Afc) error) (err error) {
+	if err = p.begin(); err != nil {
+		return err
+	}
+	defer p.running.Done()
+
+	return p.do(fn)
+}
+
+// do calls fn with a free connection
+func (p *afcPool) do(fn func(afc Afc) error) error {
+	afc := <-p.free
+	defer func() { p.free <- afc }()
+	return fn(afc)
+}
+
+// each calls fn for each of names, as many at once as there are connections,
+// and gathers the errors into an *AfcBatchError
+func (p *afcPool) each(names []string, fn func(afc Afc, name string) error) (err error) {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs = make(map[string]error)
+	)
+	for _, name := range names {
+		afc := <-p.free
+		wg.Add(1)
+		go func(afc Afc, name string) {
+			defer wg.Done()
+			defer func() { p.free <- afc }()
+			if err := fn(afc, name); err != nil {
+				mu.Lock()
+				errs[name] = err
+				mu.Unlock()
+			}
+		}(afc, name)
+	}
+	wg.Wait()
+	if len(errs) != 0 {
+		return &AfcBatchError{Errs: errs}
+	}
+	return nil
+}
+
+func (p *afcPool) StatMany(names []string) (infos []*AfcFileInfo, err error) {
+	if err = p.begin(); err != nil {
+		return nil, err
+	}
+	defer p.running.Done()
+
+	index := make(map[string][]int, len(names))
+	for i, name := range names {
+		index[name] = append(index[name], i)
+	}
+	unique := make([]string, 0, len(index))
+	for name := range index {
+		unique = append(unique, name)
+	}
+	infos = make([]*AfcFileInfo, len(names))
+	err = p.each(unique, func(afc Afc, name string) error {
+		info, err := afc.Stat(name)
+		if err != nil {
+			return err
+		}
+		for _, i := range index[name] {
+			infos[i] = info
+		}
+		return nil
+	})
+	return
+}
+
+func (p *afcPool) RemoveMany(names []string) (err error) {
+	if err = p.begin(); err != nil {
+		return err
+	}
+	defer p.running.Done()
+
+	return p.each(names, func(afc Afc, name string) error {
+		return afc.RemoveAll(name)
+	})
+}
+
+func (p *afcPool) CopyTree(src, dst string, direction AfcCopyDirection, opts ...AfcTransferOption) (err error) {
+	if err = p.begin(); err != nil {
+		return err
+	}
+	defer p.running.Done()
+
+	var tree map[string]*syncEntry
+	switch direction {
+	case AfcCopyToHost:
+		err = p.do(func(afc Afc) (err error) {
+			tree, err = deviceTree(afc, src)
+			return
+		})
+	case AfcCopyToDevice:
+		tree, err = hostTree(src)
+	default:
+		return fmt.Errorf("afc copy: unknown direction %d", direction)
+	}
+	if err != nil {
+		return fmt.Errorf("afc copy: %w", err)
+	}
+	if tree["."] == nil {
+		return fmt.Errorf("afc copy %s: %w", src, fs.ErrNotExist)
+	}
+
+	var dirs, files, links []string
+	for rel, e := range tree {
+		switch e.mode {
+		case fs.ModeDir:
+			dirs = append(dirs, rel)
+		case fs.ModeSymlink:
+			links = append(links, rel)
+		case 0:
+			files = append(files, rel)
+		}
+	}
+	sort.Strings(dirs)
+
+	hostPath := func(dir, rel string) string { return filepath.Join(dir, filepath.FromSlash(rel)) }
+
+	if direction == AfcCopyToHost {
+		for _, rel := range dirs {
+			if err = os.MkdirAll(hostPath(dst, rel), 0755); err != nil {
+				return fmt.Errorf("afc copy: %w", err)
+			}
+		}
+		return p.each(append(files, links...), func(afc Afc, rel string) error {
+			e := tree[rel]
+			if e.mode == fs.ModeSymlink {
+				_ = os.Remove(hostPath(dst, rel))
+				return os.Symlink(e.target, hostPath(dst, rel))
+			}
+			if err := afc.Pull(path.Join(src, rel), hostPath(dst, rel), opts...); err != nil {
+				return err
+			}
+			return os.Chtimes(hostPath(dst, rel), e.modTime, e.modTime)
+		})
+	}
+
+	if err = p.do(func(afc Afc) (err error) {
+		for _, rel := range dirs {
+			if err = afc.Mkdir(path.Join(dst, rel)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("afc copy: %w", err)
+	}
+	return p.each(append(files, links...), func(afc Afc, rel string) error {
+		e := tree[rel]
+		if e.mode == fs.ModeSymlink {
+			_ = afc.Remove(path.Join(dst, rel))
+			return afc.Link(e.target, path.Join(dst, rel), AfcLinkTypeSymLink)
+		}
+		if err := afc.Push(hostPath(src, rel), path.Join(dst, rel), opts...); err != nil {
+			return err
+		}
+		return afc.SetFileModTime(path.Join(dst, rel), e.modTime)
+	})
+}
+
+func (p *afcPool) Close() (err error) {
+	p.mu.Lock()
+	owned := p.owned
+	p.owned, p.closed = nil, true
+	p.mu.Unlock()
+	p.running.Wait()
+
+	for _, afc := range owned {
+		if closeErr := afc.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return
+}
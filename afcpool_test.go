@@ -0,0 +1,131 @@
+package giDevice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/idevicetest"
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/libimobiledevice"
+)
+
+func Test_afcPool_simulated(t *testing.T) {
+	setupSimulatedDevice(t)
+	root := t.TempDir()
+	afcHandler := idevicetest.AfcHandler(root)
+	var opened int32
+	simDev.Handle(libimobiledevice.AfcServiceName, func(conn net.Conn) {
+		atomic.AddInt32(&opened, 1)
+		afcHandler(conn)
+	})
+
+	reportsDir := filepath.Join(root, "Logs", "CrashReporter")
+	if err := os.MkdirAll(filepath.Join(reportsDir, "Retired"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	var names, contents []string
+	for i := 0; i < 40; i++ {
+		name := fmt.Sprintf("app-%02d.ips", i)
+		if i%4 == 0 {
+			name = "Retired/" + name
+		}
+		if err := os.WriteFile(filepath.Join(reportsDir, filepath.FromSlash(name)), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		names, contents = append(names, "Logs/CrashReporter/"+name), append(contents, name)
+	}
+
+	pool, err := dev.AfcPool(context.Background(), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	if n := atomic.LoadInt32(&opened); n != 4 {
+		t.Fatalf("%d connections opened", n)
+	}
+
+	infos, err := pool.StatMany(append(names, "Logs/CrashReporter/missing.ips"))
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected fs.ErrNotExist, got %v", err)
+	}
+	var batchErr *AfcBatchError
+	if !errors.As(err, &batchErr) || len(batchErr.Errs) != 1 || batchErr.Errs["Logs/CrashReporter/missing.ips"] == nil {
+		t.Fatalf("batch error: %v", err)
+	}
+	for i, name := range names {
+		if infos[i] == nil || infos[i].Size() != int64(len(contents[i])) {
+			t.Fatalf("%s: %+v", name, infos[i])
+		}
+	}
+	if infos[len(names)] != nil {
+		t.Fatal("info for a missing file")
+	}
+
+	hostDir := filepath.Join(t.TempDir(), "reports")
+	if err = pool.CopyTree("Logs/CrashReporter", hostDir, AfcCopyToHost); err != nil {
+		t.Fatal(err)
+	}
+	for _, content := range contents {
+		if data, err := os.ReadFile(filepath.Join(hostDir, filepath.FromSlash(content))); err != nil || string(data) != content {
+			t.Fatalf("%s: %q %v", content, data, err)
+		}
+	}
+
+	if err = pool.CopyTree(hostDir, "Backup/reports", AfcCopyToDevice); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(root, "Backup", "reports", "Retired", "app-04.ips")); err != nil || string(data) != "Retired/app-04.ips" {
+		t.Fatalf("copied to device: %q %v", data, err)
+	}
+
+	if err = pool.RemoveMany(names); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(reportsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "Retired" {
+		t.Fatalf("left after RemoveMany: %v", entries)
+	}
+	if n := atomic.LoadInt32(&opened); n != 4 {
+		t.Fatalf("%d connections opened in all", n)
+	}
+
+	// waits for the calls running
+	running, release := make(chan struct{}), make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- pool.Do(func(afc Afc) error {
+			close(running)
+			<-release
+			_, err := afc.Stat("Logs")
+			return err
+		})
+	}()
+	<-running
+	closed := make(chan error, 1)
+	go func() { closed <- pool.Close() }()
+	select {
+	case <-closed:
+		t.Fatal("closed while a call is running")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+	if err = <-closed; err != nil {
+		t.Fatal(err)
+	}
+	if err = pool.RemoveMany(names); !errors.Is(err, ErrAfcPoolClosed) {
+		t.Fatalf("expected ErrAfcPoolClosed, got %v", err)
+	}
+}
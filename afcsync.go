@@ -0,0 +1,305 @@
+package giDevice
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SyncToDevice mirrors the host directory localDir into remoteDir on the device,
+// transferring only the files whose size or mtime differ, see AfcSyncOption
+func SyncToDevice(afc Afc, localDir, remoteDir string, opts ...AfcSyncOption) (err error) {
+	opt := defaultAfcSyncOption()
+	for _, fn := range opts {
+		fn(opt)
+	}
+
+	var src, dst map[string]*syncEntry
+	if src, err = hostTree(localDir); err != nil {
+		return fmt.Errorf("afc sync: %w", err)
+	}
+	if dst, err = deviceTree(afc, remoteDir); err != nil {
+		return fmt.Errorf("afc sync: %w", err)
+	}
+	plan := planSync(src, dst, opt)
+
+	remotePath := func(rel string) string { return path.Join(remoteDir, rel) }
+	localPath := func(rel string) string { return filepath.Join(localDir, filepath.FromSlash(rel)) }
+
+	for _, rel := range plan.remove {
+		if err = afc.RemoveAll(remotePath(rel)); err != nil {
+			return fmt.Errorf("afc sync %s: %w", rel, err)
+		}
+	}
+	for _, rel := range append([]string{"."}, plan.mkdir...) {
+		if rel == "." && dst[rel] != nil {
+			continue
+		}
+		if err = afc.Mkdir(remotePath(rel)); err != nil {
+			return fmt.Errorf("afc sync %s: %w", rel, err)
+		}
+	}
+	if err = syncFiles(afc, opt, plan.files, func(afc Afc, rel string) (err error) {
+		if dst[rel] != nil {
+			var unchanged bool
+			if unchanged, err = sameFile(afc, opt, localPath(rel), remotePath(rel), src[rel], dst[rel]); err != nil || unchanged {
+				return err
+			}
+		}
+		if err = afc.Push(localPath(rel), remotePath(rel)); err != nil {
+			return err
+		}
+		return afc.SetFileModTime(remotePath(rel), src[rel].modTime)
+	}); err != nil {
+		return err
+	}
+	for _, rel := range plan.link {
+		if err = afc.Link(src[rel].target, remotePath(rel), AfcLinkTypeSymLink); err != nil {
+			return fmt.Errorf("afc sync %s: %w", rel, err)
+		}
+	}
+	return nil
+}
+
+// SyncFromDevice mirrors remoteDir on the device into the host directory localDir,
+// transferring only the files whose size or mtime differ, see AfcSyncOption
+func SyncFromDevice(afc Afc, remoteDir, localDir string, opts ...AfcSyncOption) (err error) {
+	opt := defaultAfcSyncOption()
+	for _, fn := range opts {
+		fn(opt)
+	}
+
+	var src, dst map[string]*syncEntry
+	if src, err = deviceTree(afc, remoteDir); err != nil {
+		return fmt.Errorf("afc sync: %w", err)
+	}
+	if src["."] == nil {
+		return fmt.Errorf("afc sync %s: %w", remoteDir, fs.ErrNotExist)
+	}
+	if dst, err = hostTree(localDir); err != nil {
+		return fmt.Errorf("afc sync: %w", err)
+	}
+	plan := planSync(src, dst, opt)
+
+	remotePath := func(rel string) string { return path.Join(remoteDir, rel) }
+	localPath := func(rel string) string { return filepath.Join(localDir, filepath.FromSlash(rel)) }
+
+	for _, rel := range plan.remove {
+		if err = os.RemoveAll(localPath(rel)); err != nil {
+			return fmt.Errorf("afc sync: %w", err)
+		}
+	}
+	for _, rel := range append([]string{"."}, plan.mkdir...) {
+		if err = os.MkdirAll(localPath(rel), 0755); err != nil {
+			return fmt.Errorf("afc sync: %w", err)
+		}
+	}
+	if err = syncFiles(afc, opt, plan.files, func(afc Afc, rel string) (err error) {
+		if dst[rel] != nil {
+			var unchanged bool
+			if unchanged, err = sameFile(afc, opt, localPath(rel), remotePath(rel), src[rel], dst[rel]); err != nil || unchanged {
+				return err
+			}
+		}
+		if err = afc.Pull(remotePath(rel), localPath(rel)); err != nil {
+			return err
+		}
+		return os.Chtimes(localPath(rel), src[rel].modTime, src[rel].modTime)
+	}); err != nil {
+		return err
+	}
+	for _, rel := range plan.link {
+		if err = os.Symlink(src[rel].target, localPath(rel)); err != nil {
+			return fmt.Errorf("afc sync: %w", err)
+		}
+	}
+	return nil
+}
+
+// syncEntry a directory, regular file or symlink in a tree being synced
+type syncEntry struct {
+	mode    fs.FileMode
+	size    int64
+	modTime time.Time
+	target  string
+}
+
+// hostTree the entries under the host directory dir by slash separated path, `.` being dir,
+// empty while dir does not exist
+func hostTree(dir string) (tree map[string]*syncEntry, err error) {
+	tree = make(map[string]*syncEntry)
+	err = filepath.WalkDir(dir, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if name == dir && errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		var rel string
+		if rel, err = filepath.Rel(dir, name); err != nil {
+			return err
+		}
+		var info fs.FileInfo
+		if info, err = entry.Info(); err != nil {
+			return err
+		}
+		e := &syncEntry{mode: info.Mode().Type(), size: info.Size(), modTime: info.ModTime()}
+		if e.mode == fs.ModeSymlink {
+			if e.target, err = os.Readlink(name); err != nil {
+				return err
+			}
+		}
+		tree[filepath.ToSlash(rel)] = e
+		return nil
+	})
+	return
+}
+
+// deviceTree the entries under dir on the device, as hostTree
+func deviceTree(afc Afc, dir string) (tree map[string]*syncEntry, err error) {
+	root := strings.TrimPrefix(path.Clean("/"+dir), "/")
+	if root == "" {
+		root = "."
+	}
+	tree = make(map[string]*syncEntry)
+	err = Walk(afc, root, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if name == root && errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		rel := "."
+		if name != root {
+			rel = strings.TrimPrefix(name, root+"/")
+			if root == "." {
+				rel = name
+			}
+		}
+		fileInfo, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		info := fileInfo.(*AfcFileInfo)
+		tree[rel] = &syncEntry{mode: info.Type(), size: info.Size(), modTime: info.ModTime(), target: info.LinkTarget()}
+		return nil
+	})
+	return
+}
+
+type syncPlan struct {
+	remove []string
+	mkdir  []string
+	files  []string
+	link   []string
+}
+
+// planSync what to do to dst for it to mirror src: remove what is in the way, then
+// make the directories, transfer the files that may have changed and make the symlinks
+func planSync(src, dst map[string]*syncEntry, opt *afcSyncOption) (plan syncPlan) {
+	for rel, d := range dst {
+		if rel == "." {
+			continue
+		}
+		s := src[rel]
+		switch {
+		case s == nil && opt.delete,
+			s != nil && s.mode != d.mode,
+			s != nil && s.mode == fs.ModeSymlink && s.target != d.target:
+			plan.remove = append(plan.remove, rel)
+		}
+	}
+	sort.Strings(plan.remove)
+	removed := plan.remove[:0]
+	for _, rel := range plan.remove {
+		delete(dst, rel)
+		// gone along with a directory above
+		if n := len(removed); n != 0 && strings.HasPrefix(rel, removed[n-1]+"/") {
+			continue
+		}
+		removed = append(removed, rel)
+	}
+	plan.remove = removed
+
+	for rel, s := range src {
+		if rel == "." {
+			continue
+		}
+		d := dst[rel]
+		switch s.mode {
+		case fs.ModeDir:
+			if d == nil {
+				plan.mkdir = append(plan.mkdir, rel)
+			}
+		case fs.ModeSymlink:
+			if d == nil {
+				plan.link = append(plan.link, rel)
+			}
+		case 0:
+			plan.files = append(plan.files, rel)
+		}
+	}
+	sort.Strings(plan.mkdir)
+	sort.Strings(plan.files)
+	sort.Strings(plan.link)
+	return
+}
+
+// sameFile whether the host and device files, of entries src and dst, need no transfer
+func sameFile(afc Afc, opt *afcSyncOption, localPath, remotePath string, src, dst *syncEntry) (same bool, err error) {
+	if src.size != dst.size {
+		return false, nil
+	}
+	if !opt.hash {
+		return src.modTime.Unix() == dst.modTime.Unix(), nil
+	}
+
+	var local *os.File
+	if local, err = os.Open(localPath); err != nil {
+		return false, err
+	}
+	defer local.Close()
+	h := sha1.New()
+	if _, err = io.Copy(h, local); err != nil {
+		return false, err
+	}
+	var remoteSum []byte
+	if remoteSum, err = afc.Hash(remotePath); err != nil {
+		return false, err
+	}
+	return bytes.Equal(h.Sum(nil), remoteSum), nil
+}
+
+// syncFiles calls transfer for each of files, on up to opt.parallel connections at once
+func syncFiles(afc Afc, opt *afcSyncOption, files []string, transfer func(afc Afc, rel string) error) (err error) {
+	n := 1
+	if opt.open != nil {
+		for n < opt.parallel && n < len(files) {
+			n++
+		}
+	}
+	p := newAfcPool(n)
+	defer p.Close()
+	p.add(afc, false)
+	for i := 1; i < n; i++ {
+		var conn Afc
+		if conn, err = opt.open(); err != nil {
+			return fmt.Errorf("afc sync: %w", err)
+		}
+		p.add(conn, true)
+	}
+
+	if err = p.each(files, transfer); err != nil {
+		return fmt.Errorf("afc sync: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package giDevice
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/idevicetest"
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/libimobiledevice"
+)
+
+func Test_afc_simulated_Sync(t *testing.T) {
+	setupSimulatedDevice(t)
+	root := t.TempDir()
+	afcHandler := idevicetest.AfcHandler(root)
+	var opened int32
+	simDev.Handle(libimobiledevice.AfcServiceName, func(conn net.Conn) {
+		atomic.AddInt32(&opened, 1)
+		afcHandler(conn)
+	})
+
+	hostDir := t.TempDir()
+	mtime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	files := map[string]string{
+		"top.txt":      "top",
+		"a/b.txt":      "bee",
+		"a/c/d.txt":    "dee",
+		"a/c/e/f.json": "{}",
+	}
+	for name, data := range files {
+		hostPath := filepath.Join(hostDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(hostPath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(hostPath, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(hostPath, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("top.txt", filepath.Join(hostDir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	afc, err := dev.StartAfc(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer afc.Close()
+	parallel := WithAfcSyncParallel(3, func() (Afc, error) {
+		return dev.StartAfc(context.Background())
+	})
+
+	deviceDir := filepath.Join(root, "Documents", "fixtures")
+	assertTree := func(dir string, want map[string]string) {
+		t.Helper()
+		for name, data := range want {
+			got, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != data {
+				t.Fatalf("%s: %q, want %q", name, got, data)
+			}
+		}
+		if target, err := os.Readlink(filepath.Join(dir, "link")); err != nil || target != "top.txt" {
+			t.Fatalf("link: %q %v", target, err)
+		}
+	}
+
+	if err = SyncToDevice(afc, hostDir, "/Documents/fixtures", parallel); err != nil {
+		t.Fatal(err)
+	}
+	assertTree(deviceDir, files)
+	if n := atomic.LoadInt32(&opened); n != 3 {
+		t.Fatalf("%d connections opened", n)
+	}
+	if info, err := os.Stat(filepath.Join(deviceDir, "a", "b.txt")); err != nil || !info.ModTime().Equal(mtime) {
+		t.Fatalf("mtime not kept: %v", err)
+	}
+
+	// same size and mtime, skipped unless hashed
+	tampered := filepath.Join(deviceDir, "a", "b.txt")
+	if err = os.WriteFile(tampered, []byte("BEE"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chtimes(tampered, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	extraneous := filepath.Join(deviceDir, "a", "c", "old.log")
+	if err = os.WriteFile(extraneous, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = SyncToDevice(afc, hostDir, "Documents/fixtures"); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(tampered); string(data) != "BEE" {
+		t.Fatalf("unchanged file transferred: %q", data)
+	}
+	if _, err = os.Stat(extraneous); err != nil {
+		t.Fatalf("extraneous file removed without WithAfcSyncDelete: %v", err)
+	}
+	if err = SyncToDevice(afc, hostDir, "Documents/fixtures", WithAfcSyncHash(true), WithAfcSyncDelete(true)); err != nil {
+		t.Fatal(err)
+	}
+	assertTree(deviceDir, files)
+	if _, err = os.Stat(extraneous); !os.IsNotExist(err) {
+		t.Fatalf("extraneous file kept: %v", err)
+	}
+
+	pulledDir := filepath.Join(t.TempDir(), "results")
+	if err = SyncFromDevice(afc, "Documents/fixtures", pulledDir, parallel); err != nil {
+		t.Fatal(err)
+	}
+	assertTree(pulledDir, files)
+	if info, err := os.Stat(filepath.Join(pulledDir, "a", "c", "d.txt")); err != nil || !info.ModTime().Equal(mtime) {
+		t.Fatalf("mtime not kept: %v", err)
+	}
+
+	// a file where the device has a directory is replaced
+	if err = os.RemoveAll(filepath.Join(pulledDir, "a", "c")); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(pulledDir, "a", "c"), []byte("in the way"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = SyncFromDevice(afc, "Documents/fixtures", pulledDir); err != nil {
+		t.Fatal(err)
+	}
+	assertTree(pulledDir, files)
+}
@@ -0,0 +1,230 @@
+package giDevice
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/libimobiledevice"
+)
+
+// ErrAfcHashMismatch the file at the destination does not hash as the source once transferred
+var ErrAfcHashMismatch = errors.New("afc: hash mismatch")
+
+func (c *afc) Push(localPath, remotePath string, opts ...AfcTransferOption) (err error) {
+	opt := defaultAfcTransferOption()
+	for _, fn := range opts {
+		fn(opt)
+	}
+
+	var local *os.File
+	if local, err = os.Open(localPath); err != nil {
+		return fmt.Errorf("afc push: %w", err)
+	}
+	defer local.Close()
+	var localInfo os.FileInfo
+	if localInfo, err = local.Stat(); err != nil {
+		return fmt.Errorf("afc push: %w", err)
+	}
+	total := localInfo.Size()
+
+	h := sha1.New()
+	var offset int64
+	mode := AfcFileModeWr
+	if opt.resume {
+		var remoteInfo *AfcFileInfo
+		if remoteInfo, err = c.Stat(remotePath); err != nil && !errors.Is(err, ErrAfcStatNotExist) {
+			return fmt.Errorf("afc push: %w", err)
+		}
+		if err == nil && !remoteInfo.IsDir() && remoteInfo.Size() > 0 && remoteInfo.Size() <= total {
+			var resumed bool
+			if resumed, err = c.matchPrefix(local, h, remotePath, remoteInfo.Size()); err != nil {
+				return fmt.Errorf("afc push: %w", err)
+			}
+			if resumed {
+				offset, mode = remoteInfo.Size(), AfcFileModeRw
+			}
+		}
+	}
+
+	var file *AfcFile
+	if file, err = c.Open(remotePath, mode); err != nil {
+		return fmt.Errorf("afc push: %w", err)
+	}
+	if offset > 0 {
+		if _, err = file.Seek(offset, io.SeekStart); err != nil {
+			_ = file.Close()
+			return fmt.Errorf("afc push: %w", err)
+		}
+	}
+	var transferred int64
+	if transferred, err = copyChunks(file, local, h, opt, offset, total); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("afc push %s: %w", remotePath, err)
+	}
+	if err = file.Close(); err != nil {
+		return fmt.Errorf("afc push: %w", err)
+	}
+
+	if opt.verify {
+		if err = c.verify(remotePath, h, transferred); err != nil {
+			return fmt.Errorf("afc push %s: %w", remotePath, err)
+		}
+	}
+	return nil
+}
+
+func (c *afc) Pull(remotePath, localPath string, opts ...AfcTransferOption) (err error) {
+	opt := defaultAfcTransferOption()
+	for _, fn := range opts {
+		fn(opt)
+	}
+
+	var remoteInfo *AfcFileInfo
+	if remoteInfo, err = c.Stat(remotePath); err != nil {
+		return fmt.Errorf("afc pull: %w", err)
+	}
+	if remoteInfo.IsDir() {
+		return fmt.Errorf("afc pull %s: is a directory", remotePath)
+	}
+	total := remoteInfo.Size()
+
+	var local *os.File
+	if local, err = os.OpenFile(localPath, os.O_RDWR|os.O_CREATE, 0644); err != nil {
+		return fmt.Errorf("afc pull: %w", err)
+	}
+	defer func() {
+		if closeErr := local.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("afc pull: %w", closeErr)
+		}
+	}()
+
+	h := sha1.New()
+	var offset int64
+	if opt.resume {
+		var localInfo os.FileInfo
+		if localInfo, err = local.Stat(); err != nil {
+			return fmt.Errorf("afc pull: %w", err)
+		}
+		if localInfo.Size() > 0 && localInfo.Size() <= total {
+			var resumed bool
+			if resumed, err = c.matchPrefix(local, h, remotePath, localInfo.Size()); err != nil {
+				return fmt.Errorf("afc pull: %w", err)
+			}
+			if resumed {
+				offset = localInfo.Size()
+			}
+		}
+	}
+	if err = local.Truncate(offset); err != nil {
+		return fmt.Errorf("afc pull: %w", err)
+	}
+
+	var file *AfcFile
+	if file, err = c.Open(remotePath, AfcFileModeRdOnly); err != nil {
+		return fmt.Errorf("afc pull: %w", err)
+	}
+	if offset > 0 {
+		if _, err = file.Seek(offset, io.SeekStart); err != nil {
+			_ = file.Close()
+			return fmt.Errorf("afc pull: %w", err)
+		}
+	}
+	var transferred int64
+	if transferred, err = copyChunks(local, file, h, opt, offset, total); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("afc pull %s: %w", remotePath, err)
+	}
+	if err = file.Close(); err != nil {
+		return fmt.Errorf("afc pull: %w", err)
+	}
+	if opt.verify {
+		if err = c.verify(remotePath, h, transferred); err != nil {
+			return fmt.Errorf("afc pull %s: %w", remotePath, err)
+		}
+	}
+	return nil
+}
+
+// matchPrefix whether the first size bytes of host, read from its start into h,
+// hash as the same range of remotePath. If not, h and host are set back to the start
+func (c *afc) matchPrefix(host *os.File, h hash.Hash, remotePath string, size int64) (ok bool, err error) {
+	if _, err = io.CopyN(h, host, size); err != nil {
+		return false, err
+	}
+	var remoteSum []byte
+	if remoteSum, err = c.HashWithRange(remotePath, 0, uint64(size)); err != nil && !hashUnsupported(err) {
+		return false, err
+	}
+	// transferred in full when the device does not hash
+	if err == nil && bytes.Equal(h.Sum(nil), remoteSum) {
+		return true, nil
+	}
+	h.Reset()
+	_, err = host.Seek(0, io.SeekStart)
+	return false, err
+}
+
+// verify compares h, the hash of all the bytes transferred, with that of remotePath
+func (c *afc) verify(remotePath string, h hash.Hash, size int64) (err error) {
+	// nothing to compare, and not every iOS hashes an empty range
+	if size == 0 {
+		return nil
+	}
+	var remoteSum []byte
+	if remoteSum, err = c.HashWithRange(remotePath, 0, uint64(size)); hashUnsupported(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !bytes.Equal(h.Sum(nil), remoteSum) {
+		return ErrAfcHashMismatch
+	}
+	return nil
+}
+
+// hashUnsupported whether err is a device not knowing how to hash a range, as
+// some do not: resuming and verifying are skipped then
+func hashUnsupported(err error) bool {
+	var afcErr libimobiledevice.AfcError
+	if !errors.As(err, &afcErr) {
+		return false
+	}
+	switch afcErr {
+	case libimobiledevice.AfcErrUnknownPacketType, libimobiledevice.AfcErrOperationNotSupported:
+		return true
+	}
+	return false
+}
+
+// copyChunks copies src to dst opt.chunkSize bytes at a time, hashing them into h,
+// and returns the bytes transferred, offset included
+func copyChunks(dst io.Writer, src io.Reader, h hash.Hash, opt *afcTransferOption, offset, total int64) (transferred int64, err error) {
+	transferred = offset
+	opt.progress(transferred, total)
+
+	buf := make([]byte, opt.chunkSize)
+	for {
+		var n int
+		n, err = io.ReadFull(src, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return transferred, err
+		}
+		if n == 0 {
+			return transferred, nil
+		}
+		h.Write(buf[:n])
+		if _, err = dst.Write(buf[:n]); err != nil {
+			return transferred, err
+		}
+		transferred += int64(n)
+		opt.progress(transferred, total)
+		if n < len(buf) {
+			return transferred, nil
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package giDevice
+
+import (
+	"bytes"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/idevicetest"
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/libimobiledevice"
+)
+
+func Test_afc_simulated_PushPull(t *testing.T) {
+	setupSimulatedDevice(t)
+	root := t.TempDir()
+	simDev.Handle(libimobiledevice.AfcServiceName, idevicetest.AfcHandler(root))
+
+	data := make([]byte, 300<<10+123)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	hostDir := t.TempDir()
+	localPath := filepath.Join(hostDir, "build.ipa")
+	if err := os.WriteFile(localPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	afc, err := dev.AfcService()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// transfer records the first and last progress reported
+	transfer := func(fn func(opts ...AfcTransferOption) error) (first, last int64) {
+		t.Helper()
+		first = -1
+		if err := fn(WithAfcChunkSize(64<<10), WithAfcProgress(func(transferred, total int64) {
+			if total != int64(len(data)) || transferred < last {
+				t.Fatalf("progress %d/%d after %d", transferred, total, last)
+			}
+			if first < 0 {
+				first = transferred
+			}
+			last = transferred
+		})); err != nil {
+			t.Fatal(err)
+		}
+		if last != int64(len(data)) {
+			t.Fatalf("last progress %d", last)
+		}
+		return
+	}
+	push := func(opts ...AfcTransferOption) error { return afc.Push(localPath, "build.ipa", opts...) }
+	pulledPath := filepath.Join(hostDir, "pulled.ipa")
+	pull := func(opts ...AfcTransferOption) error { return afc.Pull("build.ipa", pulledPath, opts...) }
+
+	assertContent := func(name string) {
+		t.Helper()
+		got, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("%s: %d bytes differ from the %d pushed", name, len(got), len(data))
+		}
+	}
+
+	if first, _ := transfer(push); first != 0 {
+		t.Fatalf("fresh push started at %d", first)
+	}
+	assertContent(filepath.Join(root, "build.ipa"))
+
+	// a push cut short resumes where it stopped
+	if err = os.WriteFile(filepath.Join(root, "build.ipa"), data[:100<<10], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if first, _ := transfer(push); first != 100<<10 {
+		t.Fatalf("push resumed at %d", first)
+	}
+	assertContent(filepath.Join(root, "build.ipa"))
+
+	// unless what is there is not what is being pushed
+	corrupt := append([]byte(nil), data[:100<<10]...)
+	corrupt[10] ^= 0xff
+	if err = os.WriteFile(filepath.Join(root, "build.ipa"), corrupt, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if first, _ := transfer(push); first != 0 {
+		t.Fatalf("push of a different file resumed at %d", first)
+	}
+	assertContent(filepath.Join(root, "build.ipa"))
+
+	if first, _ := transfer(pull); first != 0 {
+		t.Fatalf("fresh pull started at %d", first)
+	}
+	assertContent(pulledPath)
+
+	if err = os.WriteFile(pulledPath, data[:200<<10], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if first, _ := transfer(pull); first != 200<<10 {
+		t.Fatalf("pull resumed at %d", first)
+	}
+	assertContent(pulledPath)
+
+	// a longer file at the destination is not kept around the pulled one
+	if err = os.WriteFile(pulledPath, append(append([]byte(nil), data...), "tail"...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if first, _ := transfer(pull); first != 0 {
+		t.Fatalf("pull over a longer file resumed at %d", first)
+	}
+	assertContent(pulledPath)
+}
+
+func Test_afc_simulated_PushPullWithoutHash(t *testing.T) {
+	setupSimulatedDevice(t)
+	root := t.TempDir()
+	simDev.Handle(libimobiledevice.AfcServiceName, idevicetest.AfcHandlerWithout(root, libimobiledevice.AfcOperationGetFileHashRange))
+
+	data := make([]byte, 100<<10)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	localPath := filepath.Join(t.TempDir(), "build.ipa")
+	if err := os.WriteFile(localPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// a partial file to resume from, the device can not tell it matches
+	if err := os.WriteFile(filepath.Join(root, "build.ipa"), data[:10<<10], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	afc, err := dev.AfcService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := int64(-1)
+	progress := WithAfcProgress(func(transferred, total int64) {
+		if first < 0 {
+			first = transferred
+		}
+	})
+	if err = afc.Push(localPath, "build.ipa", progress); err != nil {
+		t.Fatal(err)
+	}
+	if first != 0 {
+		t.Fatalf("push resumed at %d", first)
+	}
+	if got, err := os.ReadFile(filepath.Join(root, "build.ipa")); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("pushed: %d bytes %v", len(got), err)
+	}
+
+	pulledPath := filepath.Join(t.TempDir(), "pulled.ipa")
+	if err = os.WriteFile(pulledPath, data[:10<<10], 0644); err != nil {
+		t.Fatal(err)
+	}
+	first = -1
+	if err = afc.Pull("build.ipa", pulledPath, progress); err != nil {
+		t.Fatal(err)
+	}
+	if first != 0 {
+		t.Fatalf("pull resumed at %d", first)
+	}
+	if got, err := os.ReadFile(pulledPath); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("pulled: %d bytes %v", len(got), err)
+	}
+}
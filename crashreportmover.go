@@ -5,8 +5,8 @@ import (
 	"github.com/SonicCloudOrg/sonic-gidevice/pkg/libimobiledevice"
 	"howett.net/plist"
 	"io"
+	"io/fs"
 	"os"
-	"path"
 	"path/filepath"
 	"strings"
 )
@@ -44,15 +44,14 @@ func (c *crashReportMover) Move(hostDir string, opts ...CrashReportMoverOption)
 
 	toExtract := make([]string, 0, 64)
 
-	fn := func(cwd string, info *AfcFileInfo) {
-		if info.IsDir() {
-			return
+	fn := func(devFilename string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
 		}
-		if cwd == "." {
-			cwd = ""
+		if entry.IsDir() {
+			return nil
 		}
 
-		devFilename := path.Join(cwd, info.Name())
 		hostElem := strings.Split(devFilename, "/")
 		hostFilename := filepath.Join(hostDir, filepath.Join(hostElem...))
 		hostFilename = strings.TrimSuffix(hostFilename, ".synced")
@@ -64,7 +63,7 @@ func (c *crashReportMover) Move(hostDir string, opts ...CrashReportMoverOption)
 		var afcFile *AfcFile
 		if afcFile, err = c.afc.Open(devFilename, AfcFileModeRdOnly); err != nil {
 			debugLog(fmt.Sprintf("crashReportMover open %s: %s", devFilename, err))
-			return
+			return nil
 		}
 		defer func() {
 			if err = afcFile.Close(); err != nil {
@@ -74,12 +73,12 @@ func (c *crashReportMover) Move(hostDir string, opts ...CrashReportMoverOption)
 
 		if err = os.MkdirAll(filepath.Dir(hostFilename), 0755); err != nil {
 			debugLog(fmt.Sprintf("crashReportMover mkdir %s: %s", filepath.Dir(hostFilename), err))
-			return
+			return nil
 		}
 		var hostFile *os.File
 		if hostFile, err = os.Create(hostFilename); err != nil {
 			debugLog(fmt.Sprintf("crashReportMover create %s: %s", hostFilename, err))
-			return
+			return nil
 		}
 		defer func() {
 			if err = hostFile.Close(); err != nil {
@@ -89,21 +88,22 @@ func (c *crashReportMover) Move(hostDir string, opts ...CrashReportMoverOption)
 
 		if _, err = io.Copy(hostFile, afcFile); err != nil {
 			debugLog(fmt.Sprintf("crashReportMover copy %s", err))
-			return
+			return nil
 		}
 
 		opt.whenDone(devFilename)
 
 		if opt.keep {
-			return
+			return nil
 		}
 
 		if err = c.afc.Remove(devFilename); err != nil {
 			debugLog(fmt.Sprintf("crashReportMover remove %s: %s", devFilename, err))
-			return
+			return nil
 		}
+		return nil
 	}
-	if err = c.walkDir(".", fn); err != nil {
+	if err = Walk(c.afc, ".", fn); err != nil {
 		return err
 	}
 
@@ -136,32 +136,3 @@ func (c *crashReportMover) Move(hostDir string, opts ...CrashReportMoverOption)
 
 	return
 }
-
-func (c *crashReportMover) walkDir(dirname string, fn func(path string, info *AfcFileInfo)) (err error) {
-	var names []string
-	if names, err = c.afc.ReadDir(dirname); err != nil {
-		return err
-	}
-
-	cwd := dirname
-
-	for _, n := range names {
-		if n == "." || n == ".." {
-			continue
-		}
-
-		var info *AfcFileInfo
-		if info, err = c.afc.Stat(path.Join(cwd, n)); err != nil {
-			return err
-		}
-		if info.IsDir() {
-			if err = c.walkDir(path.Join(cwd, info.name), fn); err != nil {
-				return err
-			}
-		}
-
-		fn(cwd, info)
-	}
-
-	return
-}
@@ -0,0 +1,118 @@
+package giDevice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/libimobiledevice"
+)
+
+var (
+	ErrDeveloperModeUnsupported = errors.New("developer mode: needs iOS 16 or later")
+	// ErrDeveloperModePasscodeSet developer mode is only armed without a passcode
+	ErrDeveloperModePasscodeSet = errors.New("developer mode: a passcode is set")
+)
+
+const (
+	developerModeDomain = "com.apple.security.mac.amfi"
+	// developerModeRestartGrace for the device to restart once armed, before it is rebooted
+	developerModeRestartGrace = 30 * time.Second
+)
+
+func (d *device) EnableDeveloperMode(ctx context.Context, opts ...DeveloperModeOption) (dev Device, err error) {
+	opt := defaultDeveloperModeOption()
+	for _, fn := range opts {
+		fn(opt)
+	}
+	report := func(stage DeveloperModeStage) {
+		if opt.progress != nil {
+			opt.progress(stage)
+		}
+	}
+
+	var enabled bool
+	if enabled, err = d.developerModeStatus(ctx); err != nil {
+		return nil, err
+	}
+	if enabled {
+		report(DeveloperModeStageEnabled)
+		return d, nil
+	}
+
+	lifecycleOpt := defaultLifecycleOption()
+	for _, fn := range opt.lifecycle {
+		fn(lifecycleOpt)
+	}
+	// the prompt only shows on an unlocked device
+	lifecycleOpt.unlocked = true
+	waitCtx, cancel := lifecycleOpt.withTimeout(ctx)
+	defer cancel()
+
+	var renewed *device
+	if renewed, err = d.restartAndWait(waitCtx, lifecycleOpt, func() error {
+		if err := d.developerModeAction(waitCtx, (*amfi).DevModeArm); err != nil {
+			return err
+		}
+		report(DeveloperModeStageArmed)
+		return nil
+	}, developerModeRestartGrace); errors.Is(err, ErrDeveloperModePasscodeSet) {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("developer mode: %w", err)
+	}
+	report(DeveloperModeStageRestarted)
+
+	if err = renewed.developerModeAction(ctx, (*amfi).DevModeEnable); err != nil {
+		return nil, fmt.Errorf("developer mode: %w", err)
+	}
+	if enabled, err = renewed.developerModeStatus(ctx); err != nil {
+		return nil, err
+	}
+	if !enabled {
+		return nil, errors.New("developer mode: still disabled once enabled")
+	}
+	report(DeveloperModeStageEnabled)
+	return renewed, nil
+}
+
+func (d *device) developerModeStatus(ctx context.Context) (enabled bool, err error) {
+	var version *semver.Version
+	if version, err = d.OSVersion(ctx); err != nil {
+		return false, fmt.Errorf("developer mode: %w", err)
+	}
+	if version.Major() < 16 {
+		return false, ErrDeveloperModeUnsupported
+	}
+	var v interface{}
+	if v, err = d.GetValueContext(ctx, developerModeDomain, "DeveloperModeStatus"); err != nil {
+		return false, fmt.Errorf("developer mode: %w", err)
+	}
+	enabled, _ = v.(bool)
+	return enabled, nil
+}
+
+// developerModeAction sends an action to amfi over a connection of its own
+func (d *device) developerModeAction(ctx context.Context, action func(c *amfi) (int, error)) (err error) {
+	var innerConn InnerConn
+	if innerConn, err = d.StartService(ctx, libimobiledevice.AmfiServiceName); err != nil {
+		return err
+	}
+	defer innerConn.Close()
+	release := innerConn.BindContext(ctx)
+	defer release()
+
+	status, err := action(newAmfi(libimobiledevice.NewAmfiClient(innerConn)))
+	switch {
+	case status == http.StatusOK:
+		return nil
+	case status == http.StatusLocked:
+		return ErrDeveloperModePasscodeSet
+	case err != nil:
+		return fmt.Errorf("amfi: %w", err)
+	}
+	return fmt.Errorf("amfi: refused (%d)", status)
+}
@@ -0,0 +1,76 @@
+package giDevice
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/idevicetest"
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/libimobiledevice"
+)
+
+func Test_device_simulated_EnableDeveloperMode(t *testing.T) {
+	setupSimulatedDevice(t)
+	simDev.SetValue("", "ProductVersion", "17.0")
+	simDev.SetValue(developerModeDomain, "DeveloperModeStatus", false)
+	passcode := true
+	simDev.Handle(libimobiledevice.AmfiServiceName, func(conn net.Conn) {
+		var req map[string]interface{}
+		if err := idevicetest.ReadMessage(conn, &req); err != nil {
+			return
+		}
+		switch action, _ := req["action"].(uint64); {
+		case passcode:
+			_ = idevicetest.WriteMessage(conn, map[string]interface{}{"Error": libimobiledevice.AmfiErrPasscodeSet})
+		case action == libimobiledevice.DEV_MODE_ARM:
+			_ = idevicetest.WriteMessage(conn, map[string]interface{}{"success": true})
+			// restarts by itself, the prompt waits for the passcode
+			simSrv.RemoveDevice(simDev)
+			simDev.SetValue("", "PasswordProtected", true)
+			time.AfterFunc(50*time.Millisecond, func() {
+				simSrv.AddDevice(simDev)
+				time.AfterFunc(100*time.Millisecond, func() {
+					simDev.SetValue("", "PasswordProtected", false)
+				})
+			})
+		case action == libimobiledevice.DEV_MODE_ENABLE:
+			simDev.SetValue(developerModeDomain, "DeveloperModeStatus", true)
+			_ = idevicetest.WriteMessage(conn, map[string]interface{}{"success": true})
+		}
+	})
+
+	if _, err := dev.EnableDeveloperMode(context.Background()); !errors.Is(err, ErrDeveloperModePasscodeSet) {
+		t.Fatalf("expected ErrDeveloperModePasscodeSet, got %v", err)
+	}
+
+	passcode = false
+	var stages []DeveloperModeStage
+	renewed, err := dev.EnableDeveloperMode(context.Background(),
+		WithDeveloperModeLifecycle(WithLifecyclePollInterval(20*time.Millisecond)),
+		WithDeveloperModeProgress(func(stage DeveloperModeStage) {
+			stages = append(stages, stage)
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []DeveloperModeStage{DeveloperModeStageArmed, DeveloperModeStageRestarted, DeveloperModeStageEnabled}
+	if !reflect.DeepEqual(stages, expected) {
+		t.Fatalf("stages: %v", stages)
+	}
+
+	// nothing left to do
+	stages = nil
+	if _, err = renewed.EnableDeveloperMode(context.Background(), WithDeveloperModeProgress(func(stage DeveloperModeStage) {
+		stages = append(stages, stage)
+	})); err != nil || !reflect.DeepEqual(stages, []DeveloperModeStage{DeveloperModeStageEnabled}) {
+		t.Fatalf("stages: %v %v", stages, err)
+	}
+
+	simDev.SetValue("", "ProductVersion", "15.7")
+	if _, err = renewed.EnableDeveloperMode(context.Background()); !errors.Is(err, ErrDeveloperModeUnsupported) {
+		t.Fatalf("expected ErrDeveloperModeUnsupported, got %v", err)
+	}
+}
@@ -26,16 +26,18 @@ import (
 	"io"
 	"log"
 	"net"
-	"os"
 	"path"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/Masterminds/semver/v3"
 	"github.com/SonicCloudOrg/sonic-gidevice/pkg/ipa"
 	"github.com/SonicCloudOrg/sonic-gidevice/pkg/libimobiledevice"
 	"github.com/SonicCloudOrg/sonic-gidevice/pkg/nskeyedarchiver"
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/remotexpc"
 	uuid "github.com/satori/go.uuid"
-	"howett.net/plist"
 )
 
 const LockdownPort = 62078
@@ -49,6 +51,9 @@ func newDevice(client *libimobiledevice.UsbmuxClient, properties DevicePropertie
 	}
 }
 
+// NewRemoteConnect connects to the device shared with Device.Share.
+//
+// Deprecated: use NewRemoteUsbmux with a UsbmuxServer.
 func NewRemoteConnect(ip string, port int, timeout int) (*device, error) {
 	client, err := libimobiledevice.NewUsbmuxClient(fmt.Sprintf("%s:%d", ip, port), time.Duration(timeout)*time.Second)
 	if err != nil {
@@ -81,42 +86,107 @@ func NewRemoteConnect(ip string, port int, timeout int) (*device, error) {
 	return dev, nil
 }
 
+// NewNetworkDevice talks to a Wi-Fi device directly over TCP, without usbmuxd:
+// lockdownd on LockdownPort of properties.NetworkAddress, and services on the ports
+// lockdownd starts them on, with TLS from the pair record kept in store.
+// Pairing itself needs a USB connection.
+func NewNetworkDevice(properties DeviceProperties, store PairRecordStore, opts ...NetworkOption) (Device, error) {
+	if store == nil {
+		return nil, errors.New("network device: no PairRecordStore")
+	}
+	ip, err := libimobiledevice.ParseNetworkAddress(properties.NetworkAddress)
+	if err != nil {
+		return nil, fmt.Errorf("network device: %w", err)
+	}
+	host := ip.String()
+	if ip.IsLinkLocalUnicast() && properties.InterfaceIndex != 0 {
+		if iface, err := net.InterfaceByIndex(properties.InterfaceIndex); err == nil {
+			host += "%" + iface.Name
+		}
+	}
+
+	opt := defaultNetworkOption()
+	for _, fn := range opts {
+		fn(opt)
+	}
+
+	if properties.ConnectionType == "" {
+		properties.ConnectionType = "Network"
+	}
+	dev := newDevice(nil, properties)
+	dev.networkHost = host
+	dev.lockdownPort = opt.lockdownPort
+	dev.pairRecordStore = store
+	return dev, nil
+}
+
 type device struct {
-	remoteAddr     string
-	umClient       *libimobiledevice.UsbmuxClient
-	lockdownClient *libimobiledevice.LockdownClient
+	remoteAddr   string
+	networkHost  string
+	lockdownPort int
+	umClient     *libimobiledevice.UsbmuxClient
+	// dialUsbmux connects to a remote usbmuxd, see NewRemoteUsbmux
+	dialUsbmux func(ctx context.Context, timeout ...time.Duration) (*libimobiledevice.UsbmuxClient, error)
 
 	properties *DeviceProperties
-
-	lockdown          *lockdown
-	imageMounter      ImageMounter
-	screenshot        Screenshot
-	simulateLocation  SimulateLocation
-	installationProxy InstallationProxy
-	instruments       Instruments
-	afc               Afc
-	amfi              Amfi
-	houseArrest       HouseArrest
-	misagent          Misagent
-	syslogRelay       SyslogRelay
-	diagnosticsRelay  DiagnosticsRelay
-	springBoard       SpringBoard
-	crashReportMover  CrashReportMover
-	pcapd             Pcapd
-	webInspector      WebInspector
-	perfd             []Perfd
+	// propertiesMu guards properties.DeviceID, renewed when usbmuxd comes back, see DeviceManager
+	propertiesMu    sync.Mutex
+	pairRecordStore PairRecordStore
+	pairMu          sync.Mutex
+
+	// services the connections to services kept to be used again
+	services servicePool
+
+	// mu guards the fields below
+	mu     sync.Mutex
+	closed bool
+	// services lockdownd refuses are looked up there, see UseRemoteServiceDiscovery
+	rsd     *remotexpc.ServiceDirectory
+	rsdHost string
+	// the CoreDeviceProxy tunnel RemoteServiceDiscovery is reached through, if any
+	tunnel      *deviceTunnel
+	syslogRelay *service
+	pcapd       *service
+	perfd       []Perfd
 }
 
 func (d *device) Properties() DeviceProperties {
+	d.propertiesMu.Lock()
+	defer d.propertiesMu.Unlock()
 	return *d.properties
 }
 
+// deviceID the DeviceID usbmuxd gave the device
+func (d *device) deviceID() int {
+	d.propertiesMu.Lock()
+	defer d.propertiesMu.Unlock()
+	return d.properties.DeviceID
+}
+
+func (d *device) setDeviceID(id int) {
+	d.propertiesMu.Lock()
+	defer d.propertiesMu.Unlock()
+	d.properties.DeviceID = id
+}
+
 func (d *device) NewConnect(port int, timeout ...time.Duration) (InnerConn, error) {
+	return d.NewConnectContext(context.Background(), port, timeout...)
+}
+
+func (d *device) NewConnectContext(ctx context.Context, port int, timeout ...time.Duration) (InnerConn, error) {
+	if d.networkHost != "" {
+		if port == LockdownPort {
+			port = d.lockdownPort
+		}
+		return libimobiledevice.NewNetworkConnContext(ctx, net.JoinHostPort(d.networkHost, strconv.Itoa(port)), timeout...)
+	}
 
-	newClient, err := libimobiledevice.NewUsbmuxClient(d.remoteAddr, timeout...)
+	newClient, err := d.newUsbmuxClient(ctx, timeout...)
 	if err != nil {
 		return nil, err
 	}
+	release := newClient.InnerConn().BindContext(ctx)
+	defer release()
 
 	if d.remoteAddr != "" {
 		clientConnectInit(newClient.InnerConn())
@@ -124,7 +194,7 @@ func (d *device) NewConnect(port int, timeout ...time.Duration) (InnerConn, erro
 
 	var pkt libimobiledevice.Packet
 	if pkt, err = newClient.NewPlistPacket(
-		newClient.NewConnectRequest(d.properties.DeviceID, port),
+		newClient.NewConnectRequest(d.deviceID(), port),
 	); err != nil {
 		newClient.Close()
 		return nil, err
@@ -143,305 +213,449 @@ func (d *device) NewConnect(port int, timeout ...time.Duration) (InnerConn, erro
 	return newClient.InnerConn(), err
 }
 
-func (d *device) ReadPairRecord() (pairRecord *PairRecord, err error) {
-	var pkt libimobiledevice.Packet
-	if pkt, err = d.umClient.NewPlistPacket(
-		d.umClient.NewReadPairRecordRequest(d.properties.SerialNumber),
-	); err != nil {
-		return nil, err
+// usbmuxClient the connection to usbmuxd the device was found on, else one dialed
+// for the caller, closed by release
+func (d *device) usbmuxClient(ctx context.Context) (client *libimobiledevice.UsbmuxClient, release func(), err error) {
+	if d.umClient != nil {
+		return d.umClient, func() {}, nil
 	}
-
-	if err = d.umClient.SendPacket(pkt); err != nil {
-		return nil, err
+	if d.dialUsbmux == nil {
+		return nil, nil, errNoUsbmuxd
 	}
-
-	var respPkt libimobiledevice.Packet
-	if respPkt, err = d.umClient.ReceivePacket(); err != nil {
-		return nil, err
+	if client, err = d.dialUsbmux(ctx); err != nil {
+		return nil, nil, err
 	}
+	return client, func() { client.Close() }, nil
+}
 
-	var reply = struct {
-		Data []byte `plist:"PairRecordData"`
-	}{}
-	if err = respPkt.Unmarshal(&reply); err != nil {
-		return nil, err
+func (d *device) newUsbmuxClient(ctx context.Context, timeout ...time.Duration) (*libimobiledevice.UsbmuxClient, error) {
+	if d.dialUsbmux != nil {
+		return d.dialUsbmux(ctx, timeout...)
 	}
+	return libimobiledevice.NewUsbmuxClientContext(ctx, d.remoteAddr, timeout...)
+}
 
-	var record PairRecord
-	if _, err = plist.Unmarshal(reply.Data, &record); err != nil {
-		return nil, err
+// SetPairRecordStore selects where this device's pair record is kept, nil is usbmuxd
+func (d *device) SetPairRecordStore(store PairRecordStore) {
+	d.pairRecordStore = store
+}
+
+func (d *device) pairRecords() PairRecordStore {
+	if d.pairRecordStore == nil {
+		return &usbmuxdPairRecordStore{dev: d}
 	}
+	return d.pairRecordStore
+}
 
-	pairRecord = &record
-	return
+func (d *device) ReadPairRecord() (pairRecord *PairRecord, err error) {
+	return d.pairRecords().ReadPairRecord(d.properties.SerialNumber)
 }
 
 func (d *device) SavePairRecord(pairRecord *PairRecord) (err error) {
-	var data []byte
-	if data, err = plist.Marshal(pairRecord, plist.XMLFormat); err != nil {
-		return err
-	}
+	return d.pairRecords().SavePairRecord(d.properties.SerialNumber, pairRecord)
+}
 
-	var pkt libimobiledevice.Packet
-	if pkt, err = d.umClient.NewPlistPacket(
-		d.umClient.NewSavePairRecordRequest(d.properties.SerialNumber, d.properties.DeviceID, data),
-	); err != nil {
-		return err
-	}
+func (d *device) DeletePairRecord() (err error) {
+	return d.pairRecords().DeletePairRecord(d.properties.SerialNumber)
+}
 
-	if err = d.umClient.SendPacket(pkt); err != nil {
-		return err
-	}
+func (d *device) lockdownService() (lockdown Lockdown, err error) {
+	return d.lockdownServiceContext(context.Background())
+}
 
-	if _, err = d.umClient.ReceivePacket(); err != nil {
-		return err
-	}
+// interrupted reports whether err came from ctx, the connection gives up at
+// the deadline a moment before ctx.Err() is set
+func interrupted(ctx context.Context, err error) bool {
+	return err != nil && (ctx.Err() != nil ||
+		errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled))
+}
 
-	return
+// lockdownServiceContext connects to lockdownd, services started through it give up when ctx is done
+func (d *device) lockdownServiceContext(ctx context.Context) (lockdown Lockdown, err error) {
+	return d.newLockdownContext(ctx)
 }
 
-func (d *device) DeletePairRecord() (err error) {
-	var pkt libimobiledevice.Packet
-	if pkt, err = d.umClient.NewPlistPacket(
-		d.umClient.NewDeletePairRecordRequest(d.properties.SerialNumber),
-	); err != nil {
-		return err
+// newLockdownContext a lockdown over a connection of its own, for the caller to close
+func (d *device) newLockdownContext(ctx context.Context) (lockdown *lockdown, err error) {
+	d.mu.Lock()
+	closed := d.closed
+	d.mu.Unlock()
+	if closed {
+		return nil, ErrDeviceClosed
 	}
 
-	if err = d.umClient.SendPacket(pkt); err != nil {
-		return err
+	var innerConn InnerConn
+	if innerConn, err = d.NewConnectContext(ctx, LockdownPort, 0); err != nil {
+		return nil, err
 	}
+	lockdown = newLockdown(d, libimobiledevice.NewLockdownClient(innerConn))
+	lockdown.ctx = ctx
 
-	if _, err = d.umClient.ReceivePacket(); err != nil {
-		return err
+	release := innerConn.BindContext(ctx)
+	defer release()
+	if _, err = lockdown._getProductVersion(); err != nil {
+		innerConn.Close()
+		return nil, err
 	}
-
 	return
 }
 
-func (d *device) lockdownService() (lockdown Lockdown, err error) {
-	// if d.lockdown != nil {
-	// 	return d.lockdown, nil
-	// }
+func (d *device) QueryType() (LockdownType, error) {
+	lockdown, err := d.newLockdownContext(context.Background())
+	if err != nil {
+		return LockdownType{}, err
+	}
+	defer lockdown.close()
+	return lockdown.QueryType()
+}
 
-	var innerConn InnerConn
-	if innerConn, err = d.NewConnect(LockdownPort, 0); err != nil {
+func (d *device) GetValue(domain, key string) (v interface{}, err error) {
+	return d.GetValueContext(context.Background(), domain, key)
+}
+
+func (d *device) GetValueContext(ctx context.Context, domain, key string) (v interface{}, err error) {
+	var lockdown *lockdown
+	if lockdown, err = d.newLockdownContext(ctx); err != nil {
 		return nil, err
 	}
-	d.lockdownClient = libimobiledevice.NewLockdownClient(innerConn)
-	d.lockdown = newLockdown(d)
-	_, err = d.lockdown._getProductVersion()
-	lockdown = d.lockdown
-	return
+	defer lockdown.close()
+	return lockdown.sessionGetValue(ctx, domain, key)
 }
 
-func (d *device) QueryType() (LockdownType, error) {
-	if _, err := d.lockdownService(); err != nil {
-		return LockdownType{}, err
+func (d *device) Pair() (pairRecord *PairRecord, err error) {
+	var lockdown *lockdown
+	if lockdown, err = d.newLockdownContext(context.Background()); err != nil {
+		return nil, err
 	}
-	return d.lockdown.QueryType()
+	defer lockdown.close()
+	return lockdown.Pair()
 }
 
-func (d *device) GetValue(domain, key string) (v interface{}, err error) {
-	if _, err = d.lockdownService(); err != nil {
+func (d *device) PairWithOptions(ctx context.Context, opts ...PairOption) (pairRecord *PairRecord, err error) {
+	opt := defaultPairOption()
+	for _, fn := range opts {
+		fn(opt)
+	}
+
+	var lockdown *lockdown
+	if lockdown, err = d.newLockdownContext(ctx); err != nil {
 		return nil, err
 	}
-	if d.lockdown.pairRecord == nil {
-		if err = d.lockdown.handshake(); err != nil {
+	defer lockdown.close()
+	release := lockdown.client.InnerConn().BindContext(ctx)
+	defer release()
+
+	if pairRecord, err = lockdown.newPairRecord(opt.identity); err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(opt.timeout)
+	for {
+		if opt.supervisorCert != nil {
+			err = lockdown.pairSupervised(pairRecord, opt.supervisorCert, opt.supervisorKey)
+		} else {
+			err = lockdown.pair(pairRecord)
+		}
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, ErrPairingDialogResponsePending) || time.Now().Add(opt.interval).After(deadline) {
 			return nil, err
 		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(opt.interval):
+		}
 	}
-	if err = d.lockdown.startSession(d.lockdown.pairRecord); err != nil {
-		return nil, err
-	}
-	if v, err = d.lockdown.GetValue(domain, key); err != nil {
+
+	if err = d.SavePairRecord(pairRecord); err != nil {
 		return nil, err
 	}
-	err = d.lockdown.stopSession()
 	return
 }
 
-func (d *device) Pair() (pairRecord *PairRecord, err error) {
-	if _, err = d.lockdownService(); err != nil {
-		return nil, err
+func (d *device) ValidatePair() (err error) {
+	var pairRecord *PairRecord
+	if pairRecord, err = d.ReadPairRecord(); err != nil {
+		return err
+	}
+	var lockdown *lockdown
+	if lockdown, err = d.newLockdownContext(context.Background()); err != nil {
+		return err
 	}
-	return d.lockdown.Pair()
+	defer lockdown.close()
+	return lockdown.ValidatePair(pairRecord)
 }
 
-func (d *device) imageMounterService() (imageMounter ImageMounter, err error) {
-	if d.imageMounter != nil {
-		return d.imageMounter, nil
+func (d *device) Unpair() (err error) {
+	var pairRecord *PairRecord
+	if pairRecord, err = d.ReadPairRecord(); err != nil {
+		return err
 	}
-	if _, err = d.lockdownService(); err != nil {
-		return nil, err
+	var lockdown *lockdown
+	if lockdown, err = d.newLockdownContext(context.Background()); err != nil {
+		return err
 	}
-	if d.imageMounter, err = d.lockdown.ImageMounterService(); err != nil {
+	defer lockdown.close()
+	if err = lockdown.Unpair(pairRecord); err != nil {
+		return err
+	}
+	return d.DeletePairRecord()
+}
+
+func (d *device) imageMounterService() (imageMounter ImageMounter, err error) {
+	var svc interface{}
+	if svc, err = d.shareService(context.Background(), libimobiledevice.ImageMounterServiceName, func(lockdown *lockdown) (interface{}, error) {
+		return lockdown.ImageMounterService()
+	}); err != nil {
 		return nil, err
 	}
-	imageMounter = d.imageMounter
-	return
+	return svc.(ImageMounter), nil
 }
 
 func (d *device) Images(imgType ...string) (imageSignatures [][]byte, err error) {
-	if _, err = d.imageMounterService(); err != nil {
+	var imageMounter ImageMounter
+	if imageMounter, err = d.imageMounterService(); err != nil {
 		return nil, err
 	}
 	if len(imgType) == 0 {
 		imgType = []string{"Developer"}
 	}
-	return d.imageMounter.Images(imgType[0])
+	return imageMounter.Images(imgType[0])
 }
 
 func (d *device) MountDeveloperDiskImage(dmgPath string, signaturePath string) (err error) {
-	if _, err = d.imageMounterService(); err != nil {
+	var imageMounter ImageMounter
+	if imageMounter, err = d.imageMounterService(); err != nil {
 		return err
 	}
 	devImgPath := "/private/var/mobile/Media/PublicStaging/staging.dimage"
-	return d.imageMounter.UploadImageAndMount("Developer", devImgPath, dmgPath, signaturePath)
+	return imageMounter.UploadImageAndMount("Developer", devImgPath, dmgPath, signaturePath)
+}
+
+func startScreenshot(lockdown *lockdown) (interface{}, error) {
+	return lockdown.ScreenshotService()
 }
 
 func (d *device) screenshotService() (screenshot Screenshot, err error) {
-	if d.screenshot != nil {
-		return d.screenshot, nil
-	}
+	return d.screenshotServiceContext(context.Background())
+}
 
-	if _, err = d.lockdownService(); err != nil {
+func (d *device) screenshotServiceContext(ctx context.Context) (screenshot Screenshot, err error) {
+	var svc interface{}
+	if svc, err = d.shareService(ctx, libimobiledevice.ScreenshotServiceName, startScreenshot); err != nil {
 		return nil, err
 	}
-	if d.screenshot, err = d.lockdown.ScreenshotService(); err != nil {
-		return nil, err
-	}
-	screenshot = d.screenshot
-	return
+	return svc.(Screenshot), nil
 }
 
 func (d *device) Screenshot() (raw *bytes.Buffer, err error) {
-	if _, err = d.screenshotService(); err != nil {
+	return d.ScreenshotContext(context.Background())
+}
+
+func (d *device) ScreenshotContext(ctx context.Context) (raw *bytes.Buffer, err error) {
+	var s *service
+	if s, err = d.borrowService(ctx, libimobiledevice.ScreenshotServiceName, startScreenshot); err != nil {
 		return nil, err
 	}
-	return d.screenshot.Take()
+	// the interrupted connection is out of sync, it is closed as it is put back
+	defer d.services.put(s)
+	return s.svc.(Screenshot).TakeContext(ctx)
+}
+
+func startSimulateLocation(lockdown *lockdown) (interface{}, error) {
+	return lockdown.SimulateLocationService()
 }
 
 func (d *device) simulateLocationService() (simulateLocation SimulateLocation, err error) {
-	if d.simulateLocation != nil {
-		return d.simulateLocation, nil
-	}
-	if _, err = d.lockdownService(); err != nil {
+	var svc interface{}
+	if svc, err = d.shareService(context.Background(), libimobiledevice.SimulateLocationServiceName, startSimulateLocation); err != nil {
 		return nil, err
 	}
-	if d.simulateLocation, err = d.lockdown.SimulateLocationService(); err != nil {
-		return nil, err
-	}
-	simulateLocation = d.simulateLocation
-	return
+	return svc.(SimulateLocation), nil
 }
 
 func (d *device) SimulateLocationUpdate(longitude float64, latitude float64, coordinateSystem ...CoordinateSystem) (err error) {
-	if _, err = d.simulateLocationService(); err != nil {
+	var s *service
+	if s, err = d.borrowService(context.Background(), libimobiledevice.SimulateLocationServiceName, startSimulateLocation); err != nil {
 		return err
 	}
-	return d.simulateLocation.Update(longitude, latitude, coordinateSystem...)
+	defer d.services.put(s)
+	return s.svc.(SimulateLocation).Update(longitude, latitude, coordinateSystem...)
 }
 
 func (d *device) SimulateLocationRecover() (err error) {
-	if _, err = d.simulateLocationService(); err != nil {
+	var s *service
+	if s, err = d.borrowService(context.Background(), libimobiledevice.SimulateLocationServiceName, startSimulateLocation); err != nil {
 		return err
 	}
-	return d.simulateLocation.Recover()
+	defer d.services.put(s)
+	return s.svc.(SimulateLocation).Recover()
+}
+
+func startInstallationProxy(lockdown *lockdown) (interface{}, error) {
+	return lockdown.InstallationProxyService()
 }
 
 func (d *device) installationProxyService() (installationProxy InstallationProxy, err error) {
-	if d.installationProxy != nil {
-		return d.installationProxy, nil
-	}
-	if _, err = d.lockdownService(); err != nil {
+	return d.installationProxyServiceContext(context.Background())
+}
+
+func (d *device) installationProxyServiceContext(ctx context.Context) (installationProxy InstallationProxy, err error) {
+	var svc interface{}
+	if svc, err = d.shareService(ctx, libimobiledevice.InstallationProxyServiceName, startInstallationProxy); err != nil {
 		return nil, err
 	}
-	if d.installationProxy, err = d.lockdown.InstallationProxyService(); err != nil {
-		return nil, err
+	return svc.(InstallationProxy), nil
+}
+
+// withInstallationProxy calls fn with an installation proxy no one else uses meanwhile
+func (d *device) withInstallationProxy(ctx context.Context, fn func(installationProxy InstallationProxy) error) (err error) {
+	var s *service
+	if s, err = d.borrowService(ctx, libimobiledevice.InstallationProxyServiceName, startInstallationProxy); err != nil {
+		return err
 	}
-	installationProxy = d.installationProxy
-	return
+	defer d.services.put(s)
+	return fn(s.svc.(InstallationProxy))
 }
 
 func (d *device) InstallationProxyBrowse(opts ...InstallationProxyOption) (currentList []interface{}, err error) {
-	if _, err = d.installationProxyService(); err != nil {
-		return nil, err
-	}
-	return d.installationProxy.Browse(opts...)
+	return d.InstallationProxyBrowseContext(context.Background(), opts...)
+}
+
+func (d *device) InstallationProxyBrowseContext(ctx context.Context, opts ...InstallationProxyOption) (currentList []interface{}, err error) {
+	err = d.withInstallationProxy(ctx, func(installationProxy InstallationProxy) (err error) {
+		currentList, err = installationProxy.BrowseContext(ctx, opts...)
+		return
+	})
+	return
 }
 
 func (d *device) InstallationProxyLookup(opts ...InstallationProxyOption) (lookupResult interface{}, err error) {
-	if _, err = d.installationProxyService(); err != nil {
-		return nil, err
-	}
-	return d.installationProxy.Lookup(opts...)
+	return d.InstallationProxyLookupContext(context.Background(), opts...)
+}
+
+func (d *device) InstallationProxyLookupContext(ctx context.Context, opts ...InstallationProxyOption) (lookupResult interface{}, err error) {
+	err = d.withInstallationProxy(ctx, func(installationProxy InstallationProxy) (err error) {
+		lookupResult, err = installationProxy.LookupContext(ctx, opts...)
+		return
+	})
+	return
+}
+
+func startInstruments(lockdown *lockdown) (interface{}, error) {
+	return lockdown.InstrumentsService()
 }
 
 func (d *device) newInstrumentsService() (instruments Instruments, err error) {
+	return d.newInstrumentsServiceContext(context.Background())
+}
+
+func (d *device) newInstrumentsServiceContext(ctx context.Context) (instruments Instruments, err error) {
 	// NOTICE: each instruments service should have individual connection, otherwise it will be blocked
-	if _, err = d.lockdownService(); err != nil {
+	var lockdown *lockdown
+	if lockdown, err = d.newLockdownContext(ctx); err != nil {
 		return
 	}
-	return d.lockdown.InstrumentsService()
+	defer lockdown.close()
+	return lockdown.InstrumentsService()
 }
 
 func (d *device) instrumentsService() (instruments Instruments, err error) {
-	if d.instruments != nil {
-		return d.instruments, nil
-	}
-	if d.instruments, err = d.newInstrumentsService(); err != nil {
+	return d.instrumentsServiceContext(context.Background())
+}
+
+func (d *device) instrumentsServiceContext(ctx context.Context) (instruments Instruments, err error) {
+	var svc interface{}
+	if svc, err = d.shareService(ctx, libimobiledevice.InstrumentsServiceName, startInstruments); err != nil {
 		return nil, err
 	}
-	instruments = d.instruments
-	return
+	return svc.(Instruments), nil
 }
 
-func (d *device) AppLaunch(bundleID string, opts ...AppLaunchOption) (pid int, err error) {
-	if _, err = d.instrumentsService(); err != nil {
-		return 0, err
+// withInstruments calls fn with an instruments service no one else uses meanwhile
+func (d *device) withInstruments(ctx context.Context, fn func(instruments Instruments) error) (err error) {
+	var s *service
+	if s, err = d.borrowService(ctx, libimobiledevice.InstrumentsServiceName, startInstruments); err != nil {
+		return err
 	}
-	return d.instruments.AppLaunch(bundleID, opts...)
+	defer d.services.put(s)
+	return fn(s.svc.(Instruments))
+}
+
+func (d *device) AppLaunch(bundleID string, opts ...AppLaunchOption) (pid int, err error) {
+	return d.AppLaunchContext(context.Background(), bundleID, opts...)
+}
+
+func (d *device) AppLaunchContext(ctx context.Context, bundleID string, opts ...AppLaunchOption) (pid int, err error) {
+	err = d.withInstruments(ctx, func(instruments Instruments) (err error) {
+		pid, err = instruments.AppLaunchContext(ctx, bundleID, opts...)
+		return
+	})
+	return
 }
 
 func (d *device) AppKill(pid int) (err error) {
-	if _, err = d.instrumentsService(); err != nil {
-		return err
-	}
-	return d.instruments.AppKill(pid)
+	return d.AppKillContext(context.Background(), pid)
+}
+
+func (d *device) AppKillContext(ctx context.Context, pid int) (err error) {
+	return d.withInstruments(ctx, func(instruments Instruments) error {
+		return instruments.AppKillContext(ctx, pid)
+	})
 }
 
 func (d *device) AppRunningProcesses() (processes []Process, err error) {
-	if _, err = d.instrumentsService(); err != nil {
-		return nil, err
-	}
-	return d.instruments.AppRunningProcesses()
+	return d.AppRunningProcessesContext(context.Background())
+}
+
+func (d *device) AppRunningProcessesContext(ctx context.Context) (processes []Process, err error) {
+	err = d.withInstruments(ctx, func(instruments Instruments) (err error) {
+		processes, err = instruments.AppRunningProcessesContext(ctx)
+		return
+	})
+	return
 }
 
 func (d *device) AppList(opts ...AppListOption) (apps []Application, err error) {
-	if _, err = d.instrumentsService(); err != nil {
-		return nil, err
-	}
-	return d.instruments.AppList(opts...)
+	return d.AppListContext(context.Background(), opts...)
+}
+
+func (d *device) AppListContext(ctx context.Context, opts ...AppListOption) (apps []Application, err error) {
+	err = d.withInstruments(ctx, func(instruments Instruments) (err error) {
+		apps, err = instruments.AppListContext(ctx, opts...)
+		return
+	})
+	return
 }
 
 func (d *device) DeviceInfo() (devInfo *DeviceInfo, err error) {
-	if _, err = d.instrumentsService(); err != nil {
-		return nil, err
-	}
-	return d.instruments.DeviceInfo()
+	return d.DeviceInfoContext(context.Background())
+}
+
+func (d *device) DeviceInfoContext(ctx context.Context) (devInfo *DeviceInfo, err error) {
+	err = d.withInstruments(ctx, func(instruments Instruments) (err error) {
+		devInfo, err = instruments.DeviceInfoContext(ctx)
+		return
+	})
+	return
 }
 
 func (d *device) testmanagerdService() (testmanagerd Testmanagerd, err error) {
-	if _, err = d.lockdownService(); err != nil {
-		return nil, err
-	}
-	if testmanagerd, err = d.lockdown.TestmanagerdService(); err != nil {
+	return d.testmanagerdServiceContext(context.Background())
+}
+
+func (d *device) testmanagerdServiceContext(ctx context.Context) (testmanagerd Testmanagerd, err error) {
+	var lockdown *lockdown
+	if lockdown, err = d.newLockdownContext(ctx); err != nil {
 		return nil, err
 	}
-	return
+	defer lockdown.close()
+	return lockdown.TestmanagerdService()
 }
 
+// Deprecated: use NewUsbmuxServer.
 func (d *device) Share(port int) error {
 
 	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
@@ -542,44 +756,67 @@ func (d *device) shareServer(ln net.Listener) error {
 }
 
 func (d *device) AmfiService() (amfi Amfi, err error) {
-	if d.amfi != nil {
-		return d.amfi, nil
-	}
-	if _, err = d.lockdownService(); err != nil {
-		return nil, err
-	}
-	if d.amfi, err = d.lockdown.AmfiService(); err != nil {
+	var svc interface{}
+	if svc, err = d.shareService(context.Background(), libimobiledevice.AmfiServiceName, func(lockdown *lockdown) (interface{}, error) {
+		return lockdown.AmfiService()
+	}); err != nil {
 		return nil, err
 	}
-	amfi = d.amfi
-	return amfi, nil
+	return svc.(Amfi), nil
+}
+
+func startAfc(lockdown *lockdown) (interface{}, error) {
+	return lockdown.AfcService()
 }
 
 func (d *device) AfcService() (afc Afc, err error) {
-	if d.afc != nil {
-		return d.afc, nil
-	}
-	if _, err = d.lockdownService(); err != nil {
+	return d.AfcServiceContext(context.Background())
+}
+
+func (d *device) AfcServiceContext(ctx context.Context) (afc Afc, err error) {
+	var svc interface{}
+	if svc, err = d.shareService(ctx, libimobiledevice.AfcServiceName, startAfc); err != nil {
 		return nil, err
 	}
-	if d.afc, err = d.lockdown.AfcService(); err != nil {
+	return svc.(Afc), nil
+}
+
+func (d *device) StartAfc(ctx context.Context) (afc Afc, err error) {
+	var s *service
+	if s, err = d.startServiceContext(ctx, libimobiledevice.AfcServiceName, startAfc); err != nil {
 		return nil, err
 	}
-	afc = d.afc
-	return
+	return s.svc.(Afc), nil
+}
+
+func (d *device) AfcPool(ctx context.Context, n int) (pool AfcPool, err error) {
+	return NewAfcPool(n, func() (Afc, error) {
+		return d.StartAfc(ctx)
+	})
 }
 
 func (d *device) AppInstall(ipaPath string) (err error) {
-	if _, err = d.AfcService(); err != nil {
+	return d.AppInstallContext(context.Background(), ipaPath)
+}
+
+func (d *device) AppInstallContext(ctx context.Context, ipaPath string) (err error) {
+	var s *service
+	if s, err = d.borrowService(ctx, libimobiledevice.AfcServiceName, startAfc); err != nil {
 		return err
 	}
+	defer d.services.put(s)
+	afc := s.svc.(Afc)
+	if binder, ok := afc.(contextBinder); ok {
+		release := binder.bindContext(ctx)
+		defer release()
+	}
 
 	stagingPath := "PublicStaging"
-	if _, err = d.afc.Stat(stagingPath); err != nil {
-		if err != ErrAfcStatNotExist {
+	if _, err = afc.Stat(stagingPath); err != nil {
+		if !errors.Is(err, ErrAfcStatNotExist) {
 			return err
 		}
-		if err = d.afc.Mkdir(stagingPath); err != nil {
+		if err = afc.Mkdir(stagingPath); err != nil {
 			return fmt.Errorf("app install: %w", err)
 		}
 	}
@@ -595,240 +832,245 @@ func (d *device) AppInstall(ipaPath string) (err error) {
 
 	installationPath := path.Join(stagingPath, fmt.Sprintf("%s.ipa", bundleID))
 
-	var data []byte
-	if data, err = os.ReadFile(ipaPath); err != nil {
-		return err
-	}
-	if err = d.afc.WriteFile(installationPath, data, AfcFileModeWr); err != nil {
-		return err
-	}
-
-	if _, err = d.installationProxyService(); err != nil {
-		return err
+	// staged whole, without the file hashes not every device answers
+	if err = afc.Push(ipaPath, installationPath, WithAfcResume(false), WithAfcVerify(false)); err != nil {
+		return fmt.Errorf("app install: %w", err)
 	}
 
-	return d.installationProxy.Install(fmt.Sprintf("%s", bundleID), installationPath)
+	return d.withInstallationProxy(ctx, func(installationProxy InstallationProxy) error {
+		return installationProxy.InstallContext(ctx, fmt.Sprintf("%s", bundleID), installationPath)
+	})
 }
 
 func (d *device) AppUninstall(bundleID string) (err error) {
-	if _, err = d.installationProxyService(); err != nil {
-		return err
-	}
+	return d.AppUninstallContext(context.Background(), bundleID)
+}
+
+func (d *device) AppUninstallContext(ctx context.Context, bundleID string) (err error) {
+	return d.withInstallationProxy(ctx, func(installationProxy InstallationProxy) error {
+		return installationProxy.UninstallContext(ctx, bundleID)
+	})
+}
 
-	return d.installationProxy.Uninstall(bundleID)
+func startHouseArrest(lockdown *lockdown) (interface{}, error) {
+	return lockdown.HouseArrestService()
 }
 
-func (d *device) HouseArrestService() (houseArrest HouseArrest, err error) {
-	if d.houseArrest != nil {
-		return d.houseArrest, nil
-	}
-	if _, err = d.lockdownService(); err != nil {
+func (d *device) StartHouseArrest(ctx context.Context) (houseArrest HouseArrest, err error) {
+	var s *service
+	if s, err = d.startServiceContext(ctx, libimobiledevice.HouseArrestServiceName, startHouseArrest); err != nil {
 		return nil, err
 	}
-	if d.houseArrest, err = d.lockdown.HouseArrestService(); err != nil {
+	return s.svc.(HouseArrest), nil
+}
+
+func (d *device) HouseArrestService() (houseArrest HouseArrest, err error) {
+	var svc interface{}
+	if svc, err = d.shareService(context.Background(), libimobiledevice.HouseArrestServiceName, startHouseArrest); err != nil {
 		return nil, err
 	}
-	houseArrest = d.houseArrest
-	return
+	return svc.(HouseArrest), nil
 }
 
-func (d *device) MisagentService() (Misagent, error) {
-	if d.misagent != nil {
-		return d.misagent, nil
-	}     
-	if _, err := d.lockdownService(); err != nil {
+func (d *device) MisagentService() (misagent Misagent, err error) {
+	var svc interface{}
+	if svc, err = d.shareService(context.Background(), libimobiledevice.MisagentServiceName, func(lockdown *lockdown) (interface{}, error) {
+		return lockdown.MisagentService(lockdown.version.Original())
+	}); err != nil {
 		return nil, err
-	}     
-	strProductVersion := ""
-	if varProductVersion, errPV := d.GetValue("", "ProductVersion"); errPV == nil {
-		switch varProductVersion.(type) {
-		case string:
-			strProductVersion = varProductVersion.(string)
-		}     
-	}     
-	if svc, err := d.lockdown.MisagentService(strProductVersion); err != nil {
-		return nil, err
-	} else {
-		d.misagent = svc
-		return d.misagent, nil
-	}     
+	}
+	return svc.(Misagent), nil
 }
 
 func (d *device) syslogRelayService() (syslogRelay SyslogRelay, err error) {
-	if d.syslogRelay != nil {
-		return d.syslogRelay, nil
-	}
-	if _, err = d.lockdownService(); err != nil {
+	d.mu.Lock()
+	s := d.syslogRelay
+	d.mu.Unlock()
+	if s != nil && s.healthy() {
+		return s.svc.(SyslogRelay), nil
+	}
+	if s, err = d.startServiceContext(context.Background(), libimobiledevice.SyslogRelayServiceName, func(lockdown *lockdown) (interface{}, error) {
+		return lockdown.SyslogRelayService()
+	}); err != nil {
 		return nil, err
 	}
-	if d.syslogRelay, err = d.lockdown.SyslogRelayService(); err != nil {
-		return nil, err
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		s.close()
+		return nil, ErrDeviceClosed
 	}
-	syslogRelay = d.syslogRelay
-	return
+	d.syslogRelay = s
+	return s.svc.(SyslogRelay), nil
 }
 
 func (d *device) Syslog() (lines <-chan string, err error) {
-	if _, err = d.syslogRelayService(); err != nil {
+	var syslogRelay SyslogRelay
+	if syslogRelay, err = d.syslogRelayService(); err != nil {
 		return nil, err
 	}
-	return d.syslogRelay.Lines(), nil
+	return syslogRelay.Lines(), nil
 }
 
 func (d *device) SyslogStop() {
-	if d.syslogRelay == nil {
+	d.mu.Lock()
+	s := d.syslogRelay
+	d.mu.Unlock()
+	if s == nil {
 		return
 	}
-	d.syslogRelay.Stop()
+	s.svc.(SyslogRelay).Stop()
 }
 
-func (d *device) Reboot() (err error) {
-	if _, err = d.lockdownService(); err != nil {
-		return
-	}
-	if d.diagnosticsRelay, err = d.lockdown.DiagnosticsRelayService(); err != nil {
-		return
-	}
-	if err = d.diagnosticsRelay.Reboot(); err != nil {
-		return
+func startDiagnosticsRelay(lockdown *lockdown) (interface{}, error) {
+	return lockdown.DiagnosticsRelayService()
+}
+
+// withDiagnosticsRelay calls fn with a diagnostics relay no one else uses meanwhile
+func (d *device) withDiagnosticsRelay(fn func(diagnosticsRelay DiagnosticsRelay) error) (err error) {
+	var s *service
+	if s, err = d.borrowService(context.Background(), libimobiledevice.DiagnosticsRelayServiceName, startDiagnosticsRelay); err != nil {
+		return err
 	}
-	return
+	defer d.services.put(s)
+	return fn(s.svc.(DiagnosticsRelay))
+}
+
+func (d *device) Reboot() (err error) {
+	return d.withDiagnosticsRelay(func(diagnosticsRelay DiagnosticsRelay) error {
+		return diagnosticsRelay.Reboot()
+	})
 }
 
-func (d *device) EnterRecovery() error {
-	if _, err := d.lockdownService(); err != nil {
+func (d *device) EnterRecovery() (err error) {
+	var lockdown *lockdown
+	if lockdown, err = d.newLockdownContext(context.Background()); err != nil {
 		return err
-	}     
-	return d.lockdown.EnterRecovery()
+	}
+	defer lockdown.close()
+	return lockdown.EnterRecovery()
 }
 
 func (d *device) PowerSource() (powerInfo map[string]interface{}, err error) {
-	if _, err = d.lockdownService(); err != nil {
-		return
-	}
-	if d.diagnosticsRelay, err = d.lockdown.DiagnosticsRelayService(); err != nil {
-		return
-	}
-	if powerInfo, err = d.diagnosticsRelay.PowerSource(); err != nil {
+	err = d.withDiagnosticsRelay(func(diagnosticsRelay DiagnosticsRelay) (err error) {
+		powerInfo, err = diagnosticsRelay.PowerSource()
 		return
-	}
+	})
 	return
 }
 
 func (d *device) Shutdown() (err error) {
-	if _, err = d.lockdownService(); err != nil {
-		return
-	}
-	if d.diagnosticsRelay, err = d.lockdown.DiagnosticsRelayService(); err != nil {
-		return
-	}
-	if err = d.diagnosticsRelay.Shutdown(); err != nil {
-		return
-	}
-	return
+	return d.withDiagnosticsRelay(func(diagnosticsRelay DiagnosticsRelay) error {
+		return diagnosticsRelay.Shutdown()
+	})
+}
+
+func startSpringBoard(lockdown *lockdown) (interface{}, error) {
+	return lockdown.SpringBoardService()
 }
 
 func (d *device) springBoardService() (springBoard SpringBoard, err error) {
-	if d.springBoard != nil {
-		return d.springBoard, nil
-	}
-	if _, err = d.lockdownService(); err != nil {
+	var svc interface{}
+	if svc, err = d.shareService(context.Background(), libimobiledevice.SpringBoardServiceName, startSpringBoard); err != nil {
 		return nil, err
 	}
-	if d.springBoard, err = d.lockdown.SpringBoardService(); err != nil {
-		return nil, err
+	return svc.(SpringBoard), nil
+}
+
+// withSpringBoard calls fn with a springboard service no one else uses meanwhile
+func (d *device) withSpringBoard(fn func(springBoard SpringBoard) error) (err error) {
+	var s *service
+	if s, err = d.borrowService(context.Background(), libimobiledevice.SpringBoardServiceName, startSpringBoard); err != nil {
+		return err
 	}
-	springBoard = d.springBoard
-	return
+	defer d.services.put(s)
+	return fn(s.svc.(SpringBoard))
 }
 
 func (d *device) GetIconPNGData(bundleId string) (raw *bytes.Buffer, err error) {
-	if _, err = d.lockdownService(); err != nil {
-		return
-	}
-	if d.springBoard, err = d.lockdown.SpringBoardService(); err != nil {
-		return
-	}
-	if raw, err = d.springBoard.GetIconPNGData(bundleId); err != nil {
+	err = d.withSpringBoard(func(springBoard SpringBoard) (err error) {
+		raw, err = springBoard.GetIconPNGData(bundleId)
 		return
-	}
+	})
 	return
 }
 
 func (d *device) GetInterfaceOrientation() (orientation libimobiledevice.OrientationState, err error) {
-	if _, err = d.springBoardService(); err != nil {
+	err = d.withSpringBoard(func(springBoard SpringBoard) (err error) {
+		orientation, err = springBoard.GetInterfaceOrientation()
 		return
-	}
-	if orientation, err = d.springBoard.GetInterfaceOrientation(); err != nil {
-		return
-	}
+	})
 	return
 }
 
 func (d *device) WebInspectorService() (webInspector WebInspector, err error) {
-	if d.webInspector != nil {
-		return d.webInspector, nil
-	}
-	if _, err = d.lockdownService(); err != nil {
+	var svc interface{}
+	if svc, err = d.shareService(context.Background(), libimobiledevice.WebInspectorServiceName, func(lockdown *lockdown) (interface{}, error) {
+		return lockdown.WebInspectorService()
+	}); err != nil {
 		return nil, err
 	}
-	if d.webInspector, err = d.lockdown.WebInspectorService(); err != nil {
-		return nil, err
-	}
-	webInspector = d.webInspector
-	return
+	return svc.(WebInspector), nil
 }
 
 func (d *device) PcapdService() (pcapd Pcapd, err error) {
-	// if d.pcapd != nil {
-	// 	return d.pcapd, nil
-	// }
-	if _, err = d.lockdownService(); err != nil {
+	var s *service
+	if s, err = d.startServiceContext(context.Background(), libimobiledevice.PcapdServiceName, func(lockdown *lockdown) (interface{}, error) {
+		return lockdown.PcapdService()
+	}); err != nil {
 		return nil, err
 	}
-	if d.pcapd, err = d.lockdown.PcapdService(); err != nil {
-		return nil, err
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		s.close()
+		return nil, ErrDeviceClosed
 	}
-	pcapd = d.pcapd
-	return
+	d.pcapd = s
+	return s.svc.(Pcapd), nil
 }
 
 func (d *device) Pcap() (lines <-chan []byte, err error) {
-	if _, err = d.PcapdService(); err != nil {
+	var pcapd Pcapd
+	if pcapd, err = d.PcapdService(); err != nil {
 		return nil, err
 	}
-	return d.pcapd.Packet(), nil
+	return pcapd.Packet(), nil
 }
 
 func (d *device) PcapStop() {
-	if d.pcapd == nil {
+	d.mu.Lock()
+	s := d.pcapd
+	d.mu.Unlock()
+	if s == nil {
 		return
 	}
-	d.pcapd.Stop()
+	s.svc.(Pcapd).Stop()
 }
 
 func (d *device) crashReportMoverService() (crashReportMover CrashReportMover, err error) {
-	if d.crashReportMover != nil {
-		return d.crashReportMover, nil
-	}
-	if _, err = d.lockdownService(); err != nil {
+	var svc interface{}
+	if svc, err = d.shareService(context.Background(), libimobiledevice.CrashReportMoverServiceName, func(lockdown *lockdown) (interface{}, error) {
+		return lockdown.CrashReportMoverService()
+	}); err != nil {
 		return nil, err
 	}
-	if d.crashReportMover, err = d.lockdown.CrashReportMoverService(); err != nil {
-		return nil, err
-	}
-	crashReportMover = d.crashReportMover
-	return
+	return svc.(CrashReportMover), nil
 }
 
 func (d *device) MoveCrashReport(hostDir string, opts ...CrashReportMoverOption) (err error) {
-	if _, err = d.crashReportMoverService(); err != nil {
+	var crashReportMover CrashReportMover
+	if crashReportMover, err = d.crashReportMoverService(); err != nil {
 		return err
 	}
-	return d.crashReportMover.Move(hostDir, opts...)
+	return crashReportMover.Move(hostDir, opts...)
 }
 
 func (d *device) PerfStart(opts ...PerfOption) (data <-chan []byte, err error) {
+	return d.PerfStartContext(context.Background(), opts...)
+}
+
+func (d *device) PerfStartContext(ctx context.Context, opts ...PerfOption) (data <-chan []byte, err error) {
 	perfOptions := defaulPerfOption()
 	for _, fn := range opts {
 		fn(perfOptions)
@@ -836,16 +1078,19 @@ func (d *device) PerfStart(opts ...PerfOption) (data <-chan []byte, err error) {
 
 	// wait until get pid for bundle id
 	if perfOptions.BundleID != "" {
-		instruments, err := d.newInstrumentsService()
+		instruments, err := d.newInstrumentsServiceContext(ctx)
 		if err != nil {
-			fmt.Printf("get pid by bundle id failed: %v\n", err)
-			os.Exit(1)
+			return nil, err
 		}
 
 		for {
 			pid, err := instruments.getPidByBundleID(perfOptions.BundleID)
 			if err != nil {
-				time.Sleep(1 * time.Second)
+				select {
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				case <-time.After(1 * time.Second):
+				}
 				continue
 			}
 			perfOptions.Pid = pid
@@ -859,6 +1104,7 @@ func (d *device) PerfStart(opts ...PerfOption) (data <-chan []byte, err error) {
 	}
 
 	outCh := make(chan []byte, 100)
+	var started []Perfd
 
 	if perfOptions.SysCPU || perfOptions.SysMem || perfOptions.SysDisk ||
 		perfOptions.SysNetwork || len(perfOptions.ProcessAttributes) > 1 {
@@ -880,7 +1126,7 @@ func (d *device) PerfStart(opts ...PerfOption) (data <-chan []byte, err error) {
 				"netPacketsOut"}
 			perfOptions.SystemAttributes = append(perfOptions.SystemAttributes, networkAttr...)
 		}
-		perfd, err := d.newPerfdSysmontap(perfOptions)
+		perfd, err := d.newPerfdSysmontap(ctx, perfOptions)
 		if err != nil {
 			return nil, err
 		}
@@ -893,11 +1139,12 @@ func (d *device) PerfStart(opts ...PerfOption) (data <-chan []byte, err error) {
 				outCh <- (<-data)
 			}
 		}()
-		d.perfd = append(d.perfd, perfd)
+		started = append(started, perfd)
+		d.addPerfd(perfd)
 	}
 
 	if perfOptions.Network {
-		perfd, err := d.newPerfdNetworking(perfOptions)
+		perfd, err := d.newPerfdNetworking(ctx, perfOptions)
 		if err != nil {
 			return nil, err
 		}
@@ -910,11 +1157,12 @@ func (d *device) PerfStart(opts ...PerfOption) (data <-chan []byte, err error) {
 				outCh <- (<-data)
 			}
 		}()
-		d.perfd = append(d.perfd, perfd)
+		started = append(started, perfd)
+		d.addPerfd(perfd)
 	}
 
 	if perfOptions.FPS || perfOptions.gpu {
-		perfd, err := d.newPerfdGraphicsOpengl(perfOptions)
+		perfd, err := d.newPerfdGraphicsOpengl(ctx, perfOptions)
 		if err != nil {
 			return nil, err
 		}
@@ -927,34 +1175,54 @@ func (d *device) PerfStart(opts ...PerfOption) (data <-chan []byte, err error) {
 				outCh <- (<-data)
 			}
 		}()
-		d.perfd = append(d.perfd, perfd)
+		started = append(started, perfd)
+		d.addPerfd(perfd)
+	}
+
+	if ctx.Done() != nil {
+		go func() {
+			<-ctx.Done()
+			for _, p := range started {
+				p.Stop()
+			}
+		}()
 	}
 
 	return outCh, nil
 }
 
+func (d *device) addPerfd(perfd Perfd) {
+	d.mu.Lock()
+	d.perfd = append(d.perfd, perfd)
+	d.mu.Unlock()
+}
+
 func (d *device) PerfStop() {
-	if d.perfd == nil {
-		return
-	}
-	for _, p := range d.perfd {
+	d.mu.Lock()
+	perfd := d.perfd
+	d.mu.Unlock()
+	for _, p := range perfd {
 		p.Stop()
 	}
 }
 
 func (d *device) XCTest(bundleID string, opts ...XCTestOption) (out <-chan string, cancel context.CancelFunc, err error) {
+	return d.XCTestContext(context.Background(), bundleID, opts...)
+}
+
+func (d *device) XCTestContext(parent context.Context, bundleID string, opts ...XCTestOption) (out <-chan string, cancel context.CancelFunc, err error) {
 	xcTestOpt := defaultXCTestOption()
 	for _, fn := range opts {
 		fn(xcTestOpt)
 	}
 
-	ctx, cancelFunc := context.WithCancel(context.TODO())
+	ctx, cancelFunc := context.WithCancel(parent)
 	_out := make(chan string)
 
 	xcodeVersion := uint64(30)
 
 	var tmSrv1 Testmanagerd
-	if tmSrv1, err = d.testmanagerdService(); err != nil {
+	if tmSrv1, err = d.testmanagerdServiceContext(ctx); err != nil {
 		return _out, cancelFunc, err
 	}
 
@@ -963,19 +1231,19 @@ func (d *device) XCTest(bundleID string, opts ...XCTestOption) (out <-chan strin
 		return _out, cancelFunc, err
 	}
 
-	var version []int
-	if version, err = d.lockdown._getProductVersion(); err != nil {
+	var version *semver.Version
+	if version, err = d.OSVersion(ctx); err != nil {
 		return _out, cancelFunc, err
 	}
 
-	if DeviceVersion(version...) >= DeviceVersion(11, 0, 0) {
+	if version.Major() >= 11 {
 		if err = xcTestManager1.initiateControlSession(xcodeVersion); err != nil {
 			return _out, cancelFunc, err
 		}
 	}
 
 	var tmSrv2 Testmanagerd
-	if tmSrv2, err = d.testmanagerdService(); err != nil {
+	if tmSrv2, err = d.testmanagerdServiceContext(ctx); err != nil {
 		return _out, cancelFunc, err
 	}
 
@@ -1013,12 +1281,8 @@ func (d *device) XCTest(bundleID string, opts ...XCTestOption) (out <-chan strin
 		return _out, cancelFunc, err
 	}
 
-	if _, err = d.installationProxyService(); err != nil {
-		return _out, cancelFunc, err
-	}
-
 	var vResult interface{}
-	if vResult, err = d.installationProxy.Lookup(WithBundleIDs(bundleID)); err != nil {
+	if vResult, err = d.InstallationProxyLookupContext(ctx, WithBundleIDs(bundleID)); err != nil {
 		return _out, cancelFunc, err
 	}
 
@@ -1032,11 +1296,15 @@ func (d *device) XCTest(bundleID string, opts ...XCTestOption) (out <-chan strin
 		return _out, cancelFunc, err
 	}
 
-	if _, err = d.instrumentsService(); err != nil {
+	// the callbacks stay registered for the run, on an instruments connection of its own
+	var instrumentsSrv *service
+	if instrumentsSrv, err = d.startServiceContext(ctx, libimobiledevice.InstrumentsServiceName, startInstruments); err != nil {
 		return _out, cancelFunc, err
 	}
+	instruments := instrumentsSrv.svc.(Instruments)
 
-	if err = d.instruments.appProcess(bundleID); err != nil {
+	if err = instruments.appProcess(bundleID); err != nil {
+		instrumentsSrv.close()
 		return _out, cancelFunc, err
 	}
 
@@ -1060,7 +1328,7 @@ func (d *device) XCTest(bundleID string, opts ...XCTestOption) (out <-chan strin
 		"USE_PORT":                 "",
 		"LLVM_PROFILE_FILE":        appContainer + "/tmp/%p.profraw",
 	}
-	if DeviceVersion(version...) >= DeviceVersion(11, 0, 0) {
+	if version.Major() >= 11 {
 		appEnv["DYLD_INSERT_LIBRARIES"] = "/Developer/usr/lib/libMainThreadChecker.dylib"
 		appEnv["OS_ACTIVITY_DT_MODE"] = "YES"
 	}
@@ -1071,7 +1339,7 @@ func (d *device) XCTest(bundleID string, opts ...XCTestOption) (out <-chan strin
 	appOpt := map[string]interface{}{
 		"StartSuspendedKey": uint64(0),
 	}
-	if DeviceVersion(version...) >= DeviceVersion(12, 0, 0) {
+	if version.Major() >= 12 {
 		appOpt["ActivateSuspended"] = uint64(1)
 	}
 
@@ -1087,19 +1355,20 @@ func (d *device) XCTest(bundleID string, opts ...XCTestOption) (out <-chan strin
 		}
 	}
 
-	d.instruments.registerCallback("outputReceived:fromProcess:atTime:", func(m libimobiledevice.DTXMessageResult) {
+	instruments.registerCallback("outputReceived:fromProcess:atTime:", func(m libimobiledevice.DTXMessageResult) {
 		// fmt.Println("###### instruments ### -->", m.Aux[0])
 		_out <- fmt.Sprintf("%s", m.Aux[0])
 	})
 
 	var pid int
-	if pid, err = d.instruments.AppLaunch(bundleID,
+	if pid, err = instruments.AppLaunchContext(ctx, bundleID,
 		WithAppPath(appPath),
 		WithEnvironment(appEnv),
 		WithArguments(appArgs),
 		WithOptions(appOpt),
 		WithKillExisting(true),
 	); err != nil {
+		instrumentsSrv.close()
 		return _out, cancelFunc, err
 	}
 
@@ -1107,19 +1376,20 @@ func (d *device) XCTest(bundleID string, opts ...XCTestOption) (out <-chan strin
 	// 	return _out, cancelFunc, err
 	// }
 
-	if DeviceVersion(version...) >= DeviceVersion(12, 0, 0) {
+	if version.Major() >= 12 {
 		err = xcTestManager1.authorizeTestSession(pid)
-	} else if DeviceVersion(version...) <= DeviceVersion(9, 0, 0) {
+	} else if version.Compare(semver.MustParse("9.0.0")) <= 0 {
 		err = xcTestManager1.initiateControlSessionForTestProcessID(pid)
 	} else {
 		err = xcTestManager1.initiateControlSessionForTestProcessIDProtocolVersion(pid, xcodeVersion)
 	}
 	if err != nil {
+		instrumentsSrv.close()
 		return _out, cancelFunc, err
 	}
 
 	go func() {
-		d.instruments.registerCallback("_Golang-iDevice_Over", func(_ libimobiledevice.DTXMessageResult) {
+		instruments.registerCallback("_Golang-iDevice_Over", func(_ libimobiledevice.DTXMessageResult) {
 			cancelFunc()
 		})
 
@@ -1131,6 +1401,7 @@ func (d *device) XCTest(bundleID string, opts ...XCTestOption) (out <-chan strin
 		if _err := d.AppKill(pid); _err != nil {
 			debugLog(fmt.Sprintf("xctest kill: %d", pid))
 		}
+		instrumentsSrv.close()
 		// time.Sleep(time.Second)
 		close(_out)
 		return
@@ -1140,12 +1411,13 @@ func (d *device) XCTest(bundleID string, opts ...XCTestOption) (out <-chan strin
 }
 
 func (d *device) _uploadXCTestConfiguration(bundleID string, sessionId uuid.UUID, lookupResult map[string]interface{}) (pathXCTestCfg string, err error) {
-	if _, err = d.HouseArrestService(); err != nil {
+	var houseArrest HouseArrest
+	if houseArrest, err = d.HouseArrestService(); err != nil {
 		return "", err
 	}
 
 	var appAfc Afc
-	if appAfc, err = d.houseArrest.Container(bundleID); err != nil {
+	if appAfc, err = houseArrest.Container(bundleID); err != nil {
 		return "", err
 	}
 
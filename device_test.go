@@ -1,12 +1,27 @@
 package giDevice
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"log"
+	"math/big"
+	"net"
 	"os"
 	"os/signal"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/idevicetest"
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/libimobiledevice"
 )
 
 var dev Device
@@ -24,6 +39,323 @@ func setupDevice(t *testing.T) {
 
 	dev = devices[0]
 }
+
+func setupSimulatedDevice(t *testing.T) {
+	setupSimulator(t)
+	devices, err := um.Devices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) == 0 {
+		t.Fatal("No Device")
+	}
+	dev = devices[0]
+}
+
+func Test_device_simulated_GetValue(t *testing.T) {
+	setupSimulatedDevice(t)
+
+	v, err := dev.GetValue("", "ProductVersion")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != "16.0" {
+		t.Fatalf("ProductVersion: %v", v)
+	}
+
+	// the first session pairs and saves the record through usbmuxd
+	pairRecord, err := dev.ReadPairRecord()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := simDev.Trusted(pairRecord.HostID); !ok {
+		t.Fatalf("host %s is not trusted", pairRecord.HostID)
+	}
+}
+
+func Test_device_simulated_Pair(t *testing.T) {
+	setupSimulatedDevice(t)
+
+	pairRecord, err := dev.Pair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pairRecord.SystemBUID != simSrv.BUID() || len(pairRecord.EscrowBag) == 0 {
+		t.Fatalf("pair record: %#v", pairRecord)
+	}
+	if _, ok := simDev.Trusted(pairRecord.HostID); !ok {
+		t.Fatalf("host %s is not trusted", pairRecord.HostID)
+	}
+}
+
+func Test_device_simulated_PairWithOptions(t *testing.T) {
+	setupSimulatedDevice(t)
+
+	// the Trust dialog is answered on the third attempt
+	var mu sync.Mutex
+	attempts, answer := 0, ""
+	simDev.HandleRequest(string(libimobiledevice.RequestTypePair), func(req map[string]interface{}) map[string]interface{} {
+		mu.Lock()
+		defer mu.Unlock()
+		if attempts++; attempts < 3 {
+			return map[string]interface{}{"Error": string(ErrPairingDialogResponsePending)}
+		}
+		if answer != "" {
+			return map[string]interface{}{"Error": answer}
+		}
+		return nil
+	})
+
+	identity := &PairRecord{HostID: "00000000-0000-0000-0000-000000000001", SystemBUID: "lab-host"}
+	pairRecord, err := dev.PairWithOptions(context.Background(),
+		WithPairPollInterval(10*time.Millisecond), WithPairHostIdentity(identity))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 3 || pairRecord.HostID != identity.HostID || pairRecord.SystemBUID != identity.SystemBUID {
+		t.Fatalf("attempts %d, pair record: %s %s", attempts, pairRecord.HostID, pairRecord.SystemBUID)
+	}
+	if saved, err := dev.ReadPairRecord(); err != nil || saved.HostID != identity.HostID {
+		t.Fatalf("saved pair record: %v", err)
+	}
+	if err = dev.ValidatePair(); err != nil {
+		t.Fatal(err)
+	}
+
+	// same host identity, new keys are not generated
+	again, err := dev.PairWithOptions(context.Background(), WithPairHostIdentity(pairRecord))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again.HostCertificate, pairRecord.HostCertificate) || !bytes.Equal(again.RootPrivateKey, pairRecord.RootPrivateKey) {
+		t.Fatal("host identity was not reused")
+	}
+
+	if err = dev.Unpair(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := simDev.Trusted(identity.HostID); ok {
+		t.Fatal("host is still trusted")
+	}
+	if _, err = dev.ReadPairRecord(); !errors.Is(err, libimobiledevice.ReplyCodeBadDevice) {
+		t.Fatalf("pair record not deleted: %v", err)
+	}
+
+	mu.Lock()
+	attempts, answer = 0, string(ErrUserDeniedPairing)
+	mu.Unlock()
+	if _, err = dev.PairWithOptions(context.Background(), WithPairPollInterval(10*time.Millisecond)); !errors.Is(err, ErrUserDeniedPairing) {
+		t.Fatalf("expected UserDeniedPairing, got %v", err)
+	}
+
+	mu.Lock()
+	attempts = -1 << 30
+	mu.Unlock()
+	_, err = dev.PairWithOptions(context.Background(),
+		WithPairPollInterval(10*time.Millisecond), WithPairTimeout(100*time.Millisecond))
+	if !errors.Is(err, ErrPairingDialogResponsePending) {
+		t.Fatalf("expected PairingDialogResponsePending, got %v", err)
+	}
+}
+
+func Test_device_simulated_PairSupervised(t *testing.T) {
+	setupSimulatedDevice(t)
+
+	newIdentity := func() (*x509.Certificate, *rsa.PrivateKey) {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert, key
+	}
+	cert, key := newIdentity()
+	simDev.Supervise(cert)
+
+	pairRecord, err := dev.PairWithOptions(context.Background(), WithPairSupervisor(cert, key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := simDev.Trusted(pairRecord.HostID); !ok || len(pairRecord.EscrowBag) == 0 {
+		t.Fatalf("host %s is not trusted", pairRecord.HostID)
+	}
+	if _, err = dev.GetValue("", "ProductVersion"); err != nil {
+		t.Fatal(err)
+	}
+
+	// supervised by another host
+	otherCert, _ := newIdentity()
+	simDev.Supervise(otherCert)
+	if _, err = dev.PairWithOptions(context.Background(), WithPairSupervisor(cert, key)); !errors.Is(err, libimobiledevice.ErrPairingFailed) {
+		t.Fatalf("expected PairingFailed, got %v", err)
+	}
+}
+
+func Test_device_simulated_NetworkDevice(t *testing.T) {
+	setupSimulator(t)
+	simDev.HandleTLS(libimobiledevice.AfcServiceName, idevicetest.AfcHandler(t.TempDir()))
+
+	pairRecord, err := simSrv.Pair(simDev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewMemoryPairRecordStore()
+	if err = store.SavePairRecord(simDev.UDID, pairRecord); err != nil {
+		t.Fatal(err)
+	}
+
+	addr, err := simDev.ListenNetwork()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(simDev.Close)
+	_, port, _ := net.SplitHostPort(addr)
+	lockdownPort, _ := strconv.Atoi(port)
+
+	// no usbmuxd from here on
+	simSrv.Close()
+
+	netDev, err := NewNetworkDevice(DeviceProperties{
+		SerialNumber:   simDev.UDID,
+		NetworkAddress: simDev.NetworkAddress(),
+	}, store, WithNetworkLockdownPort(lockdownPort))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v, err := netDev.GetValue("", "ProductVersion"); err != nil || v != "16.0" {
+		t.Fatalf("ProductVersion: %v %v", v, err)
+	}
+
+	afc, err := netDev.AfcService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = afc.Mkdir("Downloads"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = afc.Stat("Downloads"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = NewNetworkDevice(DeviceProperties{SerialNumber: simDev.UDID}, store); err == nil {
+		t.Fatal("expected an error without NetworkAddress")
+	}
+}
+
+func Test_device_simulated_Context(t *testing.T) {
+	setupSimulatedDevice(t)
+	// never replies
+	simDev.Handle(libimobiledevice.InstallationProxyServiceName, func(conn net.Conn) {
+		_, _ = io.Copy(io.Discard, conn)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := dev.GetValueContext(ctx, "", "ProductVersion"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled, got %v", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := dev.InstallationProxyBrowseContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("gave up after %s", elapsed)
+	}
+	if len(dev.(*device).services.idle[libimobiledevice.InstallationProxyServiceName]) != 0 {
+		t.Fatal("interrupted service still kept")
+	}
+
+	if _, err := dev.GetValue("", "ProductVersion"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_device_simulated_AfcService(t *testing.T) {
+	setupSimulatedDevice(t)
+	simDev.Handle(libimobiledevice.AfcServiceName, idevicetest.AfcHandler(t.TempDir()))
+
+	afc, err := dev.AfcService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = afc.Mkdir("Downloads"); err != nil {
+		t.Fatal(err)
+	}
+	if err = afc.WriteFile("Downloads/hello.txt", []byte("hello"), AfcFileModeWr); err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := afc.ReadDir("Downloads")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 3 || names[2] != "hello.txt" {
+		t.Fatalf("names: %v", names)
+	}
+
+	info, err := afc.Stat("Downloads/hello.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 5 || info.IsDir() {
+		t.Fatalf("stat: %d %v", info.Size(), info.IsDir())
+	}
+
+	if _, err = afc.Stat("Downloads/missing"); err != ErrAfcStatNotExist || !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("stat missing: %v", err)
+	}
+}
+
+func Test_device_simulated_Errors(t *testing.T) {
+	setupSimulatedDevice(t)
+	simDev.Handle(libimobiledevice.AfcServiceName, idevicetest.AfcHandler(t.TempDir()))
+	simDev.Handle(libimobiledevice.InstallationProxyServiceName, func(conn net.Conn) {
+		var req map[string]interface{}
+		if err := idevicetest.ReadMessage(conn, &req); err != nil {
+			return
+		}
+		_ = idevicetest.WriteMessage(conn, map[string]interface{}{
+			"Error":            "UninstallProhibited",
+			"ErrorDescription": "not allowed",
+		})
+	})
+
+	if _, err := dev.Screenshot(); !errors.Is(err, ErrInvalidService) {
+		t.Fatalf("expected InvalidService, got %v", err)
+	}
+
+	afc, err := dev.AfcService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = afc.Remove("missing")
+	var afcErr AfcError
+	if !errors.Is(err, fs.ErrNotExist) || !errors.As(err, &afcErr) || afcErr != libimobiledevice.AfcErrObjectNotFound {
+		t.Fatalf("expected ObjectNotFound, got %v", err)
+	}
+
+	err = dev.AppUninstall("com.example.app")
+	var ipErr *InstallationProxyError
+	if !errors.Is(err, libimobiledevice.ErrUninstallProhibited) || !errors.As(err, &ipErr) || ipErr.Description != "not allowed" {
+		t.Fatalf("expected UninstallProhibited, got %v", err)
+	}
+}
 func Test_device_ReadPairRecord(t *testing.T) {
 	setupDevice(t)
 
@@ -0,0 +1,414 @@
+package giDevice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/libimobiledevice"
+)
+
+var ErrDeviceManagerClosed = errors.New("device manager: closed")
+
+var _ DeviceManager = (*deviceManager)(nil)
+
+type deviceManager struct {
+	opt    *deviceManagerOption
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu sync.Mutex
+	// attached by usbmuxd DeviceID, a device may be attached over USB and the network
+	attached    map[int]*device
+	subscribers map[*deviceSubscriber]struct{}
+	// changed is closed and replaced on every change, see WaitForDevice
+	changed chan struct{}
+}
+
+// NewDeviceManager follows usbmuxd, listing what is attached right away. When
+// usbmuxd goes away the devices are kept until it is back, then brought up to date.
+func NewDeviceManager(opts ...DeviceManagerOption) (DeviceManager, error) {
+	opt := defaultDeviceManagerOption()
+	for _, fn := range opts {
+		fn(opt)
+	}
+	m := &deviceManager{
+		opt:         opt,
+		done:        make(chan struct{}),
+		attached:    make(map[int]*device),
+		subscribers: make(map[*deviceSubscriber]struct{}),
+		changed:     make(chan struct{}),
+	}
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+
+	client, err := m.connect()
+	if err != nil {
+		m.cancel()
+		return nil, fmt.Errorf("device manager: %w", err)
+	}
+	go m.run(client)
+	return m, nil
+}
+
+func (m *deviceManager) dial(ctx context.Context, timeout ...time.Duration) (*libimobiledevice.UsbmuxClient, error) {
+	return libimobiledevice.NewUsbmuxClientContext(ctx, m.opt.usbmuxdAddr, timeout...)
+}
+
+func (m *deviceManager) request(client *libimobiledevice.UsbmuxClient, msgType libimobiledevice.MessageType) (resp libimobiledevice.Packet, err error) {
+	release := client.InnerConn().BindContext(m.ctx)
+	defer release()
+	var pkt libimobiledevice.Packet
+	if pkt, err = client.NewPlistPacket(client.NewBasicRequest(msgType)); err != nil {
+		return nil, err
+	}
+	if err = client.SendPacket(pkt); err != nil {
+		return nil, err
+	}
+	return client.ReceivePacket()
+}
+
+// connect starts listening, then brings the devices up to date: the `Attached`
+// of the devices already known are ignored
+func (m *deviceManager) connect() (client *libimobiledevice.UsbmuxClient, err error) {
+	if client, err = m.dial(m.ctx); err != nil {
+		return nil, err
+	}
+	if _, err = m.request(client, libimobiledevice.MessageTypeListen); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	var listClient *libimobiledevice.UsbmuxClient
+	if listClient, err = m.dial(m.ctx); err != nil {
+		client.Close()
+		return nil, err
+	}
+	defer listClient.Close()
+	var resp libimobiledevice.Packet
+	if resp, err = m.request(listClient, libimobiledevice.MessageTypeDeviceList); err != nil {
+		client.Close()
+		return nil, err
+	}
+	var reply struct {
+		DeviceList []libimobiledevice.BaseDevice `plist:"DeviceList"`
+	}
+	if err = resp.Unmarshal(&reply); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	attached := make(map[int]*device, len(reply.DeviceList))
+	for _, baseDev := range reply.DeviceList {
+		attached[baseDev.DeviceID] = m.newDevice(baseDev)
+	}
+	m.reconcile(attached)
+	return client, nil
+}
+
+// newDevice dials usbmuxd only when the device needs it, not per device attached
+func (m *deviceManager) newDevice(baseDev libimobiledevice.BaseDevice) *device {
+	baseDev.Properties.DeviceID = baseDev.DeviceID
+	dev := newDevice(nil, baseDev.Properties)
+	dev.dialUsbmux = m.dial
+	return dev
+}
+
+// reconcile replaces the devices with the ones of a new usbmuxd connection, the
+// DeviceIDs of the old one mean nothing anymore. The devices attached all along
+// are kept, for the handles given out to stay valid, under their new DeviceID
+func (m *deviceManager) reconcile(attached map[int]*device) {
+	type connection struct{ udid, connectionType string }
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	before := make(map[connection]*device, len(m.attached))
+	for _, dev := range m.attached {
+		before[connection{dev.properties.SerialNumber, dev.properties.ConnectionType}] = dev
+	}
+	after := make(map[connection]bool, len(attached))
+	for id, dev := range attached {
+		c := connection{dev.properties.SerialNumber, dev.properties.ConnectionType}
+		after[c] = true
+		if known, ok := before[c]; ok {
+			known.setDeviceID(id)
+			attached[id] = known
+		}
+	}
+	m.attached = attached
+
+	for c := range before {
+		if !after[c] {
+			m.emitLocked(DeviceEventDetached, c.udid, c.connectionType)
+		}
+	}
+	for c := range after {
+		if before[c] == nil {
+			m.emitLocked(DeviceEventAttached, c.udid, c.connectionType)
+		}
+	}
+	m.notifyLocked()
+}
+
+func (m *deviceManager) run(client *libimobiledevice.UsbmuxClient) {
+	defer close(m.done)
+	backoff := m.opt.minBackoff
+	for {
+		if client != nil {
+			err := m.serve(client)
+			client.Close()
+			if m.ctx.Err() != nil {
+				return
+			}
+			if debugFlag {
+				log.Printf("device manager: usbmuxd lost: %s", err)
+			}
+			backoff = m.opt.minBackoff
+		}
+
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		var err error
+		if client, err = m.connect(); err != nil {
+			client = nil
+			if backoff *= 2; backoff > m.opt.maxBackoff {
+				backoff = m.opt.maxBackoff
+			}
+		}
+	}
+}
+
+func (m *deviceManager) serve(client *libimobiledevice.UsbmuxClient) error {
+	client.InnerConn().Timeout(0)
+	release := client.InnerConn().BindContext(m.ctx)
+	defer release()
+	for {
+		pkt, err := client.ReceivePacket()
+		if err != nil {
+			return err
+		}
+		var baseDev libimobiledevice.BaseDevice
+		if err = pkt.Unmarshal(&baseDev); err != nil {
+			return err
+		}
+
+		switch baseDev.MessageType {
+		case libimobiledevice.MessageTypeDeviceAdd:
+			m.mu.Lock()
+			_, ok := m.attached[baseDev.DeviceID]
+			m.mu.Unlock()
+			if ok {
+				continue
+			}
+			dev := m.newDevice(baseDev)
+			m.mu.Lock()
+			m.attached[baseDev.DeviceID] = dev
+			m.emitLocked(DeviceEventAttached, dev.properties.SerialNumber, dev.properties.ConnectionType)
+			m.notifyLocked()
+			m.mu.Unlock()
+		case libimobiledevice.MessageTypeDeviceRemove:
+			m.mu.Lock()
+			if dev, ok := m.attached[baseDev.DeviceID]; ok {
+				delete(m.attached, baseDev.DeviceID)
+				m.emitLocked(DeviceEventDetached, dev.properties.SerialNumber, dev.properties.ConnectionType)
+				m.notifyLocked()
+			}
+			m.mu.Unlock()
+		case libimobiledevice.MessageTypeDevicePaired:
+			m.mu.Lock()
+			if dev, ok := m.attached[baseDev.DeviceID]; ok {
+				m.emitLocked(DeviceEventPaired, dev.properties.SerialNumber, dev.properties.ConnectionType)
+			}
+			m.mu.Unlock()
+		}
+	}
+}
+
+func (m *deviceManager) emitLocked(eventType DeviceEventType, udid, connectionType string) {
+	event := DeviceEvent{Type: eventType, UDID: udid, ConnectionType: connectionType}
+	if dev := m.preferredLocked(udid); dev != nil {
+		event.Device = dev
+	}
+	for sub := range m.subscribers {
+		sub.push(event)
+	}
+}
+
+func (m *deviceManager) notifyLocked() {
+	close(m.changed)
+	m.changed = make(chan struct{})
+}
+
+// preferredLocked the device of udid attached over USB, else over the network
+func (m *deviceManager) preferredLocked(udid string) (preferred *device) {
+	for _, dev := range m.attached {
+		if dev.properties.SerialNumber == udid && (preferred == nil || preferable(dev, preferred)) {
+			preferred = dev
+		}
+	}
+	return
+}
+
+// preferable whether a is better than b, USB first then the earliest attached
+func preferable(a, b *device) bool {
+	aUSB, bUSB := a.properties.ConnectionType == "USB", b.properties.ConnectionType == "USB"
+	if aUSB != bUSB {
+		return aUSB
+	}
+	return a.deviceID() < b.deviceID()
+}
+
+func (m *deviceManager) Devices() []Device {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ids := make([]int, 0, len(m.attached))
+	for id := range m.attached {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	devices := make([]Device, 0, len(ids))
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		udid := m.attached[id].properties.SerialNumber
+		if !seen[udid] {
+			seen[udid] = true
+			devices = append(devices, m.preferredLocked(udid))
+		}
+	}
+	return devices
+}
+
+func (m *deviceManager) Device(udid string) (Device, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if dev := m.preferredLocked(udid); dev != nil {
+		return dev, true
+	}
+	return nil, false
+}
+
+func (m *deviceManager) Subscribe() (<-chan DeviceEvent, context.CancelFunc) {
+	sub := newDeviceSubscriber()
+	m.mu.Lock()
+	select {
+	case <-m.done:
+		sub.close()
+	default:
+		m.subscribers[sub] = struct{}{}
+	}
+	m.mu.Unlock()
+	return sub.events, func() {
+		m.mu.Lock()
+		delete(m.subscribers, sub)
+		m.mu.Unlock()
+		sub.close()
+	}
+}
+
+func (m *deviceManager) WaitForDevice(ctx context.Context, udid string) (Device, error) {
+	for {
+		m.mu.Lock()
+		dev := m.preferredLocked(udid)
+		changed := m.changed
+		m.mu.Unlock()
+		if dev != nil {
+			return dev, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-m.done:
+			return nil, ErrDeviceManagerClosed
+		case <-changed:
+		}
+	}
+}
+
+func (m *deviceManager) Close() error {
+	m.cancel()
+	<-m.done
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for sub := range m.subscribers {
+		sub.close()
+	}
+	m.subscribers = nil
+	return nil
+}
+
+// deviceSubscriber queues the events, so that a slow subscriber holds up nobody
+type deviceSubscriber struct {
+	events chan DeviceEvent
+	done   chan struct{}
+
+	mu        sync.Mutex
+	cond      *sync.Cond
+	queue     []DeviceEvent
+	closeOnce sync.Once
+}
+
+func newDeviceSubscriber() *deviceSubscriber {
+	sub := &deviceSubscriber{
+		events: make(chan DeviceEvent),
+		done:   make(chan struct{}),
+	}
+	sub.cond = sync.NewCond(&sub.mu)
+	go sub.deliver()
+	return sub
+}
+
+func (sub *deviceSubscriber) push(event DeviceEvent) {
+	sub.mu.Lock()
+	sub.queue = append(sub.queue, event)
+	sub.cond.Signal()
+	sub.mu.Unlock()
+}
+
+func (sub *deviceSubscriber) close() {
+	sub.closeOnce.Do(func() {
+		sub.mu.Lock()
+		close(sub.done)
+		sub.cond.Signal()
+		sub.mu.Unlock()
+	})
+}
+
+func (sub *deviceSubscriber) deliver() {
+	defer close(sub.events)
+	for {
+		sub.mu.Lock()
+		for len(sub.queue) == 0 && !sub.closed() {
+			sub.cond.Wait()
+		}
+		if sub.closed() {
+			sub.mu.Unlock()
+			return
+		}
+		event := sub.queue[0]
+		sub.queue = sub.queue[1:]
+		sub.mu.Unlock()
+
+		select {
+		case sub.events <- event:
+		case <-sub.done:
+			return
+		}
+	}
+}
+
+func (sub *deviceSubscriber) closed() bool {
+	select {
+	case <-sub.done:
+		return true
+	default:
+		return false
+	}
+}
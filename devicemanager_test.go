@@ -0,0 +1,148 @@
+package giDevice
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/idevicetest"
+)
+
+func Test_deviceManager_simulated(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "usbmuxd")
+	srv, err := idevicetest.NewUnixServer(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const udid = "00008030-001A2B3C4D5E6F70"
+	newDevice := func(connectionType string) *idevicetest.Device {
+		d, err := idevicetest.NewDevice(udid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.ConnectionType = connectionType
+		return d
+	}
+	usb := newDevice("USB")
+	srv.AddDevice(usb)
+
+	m, err := NewDeviceManager(WithDeviceManagerUsbmuxd(srv.Addr()),
+		WithDeviceManagerBackoff(10*time.Millisecond, 100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if devices := m.Devices(); len(devices) != 1 || devices[0].Properties().SerialNumber != udid {
+		t.Fatalf("devices: %v", devices)
+	}
+
+	events, cancel := m.Subscribe()
+	defer cancel()
+	expect := func(eventType DeviceEventType, connectionType, current string) DeviceEvent {
+		t.Helper()
+		select {
+		case event := <-events:
+			if event.Type != eventType || event.UDID != udid || event.ConnectionType != connectionType {
+				t.Fatalf("event: %+v", event)
+			}
+			if (event.Device == nil) != (current == "") ||
+				event.Device != nil && event.Device.Properties().ConnectionType != current {
+				t.Fatalf("event device: %+v", event)
+			}
+			return event
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no %s event", eventType)
+		}
+		return DeviceEvent{}
+	}
+
+	// the same device over Wi-Fi as well, USB is still preferred
+	network := newDevice("Network")
+	srv.AddDevice(network)
+	expect(DeviceEventAttached, "Network", "USB")
+	if devices := m.Devices(); len(devices) != 1 {
+		t.Fatalf("devices: %v", devices)
+	}
+	srv.RemoveDevice(usb)
+	event := expect(DeviceEventDetached, "USB", "Network")
+
+	pairRecord, err := srv.Pair(network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = event.Device.SavePairRecord(pairRecord); err != nil {
+		t.Fatal(err)
+	}
+	expect(DeviceEventPaired, "Network", "Network")
+
+	// usbmuxd restarts with the device attached over USB only
+	srv.Close()
+	if srv, err = idevicetest.NewUnixServer(socketPath); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	srv.AddDevice(usb)
+	expect(DeviceEventDetached, "Network", "USB")
+	// the Attached is about USB, the device it carries as well
+	select {
+	case event = <-events:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no attached event")
+	}
+	if event.Type != DeviceEventAttached || event.ConnectionType != "USB" {
+		t.Fatalf("event: %+v", event)
+	}
+	// the new usbmuxd has no pair record, lockdownd pairs again
+	if _, err = event.Device.GetValue("", "ProductVersion"); err != nil {
+		t.Fatal(err)
+	}
+	expect(DeviceEventPaired, "USB", "USB")
+
+	srv.RemoveDevice(usb)
+	expect(DeviceEventDetached, "USB", "")
+	ctx, cancelWait := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelWait()
+	if _, err = m.WaitForDevice(ctx, udid); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	go srv.AddDevice(usb)
+	ctx, cancelWait = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelWait()
+	dev, err := m.WaitForDevice(ctx, udid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dev.Properties().DeviceID != usb.DeviceID() {
+		t.Fatalf("device: %+v", dev.Properties())
+	}
+	expect(DeviceEventAttached, "USB", "USB")
+
+	// usbmuxd restarts with the device still attached, under another DeviceID,
+	// the handle given out stays the same and keeps working
+	previousID := usb.DeviceID()
+	srv.Close()
+	if srv, err = idevicetest.NewUnixServer(socketPath); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	srv.AddDevice(usb)
+	if usb.DeviceID() == previousID {
+		t.Fatalf("DeviceID unchanged: %d", previousID)
+	}
+	for deadline := time.Now().Add(5 * time.Second); dev.Properties().DeviceID != usb.DeviceID(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("device: %+v", dev.Properties())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if devices := m.Devices(); len(devices) != 1 || devices[0] != dev {
+		t.Fatalf("devices: %v", devices)
+	}
+	if _, err = dev.GetValue("", "ProductVersion"); err != nil {
+		t.Fatal(err)
+	}
+	expect(DeviceEventPaired, "USB", "USB")
+}
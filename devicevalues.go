@@ -0,0 +1,258 @@
+package giDevice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Masterminds/semver/v3"
+	"howett.net/plist"
+)
+
+const (
+	diskUsageDomain        = "com.apple.disk_usage"
+	batteryDomain          = "com.apple.mobile.battery"
+	wirelessLockdownDomain = "com.apple.mobile.wireless_lockdown"
+	internationalDomain    = "com.apple.international"
+	iTunesDomain           = "com.apple.mobile.iTunes"
+)
+
+// DeviceValues the values of the root lockdown domain
+type DeviceValues struct {
+	ActivationState                      string `plist:"ActivationState"`
+	BasebandVersion                      string `plist:"BasebandVersion"`
+	BluetoothAddress                     string `plist:"BluetoothAddress"`
+	BuildVersion                         string `plist:"BuildVersion"`
+	CPUArchitecture                      string `plist:"CPUArchitecture"`
+	DeviceClass                          string `plist:"DeviceClass"`
+	DeviceColor                          string `plist:"DeviceColor"`
+	DeviceName                           string `plist:"DeviceName"`
+	EthernetAddress                      string `plist:"EthernetAddress"`
+	FirmwareVersion                      string `plist:"FirmwareVersion"`
+	HardwareModel                        string `plist:"HardwareModel"`
+	HardwarePlatform                     string `plist:"HardwarePlatform"`
+	InternationalMobileEquipmentIdentity string `plist:"InternationalMobileEquipmentIdentity"`
+	ModelNumber                          string `plist:"ModelNumber"`
+	PasswordProtected                    bool   `plist:"PasswordProtected"`
+	PhoneNumber                          string `plist:"PhoneNumber"`
+	ProductName                          string `plist:"ProductName"`
+	ProductType                          string `plist:"ProductType"`
+	ProductVersion                       string `plist:"ProductVersion"`
+	ProtocolVersion                      string `plist:"ProtocolVersion"`
+	RegionInfo                           string `plist:"RegionInfo"`
+	SerialNumber                         string `plist:"SerialNumber"`
+	TimeZone                             string `plist:"TimeZone"`
+	UniqueChipID                         uint64 `plist:"UniqueChipID"`
+	UniqueDeviceID                       string `plist:"UniqueDeviceID"`
+	WiFiAddress                          string `plist:"WiFiAddress"`
+}
+
+// OSVersion ProductVersion as semver
+func (v *DeviceValues) OSVersion() (*semver.Version, error) {
+	return parseProductVersion(v.ProductVersion)
+}
+
+// DiskUsage the values of com.apple.disk_usage, in bytes
+type DiskUsage struct {
+	TotalDiskCapacity    uint64 `plist:"TotalDiskCapacity"`
+	TotalSystemCapacity  uint64 `plist:"TotalSystemCapacity"`
+	TotalSystemAvailable uint64 `plist:"TotalSystemAvailable"`
+	TotalDataCapacity    uint64 `plist:"TotalDataCapacity"`
+	TotalDataAvailable   uint64 `plist:"TotalDataAvailable"`
+	AmountDataAvailable  uint64 `plist:"AmountDataAvailable"`
+	AmountDataReserved   uint64 `plist:"AmountDataReserved"`
+}
+
+// Battery the values of com.apple.mobile.battery
+type Battery struct {
+	BatteryCurrentCapacity int  `plist:"BatteryCurrentCapacity"`
+	BatteryIsCharging      bool `plist:"BatteryIsCharging"`
+	ExternalChargeCapable  bool `plist:"ExternalChargeCapable"`
+	ExternalConnected      bool `plist:"ExternalConnected"`
+	FullyCharged           bool `plist:"FullyCharged"`
+	HasBattery             bool `plist:"HasBattery"`
+}
+
+// International the values of com.apple.international
+type International struct {
+	Language           string   `plist:"Language"`
+	Locale             string   `plist:"Locale"`
+	Keyboards          []string `plist:"Keyboards"`
+	SupportedLanguages []string `plist:"SupportedLanguages"`
+	SupportedLocales   []string `plist:"SupportedLocales"`
+}
+
+// WirelessLockdown the values of com.apple.mobile.wireless_lockdown
+type WirelessLockdown struct {
+	// EnableWifiDebugging the device is reachable over Wi-Fi
+	EnableWifiDebugging    bool   `plist:"EnableWifiDebugging"`
+	EnableWifiConnections  bool   `plist:"EnableWifiConnections"`
+	BonjourFullServiceName string `plist:"BonjourFullServiceName"`
+}
+
+// ITunes the values of com.apple.mobile.iTunes
+type ITunes struct {
+	MinITunesVersion string `plist:"MinITunesVersion"`
+	MinMacOSVersion  string `plist:"MinMacOSVersion"`
+}
+
+// DeviceSummary the root domain along with the other domains read in one session,
+// the ones the device does not have are nil
+type DeviceSummary struct {
+	DeviceValues
+	DiskUsage        *DiskUsage
+	Battery          *Battery
+	International    *International
+	WirelessLockdown *WirelessLockdown
+	ITunes           *ITunes
+}
+
+func (d *device) DeviceValues(ctx context.Context) (values *DeviceValues, err error) {
+	values = new(DeviceValues)
+	if err = d.domainValues(ctx, "", values); err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (d *device) DeviceSummary(ctx context.Context) (summary *DeviceSummary, err error) {
+	var lockdown *lockdown
+	if lockdown, err = d.newLockdownContext(ctx); err != nil {
+		return nil, err
+	}
+	defer lockdown.close()
+
+	summary = &DeviceSummary{
+		DiskUsage:        new(DiskUsage),
+		Battery:          new(Battery),
+		International:    new(International),
+		WirelessLockdown: new(WirelessLockdown),
+		ITunes:           new(ITunes),
+	}
+	err = lockdown.session(ctx, func() (err error) {
+		if err = lockdown.domainValues("", &summary.DeviceValues); err != nil {
+			return err
+		}
+		for _, domain := range []struct {
+			name string
+			out  interface{}
+			// set nil once the device does not have the domain
+			missing func()
+		}{
+			{diskUsageDomain, summary.DiskUsage, func() { summary.DiskUsage = nil }},
+			{batteryDomain, summary.Battery, func() { summary.Battery = nil }},
+			{internationalDomain, summary.International, func() { summary.International = nil }},
+			{wirelessLockdownDomain, summary.WirelessLockdown, func() { summary.WirelessLockdown = nil }},
+			{iTunesDomain, summary.ITunes, func() { summary.ITunes = nil }},
+		} {
+			if err = lockdown.domainValues(domain.name, domain.out); errors.Is(err, ErrMissingValue) {
+				domain.missing()
+			} else if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (d *device) DiskUsage(ctx context.Context) (usage *DiskUsage, err error) {
+	usage = new(DiskUsage)
+	if err = d.domainValues(ctx, diskUsageDomain, usage); err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (d *device) Battery(ctx context.Context) (battery *Battery, err error) {
+	battery = new(Battery)
+	if err = d.domainValues(ctx, batteryDomain, battery); err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (d *device) Language(ctx context.Context) (language string, err error) {
+	var international International
+	if err = d.domainValues(ctx, internationalDomain, &international); err != nil {
+		return "", err
+	}
+	return international.Language, nil
+}
+
+func (d *device) Locale(ctx context.Context) (locale string, err error) {
+	var international International
+	if err = d.domainValues(ctx, internationalDomain, &international); err != nil {
+		return "", err
+	}
+	return international.Locale, nil
+}
+
+func (d *device) WiFiDebugging(ctx context.Context) (enabled bool, err error) {
+	var wireless WirelessLockdown
+	if err = d.domainValues(ctx, wirelessLockdownDomain, &wireless); err != nil {
+		return false, err
+	}
+	return wireless.EnableWifiDebugging, nil
+}
+
+func (d *device) ActivationState(ctx context.Context) (state string, err error) {
+	var v interface{}
+	if v, err = d.GetValueContext(ctx, "", "ActivationState"); err != nil {
+		return "", err
+	}
+	state, _ = v.(string)
+	return
+}
+
+func (d *device) OSVersion(ctx context.Context) (version *semver.Version, err error) {
+	var lockdown *lockdown
+	if lockdown, err = d.newLockdownContext(ctx); err != nil {
+		return nil, err
+	}
+	lockdown.close()
+	return lockdown.version, nil
+}
+
+// domainValues decodes the values of domain into out, within a session
+func (d *device) domainValues(ctx context.Context, domain string, out interface{}) (err error) {
+	var lockdown *lockdown
+	if lockdown, err = d.newLockdownContext(ctx); err != nil {
+		return err
+	}
+	defer lockdown.close()
+	return lockdown.session(ctx, func() error {
+		return lockdown.domainValues(domain, out)
+	})
+}
+
+// domainValues decodes the values of domain into out, a struct with plist tags
+func (c *lockdown) domainValues(domain string, out interface{}) (err error) {
+	var v interface{}
+	if v, err = c.GetValue(domain, ""); err != nil {
+		return err
+	}
+	var data []byte
+	if data, err = plist.Marshal(v, plist.BinaryFormat); err != nil {
+		return fmt.Errorf("lockdown values %q: %w", domain, err)
+	}
+	if _, err = plist.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("lockdown values %q: %w", domain, err)
+	}
+	return nil
+}
+
+// parseProductVersion a ProductVersion, `16.4.1` or `17.0` say, as semver
+func parseProductVersion(v interface{}) (version *semver.Version, err error) {
+	productVersion, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("ProductVersion: unexpected %T", v)
+	}
+	if version, err = semver.NewVersion(productVersion); err != nil {
+		return nil, fmt.Errorf("ProductVersion: %w", err)
+	}
+	return
+}
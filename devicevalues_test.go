@@ -0,0 +1,59 @@
+package giDevice
+
+import (
+	"context"
+	"testing"
+)
+
+func Test_device_simulated_DeviceSummary(t *testing.T) {
+	setupSimulatedDevice(t)
+	simDev.SetValue("", "ActivationState", "Activated")
+	simDev.SetValue(diskUsageDomain, "TotalDiskCapacity", uint64(128e9))
+	simDev.SetValue(diskUsageDomain, "AmountDataAvailable", uint64(64e9))
+	simDev.SetValue(batteryDomain, "BatteryCurrentCapacity", uint64(87))
+	simDev.SetValue(batteryDomain, "BatteryIsCharging", true)
+	simDev.SetValue(internationalDomain, "Language", "en")
+	simDev.SetValue(internationalDomain, "Locale", "en_US")
+	simDev.SetValue(wirelessLockdownDomain, "EnableWifiDebugging", true)
+	ctx := context.Background()
+
+	summary, err := dev.DeviceSummary(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.ProductVersion != "16.0" || summary.ActivationState != "Activated" || summary.UniqueDeviceID != dev.Properties().SerialNumber {
+		t.Fatalf("values: %+v", summary.DeviceValues)
+	}
+	if summary.DiskUsage == nil || summary.DiskUsage.TotalDiskCapacity != 128e9 || summary.DiskUsage.AmountDataAvailable != 64e9 {
+		t.Fatalf("disk usage: %+v", summary.DiskUsage)
+	}
+	if summary.Battery == nil || summary.Battery.BatteryCurrentCapacity != 87 || !summary.Battery.BatteryIsCharging {
+		t.Fatalf("battery: %+v", summary.Battery)
+	}
+	// not set on the device
+	if summary.ITunes != nil {
+		t.Fatalf("iTunes: %+v", summary.ITunes)
+	}
+
+	if language, err := dev.Language(ctx); err != nil || language != "en" {
+		t.Fatalf("language: %q %v", language, err)
+	}
+	if locale, err := dev.Locale(ctx); err != nil || locale != "en_US" {
+		t.Fatalf("locale: %q %v", locale, err)
+	}
+	if enabled, err := dev.WiFiDebugging(ctx); err != nil || !enabled {
+		t.Fatalf("wifi debugging: %v %v", enabled, err)
+	}
+	if state, err := dev.ActivationState(ctx); err != nil || state != "Activated" {
+		t.Fatalf("activation state: %q %v", state, err)
+	}
+
+	simDev.SetValue("", "ProductVersion", "17.4.1")
+	version, err := dev.OSVersion(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version.Major() != 17 || version.Minor() != 4 || version.Patch() != 1 {
+		t.Fatalf("OSVersion: %s", version)
+	}
+}
@@ -0,0 +1,126 @@
+package giDevice
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/libimobiledevice"
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/mdns"
+)
+
+const mobileDeviceService = "_apple-mobdev2._tcp.local."
+
+var _ Discovery = (*networkDiscovery)(nil)
+
+// NewNetworkDiscovery browses the devices advertising Wi-Fi sync over mDNS
+// (`_apple-mobdev2._tcp`), matching them to the pair records of store by WiFiMACAddress.
+// Devices without a pair record are ignored. `_remoted._tcp` is not browsed, it does
+// not speak lockdown.
+func NewNetworkDiscovery(store PairRecordStore, opts ...DiscoveryOption) (Discovery, error) {
+	lister, ok := store.(PairRecordLister)
+	if !ok {
+		return nil, errors.New("network discovery: PairRecordStore can not list its records")
+	}
+	opt := defaultDiscoveryOption()
+	for _, fn := range opts {
+		fn(opt)
+	}
+	return &networkDiscovery{store: store, lister: lister, opt: opt}, nil
+}
+
+type networkDiscovery struct {
+	store  PairRecordStore
+	lister PairRecordLister
+	opt    *discoveryOption
+}
+
+// Listen sends a network device whenever one shows up or moves to another address,
+// until the CancelFunc is called. The channel is closed then. A device leaving the
+// network is sent with no NetworkAddress, as Usbmux.Listen sends a detached device
+// with no SerialNumber.
+func (nd *networkDiscovery) Listen(devNotifier chan Device) (context.CancelFunc, error) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	browser := &mdns.Browser{Addr: nd.opt.addr, Interval: nd.opt.interval}
+	entries, err := browser.Browse(ctx, mobileDeviceService)
+	if err != nil {
+		cancelFunc()
+		return nil, err
+	}
+
+	go func(ctx context.Context) {
+		defer close(devNotifier)
+		// the UDID each instance resolved to, goodbyes carry only the instance name
+		instances := make(map[string]string)
+		for entry := range entries {
+			var dev Device
+			if entry.Removed {
+				udid := instances[entry.Name]
+				if udid == "" {
+					continue
+				}
+				delete(instances, entry.Name)
+				dev = newDevice(nil, DeviceProperties{
+					SerialNumber:           udid,
+					UDID:                   udid,
+					ConnectionType:         "Network",
+					EscapedFullServiceName: entry.Name,
+				})
+			} else {
+				udid, ip := nd.match(entry)
+				if udid == "" {
+					continue
+				}
+				instances[entry.Name] = udid
+				if dev, err = NewNetworkDevice(DeviceProperties{
+					SerialNumber:           udid,
+					UDID:                   udid,
+					ConnectionType:         "Network",
+					EscapedFullServiceName: entry.Name,
+					NetworkAddress:         libimobiledevice.NewNetworkAddress(ip),
+				}, nd.store, nd.opt.networkOptions...); err != nil {
+					continue
+				}
+			}
+			select {
+			case devNotifier <- dev:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}(ctx)
+	return cancelFunc, nil
+}
+
+// match the UDID of entry and the address to reach it on
+func (nd *networkDiscovery) match(entry *mdns.Entry) (udid string, ip net.IP) {
+	if len(entry.IPs) == 0 {
+		return "", nil
+	}
+	// the resolved addresses, the link-local one in the instance name comes without
+	// the interface it is on
+	ip = entry.IPs[0]
+	for _, addr := range entry.IPs {
+		if !addr.IsLinkLocalUnicast() {
+			ip = addr
+			break
+		}
+	}
+
+	// `<WiFiAddress>@<IP>`
+	mac := entry.Instance
+	if i := strings.IndexByte(mac, '@'); i >= 0 {
+		mac = mac[:i]
+	}
+	pairRecords, err := nd.lister.PairRecords()
+	if err != nil {
+		return "", nil
+	}
+	for id, record := range pairRecords {
+		if record.WiFiMACAddress != "" && strings.EqualFold(record.WiFiMACAddress, mac) {
+			return id, ip
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,116 @@
+package giDevice
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/idevicetest"
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/libimobiledevice"
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/mdns"
+)
+
+func Test_networkDiscovery_simulated_Listen(t *testing.T) {
+	setupSimulator(t)
+	pairRecord, err := simSrv.Pair(simDev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewMemoryPairRecordStore()
+	if err = store.SavePairRecord(simDev.UDID, pairRecord); err != nil {
+		t.Fatal(err)
+	}
+
+	addr, err := simDev.ListenNetwork()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(simDev.Close)
+	_, port, _ := net.SplitHostPort(addr)
+	lockdownPort, _ := strconv.Atoi(port)
+
+	responder, err := idevicetest.NewResponder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer responder.Close()
+	loopback := net.IPv4(127, 0, 0, 1)
+	// never paired with this host
+	responder.Advertise(idevicetest.ServiceInstance{
+		Instance: "a4:83:e7:ff:ff:ff@127.0.0.2",
+		Service:  idevicetest.MobileDeviceService,
+		Host:     "other.local.",
+		IPs:      []net.IP{net.IPv4(127, 0, 0, 2)},
+		Port:     32498,
+	})
+	// named after its link-local address, reached on the resolved one
+	wifiAddress, _ := simDev.Value("", "WiFiAddress")
+	instance := wifiAddress.(string) + "@fe80::a6:83ff:fee7:1"
+	responder.Advertise(idevicetest.ServiceInstance{
+		Instance: instance,
+		Service:  idevicetest.MobileDeviceService,
+		Host:     simDev.UDID + ".local.",
+		IPs:      []net.IP{net.ParseIP("fe80::a6:83ff:fee7:1"), loopback},
+		Port:     32498,
+	})
+	// not lockdown, never sent
+	responder.Advertise(idevicetest.ServiceInstance{
+		Instance: "iPhone",
+		Service:  idevicetest.RemoteService,
+		Host:     simDev.UDID + ".local.",
+		IPs:      []net.IP{loopback},
+		Port:     58783,
+	})
+
+	discovery, err := NewNetworkDiscovery(store,
+		WithDiscoveryAddr(responder.Addr()),
+		WithDiscoveryInterval(100*time.Millisecond),
+		WithDiscoveryNetworkOptions(WithNetworkLockdownPort(lockdownPort)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	devNotifier := make(chan Device)
+	cancel, err := discovery.Listen(devNotifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+
+	name := mdns.JoinName(instance) + idevicetest.MobileDeviceService
+	select {
+	case d := <-devNotifier:
+		props := d.Properties()
+		if props.SerialNumber != simDev.UDID || props.ConnectionType != "Network" || props.EscapedFullServiceName != name {
+			t.Fatalf("properties: %#v", props)
+		}
+		if ip, err := libimobiledevice.ParseNetworkAddress(props.NetworkAddress); err != nil || !ip.Equal(loopback) {
+			t.Fatalf("address: %v %v", ip, err)
+		}
+		if v, err := d.GetValue("", "ProductVersion"); err != nil || v != "16.0" {
+			t.Fatalf("ProductVersion: %v %v", v, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("not discovered")
+	}
+
+	responder.Withdraw(instance, idevicetest.MobileDeviceService)
+	select {
+	case d := <-devNotifier:
+		props := d.Properties()
+		if props.SerialNumber != simDev.UDID || props.EscapedFullServiceName != name || len(props.NetworkAddress) != 0 {
+			t.Fatalf("detached: %#v", props)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("not detached")
+	}
+
+	cancel()
+	for range devNotifier {
+	}
+
+	if _, err = NewNetworkDiscovery(&usbmuxdPairRecordStore{}); err == nil {
+		t.Fatal("expected an error with a store that can not list")
+	}
+}
@@ -0,0 +1,178 @@
+package giDevice
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var _ Forwarder = (*forwarder)(nil)
+
+type forwarder struct {
+	// first in the struct for 64-bit atomic access on 32-bit platforms
+	active, accepted, sent, received int64
+
+	dev        *device
+	devicePort int
+	ln         net.Listener
+	ctx        context.Context
+	cancel     context.CancelFunc
+
+	mu sync.Mutex
+	// conns of the clients and to the device, closed when stopping
+	conns map[net.Conn]struct{}
+	wg    sync.WaitGroup
+	done  chan struct{}
+}
+
+func (d *device) Forward(ctx context.Context, localAddr string, devicePort int) (Forwarder, error) {
+	ln, err := net.Listen("tcp", localAddr)
+	if err != nil {
+		return nil, fmt.Errorf("forward: %w", err)
+	}
+	f := &forwarder{
+		dev:        d,
+		devicePort: devicePort,
+		ln:         ln,
+		conns:      make(map[net.Conn]struct{}),
+		done:       make(chan struct{}),
+	}
+	f.ctx, f.cancel = context.WithCancel(ctx)
+	go f.serve()
+	return f, nil
+}
+
+func (f *forwarder) serve() {
+	defer close(f.done)
+	go func() {
+		<-f.ctx.Done()
+		_ = f.ln.Close()
+		f.mu.Lock()
+		for conn := range f.conns {
+			_ = conn.Close()
+		}
+		f.mu.Unlock()
+	}()
+
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			if f.ctx.Err() == nil && debugFlag {
+				log.Printf("forward: %s", err)
+			}
+			break
+		}
+		atomic.AddInt64(&f.accepted, 1)
+		if !f.track(conn, true) {
+			_ = conn.Close()
+			break
+		}
+		atomic.AddInt64(&f.active, 1)
+		f.wg.Add(1)
+		go func() {
+			defer f.wg.Done()
+			defer atomic.AddInt64(&f.active, -1)
+			defer f.track(conn, false)
+			f.forward(conn)
+		}()
+	}
+	f.cancel()
+	f.wg.Wait()
+}
+
+// track adds or removes a connection to close when stopping, none is added once stopping
+func (f *forwarder) track(conn net.Conn, add bool) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !add {
+		delete(f.conns, conn)
+		return true
+	}
+	if f.ctx.Err() != nil {
+		return false
+	}
+	f.conns[conn] = struct{}{}
+	return true
+}
+
+func (f *forwarder) forward(conn net.Conn) {
+	defer conn.Close()
+	innerConn, err := f.dev.NewConnectContext(f.ctx, f.devicePort)
+	if err != nil {
+		if debugFlag {
+			log.Printf("forward: %s to %d: %s", conn.RemoteAddr(), f.devicePort, err)
+		}
+		return
+	}
+	defer innerConn.Close()
+	devConn := innerConn.RawConn()
+	_ = devConn.SetDeadline(time.Time{})
+	if !f.track(devConn, true) {
+		return
+	}
+	defer f.track(devConn, false)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		pipe(devConn, conn, &f.sent)
+	}()
+	go func() {
+		defer wg.Done()
+		pipe(conn, devConn, &f.received)
+	}()
+	wg.Wait()
+}
+
+// pipe copies src to dst until EOF, then closes the writing side of dst,
+// or all of both connections when that is not possible
+func pipe(dst, src net.Conn, count *int64) {
+	_, err := io.Copy(&countingWriter{dst, count}, src)
+	if cw, ok := dst.(interface{ CloseWrite() error }); ok && err == nil {
+		if cw.CloseWrite() == nil {
+			return
+		}
+	}
+	_ = dst.Close()
+	_ = src.Close()
+}
+
+type countingWriter struct {
+	w     io.Writer
+	count *int64
+}
+
+func (w *countingWriter) Write(p []byte) (n int, err error) {
+	n, err = w.w.Write(p)
+	atomic.AddInt64(w.count, int64(n))
+	return
+}
+
+func (f *forwarder) Addr() net.Addr {
+	return f.ln.Addr()
+}
+
+func (f *forwarder) Stats() ForwardStats {
+	return ForwardStats{
+		Active:        int(atomic.LoadInt64(&f.active)),
+		Accepted:      atomic.LoadInt64(&f.accepted),
+		BytesSent:     atomic.LoadInt64(&f.sent),
+		BytesReceived: atomic.LoadInt64(&f.received),
+	}
+}
+
+func (f *forwarder) Close() error {
+	f.cancel()
+	<-f.done
+	return nil
+}
+
+func (f *forwarder) Done() <-chan struct{} {
+	return f.done
+}
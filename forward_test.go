@@ -0,0 +1,89 @@
+package giDevice
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_device_simulated_Forward(t *testing.T) {
+	setupSimulatedDevice(t)
+	// a line echo server, WebDriverAgent say
+	simDev.HandlePort(8100, func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if _, err = io.WriteString(conn, line); err != nil {
+				return
+			}
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	f, err := dev.Forward(ctx, "127.0.0.1:0", 8100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const clients = 4
+	conns := make([]net.Conn, clients)
+	var wg sync.WaitGroup
+	for i := range conns {
+		if conns[i], err = net.Dial("tcp", f.Addr().String()); err != nil {
+			t.Fatal(err)
+		}
+		defer conns[i].Close()
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conn := conns[i]
+			_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+			want := fmt.Sprintf("hello %d\n", i)
+			if _, err := io.WriteString(conn, want); err != nil {
+				t.Error(err)
+				return
+			}
+			if line, err := bufio.NewReader(conn).ReadString('\n'); err != nil || line != want {
+				t.Errorf("echo %q, %v", line, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// the counts may trail the echo a little
+	want := ForwardStats{Active: clients, Accepted: clients, BytesSent: int64(len("hello 0\n") * clients)}
+	want.BytesReceived = want.BytesSent
+	stats := f.Stats()
+	for deadline := time.Now().Add(5 * time.Second); stats != want && time.Now().Before(deadline); stats = f.Stats() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if stats != want {
+		t.Fatalf("stats: %+v", stats)
+	}
+
+	cancel()
+	select {
+	case <-f.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("still forwarding")
+	}
+	_ = conns[0].SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err = conns[0].Read(make([]byte, 1)); err == nil {
+		t.Fatal("client still connected")
+	}
+	if _, err = net.Dial("tcp", f.Addr().String()); err == nil {
+		t.Fatal("still listening")
+	}
+	if stats = f.Stats(); stats.Active != 0 {
+		t.Fatalf("stats: %+v", stats)
+	}
+}
@@ -4,6 +4,7 @@ go 1.16
 
 require (
 	github.com/Masterminds/semver/v3 v3.2.1
+	github.com/github/ietf-cms v0.2.0
 	github.com/google/gopacket v1.1.19
 	github.com/lunixbochs/struc v0.0.0-20200707160740-784aaebc1d40
 	github.com/sam80180/mobileprovision v0.0.0-20240118170613-cd32c3f72901
@@ -3,11 +3,19 @@ package giDevice
 import (
 	"bytes"
 	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io/fs"
+	"net"
 	"time"
 
+	"github.com/Masterminds/semver/v3"
 	"github.com/SonicCloudOrg/sonic-gidevice/pkg/libimobiledevice"
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/mdns"
 	"github.com/SonicCloudOrg/sonic-gidevice/pkg/nskeyedarchiver"
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/remotexpc"
 	"github.com/sam80180/mobileprovision"
 )
 
@@ -17,6 +25,129 @@ type Usbmux interface {
 	Listen(chan Device) (context.CancelFunc, error)
 }
 
+// DeviceManager keeps track of the devices of usbmuxd, one per UDID, through
+// restarts of usbmuxd
+type DeviceManager interface {
+	// Devices attached, over USB when they are, else over the network
+	Devices() []Device
+	Device(udid string) (dev Device, ok bool)
+	// Subscribe receives the events from now on, until cancel or Close
+	Subscribe() (events <-chan DeviceEvent, cancel context.CancelFunc)
+	// WaitForDevice returns the device of udid as soon as it is attached
+	WaitForDevice(ctx context.Context, udid string) (dev Device, err error)
+	Close() error
+}
+
+type DeviceEventType int
+
+const (
+	DeviceEventAttached DeviceEventType = iota + 1
+	DeviceEventDetached
+	DeviceEventPaired
+)
+
+func (t DeviceEventType) String() string {
+	switch t {
+	case DeviceEventAttached:
+		return "attached"
+	case DeviceEventDetached:
+		return "detached"
+	case DeviceEventPaired:
+		return "paired"
+	}
+	return fmt.Sprintf("device event %d", int(t))
+}
+
+// DeviceEvent a connection of UDID attaching, detaching or pairing
+type DeviceEvent struct {
+	Type DeviceEventType
+	UDID string
+	// ConnectionType of the connection, `USB` or `Network`
+	ConnectionType string
+	// Device is the one DeviceManager.Devices lists after the event, nil once
+	// no connection of UDID is left
+	Device Device
+}
+
+// LifecycleStage a step of a device coming back, see WithLifecycleProgress
+type LifecycleStage int
+
+const (
+	LifecycleStageDetached LifecycleStage = iota + 1
+	LifecycleStageAttached
+	// LifecycleStageLockdown lockdownd answers
+	LifecycleStageLockdown
+	// LifecycleStageUnlocked the user entered the passcode, see WithLifecycleWaitUnlocked
+	LifecycleStageUnlocked
+	// LifecycleStageSpringBoard SpringBoard is up, see WithLifecycleWaitSpringBoard
+	LifecycleStageSpringBoard
+)
+
+func (s LifecycleStage) String() string {
+	switch s {
+	case LifecycleStageDetached:
+		return "detached"
+	case LifecycleStageAttached:
+		return "attached"
+	case LifecycleStageLockdown:
+		return "lockdownd answering"
+	case LifecycleStageUnlocked:
+		return "unlocked"
+	case LifecycleStageSpringBoard:
+		return "SpringBoard up"
+	}
+	return fmt.Sprintf("lifecycle stage %d", int(s))
+}
+
+// DeveloperModeStage a step of Device.EnableDeveloperMode, see WithDeveloperModeProgress
+type DeveloperModeStage int
+
+const (
+	// DeveloperModeStageArmed the device restarts to show the prompt
+	DeveloperModeStageArmed DeveloperModeStage = iota + 1
+	// DeveloperModeStageRestarted the device is back and unlocked
+	DeveloperModeStageRestarted
+	// DeveloperModeStageEnabled the prompt is confirmed, or was long ago
+	DeveloperModeStageEnabled
+)
+
+func (s DeveloperModeStage) String() string {
+	switch s {
+	case DeveloperModeStageArmed:
+		return "armed"
+	case DeveloperModeStageRestarted:
+		return "restarted"
+	case DeveloperModeStageEnabled:
+		return "enabled"
+	}
+	return fmt.Sprintf("developer mode stage %d", int(s))
+}
+
+// UsbmuxServer shares the devices of usbmuxd with remote hosts, see NewRemoteUsbmux
+type UsbmuxServer interface {
+	// Serve accepts clients on ln until ctx is done, then closes ln and every
+	// client and returns nil once they are gone
+	Serve(ctx context.Context, ln net.Listener) error
+	ListenAndServe(ctx context.Context, addr string) error
+	Stats() UsbmuxServerStats
+}
+
+type UsbmuxServerStats struct {
+	// Clients connected, Accepted in all, Rejected failing authentication
+	Clients  int
+	Accepted int64
+	Rejected int64
+	// Connections open to devices, BytesSent to them and BytesReceived from them
+	Connections   int
+	BytesSent     int64
+	BytesReceived int64
+}
+
+// Discovery finds devices other than through usbmuxd
+type Discovery interface {
+	Listen(chan Device) (context.CancelFunc, error)
+}
+
 type Device interface {
 	Properties() DeviceProperties
 
@@ -24,11 +155,53 @@ type Device interface {
 	ReadPairRecord() (pairRecord *PairRecord, err error)
 	SavePairRecord(pairRecord *PairRecord) (err error)
 	DeletePairRecord() (err error)
+	// SetPairRecordStore selects where the pair record is kept, nil is usbmuxd
+	SetPairRecordStore(store PairRecordStore)
+	// UseRemoteServiceDiscovery starts the services lockdownd refuses (iOS 17+) through
+	// the RemoteServiceDiscovery at addr, e.g. `[fd00::1]:58783` at the end of a tunnel
+	UseRemoteServiceDiscovery(ctx context.Context, addr string) (err error)
+	// StartTunnel opens the CoreDeviceProxy tunnel of iOS 17.4+ and uses the
+	// RemoteServiceDiscovery at its end, services lockdownd refuses go through it
+	StartTunnel(ctx context.Context) (tunnel Tunnel, err error)
+	// StartService starts the lockdown service name on a connection of its own,
+	// for the caller to close
+	StartService(ctx context.Context, name string) (innerConn InnerConn, err error)
+	// StartAfc starts an AFC service of its own, not the one AfcService shares,
+	// for the caller to Close
+	StartAfc(ctx context.Context) (afc Afc, err error)
+	// StartHouseArrest starts a house_arrest service of its own, to vend Documents
+	// or Container once, the Afc it gives being for the caller to Close
+	StartHouseArrest(ctx context.Context) (houseArrest HouseArrest, err error)
+	// AfcPool starts n AFC services of its own for batch calls, for the caller to Close
+	AfcPool(ctx context.Context, n int) (pool AfcPool, err error)
+	// Close closes the connections kept to services and stops perf, syslog, pcap
+	// and the tunnel, the device is not to be used after
+	Close() (err error)
 
 	lockdownService() (lockdown Lockdown, err error)
 	QueryType() (LockdownType, error)
 	GetValue(domain, key string) (v interface{}, err error)
 	Pair() (pairRecord *PairRecord, err error)
+	// PairWithOptions pairs, waiting for the user to trust this host, and saves the pair record
+	PairWithOptions(ctx context.Context, opts ...PairOption) (pairRecord *PairRecord, err error)
+	// ValidatePair checks the device still trusts the saved pair record
+	ValidatePair() (err error)
+	// DeviceValues the values of the root lockdown domain, typed
+	DeviceValues(ctx context.Context) (values *DeviceValues, err error)
+	// DeviceSummary the root domain along with disk usage, battery, international,
+	// wireless lockdown and iTunes, read in one session
+	DeviceSummary(ctx context.Context) (summary *DeviceSummary, err error)
+	DiskUsage(ctx context.Context) (usage *DiskUsage, err error)
+	Battery(ctx context.Context) (battery *Battery, err error)
+	Language(ctx context.Context) (language string, err error)
+	Locale(ctx context.Context) (locale string, err error)
+	// WiFiDebugging whether the device is reachable over Wi-Fi
+	WiFiDebugging(ctx context.Context) (enabled bool, err error)
+	ActivationState(ctx context.Context) (state string, err error)
+	// OSVersion the ProductVersion, `16.4.1` say
+	OSVersion(ctx context.Context) (version *semver.Version, err error)
+	// Unpair makes the device forget this host and deletes the saved pair record
+	Unpair() (err error)
 
 	imageMounterService() (imageMounter ImageMounter, err error)
 	Images(imgType ...string) (imageSignatures [][]byte, err error)
@@ -52,6 +225,9 @@ type Device interface {
 	AppList(opts ...AppListOption) (apps []Application, err error)
 	DeviceInfo() (devInfo *DeviceInfo, err error)
 	AmfiService() (Amfi, error)
+	// EnableDeveloperMode arms developer mode, waits for the device to restart and
+	// be unlocked, then confirms the prompt. The device returned replaces this one.
+	EnableDeveloperMode(ctx context.Context, opts ...DeveloperModeOption) (dev Device, err error)
 	AfcService() (afc Afc, err error)
 	AppInstall(ipaPath string) (err error)
 	AppUninstall(bundleID string) (err error)
@@ -69,6 +245,16 @@ type Device interface {
 	Reboot() error
 	Shutdown() error
 	PowerSource() (powerInfo map[string]interface{}, err error)
+	// RebootAndWait reboots and waits until the device detaches, attaches again and
+	// is ready, see WaitForReady. The device returned replaces this one.
+	RebootAndWait(ctx context.Context, opts ...LifecycleOption) (dev Device, err error)
+	// ShutdownAndWait shuts down and waits until the device detaches
+	ShutdownAndWait(ctx context.Context, opts ...LifecycleOption) (err error)
+	// EnterRecoveryAndWait enters recovery mode and waits until the device detaches
+	EnterRecoveryAndWait(ctx context.Context, opts ...LifecycleOption) (err error)
+	// WaitForReady waits until lockdownd answers, and the device is unlocked or
+	// SpringBoard is up with WithLifecycleWaitUnlocked or WithLifecycleWaitSpringBoard
+	WaitForReady(ctx context.Context, opts ...LifecycleOption) (err error)
 
 	crashReportMoverService() (crashReportMover CrashReportMover, err error)
 	MoveCrashReport(hostDir string, opts ...CrashReportMoverOption) (err error)
@@ -84,7 +270,34 @@ type Device interface {
 
 	WebInspectorService() (webInspector WebInspector, err error)
 
+	// Share serves this device to NewRemoteConnect.
+	//
+	// Deprecated: use NewUsbmuxServer, it shares every device, with authentication.
 	Share(port int) error
+	// Forward listens on localAddr (`127.0.0.1:8100`, say) and connects each client to
+	// devicePort through a connection of its own, until ctx is done or it is closed
+	Forward(ctx context.Context, localAddr string, devicePort int) (forwarder Forwarder, err error)
+
+	// Context variants give up when ctx is done. A service interrupted
+	// in the middle of a request is dropped and reconnected on next use.
+
+	NewConnectContext(ctx context.Context, port int, timeout ...time.Duration) (InnerConn, error)
+	GetValueContext(ctx context.Context, domain, key string) (v interface{}, err error)
+	ScreenshotContext(ctx context.Context) (raw *bytes.Buffer, err error)
+	InstallationProxyBrowseContext(ctx context.Context, opts ...InstallationProxyOption) (currentList []interface{}, err error)
+	InstallationProxyLookupContext(ctx context.Context, opts ...InstallationProxyOption) (lookupResult interface{}, err error)
+	AppLaunchContext(ctx context.Context, bundleID string, opts ...AppLaunchOption) (pid int, err error)
+	AppKillContext(ctx context.Context, pid int) (err error)
+	AppRunningProcessesContext(ctx context.Context) (processes []Process, err error)
+	AppListContext(ctx context.Context, opts ...AppListOption) (apps []Application, err error)
+	DeviceInfoContext(ctx context.Context) (devInfo *DeviceInfo, err error)
+	AfcServiceContext(ctx context.Context) (afc Afc, err error)
+	AppInstallContext(ctx context.Context, ipaPath string) (err error)
+	AppUninstallContext(ctx context.Context, bundleID string) (err error)
+	// XCTestContext stops the test when ctx is done
+	XCTestContext(ctx context.Context, bundleID string, opts ...XCTestOption) (out <-chan string, cancel context.CancelFunc, err error)
+	// PerfStartContext stops collecting when ctx is done
+	PerfStartContext(ctx context.Context, opts ...PerfOption) (data <-chan []byte, err error)
 }
 
 type DeviceProperties = libimobiledevice.DeviceProperties
@@ -96,8 +309,14 @@ type Lockdown interface {
 	GetValue(domain, key string) (v interface{}, err error)
 	SetValue(domain, key string, value interface{}) (err error)
 	Pair() (pairRecord *PairRecord, err error)
+	ValidatePair(pairRecord *PairRecord) (err error)
+	Unpair(pairRecord *PairRecord) (err error)
 	EnterRecovery() (err error)
 
+	newPairRecord(identity *PairRecord) (pairRecord *PairRecord, err error)
+	pair(pairRecord *PairRecord) (err error)
+	pairSupervised(pairRecord *PairRecord, supervisorCert *x509.Certificate, supervisorKey crypto.Signer) (err error)
+
 	handshake() (err error)
 
 	startSession(pairRecord *PairRecord) (err error)
@@ -130,6 +349,7 @@ type ImageMounter interface {
 type Screenshot interface {
 	exchange() (err error)
 	Take() (raw *bytes.Buffer, err error)
+	TakeContext(ctx context.Context) (raw *bytes.Buffer, err error)
 }
 
 type SimulateLocation interface {
@@ -143,6 +363,11 @@ type InstallationProxy interface {
 	Lookup(opts ...InstallationProxyOption) (lookupResult interface{}, err error)
 	Install(bundleID, packagePath string) (err error)
 	Uninstall(bundleID string) (err error)
+
+	BrowseContext(ctx context.Context, opts ...InstallationProxyOption) (currentList []interface{}, err error)
+	LookupContext(ctx context.Context, opts ...InstallationProxyOption) (lookupResult interface{}, err error)
+	InstallContext(ctx context.Context, bundleID, packagePath string) (err error)
+	UninstallContext(ctx context.Context, bundleID string) (err error)
 }
 
 type Instruments interface {
@@ -152,22 +377,31 @@ type Instruments interface {
 	AppList(opts ...AppListOption) (apps []Application, err error)
 	DeviceInfo() (devInfo *DeviceInfo, err error)
 
+	AppLaunchContext(ctx context.Context, bundleID string, opts ...AppLaunchOption) (pid int, err error)
+	AppKillContext(ctx context.Context, pid int) (err error)
+	AppRunningProcessesContext(ctx context.Context) (processes []Process, err error)
+	AppListContext(ctx context.Context, opts ...AppListOption) (apps []Application, err error)
+	DeviceInfoContext(ctx context.Context) (devInfo *DeviceInfo, err error)
+
 	getPidByBundleID(bundleID string) (pid int, err error)
 	appProcess(bundleID string) (err error)
 	startObserving(pid int) (err error)
 
-	notifyOfPublishedCapabilities() (err error)
+	notifyOfPublishedCapabilities(ctx context.Context) (err error)
 	requestChannel(channel string) (id uint32, err error)
 	call(channel, selector string, auxiliaries ...interface{}) (result *libimobiledevice.DTXMessageResult, err error)
+	callContext(ctx context.Context, channel, selector string, auxiliaries ...interface{}) (result *libimobiledevice.DTXMessageResult, err error)
 
 	// sysMonSetConfig(cfg ...interface{}) (err error)
 	// SysMonStart(cfg ...interface{}) (_ interface{}, err error)
 
 	registerCallback(obj string, cb func(m libimobiledevice.DTXMessageResult))
+	registerChannelCallback(channel string, cb func(m libimobiledevice.DTXMessageResult)) (err error)
+	unregisterChannelCallback(channel string)
 }
 
 type Testmanagerd interface {
-	notifyOfPublishedCapabilities() (err error)
+	notifyOfPublishedCapabilities(ctx context.Context) (err error)
 	requestChannel(channel string) (id uint32, err error)
 	newXCTestManagerDaemon() (xcTestManager XCTestManagerDaemon, err error)
 
@@ -193,6 +427,7 @@ type Afc interface {
 	Mkdir(path string) (err error)
 	Link(oldName string, newName string, linkType AfcLinkType) (err error)
 	Truncate(filePath string, size int64) (err error)
+	// SetFileModTime sets st_mtime, which AFC keeps in nanoseconds as GetFileInfo reports it
 	SetFileModTime(filePath string, modTime time.Time) (err error)
 	// Hash sha1 algorithm
 	Hash(filePath string) ([]byte, error)
@@ -201,6 +436,34 @@ type Afc interface {
 	RemoveAll(path string) (err error)
 
 	WriteFile(filename string, data []byte, perm AfcFileMode) (err error)
+	// Close closes the connection, AfcService starts the shared one again on the next call
+	Close() (err error)
+	// Push streams the host file localPath to remotePath, resuming a partial upload
+	Push(localPath, remotePath string, opts ...AfcTransferOption) (err error)
+	// Pull streams remotePath to the host file localPath, resuming a partial download
+	Pull(remotePath, localPath string, opts ...AfcTransferOption) (err error)
+}
+
+// AfcFS an Afc as an io/fs file system, see NewAfcFS
+type AfcFS interface {
+	fs.StatFS
+	fs.ReadDirFS
+	fs.ReadFileFS
+}
+
+// AfcPool runs AFC requests over several connections at once, see NewAfcPool
+type AfcPool interface {
+	// Do runs fn with a connection no other call is using, waiting for one to be free
+	Do(fn func(afc Afc) error) (err error)
+	// StatMany stats names at once, infos[i] being nil where names[i] failed
+	StatMany(names []string) (infos []*AfcFileInfo, err error)
+	// RemoveMany removes names at once, directories along with their contents
+	RemoveMany(names []string) (err error)
+	// CopyTree copies the directory src to dst, the files at once, keeping mtimes and symlinks
+	CopyTree(src, dst string, direction AfcCopyDirection, opts ...AfcTransferOption) (err error)
+	// Close closes the connections the pool opened, once the calls running return.
+	// Calls fail with ErrAfcPoolClosed after
+	Close() (err error)
 }
 
 type HouseArrest interface {
@@ -216,7 +479,7 @@ type Misagent interface {
 	RemoveProvisionProfile(string) error
 	RemoveAllProvisionProfiles() (*libimobiledevice.ProvisionProfileOperationResults, error)
 	InstallProvisionProfile([]byte) error
-}     
+}
 
 type XCTestManagerDaemon interface {
 	// initiateControlSession iOS 11+
@@ -266,7 +529,6 @@ type WebInspector interface {
 
 type CrashReportMover interface {
 	Move(hostDir string, opts ...CrashReportMoverOption) (err error)
-	walkDir(dirname string, fn func(path string, info *AfcFileInfo)) (err error)
 }
 
 type SpringBoard interface {
@@ -280,6 +542,58 @@ type LockdownType = libimobiledevice.LockdownType
 
 type PairRecord = libimobiledevice.PairRecord
 
+type ServiceDirectory = remotexpc.ServiceDirectory
+
+// Forwarder a local port forwarded to the device, see Device.Forward
+type Forwarder interface {
+	Addr() net.Addr
+	Stats() ForwardStats
+	// Close stops accepting and drops every client
+	Close() error
+	// Done is closed once the listener and every client are gone
+	Done() <-chan struct{}
+}
+
+type ForwardStats struct {
+	// Active clients, Accepted in all
+	Active   int
+	Accepted int64
+	// BytesSent to the device, BytesReceived from it
+	BytesSent     int64
+	BytesReceived int64
+}
+
+// Tunnel the CoreDeviceProxy tunnel of a device, see Device.StartTunnel
+type Tunnel interface {
+	// Address of the device in the tunnel
+	Address() net.IP
+	RSDPort() int
+	// DialContext connects to addr, `[ip]:port`, through the tunnel
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+	// Dial connects to port of the device
+	Dial(port int) (net.Conn, error)
+	Close() error
+}
+
+type (
+	ReplyCode                  = libimobiledevice.ReplyCode
+	LockdownError              = libimobiledevice.LockdownError
+	AfcError                   = libimobiledevice.AfcError
+	InstallationProxyError     = libimobiledevice.InstallationProxyError
+	InstallationProxyErrorCode = libimobiledevice.InstallationProxyErrorCode
+	NSError                    = libimobiledevice.NSError
+)
+
+const (
+	ErrPasswordProtected            = libimobiledevice.ErrPasswordProtected
+	ErrInvalidHostID                = libimobiledevice.ErrInvalidHostID
+	ErrPairingDialogResponsePending = libimobiledevice.ErrPairingDialogResponsePending
+	ErrUserDeniedPairing            = libimobiledevice.ErrUserDeniedPairing
+	ErrSessionInactive              = libimobiledevice.ErrSessionInactive
+	ErrInvalidService               = libimobiledevice.ErrInvalidService
+	ErrMissingValue                 = libimobiledevice.ErrMissingValue
+)
+
 type CoordinateSystem = libimobiledevice.CoordinateSystem
 
 const (
@@ -449,6 +763,93 @@ func WithWhenMoveIsDone(whenDone func(filename string)) CrashReportMoverOption {
 	}
 }
 
+type afcTransferOption struct {
+	chunkSize int
+	progress  func(transferred, total int64)
+	resume    bool
+	verify    bool
+}
+
+func defaultAfcTransferOption() *afcTransferOption {
+	return &afcTransferOption{
+		chunkSize: 1 << 20,
+		progress:  func(transferred, total int64) {},
+		resume:    true,
+		verify:    true,
+	}
+}
+
+type AfcTransferOption func(opt *afcTransferOption)
+
+// WithAfcChunkSize how many bytes to read or write per AFC request
+func WithAfcChunkSize(size int) AfcTransferOption {
+	return func(opt *afcTransferOption) {
+		if size > 0 {
+			opt.chunkSize = size
+		}
+	}
+}
+
+// WithAfcProgress calls progress after each chunk, the bytes resumed included in transferred
+func WithAfcProgress(progress func(transferred, total int64)) AfcTransferOption {
+	return func(opt *afcTransferOption) {
+		opt.progress = progress
+	}
+}
+
+// WithAfcResume continues from a partial file at the destination when its
+// range hash matches the source, on by default. Devices not hashing ranges get it in full
+func WithAfcResume(b bool) AfcTransferOption {
+	return func(opt *afcTransferOption) {
+		opt.resume = b
+	}
+}
+
+// WithAfcVerify compares the hash of the whole file on both sides once done, on by default.
+// Skipped on devices not hashing ranges
+func WithAfcVerify(b bool) AfcTransferOption {
+	return func(opt *afcTransferOption) {
+		opt.verify = b
+	}
+}
+
+type afcSyncOption struct {
+	delete   bool
+	hash     bool
+	parallel int
+	open     func() (Afc, error)
+}
+
+func defaultAfcSyncOption() *afcSyncOption {
+	return &afcSyncOption{parallel: 1}
+}
+
+type AfcSyncOption func(opt *afcSyncOption)
+
+// WithAfcSyncDelete removes what the destination has but the source does not
+func WithAfcSyncDelete(b bool) AfcSyncOption {
+	return func(opt *afcSyncOption) {
+		opt.delete = b
+	}
+}
+
+// WithAfcSyncHash compares files of the same size by Afc.Hash rather than by mtime
+func WithAfcSyncHash(b bool) AfcSyncOption {
+	return func(opt *afcSyncOption) {
+		opt.hash = b
+	}
+}
+
+// WithAfcSyncParallel transfers n files at a time, over the Afc given and n-1 more
+// opened with open and closed once done, Device.StartAfc say
+func WithAfcSyncParallel(n int, open func() (Afc, error)) AfcSyncOption {
+	return func(opt *afcSyncOption) {
+		if n > 0 {
+			opt.parallel, opt.open = n, open
+		}
+	}
+}
+
 type xcTestOption struct {
 	appEnv  map[string]interface{}
 	appArgs []interface{}
@@ -483,6 +884,301 @@ func WithXCTestOpt(appOpt map[string]interface{}) XCTestOption {
 	}
 }
 
+type networkOption struct {
+	lockdownPort int
+}
+
+func defaultNetworkOption() *networkOption {
+	return &networkOption{
+		lockdownPort: LockdownPort,
+	}
+}
+
+type NetworkOption func(opt *networkOption)
+
+// WithNetworkLockdownPort where lockdownd listens, when it is not LockdownPort (e.g. forwarded)
+func WithNetworkLockdownPort(port int) NetworkOption {
+	return func(opt *networkOption) {
+		opt.lockdownPort = port
+	}
+}
+
+type discoveryOption struct {
+	addr           *net.UDPAddr
+	interval       time.Duration
+	networkOptions []NetworkOption
+}
+
+func defaultDiscoveryOption() *discoveryOption {
+	return &discoveryOption{
+		interval: mdns.DefaultInterval,
+	}
+}
+
+type DiscoveryOption func(opt *discoveryOption)
+
+// WithDiscoveryAddr where mDNS queries are sent, mdns.DefaultAddr by default
+func WithDiscoveryAddr(addr *net.UDPAddr) DiscoveryOption {
+	return func(opt *discoveryOption) {
+		opt.addr = addr
+	}
+}
+
+// WithDiscoveryInterval between repeated queries
+func WithDiscoveryInterval(interval time.Duration) DiscoveryOption {
+	return func(opt *discoveryOption) {
+		opt.interval = interval
+	}
+}
+
+// WithDiscoveryNetworkOptions the options of the network devices found
+func WithDiscoveryNetworkOptions(opts ...NetworkOption) DiscoveryOption {
+	return func(opt *discoveryOption) {
+		opt.networkOptions = opts
+	}
+}
+
+type deviceManagerOption struct {
+	usbmuxdAddr            string
+	minBackoff, maxBackoff time.Duration
+}
+
+func defaultDeviceManagerOption() *deviceManagerOption {
+	return &deviceManagerOption{
+		minBackoff: 500 * time.Millisecond,
+		maxBackoff: 30 * time.Second,
+	}
+}
+
+type DeviceManagerOption func(opt *deviceManagerOption)
+
+// WithDeviceManagerUsbmuxd the usbmuxd followed, the local one by default, in the
+// format of libimobiledevice.UsbmuxdSocketAddressEnv
+func WithDeviceManagerUsbmuxd(addr string) DeviceManagerOption {
+	return func(opt *deviceManagerOption) {
+		opt.usbmuxdAddr = addr
+	}
+}
+
+// WithDeviceManagerBackoff between attempts to reach usbmuxd again, doubling from min up to max
+func WithDeviceManagerBackoff(min, max time.Duration) DeviceManagerOption {
+	return func(opt *deviceManagerOption) {
+		opt.minBackoff, opt.maxBackoff = min, max
+	}
+}
+
+type usbmuxServerOption struct {
+	usbmuxdAddr string
+	tlsConfig   *tls.Config
+	// udids each token and certificate common name may see, nil for all
+	tokens       map[string][]string
+	certificates map[string][]string
+}
+
+func defaultUsbmuxServerOption() *usbmuxServerOption {
+	return &usbmuxServerOption{
+		tokens:       make(map[string][]string),
+		certificates: make(map[string][]string),
+	}
+}
+
+type UsbmuxServerOption func(opt *usbmuxServerOption)
+
+// WithUsbmuxServerUsbmuxd the usbmuxd shared, the local one by default
+func WithUsbmuxServerUsbmuxd(addr string) UsbmuxServerOption {
+	return func(opt *usbmuxServerOption) {
+		opt.usbmuxdAddr = addr
+	}
+}
+
+// WithUsbmuxServerTLS serves over TLS. With WithUsbmuxServerCertificate a copy of config
+// is used, ClientAuth set to tls.RequireAndVerifyClientCert, or tls.VerifyClientCertIfGiven
+// along with WithUsbmuxServerToken
+func WithUsbmuxServerTLS(config *tls.Config) UsbmuxServerOption {
+	return func(opt *usbmuxServerOption) {
+		opt.tlsConfig = config
+	}
+}
+
+// WithUsbmuxServerToken lets in the clients presenting token, to see udids only, or
+// every device without any. Without tokens or certificates every client is let in.
+// Tokens need WithUsbmuxServerTLS, as certificates do.
+func WithUsbmuxServerToken(token string, udids ...string) UsbmuxServerOption {
+	return func(opt *usbmuxServerOption) {
+		opt.tokens[token] = udids
+	}
+}
+
+// WithUsbmuxServerCertificate lets in the clients of a verified certificate of
+// commonName, to see udids only, or every device without any
+func WithUsbmuxServerCertificate(commonName string, udids ...string) UsbmuxServerOption {
+	return func(opt *usbmuxServerOption) {
+		opt.certificates[commonName] = udids
+	}
+}
+
+type remoteUsbmuxOption struct {
+	tlsConfig *tls.Config
+	token     string
+	timeout   time.Duration
+}
+
+func defaultRemoteUsbmuxOption() *remoteUsbmuxOption {
+	return &remoteUsbmuxOption{
+		timeout: libimobiledevice.DefaultDeadlineTimeout,
+	}
+}
+
+type RemoteUsbmuxOption func(opt *remoteUsbmuxOption)
+
+// WithRemoteUsbmuxTLS connects over TLS, see WithUsbmuxServerTLS
+func WithRemoteUsbmuxTLS(config *tls.Config) RemoteUsbmuxOption {
+	return func(opt *remoteUsbmuxOption) {
+		opt.tlsConfig = config
+	}
+}
+
+// WithRemoteUsbmuxToken presents token, see WithUsbmuxServerToken
+func WithRemoteUsbmuxToken(token string) RemoteUsbmuxOption {
+	return func(opt *remoteUsbmuxOption) {
+		opt.token = token
+	}
+}
+
+// WithRemoteUsbmuxTimeout of connecting and of requests
+func WithRemoteUsbmuxTimeout(timeout time.Duration) RemoteUsbmuxOption {
+	return func(opt *remoteUsbmuxOption) {
+		opt.timeout = timeout
+	}
+}
+
+type lifecycleOption struct {
+	timeout     time.Duration
+	interval    time.Duration
+	unlocked    bool
+	springBoard bool
+	progress    func(stage LifecycleStage)
+}
+
+func defaultLifecycleOption() *lifecycleOption {
+	return &lifecycleOption{
+		timeout:  5 * time.Minute,
+		interval: time.Second,
+	}
+}
+
+type LifecycleOption func(opt *lifecycleOption)
+
+// WithLifecycleTimeout how long to wait in all, 0 for as long as ctx allows
+func WithLifecycleTimeout(timeout time.Duration) LifecycleOption {
+	return func(opt *lifecycleOption) {
+		opt.timeout = timeout
+	}
+}
+
+// WithLifecyclePollInterval how often to ask the device again while it is not there yet
+func WithLifecyclePollInterval(interval time.Duration) LifecycleOption {
+	return func(opt *lifecycleOption) {
+		opt.interval = interval
+	}
+}
+
+// WithLifecycleWaitUnlocked waits for the user to enter the passcode as well
+func WithLifecycleWaitUnlocked(b bool) LifecycleOption {
+	return func(opt *lifecycleOption) {
+		opt.unlocked = b
+	}
+}
+
+// WithLifecycleWaitSpringBoard waits for SpringBoard to answer as well
+func WithLifecycleWaitSpringBoard(b bool) LifecycleOption {
+	return func(opt *lifecycleOption) {
+		opt.springBoard = b
+	}
+}
+
+// WithLifecycleProgress calls progress as each stage is reached
+func WithLifecycleProgress(progress func(stage LifecycleStage)) LifecycleOption {
+	return func(opt *lifecycleOption) {
+		opt.progress = progress
+	}
+}
+
+type developerModeOption struct {
+	lifecycle []LifecycleOption
+	progress  func(stage DeveloperModeStage)
+}
+
+func defaultDeveloperModeOption() *developerModeOption {
+	return &developerModeOption{}
+}
+
+type DeveloperModeOption func(opt *developerModeOption)
+
+// WithDeveloperModeLifecycle how to wait for the device to restart, it is always
+// waited for to be unlocked
+func WithDeveloperModeLifecycle(opts ...LifecycleOption) DeveloperModeOption {
+	return func(opt *developerModeOption) {
+		opt.lifecycle = append(opt.lifecycle, opts...)
+	}
+}
+
+// WithDeveloperModeProgress calls progress as each stage is reached
+func WithDeveloperModeProgress(progress func(stage DeveloperModeStage)) DeveloperModeOption {
+	return func(opt *developerModeOption) {
+		opt.progress = progress
+	}
+}
+
+type pairOption struct {
+	timeout  time.Duration
+	interval time.Duration
+	identity *PairRecord
+
+	supervisorCert *x509.Certificate
+	supervisorKey  crypto.Signer
+}
+
+func defaultPairOption() *pairOption {
+	return &pairOption{
+		timeout:  2 * time.Minute,
+		interval: time.Second,
+	}
+}
+
+type PairOption func(opt *pairOption)
+
+// WithPairTimeout how long to wait for the user to answer the Trust dialog
+func WithPairTimeout(timeout time.Duration) PairOption {
+	return func(opt *pairOption) {
+		opt.timeout = timeout
+	}
+}
+
+// WithPairPollInterval how often to ask the device again while the Trust dialog is shown
+func WithPairPollInterval(interval time.Duration) PairOption {
+	return func(opt *pairOption) {
+		opt.interval = interval
+	}
+}
+
+// WithPairHostIdentity pairs as the host of identity: its HostID, SystemBUID and,
+// when present, its host and root certificates and private keys
+func WithPairHostIdentity(identity *PairRecord) PairOption {
+	return func(opt *pairOption) {
+		opt.identity = identity
+	}
+}
+
+// WithPairSupervisor pairs a supervised device without the Trust dialog,
+// using the supervision identity the device was supervised with
+func WithPairSupervisor(cert *x509.Certificate, key crypto.Signer) PairOption {
+	return func(opt *pairOption) {
+		opt.supervisorCert = cert
+		opt.supervisorKey = key
+	}
+}
+
 func _removeDuplicate(strSlice []string) []string {
 	existed := make(map[string]bool, len(strSlice))
 	noRepeat := make([]string, 0, len(strSlice))
@@ -496,6 +1192,9 @@ func _removeDuplicate(strSlice []string) []string {
 	return noRepeat
 }
 
+// DeviceVersion packs a version into an int to compare.
+//
+// Deprecated: use Device.OSVersion, a semver.Version.
 func DeviceVersion(version ...int) int {
 	if len(version) < 3 {
 		tmp := make([]int, 3)
@@ -1,6 +1,7 @@
 package giDevice
 
 import (
+	"context"
 	"fmt"
 	"github.com/SonicCloudOrg/sonic-gidevice/pkg/libimobiledevice"
 )
@@ -18,6 +19,14 @@ type installationProxy struct {
 }
 
 func (p *installationProxy) Browse(opts ...InstallationProxyOption) (currentList []interface{}, err error) {
+	return p.BrowseContext(context.Background(), opts...)
+}
+
+// BrowseContext is Browse giving up when ctx is done
+func (p *installationProxy) BrowseContext(ctx context.Context, opts ...InstallationProxyOption) (currentList []interface{}, err error) {
+	release := p.client.InnerConn().BindContext(ctx)
+	defer release()
+
 	opt := new(installationProxyOption)
 	if len(opts) == 0 {
 		opt = nil
@@ -62,6 +71,14 @@ func (p *installationProxy) Browse(opts ...InstallationProxyOption) (currentList
 }
 
 func (p *installationProxy) Lookup(opts ...InstallationProxyOption) (lookupResult interface{}, err error) {
+	return p.LookupContext(context.Background(), opts...)
+}
+
+// LookupContext is Lookup giving up when ctx is done
+func (p *installationProxy) LookupContext(ctx context.Context, opts ...InstallationProxyOption) (lookupResult interface{}, err error) {
+	release := p.client.InnerConn().BindContext(ctx)
+	defer release()
+
 	opt := new(installationProxyOption)
 	if len(opts) == 0 {
 		opt = nil
@@ -102,6 +119,14 @@ func (p *installationProxy) Lookup(opts ...InstallationProxyOption) (lookupResul
 }
 
 func (p *installationProxy) Install(bundleID, packagePath string) (err error) {
+	return p.InstallContext(context.Background(), bundleID, packagePath)
+}
+
+// InstallContext is Install giving up when ctx is done
+func (p *installationProxy) InstallContext(ctx context.Context, bundleID, packagePath string) (err error) {
+	release := p.client.InnerConn().BindContext(ctx)
+	defer release()
+
 	var pkt libimobiledevice.Packet
 	if pkt, err = p.client.NewXmlPacket(
 		p.client.NewInstallRequest(bundleID, packagePath),
@@ -114,27 +139,28 @@ func (p *installationProxy) Install(bundleID, packagePath string) (err error) {
 	}
 
 	var reply libimobiledevice.InstallationProxyInstallResponse
-	for len(reply.Error) == 0 {
+	for reply.Status != "Complete" {
 		var respPkt libimobiledevice.Packet
 		if respPkt, err = p.client.ReceivePacket(); err != nil {
-			return err
+			return fmt.Errorf("installation proxy 'Install': %w", err)
 		}
 		if err = respPkt.Unmarshal(&reply); err != nil {
 			return err
 		}
-		if reply.Status == "Complete" {
-			break
-		}
-	}
-
-	if len(reply.Error) != 0 {
-		return fmt.Errorf("installation proxy 'Install' status: %s (err: %s, desc: %s)", reply.Status, reply.Error, reply.ErrorDescription)
 	}
 
 	return
 }
 
 func (p *installationProxy) Uninstall(bundleID string) (err error) {
+	return p.UninstallContext(context.Background(), bundleID)
+}
+
+// UninstallContext is Uninstall giving up when ctx is done
+func (p *installationProxy) UninstallContext(ctx context.Context, bundleID string) (err error) {
+	release := p.client.InnerConn().BindContext(ctx)
+	defer release()
+
 	var pkt libimobiledevice.Packet
 	if pkt, err = p.client.NewXmlPacket(
 		p.client.NewUninstallRequest(bundleID),
@@ -147,21 +173,14 @@ func (p *installationProxy) Uninstall(bundleID string) (err error) {
 	}
 
 	var reply libimobiledevice.InstallationProxyInstallResponse
-	for len(reply.Error) == 0 {
+	for reply.Status != "Complete" {
 		var respPkt libimobiledevice.Packet
 		if respPkt, err = p.client.ReceivePacket(); err != nil {
-			return err
+			return fmt.Errorf("installation proxy 'Uninstall': %w", err)
 		}
 		if err = respPkt.Unmarshal(&reply); err != nil {
 			return err
 		}
-		if reply.Status == "Complete" {
-			break
-		}
-	}
-
-	if len(reply.Error) != 0 {
-		return fmt.Errorf("installation proxy 'Uninstall' status: %s (err: %s, desc: %s)", reply.Status, reply.Error, reply.ErrorDescription)
 	}
 	return
 }
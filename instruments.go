@@ -1,6 +1,7 @@
 package giDevice
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 
@@ -35,16 +36,24 @@ type instruments struct {
 	client *libimobiledevice.InstrumentsClient
 }
 
-func (i *instruments) notifyOfPublishedCapabilities() (err error) {
-	_, err = i.client.NotifyOfPublishedCapabilities()
+func (i *instruments) notifyOfPublishedCapabilities(ctx context.Context) (err error) {
+	_, err = i.client.NotifyOfPublishedCapabilitiesContext(ctx)
 	return
 }
 
 func (i *instruments) requestChannel(channel string) (id uint32, err error) {
-	return i.client.RequestChannel(channel)
+	return i.requestChannelContext(context.Background(), channel)
+}
+
+func (i *instruments) requestChannelContext(ctx context.Context, channel string) (id uint32, err error) {
+	return i.client.RequestChannelContext(ctx, channel)
 }
 
 func (i *instruments) AppLaunch(bundleID string, opts ...AppLaunchOption) (pid int, err error) {
+	return i.AppLaunchContext(context.Background(), bundleID, opts...)
+}
+
+func (i *instruments) AppLaunchContext(ctx context.Context, bundleID string, opts ...AppLaunchOption) (pid int, err error) {
 	opt := new(appLaunchOption)
 	opt.appPath = ""
 	opt.options = map[string]interface{}{
@@ -58,7 +67,7 @@ func (i *instruments) AppLaunch(bundleID string, opts ...AppLaunchOption) (pid i
 	}
 
 	var id uint32
-	if id, err = i.requestChannel(instrumentsServiceProcessControl); err != nil {
+	if id, err = i.requestChannelContext(ctx, instrumentsServiceProcessControl); err != nil {
 		return 0, err
 	}
 
@@ -81,12 +90,12 @@ func (i *instruments) AppLaunch(bundleID string, opts ...AppLaunchOption) (pid i
 
 	var result *libimobiledevice.DTXMessageResult
 	selector := "launchSuspendedProcessWithDevicePath:bundleIdentifier:environment:arguments:options:"
-	if result, err = i.client.Invoke(selector, args, id, true); err != nil {
+	if result, err = i.client.InvokeContext(ctx, selector, args, id, true); err != nil {
 		return 0, err
 	}
 
-	if nsErr, ok := result.Obj.(libimobiledevice.NSError); ok {
-		return 0, fmt.Errorf("%s", nsErr.NSUserInfo.(map[string]interface{})["NSLocalizedDescription"])
+	if err = result.Err(); err != nil {
+		return 0, err
 	}
 
 	return int(result.Obj.(uint64)), nil
@@ -128,15 +137,19 @@ func (i *instruments) startObserving(pid int) (err error) {
 		return err
 	}
 
-	if nsErr, ok := result.Obj.(libimobiledevice.NSError); ok {
-		return fmt.Errorf("%s", nsErr.NSUserInfo.(map[string]interface{})["NSLocalizedDescription"])
+	if err = result.Err(); err != nil {
+		return err
 	}
 	return
 }
 
 func (i *instruments) AppKill(pid int) (err error) {
+	return i.AppKillContext(context.Background(), pid)
+}
+
+func (i *instruments) AppKillContext(ctx context.Context, pid int) (err error) {
 	var id uint32
-	if id, err = i.requestChannel(instrumentsServiceProcessControl); err != nil {
+	if id, err = i.requestChannelContext(ctx, instrumentsServiceProcessControl); err != nil {
 		return err
 	}
 
@@ -146,7 +159,7 @@ func (i *instruments) AppKill(pid int) (err error) {
 	}
 
 	selector := "killPid:"
-	if _, err = i.client.Invoke(selector, args, id, false); err != nil {
+	if _, err = i.client.InvokeContext(ctx, selector, args, id, false); err != nil {
 		return err
 	}
 
@@ -154,15 +167,19 @@ func (i *instruments) AppKill(pid int) (err error) {
 }
 
 func (i *instruments) AppRunningProcesses() (processes []Process, err error) {
+	return i.AppRunningProcessesContext(context.Background())
+}
+
+func (i *instruments) AppRunningProcessesContext(ctx context.Context) (processes []Process, err error) {
 	var id uint32
-	if id, err = i.requestChannel(instrumentsServiceDeviceInfo); err != nil {
+	if id, err = i.requestChannelContext(ctx, instrumentsServiceDeviceInfo); err != nil {
 		return nil, err
 	}
 
 	selector := "runningProcesses"
 
 	var result *libimobiledevice.DTXMessageResult
-	if result, err = i.client.Invoke(selector, libimobiledevice.NewAuxBuffer(), id, true); err != nil {
+	if result, err = i.client.InvokeContext(ctx, selector, libimobiledevice.NewAuxBuffer(), id, true); err != nil {
 		return nil, err
 	}
 
@@ -194,6 +211,10 @@ func (i *instruments) AppRunningProcesses() (processes []Process, err error) {
 }
 
 func (i *instruments) AppList(opts ...AppListOption) (apps []Application, err error) {
+	return i.AppListContext(context.Background(), opts...)
+}
+
+func (i *instruments) AppListContext(ctx context.Context, opts ...AppListOption) (apps []Application, err error) {
 	opt := new(appListOption)
 	opt.updateToken = ""
 	opt.appsMatching = make(map[string]interface{})
@@ -204,7 +225,7 @@ func (i *instruments) AppList(opts ...AppListOption) (apps []Application, err er
 	}
 
 	var id uint32
-	if id, err = i.requestChannel(instrumentsServiceDeviceApplictionListing); err != nil {
+	if id, err = i.requestChannelContext(ctx, instrumentsServiceDeviceApplictionListing); err != nil {
 		return nil, err
 	}
 
@@ -219,7 +240,7 @@ func (i *instruments) AppList(opts ...AppListOption) (apps []Application, err er
 	selector := "installedApplicationsMatching:registerUpdateToken:"
 
 	var result *libimobiledevice.DTXMessageResult
-	if result, err = i.client.Invoke(selector, args, id, true); err != nil {
+	if result, err = i.client.InvokeContext(ctx, selector, args, id, true); err != nil {
 		return nil, err
 	}
 
@@ -248,15 +269,19 @@ func (i *instruments) AppList(opts ...AppListOption) (apps []Application, err er
 }
 
 func (i *instruments) DeviceInfo() (devInfo *DeviceInfo, err error) {
+	return i.DeviceInfoContext(context.Background())
+}
+
+func (i *instruments) DeviceInfoContext(ctx context.Context) (devInfo *DeviceInfo, err error) {
 	var id uint32
-	if id, err = i.requestChannel(instrumentsServiceDeviceInfo); err != nil {
+	if id, err = i.requestChannelContext(ctx, instrumentsServiceDeviceInfo); err != nil {
 		return nil, err
 	}
 
 	selector := "systemInformation"
 
 	var result *libimobiledevice.DTXMessageResult
-	if result, err = i.client.Invoke(selector, libimobiledevice.NewAuxBuffer(), id, true); err != nil {
+	if result, err = i.client.InvokeContext(ctx, selector, libimobiledevice.NewAuxBuffer(), id, true); err != nil {
 		return nil, err
 	}
 
@@ -274,10 +299,32 @@ func (i *instruments) registerCallback(obj string, cb func(m libimobiledevice.DT
 	i.client.RegisterCallback(obj, cb)
 }
 
+// registerChannelCallback receives every message the device sends on the channel,
+// requesting the channel first if needed
+func (i *instruments) registerChannelCallback(channel string, cb func(m libimobiledevice.DTXMessageResult)) (err error) {
+	var id uint32
+	if id, err = i.requestChannel(channel); err != nil {
+		return err
+	}
+	i.client.RegisterChannelCallback(id, cb)
+	return
+}
+
+func (i *instruments) unregisterChannelCallback(channel string) {
+	if id, err := i.requestChannel(channel); err == nil {
+		i.client.UnregisterChannelCallback(id)
+	}
+}
+
 func (i *instruments) call(channel, selector string, auxiliaries ...interface{}) (
 	result *libimobiledevice.DTXMessageResult, err error) {
+	return i.callContext(context.Background(), channel, selector, auxiliaries...)
+}
+
+func (i *instruments) callContext(ctx context.Context, channel, selector string, auxiliaries ...interface{}) (
+	result *libimobiledevice.DTXMessageResult, err error) {
 
-	chanID, err := i.requestChannel(channel)
+	chanID, err := i.requestChannelContext(ctx, channel)
 	if err != nil {
 		return nil, err
 	}
@@ -289,7 +336,7 @@ func (i *instruments) call(channel, selector string, auxiliaries ...interface{})
 		}
 	}
 
-	return i.client.Invoke(selector, args, chanID, true)
+	return i.client.InvokeContext(ctx, selector, args, chanID, true)
 }
 
 func (i *instruments) getPidByBundleID(bundleID string) (pid int, err error) {
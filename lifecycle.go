@@ -0,0 +1,381 @@
+package giDevice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/libimobiledevice"
+)
+
+// lifecycleProbeTimeout bounds each attempt to reach the device while polling,
+// a device gone from the network does not refuse connections, it just never answers
+const lifecycleProbeTimeout = 10 * time.Second
+
+// lifecycleDetachedFailures the probes in a row a device reached without the
+// local usbmuxd must fail to count as gone, over Wi-Fi one alone proves nothing
+const lifecycleDetachedFailures = 3
+
+var errStillAttached = errors.New("still attached")
+
+func (d *device) RebootAndWait(ctx context.Context, opts ...LifecycleOption) (dev Device, err error) {
+	opt := defaultLifecycleOption()
+	for _, fn := range opts {
+		fn(opt)
+	}
+	ctx, cancel := opt.withTimeout(ctx)
+	defer cancel()
+	if dev, err = d.restartAndWait(ctx, opt, d.Reboot, 0); err != nil {
+		return nil, fmt.Errorf("reboot: %w", err)
+	}
+	return dev, nil
+}
+
+// restartAndWait calls restart and waits for the device to be back, rebooting it
+// when it is still there after grace, if not 0
+func (d *device) restartAndWait(ctx context.Context, opt *lifecycleOption, restart func() error, grace time.Duration) (renewed *device, err error) {
+	var w lifecycleWatcher
+	if w, err = d.watch(ctx); err != nil {
+		return nil, err
+	}
+	defer w.close()
+	if err = restart(); err != nil {
+		return nil, err
+	}
+	if err = opt.wait(ctx, LifecycleStageDetached, func(ctx context.Context) error {
+		if grace == 0 {
+			return w.detached(ctx, opt)
+		}
+		graceCtx, cancel := context.WithTimeout(ctx, grace)
+		err := w.detached(graceCtx, opt)
+		cancel()
+		if err == nil || ctx.Err() != nil || !errors.Is(err, context.DeadlineExceeded) {
+			return err
+		}
+		if err = d.Reboot(); err != nil {
+			return err
+		}
+		return w.detached(ctx, opt)
+	}); err != nil {
+		return nil, err
+	}
+	if err = opt.wait(ctx, LifecycleStageAttached, func(ctx context.Context) (err error) {
+		renewed, err = w.attached(ctx, opt)
+		return
+	}); err != nil {
+		return nil, err
+	}
+	if err = renewed.waitForReady(ctx, opt); err != nil {
+		return nil, err
+	}
+	return renewed, nil
+}
+
+func (d *device) ShutdownAndWait(ctx context.Context, opts ...LifecycleOption) (err error) {
+	if err = d.doAndWaitDetached(ctx, d.Shutdown, opts); err != nil {
+		return fmt.Errorf("shutdown: %w", err)
+	}
+	return nil
+}
+
+func (d *device) EnterRecoveryAndWait(ctx context.Context, opts ...LifecycleOption) (err error) {
+	if err = d.doAndWaitDetached(ctx, d.EnterRecovery, opts); err != nil {
+		return fmt.Errorf("enter recovery: %w", err)
+	}
+	return nil
+}
+
+func (d *device) doAndWaitDetached(ctx context.Context, do func() error, opts []LifecycleOption) (err error) {
+	opt := defaultLifecycleOption()
+	for _, fn := range opts {
+		fn(opt)
+	}
+	ctx, cancel := opt.withTimeout(ctx)
+	defer cancel()
+
+	var w lifecycleWatcher
+	if w, err = d.watch(ctx); err != nil {
+		return err
+	}
+	defer w.close()
+	if err = do(); err != nil {
+		return err
+	}
+	return opt.wait(ctx, LifecycleStageDetached, func(ctx context.Context) error {
+		return w.detached(ctx, opt)
+	})
+}
+
+func (d *device) WaitForReady(ctx context.Context, opts ...LifecycleOption) (err error) {
+	opt := defaultLifecycleOption()
+	for _, fn := range opts {
+		fn(opt)
+	}
+	ctx, cancel := opt.withTimeout(ctx)
+	defer cancel()
+	return d.waitForReady(ctx, opt)
+}
+
+func (d *device) waitForReady(ctx context.Context, opt *lifecycleOption) (err error) {
+	if err = opt.wait(ctx, LifecycleStageLockdown, func(ctx context.Context) error {
+		return opt.poll(ctx, func(ctx context.Context) error {
+			_, err := d.probeValue(ctx, "ProductVersion")
+			return err
+		})
+	}); err != nil {
+		return err
+	}
+	if opt.unlocked {
+		if err = opt.wait(ctx, LifecycleStageUnlocked, func(ctx context.Context) error {
+			return opt.poll(ctx, d.probeUnlocked)
+		}); err != nil {
+			return err
+		}
+	}
+	if opt.springBoard {
+		if err = opt.wait(ctx, LifecycleStageSpringBoard, func(ctx context.Context) error {
+			return opt.poll(ctx, d.probeSpringBoard)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// probeValue gets a value of the root domain
+func (d *device) probeValue(ctx context.Context, key string) (v interface{}, err error) {
+	return d.GetValueContext(ctx, "", key)
+}
+
+// probeUnlocked lockdownd reports PasswordProtected until the passcode is entered
+func (d *device) probeUnlocked(ctx context.Context) (err error) {
+	var v interface{}
+	if v, err = d.probeValue(ctx, "PasswordProtected"); err != nil {
+		return err
+	}
+	if locked, _ := v.(bool); locked {
+		return ErrPasswordProtected
+	}
+	return nil
+}
+
+// probeSpringBoard springboardservices only answers once SpringBoard is up
+func (d *device) probeSpringBoard(ctx context.Context) (err error) {
+	var innerConn InnerConn
+	if innerConn, err = d.StartService(ctx, libimobiledevice.SpringBoardServiceName); err != nil {
+		return err
+	}
+	defer innerConn.Close()
+	release := innerConn.BindContext(ctx)
+	defer release()
+	_, err = newSpringBoard(libimobiledevice.NewSpringBoardClient(innerConn)).GetInterfaceOrientation()
+	return
+}
+
+// renewed a device like d without the connections to services the device dropped
+func (d *device) renewed(client *libimobiledevice.UsbmuxClient, properties DeviceProperties) *device {
+	dev := newDevice(client, properties)
+	dev.remoteAddr = d.remoteAddr
+	dev.networkHost = d.networkHost
+	dev.lockdownPort = d.lockdownPort
+	dev.dialUsbmux = d.dialUsbmux
+	dev.pairRecordStore = d.pairRecordStore
+	return dev
+}
+
+func (opt *lifecycleOption) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if opt.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, opt.timeout)
+}
+
+// wait runs until, reporting stage once it returns nil
+func (opt *lifecycleOption) wait(ctx context.Context, stage LifecycleStage, until func(ctx context.Context) error) (err error) {
+	if err = until(ctx); err != nil {
+		return fmt.Errorf("wait until %s: %w", stage, err)
+	}
+	if opt.progress != nil {
+		opt.progress(stage)
+	}
+	return nil
+}
+
+// poll tries every opt.interval until it succeeds or ctx is done
+func (opt *lifecycleOption) poll(ctx context.Context, try func(ctx context.Context) error) error {
+	for ctx.Err() == nil {
+		tryCtx, cancel := context.WithTimeout(ctx, lifecycleProbeTimeout)
+		err := try(tryCtx)
+		cancel()
+		// a try cut short by ctx proves nothing
+		if ctx.Err() != nil {
+			break
+		}
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w, last: %s", ctx.Err(), err)
+		case <-time.After(opt.interval):
+		}
+	}
+	return ctx.Err()
+}
+
+// lifecycleWatcher tells when a device goes and comes back
+type lifecycleWatcher interface {
+	detached(ctx context.Context, opt *lifecycleOption) error
+	attached(ctx context.Context, opt *lifecycleOption) (dev *device, err error)
+	close()
+}
+
+// watch starts watching d, before it is asked to go so that it cannot be missed
+func (d *device) watch(ctx context.Context) (w lifecycleWatcher, err error) {
+	if d.networkHost != "" || d.remoteAddr != "" {
+		return &lockdownWatcher{dev: d}, nil
+	}
+	var client *libimobiledevice.UsbmuxClient
+	if client, err = d.newUsbmuxClient(ctx); err != nil {
+		return nil, err
+	}
+	release := client.InnerConn().BindContext(ctx)
+	var pkt libimobiledevice.Packet
+	if pkt, err = client.NewPlistPacket(client.NewBasicRequest(libimobiledevice.MessageTypeListen)); err == nil {
+		if err = client.SendPacket(pkt); err == nil {
+			_, err = client.ReceivePacket()
+		}
+	}
+	release()
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	client.InnerConn().Timeout(0)
+
+	uw := &usbmuxWatcher{
+		dev:    d,
+		client: client,
+		events: make(chan libimobiledevice.BaseDevice),
+		done:   make(chan struct{}),
+	}
+	go uw.receive()
+	return uw, nil
+}
+
+// usbmuxWatcher follows the Attached and Detached of usbmuxd, which gives the
+// device a new DeviceID as it attaches again
+type usbmuxWatcher struct {
+	dev    *device
+	client *libimobiledevice.UsbmuxClient
+	events chan libimobiledevice.BaseDevice
+	done   chan struct{}
+	// err why events is closed
+	err error
+}
+
+func (w *usbmuxWatcher) receive() {
+	defer close(w.events)
+	for {
+		pkt, err := w.client.ReceivePacket()
+		if err != nil {
+			w.err = err
+			return
+		}
+		var baseDev libimobiledevice.BaseDevice
+		if err = pkt.Unmarshal(&baseDev); err != nil {
+			w.err = err
+			return
+		}
+		select {
+		case w.events <- baseDev:
+		case <-w.done:
+			w.err = net.ErrClosed
+			return
+		}
+	}
+}
+
+func (w *usbmuxWatcher) next(ctx context.Context) (baseDev libimobiledevice.BaseDevice, err error) {
+	select {
+	case <-ctx.Done():
+		return baseDev, ctx.Err()
+	case baseDev, ok := <-w.events:
+		if !ok {
+			return baseDev, fmt.Errorf("usbmuxd: %w", w.err)
+		}
+		return baseDev, nil
+	}
+}
+
+func (w *usbmuxWatcher) detached(ctx context.Context, _ *lifecycleOption) error {
+	for {
+		baseDev, err := w.next(ctx)
+		if err != nil {
+			return err
+		}
+		if baseDev.MessageType == libimobiledevice.MessageTypeDeviceRemove && baseDev.DeviceID == w.dev.deviceID() {
+			return nil
+		}
+	}
+}
+
+func (w *usbmuxWatcher) attached(ctx context.Context, _ *lifecycleOption) (dev *device, err error) {
+	for {
+		var baseDev libimobiledevice.BaseDevice
+		if baseDev, err = w.next(ctx); err != nil {
+			return nil, err
+		}
+		if baseDev.MessageType != libimobiledevice.MessageTypeDeviceAdd ||
+			baseDev.Properties.SerialNumber != w.dev.properties.SerialNumber ||
+			baseDev.Properties.ConnectionType != w.dev.properties.ConnectionType {
+			continue
+		}
+		var client *libimobiledevice.UsbmuxClient
+		if client, err = w.dev.newUsbmuxClient(ctx); err != nil {
+			return nil, err
+		}
+		baseDev.Properties.DeviceID = baseDev.DeviceID
+		return w.dev.renewed(client, baseDev.Properties), nil
+	}
+}
+
+func (w *usbmuxWatcher) close() {
+	close(w.done)
+	w.client.Close()
+}
+
+// lockdownWatcher tells the device is gone when lockdownd stops answering, for
+// the devices reached without the local usbmuxd
+type lockdownWatcher struct {
+	dev *device
+}
+
+func (w *lockdownWatcher) detached(ctx context.Context, opt *lifecycleOption) error {
+	failures := 0
+	return opt.poll(ctx, func(ctx context.Context) error {
+		if _, err := w.dev.probeValue(ctx, "ProductVersion"); err != nil {
+			if failures++; failures >= lifecycleDetachedFailures {
+				return nil
+			}
+			return err
+		}
+		failures = 0
+		return errStillAttached
+	})
+}
+
+func (w *lockdownWatcher) attached(ctx context.Context, opt *lifecycleOption) (dev *device, err error) {
+	dev = w.dev.renewed(w.dev.umClient, w.dev.Properties())
+	if err = opt.poll(ctx, func(ctx context.Context) error {
+		_, err := dev.probeValue(ctx, "ProductVersion")
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return dev, nil
+}
+
+func (w *lockdownWatcher) close() {}
@@ -0,0 +1,99 @@
+package giDevice
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/idevicetest"
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/libimobiledevice"
+)
+
+func Test_device_simulated_RebootAndWait(t *testing.T) {
+	setupSimulatedDevice(t)
+	// the device goes, comes back locked and is unlocked a moment later
+	simDev.Handle(libimobiledevice.DiagnosticsRelayServiceName, func(conn net.Conn) {
+		var req map[string]interface{}
+		if err := idevicetest.ReadMessage(conn, &req); err != nil || req["Request"] != "Restart" {
+			return
+		}
+		simSrv.RemoveDevice(simDev)
+		simDev.SetValue("", "PasswordProtected", true)
+		time.AfterFunc(50*time.Millisecond, func() {
+			simSrv.AddDevice(simDev)
+			time.AfterFunc(200*time.Millisecond, func() {
+				simDev.SetValue("", "PasswordProtected", false)
+			})
+		})
+	})
+	simDev.Handle(libimobiledevice.SpringBoardServiceName, func(conn net.Conn) {
+		var req map[string]interface{}
+		if err := idevicetest.ReadMessage(conn, &req); err != nil {
+			return
+		}
+		_ = idevicetest.WriteMessage(conn, map[string]interface{}{"interfaceOrientation": 1})
+	})
+
+	before := dev.Properties().DeviceID
+	var stages []LifecycleStage
+	renewed, err := dev.RebootAndWait(context.Background(),
+		WithLifecyclePollInterval(20*time.Millisecond),
+		WithLifecycleWaitUnlocked(true),
+		WithLifecycleWaitSpringBoard(true),
+		WithLifecycleProgress(func(stage LifecycleStage) {
+			stages = append(stages, stage)
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []LifecycleStage{LifecycleStageDetached, LifecycleStageAttached,
+		LifecycleStageLockdown, LifecycleStageUnlocked, LifecycleStageSpringBoard}
+	if !reflect.DeepEqual(stages, expected) {
+		t.Fatalf("stages: %v", stages)
+	}
+	if renewed.Properties().DeviceID == before || renewed.Properties().DeviceID != simDev.DeviceID() {
+		t.Fatalf("DeviceID: %d, was %d", renewed.Properties().DeviceID, before)
+	}
+	if v, err := renewed.GetValue("", "ProductVersion"); err != nil || v != "16.0" {
+		t.Fatalf("ProductVersion: %v %v", v, err)
+	}
+
+	// never goes
+	simDev.Handle(libimobiledevice.DiagnosticsRelayServiceName, func(conn net.Conn) {
+		var req map[string]interface{}
+		_ = idevicetest.ReadMessage(conn, &req)
+	})
+	err = renewed.ShutdownAndWait(context.Background(), WithLifecycleTimeout(100*time.Millisecond))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected DeadlineExceeded, got %v", err)
+	}
+}
+
+func Test_lockdownWatcher_simulated_Detached(t *testing.T) {
+	setupSimulatedDevice(t)
+	t.Cleanup(func() { simDev.SetValue("", "ProductVersion", "16.0") })
+	w := &lockdownWatcher{dev: dev.(*device)}
+	opt := defaultLifecycleOption()
+	opt.interval = 100 * time.Millisecond
+
+	// lockdownd fails twice and answers again, the device never went
+	simDev.DeleteValue("", "ProductVersion")
+	time.AfterFunc(150*time.Millisecond, func() {
+		simDev.SetValue("", "ProductVersion", "16.0")
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if err := w.detached(ctx, opt); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected DeadlineExceeded, got %v", err)
+	}
+
+	simDev.DeleteValue("", "ProductVersion")
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := w.detached(ctx, opt); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -2,6 +2,8 @@ package giDevice
 
 import (
 	"bytes"
+	"context"
+	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha1"
@@ -10,35 +12,53 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/Masterminds/semver/v3"
 	"github.com/SonicCloudOrg/sonic-gidevice/pkg/libimobiledevice"
+	cms "github.com/github/ietf-cms"
 	uuid "github.com/satori/go.uuid"
 )
 
+var ErrLockdownSetValueFailed = errors.New("lockdown SetValue: Failed")
+
 var _ Lockdown = (*lockdown)(nil)
 
-func newLockdown(dev *device) *lockdown {
+func newLockdown(dev *device, client *libimobiledevice.LockdownClient) *lockdown {
 	return &lockdown{
-		umClient: dev.umClient,
-		client:   dev.lockdownClient,
-		dev:      dev,
+		client: client,
+		dev:    dev,
+		ctx:    context.Background(),
 	}
 }
 
 type lockdown struct {
-	umClient  *libimobiledevice.UsbmuxClient
 	client    *libimobiledevice.LockdownClient
 	sessionID string
 
+	// mu one request and its reply at a time
+	mu sync.Mutex
+	// sessionMu one session at a time, from StartSession to StopSession
+	sessionMu sync.Mutex
+	// started the connections of the services started through c
+	started []InnerConn
+
 	dev        *device
 	iOSVersion []int
+	// version iOSVersion as semver
+	version    *semver.Version
 	pairRecord *PairRecord
+
+	// ctx cancels starting services
+	ctx context.Context
 }
 
 func (c *lockdown) QueryType() (LockdownType, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	pkt, err := c.client.NewXmlPacket(
 		c.client.NewBasicRequest(libimobiledevice.RequestTypeQueryType),
 	)
@@ -64,6 +84,9 @@ func (c *lockdown) QueryType() (LockdownType, error) {
 }
 
 func (c *lockdown) GetValue(domain, key string) (v interface{}, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	pkt, err := c.client.NewXmlPacket(
 		c.client.NewGetValueRequest(domain, key),
 	)
@@ -91,6 +114,9 @@ func (c *lockdown) GetValue(domain, key string) (v interface{}, err error) {
 }
 
 func (c *lockdown) SetValue(domain, key string, value interface{}) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	var pkt libimobiledevice.Packet
 	if pkt, err = c.client.NewXmlPacket(
 		c.client.NewSetValueRequest(domain, key, value),
@@ -113,12 +139,15 @@ func (c *lockdown) SetValue(domain, key string, value interface{}) (err error) {
 	}
 
 	if !reply.Value.(bool) {
-		return errors.New("lockdown SetValue: Failed")
+		return ErrLockdownSetValueFailed
 	}
 	return
 }
 
 func (c *lockdown) EnterRecovery() (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	var pkt libimobiledevice.Packet
 	if pkt, err = c.client.NewXmlPacket(
 		c.client.NewEnterRecoveryRequest(),
@@ -149,11 +178,14 @@ func (c *lockdown) handshake() (err error) {
 	// if (device->version < DEVICE_VERSION(7,0,0))
 	// for older devices, we need to validate pairing to receive trusted host status
 
+	// one pairing at a time, the others use the record it saves
+	c.dev.pairMu.Lock()
+	defer c.dev.pairMu.Unlock()
 	if c.pairRecord, err = c.dev.ReadPairRecord(); err == nil {
 		return nil
 	}
 
-	if !strings.Contains(err.Error(), libimobiledevice.ReplyCodeBadDevice.String()) {
+	if !errors.Is(err, ErrPairRecordNotFound) {
 		return err
 	}
 
@@ -167,11 +199,18 @@ func (c *lockdown) handshake() (err error) {
 }
 
 func (c *lockdown) Pair() (pairRecord *PairRecord, err error) {
-	var buid string
-	if buid, err = newUsbmux(c.umClient).ReadBUID(); err != nil {
+	if pairRecord, err = c.newPairRecord(nil); err != nil {
 		return nil, err
 	}
+	if err = c.pair(pairRecord); err != nil {
+		return nil, err
+	}
+	return
+}
 
+// newPairRecord generates a pair record for this device, reusing HostID, SystemBUID
+// and the host/root certificates of identity when they are set
+func (c *lockdown) newPairRecord(identity *PairRecord) (pairRecord *PairRecord, err error) {
 	var devPublicKeyPem []byte
 	var devWiFiAddr string
 
@@ -186,46 +225,181 @@ func (c *lockdown) Pair() (pairRecord *PairRecord, err error) {
 		devWiFiAddr = lockdownValue.(string)
 	}
 
-	if pairRecord, err = generatePairRecord(devPublicKeyPem); err != nil {
+	if pairRecord, err = generatePairRecord(devPublicKeyPem, identity); err != nil {
 		return nil, err
 	}
 
-	pairRecord.SystemBUID = buid
-	pairRecord.HostID = strings.ToUpper(uuid.NewV4().String())
-	hostPrivateKey := pairRecord.HostPrivateKey
-	pairRecord.HostPrivateKey = nil
-	rootPrivateKey := pairRecord.RootPrivateKey
-	pairRecord.RootPrivateKey = nil
+	if identity != nil && identity.SystemBUID != "" {
+		pairRecord.SystemBUID = identity.SystemBUID
+	} else if umClient, release, umErr := c.dev.usbmuxClient(c.ctx); errors.Is(umErr, errNoUsbmuxd) {
+		pairRecord.SystemBUID = strings.ToUpper(uuid.NewV4().String())
+	} else if umErr != nil {
+		return nil, umErr
+	} else {
+		pairRecord.SystemBUID, err = newUsbmux(umClient).ReadBUID()
+		release()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if identity != nil && identity.HostID != "" {
+		pairRecord.HostID = identity.HostID
+	} else {
+		pairRecord.HostID = strings.ToUpper(uuid.NewV4().String())
+	}
+	pairRecord.WiFiMACAddress = devWiFiAddr
+
+	return
+}
+
+// pair sends the `Pair` request, the device replies ErrPairingDialogResponsePending
+// until the user answers the Trust dialog
+func (c *lockdown) pair(pairRecord *PairRecord) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
 	var pkt libimobiledevice.Packet
 	if pkt, err = c.client.NewXmlPacket(
-		c.client.NewPairRequest(pairRecord),
+		c.client.NewPairRequest(publicPairRecord(pairRecord)),
 	); err != nil {
-		return nil, err
+		return err
 	}
 
 	if err = c.client.SendPacket(pkt); err != nil {
-		return nil, err
+		return err
 	}
 
 	var respPkt libimobiledevice.Packet
 	if respPkt, err = c.client.ReceivePacket(); err != nil {
-		return nil, err
+		return fmt.Errorf("lockdown pair: %w", err)
 	}
 
 	var reply libimobiledevice.LockdownPairResponse
 	if err = respPkt.Unmarshal(&reply); err != nil {
-		return nil, err
+		return err
 	}
 
 	pairRecord.EscrowBag = reply.EscrowBag
-	pairRecord.WiFiMACAddress = devWiFiAddr
-	pairRecord.HostPrivateKey = hostPrivateKey
-	pairRecord.RootPrivateKey = rootPrivateKey
+	return
+}
+
+// pairSupervised pairs a supervised device without the Trust dialog: the device replies
+// ErrMCChallengeRequired with a challenge, which is answered signed by the supervision identity
+func (c *lockdown) pairSupervised(pairRecord *PairRecord, supervisorCert *x509.Certificate, supervisorKey crypto.Signer) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var pkt libimobiledevice.Packet
+	if pkt, err = c.client.NewXmlPacket(
+		c.client.NewSupervisedPairRequest(publicPairRecord(pairRecord), supervisorCert.Raw),
+	); err != nil {
+		return err
+	}
+
+	if err = c.client.SendPacket(pkt); err != nil {
+		return err
+	}
+
+	var respPkt libimobiledevice.Packet
+	if respPkt, err = c.client.ReceiveRawPacket(); err != nil {
+		return fmt.Errorf("lockdown pair: %w", err)
+	}
+
+	var reply libimobiledevice.LockdownPairResponse
+	if err = respPkt.Unmarshal(&reply); err != nil {
+		return err
+	}
+
+	switch libimobiledevice.LockdownError(reply.Error) {
+	case "":
+		pairRecord.EscrowBag = reply.EscrowBag
+		return nil
+	case libimobiledevice.ErrMCChallengeRequired:
+	default:
+		return fmt.Errorf("lockdown pair: %w", libimobiledevice.LockdownError(reply.Error))
+	}
+
+	var challengeResponse []byte
+	if challengeResponse, err = cms.Sign(reply.ExtendedResponse.PairingChallenge, []*x509.Certificate{supervisorCert}, supervisorKey); err != nil {
+		return fmt.Errorf("lockdown pair: sign challenge: %w", err)
+	}
+
+	if pkt, err = c.client.NewXmlPacket(
+		c.client.NewPairChallengeResponseRequest(publicPairRecord(pairRecord), challengeResponse),
+	); err != nil {
+		return err
+	}
+
+	if err = c.client.SendPacket(pkt); err != nil {
+		return err
+	}
+
+	if respPkt, err = c.client.ReceivePacket(); err != nil {
+		return fmt.Errorf("lockdown pair: %w", err)
+	}
+
+	reply = libimobiledevice.LockdownPairResponse{}
+	if err = respPkt.Unmarshal(&reply); err != nil {
+		return err
+	}
+
+	pairRecord.EscrowBag = reply.EscrowBag
+	return
+}
+
+func (c *lockdown) ValidatePair(pairRecord *PairRecord) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var pkt libimobiledevice.Packet
+	if pkt, err = c.client.NewXmlPacket(
+		c.client.NewValidatePairRequest(publicPairRecord(pairRecord)),
+	); err != nil {
+		return err
+	}
+
+	if err = c.client.SendPacket(pkt); err != nil {
+		return err
+	}
+
+	if _, err = c.client.ReceivePacket(); err != nil {
+		return fmt.Errorf("lockdown validate pair: %w", err)
+	}
+	return
+}
+
+func (c *lockdown) Unpair(pairRecord *PairRecord) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var pkt libimobiledevice.Packet
+	if pkt, err = c.client.NewXmlPacket(
+		c.client.NewUnpairRequest(publicPairRecord(pairRecord)),
+	); err != nil {
+		return err
+	}
+
+	if err = c.client.SendPacket(pkt); err != nil {
+		return err
+	}
 
+	if _, err = c.client.ReceivePacket(); err != nil {
+		return fmt.Errorf("lockdown unpair: %w", err)
+	}
 	return
 }
 
+// publicPairRecord the part of pairRecord that is sent to the device
+func publicPairRecord(pairRecord *PairRecord) *PairRecord {
+	return &PairRecord{
+		DeviceCertificate: pairRecord.DeviceCertificate,
+		HostCertificate:   pairRecord.HostCertificate,
+		HostID:            pairRecord.HostID,
+		RootCertificate:   pairRecord.RootCertificate,
+		SystemBUID:        pairRecord.SystemBUID,
+	}
+}
+
 func (c *lockdown) startSession(pairRecord *PairRecord) (err error) {
 	// if we have a running session, stop current one first
 	if c.sessionID != "" {
@@ -233,6 +407,8 @@ func (c *lockdown) startSession(pairRecord *PairRecord) (err error) {
 			return err
 		}
 	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
 	var pkt libimobiledevice.Packet
 	if pkt, err = c.client.NewXmlPacket(
@@ -266,6 +442,9 @@ func (c *lockdown) startSession(pairRecord *PairRecord) (err error) {
 }
 
 func (c *lockdown) stopSession() (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	if c.sessionID == "" {
 		return nil
 	}
@@ -296,6 +475,9 @@ func (c *lockdown) stopSession() (err error) {
 }
 
 func (c *lockdown) startService(service string, escrowBag []byte) (dynamicPort int, enableSSL bool, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	req := c.client.NewStartServiceRequest(service)
 	if escrowBag != nil {
 		req.EscrowBag = escrowBag
@@ -321,7 +503,7 @@ func (c *lockdown) startService(service string, escrowBag []byte) (dynamicPort i
 	}
 
 	if reply.Error != "" {
-		return 0, false, fmt.Errorf("lockdown start service: %s", reply.Error)
+		return 0, false, fmt.Errorf("lockdown start service: %w", libimobiledevice.LockdownError(reply.Error))
 	}
 
 	dynamicPort = reply.Port
@@ -372,7 +554,7 @@ func (c *lockdown) InstallationProxyService() (installationProxy InstallationPro
 
 func (c *lockdown) InstrumentsService() (instruments Instruments, err error) {
 	service := libimobiledevice.InstrumentsServiceName
-	if DeviceVersion(c.iOSVersion...) >= DeviceVersion(14, 0, 0) {
+	if c.version.Major() >= 14 {
 		service = libimobiledevice.InstrumentsSecureProxyServiceName
 	}
 
@@ -387,7 +569,7 @@ func (c *lockdown) InstrumentsService() (instruments Instruments, err error) {
 		_ = innerConn.DismissSSL()
 	}
 
-	if err = instruments.notifyOfPublishedCapabilities(); err != nil {
+	if err = instruments.notifyOfPublishedCapabilities(c.ctx); err != nil {
 		return nil, err
 	}
 
@@ -396,7 +578,7 @@ func (c *lockdown) InstrumentsService() (instruments Instruments, err error) {
 
 func (c *lockdown) TestmanagerdService() (testmanagerd Testmanagerd, err error) {
 	service := libimobiledevice.TestmanagerdServiceName
-	if DeviceVersion(c.iOSVersion...) >= DeviceVersion(14, 0, 0) {
+	if c.version.Major() >= 14 {
 		service = libimobiledevice.TestmanagerdSecureServiceName
 	}
 
@@ -411,7 +593,7 @@ func (c *lockdown) TestmanagerdService() (testmanagerd Testmanagerd, err error)
 		_ = innerConn.DismissSSL()
 	}
 
-	if err = testmanagerd.notifyOfPublishedCapabilities(); err != nil {
+	if err = testmanagerd.notifyOfPublishedCapabilities(c.ctx); err != nil {
 		return nil, err
 	}
 
@@ -454,7 +636,7 @@ func (c *lockdown) MisagentService(v string) (Misagent, error) {
 	} else {
 		misagentClient := libimobiledevice.NewMisagentClient(innerConn, v)
 		return newMisagent(misagentClient), nil
-	}     
+	}
 }
 
 func (c *lockdown) SyslogRelayService() (syslogRelay SyslogRelay, err error) {
@@ -528,6 +710,17 @@ func (c *lockdown) CrashReportMoverService() (crashReportMover CrashReportMover,
 }
 
 func (c *lockdown) _startService(serviceName string, escrowBag []byte) (innerConn InnerConn, err error) {
+	c.sessionMu.Lock()
+	defer c.sessionMu.Unlock()
+	defer func() {
+		if err == nil {
+			c.started = append(c.started, innerConn)
+		}
+	}()
+
+	release := c.client.InnerConn().BindContext(c.ctx)
+	defer release()
+
 	if err = c.handshake(); err != nil {
 		return nil, err
 	}
@@ -538,98 +731,165 @@ func (c *lockdown) _startService(serviceName string, escrowBag []byte) (innerCon
 
 	dynamicPort, enableSSL, err := c.startService(serviceName, escrowBag)
 	if err != nil {
-		return nil, err
+		// iOS 17 moved the developer services to RemoteServiceDiscovery
+		if sd, _ := c.dev.remoteServiceDirectory(); sd == nil ||
+			!errors.Is(err, libimobiledevice.ErrInvalidService) && !errors.Is(err, libimobiledevice.ErrServiceProhibited) {
+			return nil, err
+		}
+		_ = c.stopSession()
+		var rsdErr error
+		if innerConn, rsdErr = c.dev.startRemoteService(c.ctx, serviceName); rsdErr != nil {
+			return nil, &remoteServiceError{lockdownErr: err, rsdErr: rsdErr}
+		}
+		return innerConn, nil
 	}
 
 	if err = c.stopSession(); err != nil {
 		return nil, err
 	}
 
-	if innerConn, err = c.dev.NewConnect(dynamicPort, 0); err != nil {
+	if innerConn, err = c.dev.NewConnectContext(c.ctx, dynamicPort, 0); err != nil {
 		return nil, err
 	}
 	// clean deadline
 	innerConn.Timeout(0)
 
 	if enableSSL {
-		if err = innerConn.Handshake(c.iOSVersion, c.pairRecord); err != nil {
+		releaseService := innerConn.BindContext(c.ctx)
+		err = innerConn.Handshake(c.iOSVersion, c.pairRecord)
+		releaseService()
+		if err != nil {
+			innerConn.Close()
 			return nil, err
 		}
 	}
 	return
 }
 
+// session calls fn within a session, which some domains need
+func (c *lockdown) session(ctx context.Context, fn func() error) (err error) {
+	c.sessionMu.Lock()
+	defer c.sessionMu.Unlock()
+	release := c.client.InnerConn().BindContext(ctx)
+	defer release()
+
+	if c.pairRecord == nil {
+		if err = c.handshake(); err != nil {
+			return err
+		}
+	}
+	if err = c.startSession(c.pairRecord); err != nil {
+		return err
+	}
+	if err = fn(); err != nil {
+		return err
+	}
+	return c.stopSession()
+}
+
+// sessionGetValue gets a value within a session
+func (c *lockdown) sessionGetValue(ctx context.Context, domain, key string) (v interface{}, err error) {
+	err = c.session(ctx, func() (err error) {
+		v, err = c.GetValue(domain, key)
+		return
+	})
+	return
+}
+
+// close closes the connection to lockdownd, the services started through it keep going
+func (c *lockdown) close() {
+	c.client.InnerConn().Close()
+}
+
 func (c *lockdown) _getProductVersion() (version []int, err error) {
 	if c.iOSVersion != nil {
 		return c.iOSVersion, nil
 	}
 
-	var devProductVersion []string
-	if lockdownValue, err := c.GetValue("", "ProductVersion"); err != nil {
+	var v interface{}
+	if v, err = c.GetValue("", "ProductVersion"); err != nil {
 		return nil, err
-	} else {
-		devProductVersion = strings.Split(lockdownValue.(string), ".")
 	}
-
-	version = make([]int, len(devProductVersion))
-	for i, v := range devProductVersion {
-		version[i], _ = strconv.Atoi(v)
+	if c.version, err = parseProductVersion(v); err != nil {
+		return nil, err
 	}
-
-	// if len(version) == 2 {
-	// 	version = append(version, 0)
-	// }
-	c.iOSVersion = version
-
-	return
+	c.iOSVersion = []int{int(c.version.Major()), int(c.version.Minor()), int(c.version.Patch())}
+	return c.iOSVersion, nil
 }
 
-func generatePairRecord(devPublicKeyPem []byte) (pairRecord *PairRecord, err error) {
+func generatePairRecord(devPublicKeyPem []byte, identity *PairRecord) (pairRecord *PairRecord, err error) {
 	block, _ := pem.Decode(devPublicKeyPem)
+	if block == nil {
+		return nil, errors.New("lockdown pair: invalid DevicePublicKey")
+	}
 	var deviceKey *rsa.PublicKey
 	if deviceKey, err = x509.ParsePKCS1PublicKey(block.Bytes); err != nil {
 		return nil, err
 	}
 
-	var rootKey, hostKey *rsa.PrivateKey
-	if rootKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
-		return nil, err
-	}
-	if hostKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
-		return nil, err
-	}
+	pairRecord = new(PairRecord)
+
 	serialNumber := big.NewInt(0)
 	notBefore := time.Now()
 	notAfter := notBefore.Add(time.Hour * (24 * 365) * 10)
 
-	rootTemplate := x509.Certificate{
-		IsCA:                  true,
-		SerialNumber:          serialNumber,
-		Version:               2,
-		SignatureAlgorithm:    x509.SHA1WithRSA,
-		PublicKeyAlgorithm:    x509.RSA,
-		NotBefore:             notBefore,
-		NotAfter:              notAfter,
-		BasicConstraintsValid: true,
-	}
+	var rootKey *rsa.PrivateKey
+	var rootCert *x509.Certificate
 
-	var caCert, cert []byte
-	if caCert, err = x509.CreateCertificate(rand.Reader, &rootTemplate, &rootTemplate, rootKey.Public(), rootKey); err != nil {
-		return nil, err
-	}
+	if identity != nil && len(identity.RootCertificate) != 0 && len(identity.RootPrivateKey) != 0 &&
+		len(identity.HostCertificate) != 0 && len(identity.HostPrivateKey) != 0 {
+		if rootCert, rootKey, err = parsePairIdentity(identity.RootCertificate, identity.RootPrivateKey); err != nil {
+			return nil, fmt.Errorf("lockdown pair: root identity: %w", err)
+		}
+		pairRecord.HostCertificate = identity.HostCertificate
+		pairRecord.HostPrivateKey = identity.HostPrivateKey
+		pairRecord.RootCertificate = identity.RootCertificate
+		pairRecord.RootPrivateKey = identity.RootPrivateKey
+	} else {
+		var hostKey *rsa.PrivateKey
+		if rootKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			return nil, err
+		}
+		if hostKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			return nil, err
+		}
 
-	hostTemplate := x509.Certificate{
-		SerialNumber:          serialNumber,
-		Version:               2,
-		SignatureAlgorithm:    x509.SHA1WithRSA,
-		PublicKeyAlgorithm:    x509.RSA,
-		NotBefore:             notBefore,
-		NotAfter:              notAfter,
-		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
-		BasicConstraintsValid: true,
-	}
-	if cert, err = x509.CreateCertificate(rand.Reader, &hostTemplate, &rootTemplate, hostKey.Public(), rootKey); err != nil {
-		return nil, err
+		rootCert = &x509.Certificate{
+			IsCA:                  true,
+			SerialNumber:          serialNumber,
+			Version:               2,
+			SignatureAlgorithm:    x509.SHA1WithRSA,
+			PublicKeyAlgorithm:    x509.RSA,
+			NotBefore:             notBefore,
+			NotAfter:              notAfter,
+			BasicConstraintsValid: true,
+		}
+
+		var caCert, cert []byte
+		if caCert, err = x509.CreateCertificate(rand.Reader, rootCert, rootCert, rootKey.Public(), rootKey); err != nil {
+			return nil, err
+		}
+
+		hostTemplate := x509.Certificate{
+			SerialNumber:          serialNumber,
+			Version:               2,
+			SignatureAlgorithm:    x509.SHA1WithRSA,
+			PublicKeyAlgorithm:    x509.RSA,
+			NotBefore:             notBefore,
+			NotAfter:              notAfter,
+			KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+			BasicConstraintsValid: true,
+		}
+		if cert, err = x509.CreateCertificate(rand.Reader, &hostTemplate, rootCert, hostKey.Public(), rootKey); err != nil {
+			return nil, err
+		}
+
+		if pairRecord.RootCertificate, pairRecord.RootPrivateKey, err = encodePairPemFormat(caCert, rootKey); err != nil {
+			return nil, err
+		}
+		if pairRecord.HostCertificate, pairRecord.HostPrivateKey, err = encodePairPemFormat(cert, hostKey); err != nil {
+			return nil, err
+		}
 	}
 
 	h := sha1.New()
@@ -651,33 +911,33 @@ func generatePairRecord(devPublicKeyPem []byte) (pairRecord *PairRecord, err err
 	}
 
 	var deviceCert []byte
-	if deviceCert, err = x509.CreateCertificate(rand.Reader, &deviceTemplate, &rootTemplate, deviceKey, rootKey); err != nil {
+	if deviceCert, err = x509.CreateCertificate(rand.Reader, &deviceTemplate, rootCert, deviceKey, rootKey); err != nil {
 		return nil, err
 	}
 
-	var deviceCertPEM []byte
-	if deviceCertPEM, err = encodePemCertificate(deviceCert); err != nil {
+	if pairRecord.DeviceCertificate, err = encodePemCertificate(deviceCert); err != nil {
 		return nil, err
 	}
 
-	var caPEM, caPrivatePEM []byte
-	if caPEM, caPrivatePEM, err = encodePairPemFormat(caCert, rootKey); err != nil {
-		return nil, err
-	}
+	return
+}
 
-	var certPEM, certPrivatePEM []byte
-	if certPEM, certPrivatePEM, err = encodePairPemFormat(cert, hostKey); err != nil {
-		return nil, err
+// parsePairIdentity decodes a PEM certificate and its PKCS#1 private key
+func parsePairIdentity(certPEM, keyPEM []byte) (cert *x509.Certificate, key *rsa.PrivateKey, err error) {
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return nil, nil, errors.New("invalid certificate")
+	}
+	if cert, err = x509.ParseCertificate(certBlock.Bytes); err != nil {
+		return nil, nil, err
+	}
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, nil, errors.New("invalid private key")
+	}
+	if key, err = x509.ParsePKCS1PrivateKey(keyBlock.Bytes); err != nil {
+		return nil, nil, err
 	}
-
-	pairRecord = new(PairRecord)
-
-	pairRecord.DeviceCertificate = deviceCertPEM
-	pairRecord.HostCertificate = certPEM
-	pairRecord.HostPrivateKey = certPrivatePEM
-	pairRecord.RootCertificate = caPEM
-	pairRecord.RootPrivateKey = caPrivatePEM
-
 	return
 }
 
@@ -2,9 +2,9 @@ package giDevice
 
 import (
 	"fmt"
+	"io/fs"
 	"os"
 	"os/signal"
-	"path"
 	"testing"
 	"time"
 )
@@ -87,14 +87,14 @@ func Test_lockdown_CrashReportMoverService(t *testing.T) {
 	}
 
 	filenames := make([]string, 0, 36)
-	fn := func(cwd string, info *AfcFileInfo) {
-		if cwd == "." {
-			cwd = ""
+	fn := func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
 		}
-		filenames = append(filenames, path.Join(cwd, info.Name()))
-		// fmt.Println(path.Join(cwd, name))
+		filenames = append(filenames, name)
+		return nil
 	}
-	err = crashReportMoverSrv.walkDir(".", fn)
+	err = Walk(crashReportMoverSrv.(*crashReportMover).afc, ".", fn)
 	if err != nil {
 		t.Fatal(err)
 	}
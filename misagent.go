@@ -0,0 +1,185 @@
+package giDevice
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/libimobiledevice"
+	"github.com/sam80180/mobileprovision"
+)
+
+// formats of CopyProvisionProfile and CopyAllProvisionProfiles
+const (
+	// ProvisionProfileFormatDER the signed profile as installed, i.e. a `.mobileprovision` file
+	ProvisionProfileFormatDER = iota
+	// ProvisionProfileFormatPlist the plist embedded in the signed profile
+	ProvisionProfileFormatPlist
+	// ProvisionProfileFormatJSON the decoded profile as JSON
+	ProvisionProfileFormatJSON
+)
+
+var ErrProvisionProfileNotFound = errors.New("provision profile not found")
+
+var _ Misagent = (*misagent)(nil)
+
+func newMisagent(client *libimobiledevice.MisagentClient) *misagent {
+	return &misagent{
+		client: client,
+	}
+}
+
+type misagent struct {
+	client *libimobiledevice.MisagentClient
+}
+
+func (m *misagent) ListProvisionProfiles() (profiles []*mobileprovision.ProvisioningProfile, err error) {
+	var reply libimobiledevice.MisagentCopyResponse
+	if err = m.request(m.client.NewCopyRequest(), &reply); err != nil {
+		return nil, err
+	}
+
+	profiles = make([]*mobileprovision.ProvisioningProfile, 0, len(reply.Payload))
+	for _, raw := range reply.Payload {
+		var profile *mobileprovision.ProvisioningProfile
+		if profile, err = mobileprovision.Load(raw); err != nil {
+			return nil, fmt.Errorf("misagent decode profile: %w", err)
+		}
+		profiles = append(profiles, profile)
+	}
+	return
+}
+
+func (m *misagent) GetProvisionProfile(uuid string) (profile *mobileprovision.ProvisioningProfile, err error) {
+	var profiles []*mobileprovision.ProvisioningProfile
+	if profiles, err = m.ListProvisionProfiles(); err != nil {
+		return nil, err
+	}
+	for _, profile = range profiles {
+		if strings.EqualFold(profile.UUID, uuid) {
+			return profile, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrProvisionProfileNotFound, uuid)
+}
+
+func (m *misagent) CopyProvisionProfile(uuid string, format int) (raw []byte, err error) {
+	var profile *mobileprovision.ProvisioningProfile
+	if profile, err = m.GetProvisionProfile(uuid); err != nil {
+		return nil, err
+	}
+	raw, _, err = encodeProvisionProfile(profile, format)
+	return
+}
+
+// CopyAllProvisionProfiles writes every profile to hostDir as `<UUID>.mobileprovision`,
+// `<UUID>.plist` or `<UUID>.json` according to format
+func (m *misagent) CopyAllProvisionProfiles(hostDir string, format int) (results *libimobiledevice.ProvisionProfileOperationResults, err error) {
+	if _, _, err = encodeProvisionProfile(nil, format); err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(hostDir, 0755); err != nil {
+		return nil, err
+	}
+
+	var profiles []*mobileprovision.ProvisioningProfile
+	if profiles, err = m.ListProvisionProfiles(); err != nil {
+		return nil, err
+	}
+
+	results = libimobiledevice.NewProvisionProfileOperationResults()
+	for _, profile := range profiles {
+		raw, ext, errEncode := encodeProvisionProfile(profile, format)
+		if errEncode == nil {
+			errEncode = os.WriteFile(filepath.Join(hostDir, profile.UUID+ext), raw, 0644)
+		}
+		if errEncode != nil {
+			results.Failed[profile.UUID] = errEncode
+			continue
+		}
+		results.Succeeded = append(results.Succeeded, profile.UUID)
+	}
+	return
+}
+
+func (m *misagent) RemoveProvisionProfile(uuid string) (err error) {
+	var reply libimobiledevice.MisagentBasicResponse
+	return m.request(m.client.NewRemoveRequest(uuid), &reply)
+}
+
+func (m *misagent) RemoveAllProvisionProfiles() (results *libimobiledevice.ProvisionProfileOperationResults, err error) {
+	var profiles []*mobileprovision.ProvisioningProfile
+	if profiles, err = m.ListProvisionProfiles(); err != nil {
+		return nil, err
+	}
+
+	results = libimobiledevice.NewProvisionProfileOperationResults()
+	for _, profile := range profiles {
+		if errRemove := m.RemoveProvisionProfile(profile.UUID); errRemove != nil {
+			results.Failed[profile.UUID] = errRemove
+			continue
+		}
+		results.Succeeded = append(results.Succeeded, profile.UUID)
+	}
+	return
+}
+
+func (m *misagent) InstallProvisionProfile(raw []byte) (err error) {
+	if _, err = mobileprovision.Load(raw); err != nil {
+		return fmt.Errorf("misagent install: %w", err)
+	}
+	var reply libimobiledevice.MisagentBasicResponse
+	return m.request(m.client.NewInstallRequest(raw), &reply)
+}
+
+type misagentResponse interface {
+	Err() error
+}
+
+func (m *misagent) request(req interface{}, reply misagentResponse) (err error) {
+	var pkt libimobiledevice.Packet
+	if pkt, err = m.client.NewXmlPacket(req); err != nil {
+		return err
+	}
+
+	if err = m.client.SendPacket(pkt); err != nil {
+		return err
+	}
+
+	var respPkt libimobiledevice.Packet
+	if respPkt, err = m.client.ReceivePacket(); err != nil {
+		return err
+	}
+
+	if err = respPkt.Unmarshal(reply); err != nil {
+		return err
+	}
+
+	return reply.Err()
+}
+
+// encodeProvisionProfile a nil profile only validates format
+func encodeProvisionProfile(profile *mobileprovision.ProvisioningProfile, format int) (raw []byte, ext string, err error) {
+	switch format {
+	case ProvisionProfileFormatDER:
+		ext = ".mobileprovision"
+		if profile != nil {
+			raw = profile.ToBytes()
+		}
+	case ProvisionProfileFormatPlist:
+		ext = ".plist"
+		if profile != nil {
+			raw, err = profile.ToPlist()
+		}
+	case ProvisionProfileFormatJSON:
+		ext = ".json"
+		if profile != nil {
+			raw, err = profile.ToJSON()
+		}
+	default:
+		return nil, "", fmt.Errorf("unknown provision profile format: %d", format)
+	}
+	return
+}
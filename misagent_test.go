@@ -0,0 +1,214 @@
+package giDevice
+
+import (
+	"encoding/asn1"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/idevicetest"
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/libimobiledevice"
+	"github.com/sam80180/mobileprovision"
+	"howett.net/plist"
+)
+
+var misagentSrv Misagent
+
+func setupMisagentSrv(t *testing.T) {
+	setupLockdownSrv(t)
+
+	var err error
+	if misagentSrv, err = dev.MisagentService(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_misagent_ListProvisionProfiles(t *testing.T) {
+	setupMisagentSrv(t)
+
+	profiles, err := misagentSrv.ListProvisionProfiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range profiles {
+		t.Log(p.UUID, p.Name, p.ExpirationDate)
+	}
+}
+
+// simulatedMisagent stores the installed profiles by UUID
+type simulatedMisagent struct {
+	mu       sync.Mutex
+	profiles map[string][]byte
+	requests []string
+}
+
+func (s *simulatedMisagent) serve(conn net.Conn) {
+	for {
+		var req struct {
+			MessageType string `plist:"MessageType"`
+			ProfileType string `plist:"ProfileType"`
+			Profile     []byte `plist:"Profile"`
+			ProfileID   string `plist:"ProfileID"`
+		}
+		if err := idevicetest.ReadMessage(conn, &req); err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.requests = append(s.requests, req.MessageType)
+		resp := map[string]interface{}{"Status": 0}
+		switch req.MessageType {
+		case "Install":
+			if profile, err := mobileprovision.Load(req.Profile); err != nil {
+				resp["Status"] = 0xe8008001
+			} else {
+				s.profiles[profile.UUID] = req.Profile
+			}
+		case "Remove":
+			delete(s.profiles, req.ProfileID)
+		case "Copy", "CopyAll":
+			payload := make([][]byte, 0, len(s.profiles))
+			for _, raw := range s.profiles {
+				payload = append(payload, raw)
+			}
+			resp["Payload"] = payload
+		}
+		s.mu.Unlock()
+
+		if err := idevicetest.WriteMessage(conn, resp); err != nil {
+			return
+		}
+	}
+}
+
+// newProvisionProfile the CMS SignedData of a `.mobileprovision` file, without
+// signers: misagent stores the profile, mobileprovision only reads its content
+func newProvisionProfile(t *testing.T, uuid, name string) []byte {
+	content, err := plist.Marshal(map[string]interface{}{
+		"UUID":           uuid,
+		"Name":           name,
+		"ExpirationDate": time.Now().Add(time.Hour * 24).UTC().Truncate(time.Second),
+	}, plist.XMLFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type encapsulatedContentInfo struct {
+		EContentType asn1.ObjectIdentifier
+		EContent     []byte `asn1:"explicit,tag:0"`
+	}
+	type signedData struct {
+		Version          int
+		DigestAlgorithms []asn1.RawValue `asn1:"set"`
+		EncapContentInfo encapsulatedContentInfo
+		SignerInfos      []asn1.RawValue `asn1:"set"`
+	}
+	inner, err := asn1.Marshal(signedData{
+		Version:          1,
+		DigestAlgorithms: []asn1.RawValue{},
+		EncapContentInfo: encapsulatedContentInfo{
+			EContentType: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1},
+			EContent:     content,
+		},
+		SignerInfos: []asn1.RawValue{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := asn1.Marshal(struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue
+	}{
+		ContentType: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2},
+		// [0] EXPLICIT, which the tag of a RawValue field does not apply
+		Content: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: inner},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func Test_misagent_simulated(t *testing.T) {
+	setupSimulatedDevice(t)
+	agent := &simulatedMisagent{profiles: make(map[string][]byte)}
+	simDev.Handle(libimobiledevice.MisagentServiceName, agent.serve)
+
+	misagent, err := dev.MisagentService()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uuids := []string{"0B1C8D1E-0000-4000-8000-000000000001", "0B1C8D1E-0000-4000-8000-000000000002"}
+	for i, uuid := range uuids {
+		if err = misagent.InstallProvisionProfile(newProvisionProfile(t, uuid, "profile "+uuids[i][35:])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = misagent.InstallProvisionProfile([]byte("not a profile")); err == nil {
+		t.Fatal("installed an invalid profile")
+	}
+
+	profiles, err := misagent.ListProvisionProfiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profiles) != len(uuids) {
+		t.Fatalf("%d profiles, want %d", len(profiles), len(uuids))
+	}
+
+	profile, err := misagent.GetProvisionProfile(uuids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.Name != "profile 1" {
+		t.Fatalf("profile name: %s", profile.Name)
+	}
+	if _, err = misagent.GetProvisionProfile("missing"); !errors.Is(err, ErrProvisionProfileNotFound) {
+		t.Fatalf("missing profile: %v", err)
+	}
+
+	raw, err := misagent.CopyProvisionProfile(uuids[1], ProvisionProfileFormatPlist)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var content map[string]interface{}
+	if _, err = plist.Unmarshal(raw, &content); err != nil || content["UUID"] != uuids[1] {
+		t.Fatalf("plist profile: %v %v", content, err)
+	}
+
+	hostDir := t.TempDir()
+	results, err := misagent.CopyAllProvisionProfiles(hostDir, ProvisionProfileFormatDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results.Err() != nil || len(results.Succeeded) != len(uuids) {
+		t.Fatalf("copy all: %#v", results)
+	}
+	for _, uuid := range uuids {
+		if _, err = os.Stat(filepath.Join(hostDir, uuid+".mobileprovision")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = misagent.RemoveProvisionProfile(uuids[0]); err != nil {
+		t.Fatal(err)
+	}
+	if results, err = misagent.RemoveAllProvisionProfiles(); err != nil {
+		t.Fatal(err)
+	}
+	if len(results.Succeeded) != 1 || results.Succeeded[0] != uuids[1] {
+		t.Fatalf("remove all: %#v", results)
+	}
+	if len(agent.profiles) != 0 {
+		t.Fatalf("%d profiles left", len(agent.profiles))
+	}
+	// iOS 16 lists with `CopyAll`
+	if agent.requests[len(uuids)] != "CopyAll" {
+		t.Fatalf("requests: %v", agent.requests)
+	}
+}
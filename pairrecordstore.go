@@ -0,0 +1,260 @@
+package giDevice
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/libimobiledevice"
+	"howett.net/plist"
+)
+
+// DefaultLockdownDir where usbmuxd keeps pair records on Linux
+const DefaultLockdownDir = "/var/lib/lockdown"
+
+// ErrPairRecordNotFound no pair record is stored for the device.
+// It matches libimobiledevice.ReplyCodeBadDevice (what usbmuxd replies) and fs.ErrNotExist with errors.Is
+var ErrPairRecordNotFound error = &pairRecordNotFoundError{}
+
+type pairRecordNotFoundError struct{}
+
+func (e *pairRecordNotFoundError) Error() string { return "pair record not found" }
+
+func (e *pairRecordNotFoundError) Is(target error) bool {
+	return target == libimobiledevice.ReplyCodeBadDevice || target == fs.ErrNotExist
+}
+
+// PairRecordStore keeps the pair records of devices by UDID
+type PairRecordStore interface {
+	ReadPairRecord(udid string) (pairRecord *PairRecord, err error)
+	SavePairRecord(udid string, pairRecord *PairRecord) (err error)
+	DeletePairRecord(udid string) (err error)
+}
+
+// PairRecordLister a PairRecordStore able to list its records, by UDID
+type PairRecordLister interface {
+	PairRecords() (pairRecords map[string]*PairRecord, err error)
+}
+
+var _ PairRecordStore = (*usbmuxdPairRecordStore)(nil)
+
+var errNoUsbmuxd = errors.New("pair record: device is not attached through usbmuxd")
+
+// usbmuxdPairRecordStore asks the usbmuxd the device is attached to
+type usbmuxdPairRecordStore struct {
+	dev *device
+}
+
+func (s *usbmuxdPairRecordStore) ReadPairRecord(udid string) (pairRecord *PairRecord, err error) {
+	umClient, release, err := s.dev.usbmuxClient(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	var respPkt libimobiledevice.Packet
+	if respPkt, err = umClient.Exchange(
+		umClient.NewReadPairRecordRequest(udid),
+	); err != nil {
+		if errors.Is(err, libimobiledevice.ReplyCodeBadDevice) {
+			return nil, ErrPairRecordNotFound
+		}
+		return nil, err
+	}
+
+	var reply = struct {
+		Data []byte `plist:"PairRecordData"`
+	}{}
+	if err = respPkt.Unmarshal(&reply); err != nil {
+		return nil, err
+	}
+
+	var record PairRecord
+	if _, err = plist.Unmarshal(reply.Data, &record); err != nil {
+		return nil, err
+	}
+
+	pairRecord = &record
+	return
+}
+
+func (s *usbmuxdPairRecordStore) SavePairRecord(udid string, pairRecord *PairRecord) (err error) {
+	var data []byte
+	if data, err = plist.Marshal(pairRecord, plist.XMLFormat); err != nil {
+		return err
+	}
+
+	umClient, release, err := s.dev.usbmuxClient(context.Background())
+	if err != nil {
+		return err
+	}
+	defer release()
+	_, err = umClient.Exchange(
+		umClient.NewSavePairRecordRequest(udid, s.dev.deviceID(), data),
+	)
+	return
+}
+
+func (s *usbmuxdPairRecordStore) DeletePairRecord(udid string) (err error) {
+	umClient, release, err := s.dev.usbmuxClient(context.Background())
+	if err != nil {
+		return err
+	}
+	defer release()
+	_, err = umClient.Exchange(
+		umClient.NewDeletePairRecordRequest(udid),
+	)
+	return
+}
+
+var (
+	_ PairRecordStore  = (*dirPairRecordStore)(nil)
+	_ PairRecordLister = (*dirPairRecordStore)(nil)
+)
+
+// NewDirPairRecordStore keeps pair records as <UDID>.plist files in dir,
+// the layout of DefaultLockdownDir
+func NewDirPairRecordStore(dir string) PairRecordStore {
+	return &dirPairRecordStore{dir: dir}
+}
+
+type dirPairRecordStore struct {
+	dir string
+}
+
+func (s *dirPairRecordStore) filename(udid string) string {
+	return filepath.Join(s.dir, udid+".plist")
+}
+
+func (s *dirPairRecordStore) ReadPairRecord(udid string) (pairRecord *PairRecord, err error) {
+	var data []byte
+	if data, err = os.ReadFile(s.filename(udid)); err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrPairRecordNotFound
+		}
+		return nil, err
+	}
+
+	pairRecord = new(PairRecord)
+	if _, err = plist.Unmarshal(data, pairRecord); err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (s *dirPairRecordStore) SavePairRecord(udid string, pairRecord *PairRecord) (err error) {
+	var data []byte
+	if data, err = plist.MarshalIndent(pairRecord, plist.XMLFormat, "\t"); err != nil {
+		return err
+	}
+	if err = os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+
+	// written aside then renamed, a reader never sees a partial record
+	var tmp *os.File
+	if tmp, err = os.CreateTemp(s.dir, udid+".plist.*"); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.filename(udid))
+}
+
+func (s *dirPairRecordStore) PairRecords() (pairRecords map[string]*PairRecord, err error) {
+	var entries []fs.DirEntry
+	if entries, err = os.ReadDir(s.dir); err != nil {
+		if os.IsNotExist(err) {
+			return map[string]*PairRecord{}, nil
+		}
+		return nil, err
+	}
+
+	pairRecords = make(map[string]*PairRecord, len(entries))
+	for _, entry := range entries {
+		udid := strings.TrimSuffix(entry.Name(), ".plist")
+		// SystemConfiguration.plist lives there too
+		if entry.IsDir() || udid == entry.Name() || udid == "SystemConfiguration" {
+			continue
+		}
+		var record *PairRecord
+		if record, err = s.ReadPairRecord(udid); err != nil {
+			return nil, err
+		}
+		pairRecords[udid] = record
+	}
+	return pairRecords, nil
+}
+
+func (s *dirPairRecordStore) DeletePairRecord(udid string) (err error) {
+	if err = os.Remove(s.filename(udid)); err != nil && os.IsNotExist(err) {
+		return ErrPairRecordNotFound
+	}
+	return
+}
+
+var (
+	_ PairRecordStore  = (*memoryPairRecordStore)(nil)
+	_ PairRecordLister = (*memoryPairRecordStore)(nil)
+)
+
+// NewMemoryPairRecordStore keeps pair records in memory only
+func NewMemoryPairRecordStore() PairRecordStore {
+	return &memoryPairRecordStore{records: make(map[string]PairRecord)}
+}
+
+type memoryPairRecordStore struct {
+	mu      sync.Mutex
+	records map[string]PairRecord
+}
+
+func (s *memoryPairRecordStore) ReadPairRecord(udid string) (pairRecord *PairRecord, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.records[udid]
+	if !ok {
+		return nil, ErrPairRecordNotFound
+	}
+	return &record, nil
+}
+
+func (s *memoryPairRecordStore) SavePairRecord(udid string, pairRecord *PairRecord) (err error) {
+	s.mu.Lock()
+	s.records[udid] = *pairRecord
+	s.mu.Unlock()
+	return
+}
+
+func (s *memoryPairRecordStore) PairRecords() (pairRecords map[string]*PairRecord, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pairRecords = make(map[string]*PairRecord, len(s.records))
+	for udid := range s.records {
+		record := s.records[udid]
+		pairRecords[udid] = &record
+	}
+	return
+}
+
+func (s *memoryPairRecordStore) DeletePairRecord(udid string) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.records[udid]; !ok {
+		return ErrPairRecordNotFound
+	}
+	delete(s.records, udid)
+	return
+}
@@ -0,0 +1,99 @@
+package giDevice
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/libimobiledevice"
+	"howett.net/plist"
+)
+
+func Test_dirPairRecordStore(t *testing.T) {
+	dir := t.TempDir()
+	store := NewDirPairRecordStore(dir)
+
+	if _, err := store.ReadPairRecord("00008030-001"); !errors.Is(err, ErrPairRecordNotFound) || !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+
+	record := &PairRecord{HostID: "HOST", SystemBUID: "BUID", EscrowBag: []byte{1, 2, 3}}
+	if err := store.SavePairRecord("00008030-001", record); err != nil {
+		t.Fatal(err)
+	}
+
+	// the file usbmuxd would read
+	data, err := os.ReadFile(filepath.Join(dir, "00008030-001.plist"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved PairRecord
+	if _, err = plist.Unmarshal(data, &saved); err != nil || saved.HostID != "HOST" {
+		t.Fatalf("saved: %v %v", saved, err)
+	}
+
+	got, err := store.ReadPairRecord("00008030-001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.SystemBUID != "BUID" || len(got.EscrowBag) != 3 {
+		t.Fatalf("read: %#v", got)
+	}
+
+	records, err := store.(PairRecordLister).PairRecords()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records["00008030-001"].HostID != "HOST" {
+		t.Fatalf("records: %v", records)
+	}
+
+	if err = store.DeletePairRecord("00008030-001"); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.DeletePairRecord("00008030-001"); !errors.Is(err, ErrPairRecordNotFound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+}
+
+func Test_memoryPairRecordStore(t *testing.T) {
+	store := NewMemoryPairRecordStore()
+
+	if _, err := store.ReadPairRecord("udid"); !errors.Is(err, libimobiledevice.ReplyCodeBadDevice) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	if err := store.SavePairRecord("udid", &PairRecord{HostID: "HOST"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := store.ReadPairRecord("udid"); err != nil || got.HostID != "HOST" {
+		t.Fatalf("read: %v %v", got, err)
+	}
+}
+
+func Test_device_simulated_PairRecordStore(t *testing.T) {
+	setupSimulatedDevice(t)
+	store := NewMemoryPairRecordStore()
+	dev.SetPairRecordStore(store)
+
+	// pairs on first use and keeps the record away from usbmuxd
+	if _, err := dev.GetValue("", "ProductVersion"); err != nil {
+		t.Fatal(err)
+	}
+	pairRecord, err := store.ReadPairRecord(simDev.UDID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := simDev.Trusted(pairRecord.HostID); !ok {
+		t.Fatalf("host %s is not trusted", pairRecord.HostID)
+	}
+	if _, ok := simSrv.PairRecord(simDev.UDID); ok {
+		t.Fatal("pair record saved to usbmuxd")
+	}
+
+	dev.SetPairRecordStore(nil)
+	if _, err = dev.ReadPairRecord(); !errors.Is(err, ErrPairRecordNotFound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+}
@@ -24,6 +24,7 @@ import (
 	"fmt"
 	"net"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/SonicCloudOrg/sonic-gidevice/pkg/libimobiledevice"
@@ -149,19 +150,26 @@ func WithPerfSystemAttributes(attrs ...string) PerfOption {
 }
 
 type perfdClient struct {
-	options *PerfOptions
-	i       Instruments
-	stop    chan struct{}      // used to stop perf client
-	cancel  context.CancelFunc // used to cancel all iterators
+	ctx      context.Context // used to start perf client
+	options  *PerfOptions
+	i        Instruments
+	stop     chan struct{} // used to stop perf client
+	stopOnce sync.Once
+	cancel   context.CancelFunc // used to cancel all iterators
 }
 
-func (d *device) newPerfdSysmontap(options *PerfOptions) (*perfdSysmontap, error) {
-	instruments, err := d.newInstrumentsService()
+func (c *perfdClient) Stop() {
+	c.stopOnce.Do(func() { close(c.stop) })
+}
+
+func (d *device) newPerfdSysmontap(ctx context.Context, options *PerfOptions) (*perfdSysmontap, error) {
+	instruments, err := d.newInstrumentsServiceContext(ctx)
 	if err != nil {
 		return nil, err
 	}
 	return &perfdSysmontap{
 		perfdClient: perfdClient{
+			ctx:     ctx,
 			i:       instruments,
 			options: options,
 			stop:    make(chan struct{}),
@@ -194,7 +202,8 @@ func (c *perfdSysmontap) Start() (data <-chan []byte, err error) {
 		"procAttrs":      c.options.ProcessAttributes, // process performance
 		"sysAttrs":       c.options.SystemAttributes,  // system performance
 	}
-	if _, err = c.i.call(
+	if _, err = c.i.callContext(
+		c.ctx,
 		instrumentsServiceSysmontap,
 		"setConfig:",
 		config,
@@ -203,7 +212,8 @@ func (c *perfdSysmontap) Start() (data <-chan []byte, err error) {
 	}
 
 	// start
-	if _, err = c.i.call(
+	if _, err = c.i.callContext(
+		c.ctx,
 		instrumentsServiceSysmontap,
 		"start",
 	); err != nil {
@@ -212,10 +222,9 @@ func (c *perfdSysmontap) Start() (data <-chan []byte, err error) {
 
 	// register listener
 	ctx, cancel := context.WithCancel(context.TODO())
-	c.i.registerCallback("", func(m libimobiledevice.DTXMessageResult) {
+	if err = c.i.registerChannelCallback(instrumentsServiceSysmontap, func(m libimobiledevice.DTXMessageResult) {
 		select {
 		case <-ctx.Done():
-			c.i.call(instrumentsServiceSysmontap, "stop")
 			return
 		default:
 			dataArray, ok := m.Obj.([]interface{})
@@ -230,7 +239,10 @@ func (c *perfdSysmontap) Start() (data <-chan []byte, err error) {
 				c.parseSystemData(dataArray)
 			}
 		}
-	})
+	}); err != nil {
+		cancel()
+		return nil, err
+	}
 	c.cancel = cancel
 
 	outCh := make(chan []byte, 100)
@@ -240,6 +252,8 @@ func (c *perfdSysmontap) Start() (data <-chan []byte, err error) {
 			select {
 			case <-c.stop:
 				c.cancel()
+				c.i.unregisterChannelCallback(instrumentsServiceSysmontap)
+				_, _ = c.i.call(instrumentsServiceSysmontap, "stop")
 				return
 			case cpuBytes, ok := <-c.chanSysCPU:
 				if ok {
@@ -268,10 +282,6 @@ func (c *perfdSysmontap) Start() (data <-chan []byte, err error) {
 	return outCh, nil
 }
 
-func (c *perfdSysmontap) Stop() {
-	close(c.stop)
-}
-
 func (c *perfdSysmontap) parseProcessData(dataArray []interface{}) {
 	/**
 	dataArray example:
@@ -507,13 +517,14 @@ type SystemNetworkData struct {
 	PacketsOut   int64 `json:"packets_out"`
 }
 
-func (d *device) newPerfdNetworking(options *PerfOptions) (*perfdNetworking, error) {
-	instruments, err := d.newInstrumentsService()
+func (d *device) newPerfdNetworking(ctx context.Context, options *PerfOptions) (*perfdNetworking, error) {
+	instruments, err := d.newInstrumentsServiceContext(ctx)
 	if err != nil {
 		return nil, err
 	}
 	return &perfdNetworking{
 		perfdClient: perfdClient{
+			ctx:     ctx,
 			i:       instruments,
 			options: options,
 			stop:    make(chan struct{}),
@@ -529,14 +540,16 @@ type perfdNetworking struct {
 
 func (c *perfdNetworking) Start() (data <-chan []byte, err error) {
 
-	if _, err = c.i.call(
+	if _, err = c.i.callContext(
+		c.ctx,
 		instrumentsServiceNetworking,
 		"replayLastRecordedSession",
 	); err != nil {
 		return nil, err
 	}
 
-	if _, err = c.i.call(
+	if _, err = c.i.callContext(
+		c.ctx,
 		instrumentsServiceNetworking,
 		"startMonitoring",
 	); err != nil {
@@ -544,15 +557,17 @@ func (c *perfdNetworking) Start() (data <-chan []byte, err error) {
 	}
 
 	ctx, cancel := context.WithCancel(context.TODO())
-	c.i.registerCallback("", func(m libimobiledevice.DTXMessageResult) {
+	if err = c.i.registerChannelCallback(instrumentsServiceNetworking, func(m libimobiledevice.DTXMessageResult) {
 		select {
 		case <-ctx.Done():
-			c.i.call(instrumentsServiceNetworking, "stopMonitoring")
 			return
 		default:
 			c.parseNetworking(m.Obj)
 		}
-	})
+	}); err != nil {
+		cancel()
+		return nil, err
+	}
 	c.cancel = cancel
 
 	outCh := make(chan []byte, 100)
@@ -562,6 +577,8 @@ func (c *perfdNetworking) Start() (data <-chan []byte, err error) {
 			select {
 			case <-c.stop:
 				c.cancel()
+				c.i.unregisterChannelCallback(instrumentsServiceNetworking)
+				_, _ = c.i.call(instrumentsServiceNetworking, "stopMonitoring")
 				return
 			case networkBytes, ok := <-c.chanNetwork:
 				if ok {
@@ -574,10 +591,6 @@ func (c *perfdNetworking) Start() (data <-chan []byte, err error) {
 	return outCh, nil
 }
 
-func (c *perfdNetworking) Stop() {
-	close(c.stop)
-}
-
 func (c *perfdNetworking) parseNetworking(data interface{}) {
 	raw, ok := data.([]interface{})
 	if !ok || len(raw) != 2 {
@@ -730,13 +743,14 @@ type NetworkDataConnectionUpdate struct {
 	ConnectionSerial int64 `json:"connection_serial"` // 9
 }
 
-func (d *device) newPerfdGraphicsOpengl(options *PerfOptions) (*perfdGraphicsOpengl, error) {
-	instruments, err := d.newInstrumentsService()
+func (d *device) newPerfdGraphicsOpengl(ctx context.Context, options *PerfOptions) (*perfdGraphicsOpengl, error) {
+	instruments, err := d.newInstrumentsServiceContext(ctx)
 	if err != nil {
 		return nil, err
 	}
 	return &perfdGraphicsOpengl{
 		perfdClient: perfdClient{
+			ctx:     ctx,
 			i:       instruments,
 			options: options,
 			stop:    make(chan struct{}),
@@ -754,7 +768,8 @@ type perfdGraphicsOpengl struct {
 
 func (c *perfdGraphicsOpengl) Start() (data <-chan []byte, err error) {
 
-	if _, err = c.i.call(
+	if _, err = c.i.callContext(
+		c.ctx,
 		instrumentsServiceGraphicsOpengl,
 		"setSamplingRate:",
 		c.options.OutputInterval/100,
@@ -762,7 +777,8 @@ func (c *perfdGraphicsOpengl) Start() (data <-chan []byte, err error) {
 		return nil, err
 	}
 
-	if _, err = c.i.call(
+	if _, err = c.i.callContext(
+		c.ctx,
 		instrumentsServiceGraphicsOpengl,
 		"startSamplingAtTimeInterval:",
 		0,
@@ -771,15 +787,17 @@ func (c *perfdGraphicsOpengl) Start() (data <-chan []byte, err error) {
 	}
 
 	ctx, cancel := context.WithCancel(context.TODO())
-	c.i.registerCallback("", func(m libimobiledevice.DTXMessageResult) {
+	if err = c.i.registerChannelCallback(instrumentsServiceGraphicsOpengl, func(m libimobiledevice.DTXMessageResult) {
 		select {
 		case <-ctx.Done():
-			c.i.call(instrumentsServiceGraphicsOpengl, "stopSampling")
 			return
 		default:
 			c.parseData(m.Obj)
 		}
-	})
+	}); err != nil {
+		cancel()
+		return nil, err
+	}
 	c.cancel = cancel
 
 	outCh := make(chan []byte, 100)
@@ -789,6 +807,8 @@ func (c *perfdGraphicsOpengl) Start() (data <-chan []byte, err error) {
 			select {
 			case <-c.stop:
 				c.cancel()
+				c.i.unregisterChannelCallback(instrumentsServiceGraphicsOpengl)
+				_, _ = c.i.call(instrumentsServiceGraphicsOpengl, "stopSampling")
 				return
 			case gpuBytes, ok := <-c.chanGPU:
 				if ok {
@@ -805,10 +825,6 @@ func (c *perfdGraphicsOpengl) Start() (data <-chan []byte, err error) {
 	return outCh, nil
 }
 
-func (c *perfdGraphicsOpengl) Stop() {
-	close(c.stop)
-}
-
 func (c *perfdGraphicsOpengl) parseData(data interface{}) {
 	// data example:
 	// map[
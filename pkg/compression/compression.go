@@ -0,0 +1,127 @@
+// Package compression decodes the block streams written by Apple's
+// libcompression (`compression_encode_buffer`, `compression_stream`) for
+// the LZ4 and LZFSE algorithms, the formats devices use for compressed
+// DTX messages.
+//
+// A stream is a sequence of blocks, each starting with a four byte magic:
+//
+//	"bv41"  LZ4 block            "bv4-"  uncompressed (LZ4 stream)
+//	"bvx2"  LZFSE block          "bvx1"  LZFSE block, legacy header
+//	"bvxn"  LZVN block           "bvx-"  uncompressed (LZFSE stream)
+//	"bv4$", "bvx$"  end of stream
+//
+// Matches may refer back into previous blocks of the same stream.
+//
+// The LZ4 decoder is checked against the output of the lz4 tool. The LZFSE
+// decoder is only checked against the encoder of its own tests, not yet against
+// streams written by libcompression: see TestDecode_Captured.
+package compression
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var ErrCorrupt = errors.New("compression: corrupt input")
+
+const (
+	magicLZ4               = "bv41"
+	magicLZ4Uncompressed   = "bv4-"
+	magicLZ4EndOfStream    = "bv4$"
+	magicLZFSEV1           = "bvx1"
+	magicLZFSEV2           = "bvx2"
+	magicLZVN              = "bvxn"
+	magicLZFSEUncompressed = "bvx-"
+	magicLZFSEEndOfStream  = "bvx$"
+)
+
+// IsStream reports whether src starts with a known block magic.
+func IsStream(src []byte) bool {
+	if len(src) < 4 {
+		return false
+	}
+	switch string(src[:4]) {
+	case magicLZ4, magicLZ4Uncompressed, magicLZ4EndOfStream,
+		magicLZFSEV1, magicLZFSEV2, magicLZVN, magicLZFSEUncompressed, magicLZFSEEndOfStream:
+		return true
+	}
+	return false
+}
+
+// Decode decodes a whole stream, up to and including its end of stream block.
+func Decode(src []byte) (dst []byte, err error) {
+	return AppendDecode(nil, src)
+}
+
+// AppendDecode appends the decoded stream to dst. Matches never refer to
+// bytes of dst written before the call.
+func AppendDecode(dst, src []byte) ([]byte, error) {
+	start := len(dst)
+	for {
+		if len(src) < 4 {
+			return nil, fmt.Errorf("%w: missing end of stream", ErrCorrupt)
+		}
+		magic := string(src[:4])
+
+		var (
+			n   int
+			err error
+		)
+		switch magic {
+		case magicLZ4EndOfStream, magicLZFSEEndOfStream:
+			return dst, nil
+
+		case magicLZ4Uncompressed, magicLZFSEUncompressed:
+			if len(src) < 8 {
+				return nil, fmt.Errorf("%w: truncated %q header", ErrCorrupt, magic)
+			}
+			nRaw := int(binary.LittleEndian.Uint32(src[4:]))
+			if len(src)-8 < nRaw {
+				return nil, fmt.Errorf("%w: truncated %q block", ErrCorrupt, magic)
+			}
+			dst = append(dst, src[8:8+nRaw]...)
+			n = 8 + nRaw
+
+		case magicLZ4, magicLZVN:
+			if len(src) < 12 {
+				return nil, fmt.Errorf("%w: truncated %q header", ErrCorrupt, magic)
+			}
+			nRaw := int(binary.LittleEndian.Uint32(src[4:]))
+			nPayload := int(binary.LittleEndian.Uint32(src[8:]))
+			if len(src)-12 < nPayload {
+				return nil, fmt.Errorf("%w: truncated %q block", ErrCorrupt, magic)
+			}
+			payload := src[12 : 12+nPayload]
+			if magic == magicLZ4 {
+				dst, err = decodeLZ4(dst, start, payload, nRaw)
+			} else {
+				dst, err = decodeLZVN(dst, start, payload, nRaw)
+			}
+			n = 12 + nPayload
+
+		case magicLZFSEV1, magicLZFSEV2:
+			dst, n, err = decodeLZFSE(dst, start, src)
+
+		default:
+			return nil, fmt.Errorf("%w: unknown block magic %q", ErrCorrupt, magic)
+		}
+		if err != nil {
+			return nil, err
+		}
+		src = src[n:]
+	}
+}
+
+// appendMatch copies length bytes from distance bytes back, the regions
+// may overlap.
+func appendMatch(dst []byte, distance, length int) []byte {
+	from := len(dst) - distance
+	if distance >= length {
+		return append(dst, dst[from:from+length]...)
+	}
+	for i := 0; i < length; i++ {
+		dst = append(dst, dst[from+i])
+	}
+	return dst
+}
@@ -0,0 +1,432 @@
+package compression
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"math/bits"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func block(magic string, fields ...interface{}) []byte {
+	buf := bytes.NewBufferString(magic)
+	for _, f := range fields {
+		switch v := f.(type) {
+		case int:
+			_ = binary.Write(buf, binary.LittleEndian, uint32(v))
+		case uint64:
+			_ = binary.Write(buf, binary.LittleEndian, v)
+		case string:
+			buf.WriteString(v)
+		case []byte:
+			buf.Write(v)
+		}
+	}
+	return buf.Bytes()
+}
+
+func mustHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestDecode_LZ4(t *testing.T) {
+	want := []byte("hello hello hello hello hello world, hello hello world!")
+	// block of `lz4 -9` (frame stripped)
+	payload := mustHex(t, "6f68656c6c6f200600056a776f726c642c1300506f726c6421")
+
+	got, err := Decode(block(magicLZ4, len(want), len(payload), payload, magicLZ4EndOfStream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("got %q", got)
+	}
+
+	// the terminating sequence after the last byte is ignored
+	got, err = Decode(block(magicLZ4, len(want), len(payload)+3, payload, []byte{0, 0, 0}, magicLZ4EndOfStream))
+	if err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("got %q: %v", got, err)
+	}
+}
+
+func TestDecode_LZVN(t *testing.T) {
+	want := []byte("abcabcabcabcXYZXYZXYZ-abcabc")
+	payload := []byte{
+		0xe3, 'a', 'b', 'c', // sml_l "abc"
+		0x30, 0x03, // sml_d M=9 D=3
+		0x0e,                // nop
+		0xe3, 'X', 'Y', 'Z', // sml_l "XYZ"
+		0x1f, 0x03, 0x00, // lrg_d M=6 D=3
+		0xe1, '-', // sml_l "-"
+		0xa0, 0x5b, 0x00, // med_d M=6 D=22
+		0x06, 0, 0, 0, 0, 0, 0, 0, // eos
+	}
+
+	got, err := Decode(block(magicLZVN, len(want), len(payload), payload, magicLZFSEEndOfStream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestDecode_CrossBlock(t *testing.T) {
+	first := []byte("0123456789")
+	// lz4: no literals, match of 10 at distance 10 reaching into the first block
+	payload := []byte{0x06, 0x0a, 0x00}
+
+	got, err := Decode(block(
+		magicLZ4Uncompressed, len(first), first,
+		magicLZ4, 10, len(payload), payload,
+		magicLZ4EndOfStream,
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := bytes.Repeat(first, 2); !bytes.Equal(got, want) {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestDecode_LZFSE(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	words := []string{"sysmontap ", "coreprofilesessiontap ", "cpuUsage ", "pid ", "\x00\x01\x02"}
+	text := new(bytes.Buffer)
+	for text.Len() < 50000 {
+		text.WriteString(words[r.Intn(len(words))])
+		if r.Intn(8) == 0 {
+			text.WriteByte(byte(r.Intn(256)))
+		}
+	}
+	raw := text.Bytes()
+
+	for _, src := range [][]byte{
+		[]byte("a"),
+		[]byte("abcd"),
+		bytes.Repeat([]byte{'z'}, 1000),
+		raw[:777],
+		raw,
+	} {
+		stream := append(encodeLZFSEV2Block(t, src), magicLZFSEEndOfStream...)
+		got, err := Decode(stream)
+		if err != nil {
+			t.Fatalf("decode %d bytes: %v", len(src), err)
+		}
+		if !bytes.Equal(got, src) {
+			t.Fatalf("decode %d bytes: mismatch", len(src))
+		}
+	}
+}
+
+// TestDecode_Captured decodes the streams of Apple's libcompression kept in
+// testdata: each `<name>.lzfse`, as `compression_tool -encode -a lzfse` writes it
+// on macOS, along with `<name>` it decodes to. Only encodeLZFSEV2Block is tested
+// otherwise, which shares its reading of the format with the decoder.
+func TestDecode_Captured(t *testing.T) {
+	streams, err := filepath.Glob(filepath.Join("testdata", "*.lzfse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(streams) == 0 {
+		t.Skip("no captured streams in testdata")
+	}
+	for _, name := range streams {
+		src, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := os.ReadFile(strings.TrimSuffix(name, ".lzfse"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// inputs under 4 KiB are encoded with LZVN instead
+		if !bytes.HasPrefix(src, []byte(magicLZFSEV2)) {
+			t.Fatalf("%s: no bvx2 block", name)
+		}
+		got, err := Decode(src)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("%s: mismatch", name)
+		}
+	}
+}
+
+func TestDecode_Corrupt(t *testing.T) {
+	valid := append(encodeLZFSEV2Block(t, bytes.Repeat([]byte("corrupt "), 64)), magicLZFSEEndOfStream...)
+
+	for name, src := range map[string][]byte{
+		"empty":          {},
+		"unknown magic":  []byte("bvz1\x00\x00\x00\x00"),
+		"no end":         block(magicLZFSEUncompressed, 3, []byte("abc")),
+		"short raw":      block(magicLZFSEUncompressed, 8, []byte("abc")),
+		"lz4 distance":   block(magicLZ4, 8, 3, []byte{0x04, 0x01, 0x00}, magicLZ4EndOfStream),
+		"lzvn no eos":    block(magicLZVN, 1, 2, []byte{0xe1, 'a'}, magicLZFSEEndOfStream),
+		"lzvn udef":      block(magicLZVN, 1, 1, []byte{0x1e}, magicLZFSEEndOfStream),
+		"lzfse truncate": valid[:len(valid)-12],
+		"lzfse header":   append(append([]byte{}, valid[:8]...), bytes.Repeat([]byte{0xff}, 40)...),
+	} {
+		if _, err := Decode(src); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestIsStream(t *testing.T) {
+	if !IsStream([]byte("bvx2....")) || !IsStream([]byte("bv41")) {
+		t.Fatal("known magic")
+	}
+	if IsStream([]byte("bplist00")) || IsStream([]byte("bv")) {
+		t.Fatal("unknown magic")
+	}
+}
+
+// encodeLZFSEV2Block is a small "bvx2" encoder: greedy matching and
+// FSE with frequencies normalized from the symbol counts.
+func encodeLZFSEV2Block(t *testing.T, src []byte) []byte {
+	type lmd struct{ l, m, d int }
+	var (
+		literals []byte
+		triples  []lmd
+	)
+	const maxL, maxM, maxD = 315, 2359, 65535
+	pending := 0
+	flushLiterals := func(m, d int) {
+		for pending > maxL {
+			triples = append(triples, lmd{maxL, 0, 0})
+			pending -= maxL
+		}
+		triples = append(triples, lmd{pending, m, d})
+		pending = 0
+	}
+	for i := 0; i < len(src); {
+		bestLen, bestDist := 0, 0
+		for j := i - 1; j >= 0 && i-j <= maxD && j >= i-4096; j-- {
+			n := 0
+			for i+n < len(src) && src[j+n] == src[i+n] && n < maxM {
+				n++
+			}
+			if n > bestLen {
+				bestLen, bestDist = n, i-j
+			}
+		}
+		if bestLen < 4 {
+			literals = append(literals, src[i])
+			pending++
+			i++
+			continue
+		}
+		flushLiterals(bestLen, bestDist)
+		i += bestLen
+	}
+	if pending > 0 {
+		flushLiterals(0, 0)
+	}
+	for len(literals)%4 != 0 {
+		literals = append(literals, 0)
+	}
+
+	// symbols and extra bits, a repeated distance is coded as 0
+	symbolOf := func(base []int32, extra []uint8, v int) (int, uint64, int) {
+		for s := len(base) - 1; s >= 0; s-- {
+			if int(base[s]) <= v {
+				return s, uint64(v - int(base[s])), int(extra[s])
+			}
+		}
+		t.Fatalf("no symbol for %d", v)
+		return 0, 0, 0
+	}
+	type coded struct {
+		sym, n int
+		bits   uint64
+	}
+	var ls, ms, ds []coded
+	lFreq := make([]int, lzfseEncodeLSymbols)
+	mFreq := make([]int, lzfseEncodeMSymbols)
+	dFreq := make([]int, lzfseEncodeDSymbols)
+	prevD := 0
+	for _, x := range triples {
+		d := x.d
+		if x.m == 0 || d == prevD {
+			d = 0
+		} else {
+			prevD = d
+		}
+		s, b, n := symbolOf(lzfseLBaseValue[:], lzfseLExtraBits[:], x.l)
+		ls, lFreq[s] = append(ls, coded{s, n, b}), lFreq[s]+1
+		s, b, n = symbolOf(lzfseMBaseValue[:], lzfseMExtraBits[:], x.m)
+		ms, mFreq[s] = append(ms, coded{s, n, b}), mFreq[s]+1
+		s, b, n = symbolOf(lzfseDBaseValue[:], lzfseDExtraBits[:], d)
+		ds, dFreq[s] = append(ds, coded{s, n, b}), dFreq[s]+1
+	}
+	literalFreq := make([]int, lzfseEncodeLiteralSymbols)
+	for _, c := range literals {
+		literalFreq[c]++
+	}
+
+	normalize := func(counts []int, nstates int) []uint16 {
+		total, top := 0, 0
+		for i, c := range counts {
+			total += c
+			if c > counts[top] {
+				top = i
+			}
+		}
+		freq := make([]uint16, len(counts))
+		if total == 0 {
+			freq[0] = uint16(nstates)
+			return freq
+		}
+		sum := 0
+		for i, c := range counts {
+			if c == 0 {
+				continue
+			}
+			f := c * nstates / total
+			if f == 0 {
+				f = 1
+			}
+			freq[i] = uint16(f)
+			sum += f
+		}
+		for sum > nstates {
+			for i := range freq {
+				if freq[i] > 1 && sum > nstates {
+					freq[i]--
+					sum--
+				}
+			}
+		}
+		freq[top] += uint16(nstates - sum)
+		return freq
+	}
+	lNorm := normalize(lFreq, lzfseEncodeLStates)
+	mNorm := normalize(mFreq, lzfseEncodeMStates)
+	dNorm := normalize(dFreq, lzfseEncodeDStates)
+	literalNorm := normalize(literalFreq, lzfseEncodeLiteralStates)
+
+	type encoderEntry struct{ s0, k, delta0, delta1 int }
+	encoderTable := func(nstates int, freq []uint16) []encoderEntry {
+		table := make([]encoderEntry, len(freq))
+		offset := 0
+		for i, fr := range freq {
+			f := int(fr)
+			if f == 0 {
+				continue
+			}
+			k := bits.LeadingZeros32(uint32(f)) - bits.LeadingZeros32(uint32(nstates))
+			e := encoderEntry{s0: (f << k) - nstates, k: k, delta0: offset - f + (nstates >> k)}
+			if k > 0 {
+				e.delta1 = offset - f + (nstates >> (k - 1))
+			}
+			table[i] = e
+			offset += f
+		}
+		return table
+	}
+
+	type outStream struct {
+		buf   []byte
+		accum uint64
+		nbits int
+	}
+	push := func(o *outStream, n int, b uint64) {
+		o.accum |= b << uint(o.nbits)
+		o.nbits += n
+	}
+	flush := func(o *outStream) {
+		for o.nbits >= 8 {
+			o.buf = append(o.buf, byte(o.accum))
+			o.accum >>= 8
+			o.nbits -= 8
+		}
+	}
+	finish := func(o *outStream) int {
+		n := (o.nbits + 7) >> 3
+		for i := 0; i < n; i++ {
+			o.buf = append(o.buf, byte(o.accum))
+			o.accum >>= 8
+		}
+		return o.nbits - 8*n
+	}
+	encode := func(o *outStream, table []encoderEntry, state *int, symbol int) {
+		e := table[symbol]
+		n, delta := e.k-1, e.delta1
+		if *state >= e.s0 {
+			n, delta = e.k, e.delta0
+		}
+		push(o, n, uint64(*state)&(1<<uint(n)-1))
+		*state = delta + (*state >> uint(n))
+	}
+
+	literalTable := encoderTable(lzfseEncodeLiteralStates, literalNorm)
+	literalOut := &outStream{buf: make([]byte, 8)}
+	var literalState [4]int
+	for i := len(literals) - 4; i >= 0; i -= 4 {
+		for j := 3; j >= 0; j-- {
+			encode(literalOut, literalTable, &literalState[j], int(literals[i+j]))
+		}
+		flush(literalOut)
+	}
+	literalBits := finish(literalOut)
+
+	lTable := encoderTable(lzfseEncodeLStates, lNorm)
+	mTable := encoderTable(lzfseEncodeMStates, mNorm)
+	dTable := encoderTable(lzfseEncodeDStates, dNorm)
+	lmdOut := &outStream{buf: make([]byte, 8)}
+	var lState, mState, dState int
+	for i := len(triples) - 1; i >= 0; i-- {
+		push(lmdOut, ds[i].n, ds[i].bits)
+		encode(lmdOut, dTable, &dState, ds[i].sym)
+		push(lmdOut, ms[i].n, ms[i].bits)
+		encode(lmdOut, mTable, &mState, ms[i].sym)
+		push(lmdOut, ls[i].n, ls[i].bits)
+		encode(lmdOut, lTable, &lState, ls[i].sym)
+		flush(lmdOut)
+	}
+	lmdBits := finish(lmdOut)
+
+	// frequency tables, variable length coded
+	freqOut := &outStream{}
+	for _, table := range [][]uint16{lNorm, mNorm, dNorm, literalNorm} {
+		for _, f := range table {
+			v := int(f)
+			switch {
+			case v >= 24:
+				push(freqOut, 14, uint64(v-24)<<4|0xf)
+			case v >= 8:
+				push(freqOut, 8, uint64(v-8)<<4|0x7)
+			default:
+				n, code := 32, 0
+				for b := 0; b < 32; b++ {
+					if lzfseFreqValueTable[b] == v && lzfseFreqNBitsTable[b] < n {
+						n, code = lzfseFreqNBitsTable[b], b&(1<<uint(lzfseFreqNBitsTable[b])-1)
+					}
+				}
+				push(freqOut, n, uint64(code))
+			}
+			flush(freqOut)
+		}
+	}
+	finish(freqOut)
+
+	headerSize := lzfseV2HeaderSize + len(freqOut.buf)
+	p0 := uint64(len(literals)) | uint64(len(literalOut.buf))<<20 |
+		uint64(len(triples))<<40 | uint64(literalBits+7)<<60
+	p1 := uint64(literalState[0]) | uint64(literalState[1])<<10 | uint64(literalState[2])<<20 |
+		uint64(literalState[3])<<30 | uint64(len(lmdOut.buf))<<40 | uint64(lmdBits+7)<<60
+	p2 := uint64(headerSize) | uint64(lState)<<32 | uint64(mState)<<42 | uint64(dState)<<52
+
+	return block(magicLZFSEV2, len(src), p0, p1, p2, freqOut.buf, literalOut.buf, lmdOut.buf)
+}
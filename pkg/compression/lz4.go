@@ -0,0 +1,65 @@
+package compression
+
+import "fmt"
+
+// decodeLZ4 decodes an LZ4 block (the raw block format, without frame)
+// producing nRaw bytes. Decoding stops as soon as nRaw bytes are written,
+// which skips the terminating sequence some encoders emit.
+func decodeLZ4(dst []byte, start int, src []byte, nRaw int) ([]byte, error) {
+	end := len(dst) + nRaw
+	readLength := func(i, n int) (int, int, error) {
+		if n != 15 {
+			return i, n, nil
+		}
+		for {
+			if i >= len(src) {
+				return 0, 0, fmt.Errorf("%w: lz4 truncated length", ErrCorrupt)
+			}
+			b := src[i]
+			i++
+			n += int(b)
+			if b != 255 {
+				return i, n, nil
+			}
+		}
+	}
+
+	var err error
+	for i := 0; len(dst) < end; {
+		if i >= len(src) {
+			return nil, fmt.Errorf("%w: lz4 block ends after %d of %d bytes", ErrCorrupt, nRaw-(end-len(dst)), nRaw)
+		}
+		token := src[i]
+		i++
+
+		var literalLen int
+		if i, literalLen, err = readLength(i, int(token>>4)); err != nil {
+			return nil, err
+		}
+		if len(src)-i < literalLen || end-len(dst) < literalLen {
+			return nil, fmt.Errorf("%w: lz4 literals out of range", ErrCorrupt)
+		}
+		dst = append(dst, src[i:i+literalLen]...)
+		i += literalLen
+		if len(dst) == end {
+			break
+		}
+
+		if len(src)-i < 2 {
+			return nil, fmt.Errorf("%w: lz4 truncated offset", ErrCorrupt)
+		}
+		distance := int(src[i]) | int(src[i+1])<<8
+		i += 2
+
+		var matchLen int
+		if i, matchLen, err = readLength(i, int(token&15)); err != nil {
+			return nil, err
+		}
+		matchLen += 4
+		if distance == 0 || distance > len(dst)-start || end-len(dst) < matchLen {
+			return nil, fmt.Errorf("%w: lz4 match out of range", ErrCorrupt)
+		}
+		dst = appendMatch(dst, distance, matchLen)
+	}
+	return dst, nil
+}
@@ -0,0 +1,487 @@
+package compression
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+)
+
+const (
+	lzfseEncodeLSymbols       = 20
+	lzfseEncodeMSymbols       = 20
+	lzfseEncodeDSymbols       = 64
+	lzfseEncodeLiteralSymbols = 256
+	lzfseEncodeLStates        = 64
+	lzfseEncodeMStates        = 64
+	lzfseEncodeDStates        = 256
+	lzfseEncodeLiteralStates  = 1024
+
+	lzfseLiteralsPerBlock = 4 * 10000
+	lzfseMatchesPerBlock  = 10000
+
+	// magic, n_raw_bytes and the three packed fields
+	lzfseV2HeaderSize = 32
+	// magic, seven uint32 counters, literal_bits, literal_state[4], lmd_bits,
+	// l/m/d_state and the frequency tables as uint16
+	lzfseV1HeaderSize = 4 + 4*7 + 4 + 2*4 + 4 + 2*3 + 2*(lzfseEncodeLSymbols+lzfseEncodeMSymbols+lzfseEncodeDSymbols+lzfseEncodeLiteralSymbols)
+)
+
+var (
+	lzfseLExtraBits = [lzfseEncodeLSymbols]uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2, 3, 5, 8,
+	}
+	lzfseLBaseValue = [lzfseEncodeLSymbols]int32{
+		0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 20, 28, 60,
+	}
+	lzfseMExtraBits = [lzfseEncodeMSymbols]uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 3, 5, 8, 11,
+	}
+	lzfseMBaseValue = [lzfseEncodeMSymbols]int32{
+		0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 24, 56, 312,
+	}
+	lzfseDExtraBits = [lzfseEncodeDSymbols]uint8{
+		0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2, 3, 3, 3, 3,
+		4, 4, 4, 4, 5, 5, 5, 5, 6, 6, 6, 6, 7, 7, 7, 7,
+		8, 8, 8, 8, 9, 9, 9, 9, 10, 10, 10, 10, 11, 11, 11, 11,
+		12, 12, 12, 12, 13, 13, 13, 13, 14, 14, 14, 14, 15, 15, 15, 15,
+	}
+	lzfseDBaseValue = [lzfseEncodeDSymbols]int32{
+		0, 1, 2, 3, 4, 6, 8, 10, 12, 16, 20, 24, 28, 36, 44, 52,
+		60, 76, 92, 108, 124, 156, 188, 220, 252, 316, 380, 444, 508, 636, 764, 892,
+		1020, 1276, 1532, 1788, 2044, 2556, 3068, 3580, 4092, 5116, 6140, 7164, 8188, 10236, 12284, 14332,
+		16380, 20476, 24572, 28668, 32764, 40956, 49148, 57340, 65532, 81916, 98300, 114684, 131068, 163836, 196604, 229372,
+	}
+)
+
+// lzfseBlockHeader the decoded header of a "bvx1" or "bvx2" block
+type lzfseBlockHeader struct {
+	nRaw                 int
+	nLiterals            int
+	nMatches             int
+	nLiteralPayloadBytes int
+	nLmdPayloadBytes     int
+	literalBits          int
+	literalState         [4]int
+	lmdBits              int
+	lState               int
+	mState               int
+	dState               int
+	lFreq                [lzfseEncodeLSymbols]uint16
+	mFreq                [lzfseEncodeMSymbols]uint16
+	dFreq                [lzfseEncodeDSymbols]uint16
+	literalFreq          [lzfseEncodeLiteralSymbols]uint16
+}
+
+func (h *lzfseBlockHeader) setFreqs(freqs []uint16) {
+	n := copy(h.lFreq[:], freqs)
+	n += copy(h.mFreq[:], freqs[n:])
+	n += copy(h.dFreq[:], freqs[n:])
+	copy(h.literalFreq[:], freqs[n:])
+}
+
+func (h *lzfseBlockHeader) check() error {
+	switch {
+	case h.nLiterals > lzfseLiteralsPerBlock,
+		h.nMatches > lzfseMatchesPerBlock,
+		h.literalBits < -7 || h.literalBits > 0,
+		h.lmdBits < -7 || h.lmdBits > 0,
+		h.lState >= lzfseEncodeLStates,
+		h.mState >= lzfseEncodeMStates,
+		h.dState >= lzfseEncodeDStates:
+		return fmt.Errorf("%w: lzfse invalid block header", ErrCorrupt)
+	}
+	for _, s := range h.literalState {
+		if s >= lzfseEncodeLiteralStates {
+			return fmt.Errorf("%w: lzfse invalid block header", ErrCorrupt)
+		}
+	}
+	return nil
+}
+
+// decodeLZFSEV1Header reads the legacy header, which stores every field
+// (and the frequency tables) uncompressed.
+func decodeLZFSEV1Header(src []byte) (h *lzfseBlockHeader, headerSize int, err error) {
+	if len(src) < lzfseV1HeaderSize {
+		return nil, 0, fmt.Errorf("%w: truncated %q header", ErrCorrupt, magicLZFSEV1)
+	}
+	u32 := func(off int) int { return int(binary.LittleEndian.Uint32(src[off:])) }
+	u16 := func(off int) int { return int(binary.LittleEndian.Uint16(src[off:])) }
+
+	h = &lzfseBlockHeader{
+		nRaw:                 u32(4),
+		nLiterals:            u32(12),
+		nMatches:             u32(16),
+		nLiteralPayloadBytes: u32(20),
+		nLmdPayloadBytes:     u32(24),
+		literalBits:          int(int32(u32(28))),
+		lmdBits:              int(int32(u32(40))),
+		lState:               u16(44),
+		mState:               u16(46),
+		dState:               u16(48),
+	}
+	for i := range h.literalState {
+		h.literalState[i] = u16(32 + 2*i)
+	}
+	freqs := make([]uint16, lzfseEncodeLSymbols+lzfseEncodeMSymbols+lzfseEncodeDSymbols+lzfseEncodeLiteralSymbols)
+	for i := range freqs {
+		freqs[i] = uint16(u16(50 + 2*i))
+	}
+	h.setFreqs(freqs)
+
+	if nPayload := u32(8); nPayload != h.nLiteralPayloadBytes+h.nLmdPayloadBytes {
+		return nil, 0, fmt.Errorf("%w: lzfse invalid block header", ErrCorrupt)
+	}
+	return h, lzfseV1HeaderSize, nil
+}
+
+// decodeLZFSEV2Header reads the compact header: bit packed counters and
+// states followed by variable length coded frequency tables.
+func decodeLZFSEV2Header(src []byte) (h *lzfseBlockHeader, headerSize int, err error) {
+	if len(src) < lzfseV2HeaderSize {
+		return nil, 0, fmt.Errorf("%w: truncated %q header", ErrCorrupt, magicLZFSEV2)
+	}
+	field := func(v uint64, offset, nbits uint) int {
+		return int((v >> offset) & (1<<nbits - 1))
+	}
+	p0 := binary.LittleEndian.Uint64(src[8:])
+	p1 := binary.LittleEndian.Uint64(src[16:])
+	p2 := binary.LittleEndian.Uint64(src[24:])
+
+	h = &lzfseBlockHeader{
+		nRaw:                 int(binary.LittleEndian.Uint32(src[4:])),
+		nLiterals:            field(p0, 0, 20),
+		nLiteralPayloadBytes: field(p0, 20, 20),
+		nMatches:             field(p0, 40, 20),
+		literalBits:          field(p0, 60, 3) - 7,
+		nLmdPayloadBytes:     field(p1, 40, 20),
+		lmdBits:              field(p1, 60, 3) - 7,
+		lState:               field(p2, 32, 10),
+		mState:               field(p2, 42, 10),
+		dState:               field(p2, 52, 10),
+	}
+	for i := range h.literalState {
+		h.literalState[i] = field(p1, uint(10*i), 10)
+	}
+
+	headerSize = field(p2, 0, 32)
+	if headerSize < lzfseV2HeaderSize || headerSize > len(src) {
+		return nil, 0, fmt.Errorf("%w: lzfse invalid block header", ErrCorrupt)
+	}
+
+	freqs := make([]uint16, lzfseEncodeLSymbols+lzfseEncodeMSymbols+lzfseEncodeDSymbols+lzfseEncodeLiteralSymbols)
+	table := src[lzfseV2HeaderSize:headerSize]
+	var accum uint32
+	accumBits := 0
+	for i := range freqs {
+		for len(table) > 0 && accumBits+8 <= 32 {
+			accum |= uint32(table[0]) << accumBits
+			accumBits += 8
+			table = table[1:]
+		}
+		v, nbits := decodeLZFSEFreqValue(accum)
+		if nbits > accumBits {
+			return nil, 0, fmt.Errorf("%w: lzfse truncated frequency table", ErrCorrupt)
+		}
+		freqs[i] = uint16(v)
+		accum >>= nbits
+		accumBits -= nbits
+	}
+	if accumBits >= 8 || len(table) != 0 {
+		return nil, 0, fmt.Errorf("%w: lzfse invalid frequency table", ErrCorrupt)
+	}
+	h.setFreqs(freqs)
+	return h, headerSize, nil
+}
+
+var (
+	lzfseFreqNBitsTable = [32]int{
+		2, 3, 2, 5, 2, 3, 2, 8, 2, 3, 2, 5, 2, 3, 2, 14,
+		2, 3, 2, 5, 2, 3, 2, 8, 2, 3, 2, 5, 2, 3, 2, 14,
+	}
+	lzfseFreqValueTable = [32]int{
+		0, 2, 1, 4, 0, 3, 1, -1, 0, 2, 1, 5, 0, 3, 1, -1,
+		0, 2, 1, 6, 0, 3, 1, -1, 0, 2, 1, 7, 0, 3, 1, -1,
+	}
+)
+
+// decodeLZFSEFreqValue decodes one frequency from the low bits of bits
+func decodeLZFSEFreqValue(bits uint32) (value, nbits int) {
+	b := bits & 31
+	nbits = lzfseFreqNBitsTable[b]
+	switch nbits {
+	case 8:
+		return 8 + int((bits>>4)&0xf), nbits
+	case 14:
+		return 24 + int((bits>>4)&0x3ff), nbits
+	}
+	return lzfseFreqValueTable[b], nbits
+}
+
+// decodeLZFSE decodes the "bvx1" or "bvx2" block at the start of src,
+// returning the number of bytes of src it spans.
+func decodeLZFSE(dst []byte, start int, src []byte) (_ []byte, n int, err error) {
+	var (
+		h          *lzfseBlockHeader
+		headerSize int
+	)
+	if string(src[:4]) == magicLZFSEV1 {
+		h, headerSize, err = decodeLZFSEV1Header(src)
+	} else {
+		h, headerSize, err = decodeLZFSEV2Header(src)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if err = h.check(); err != nil {
+		return nil, 0, err
+	}
+
+	n = headerSize + h.nLiteralPayloadBytes + h.nLmdPayloadBytes
+	if len(src) < n {
+		return nil, 0, fmt.Errorf("%w: truncated lzfse block", ErrCorrupt)
+	}
+	literalPayload := src[headerSize : headerSize+h.nLiteralPayloadBytes]
+	lmdPayload := src[headerSize+h.nLiteralPayloadBytes : n]
+
+	literalTable := make([]fseDecoderEntry, lzfseEncodeLiteralStates)
+	if err = fseInitDecoderTable(lzfseEncodeLiteralStates, h.literalFreq[:], literalTable); err != nil {
+		return nil, 0, err
+	}
+	lTable := make([]fseValueDecoderEntry, lzfseEncodeLStates)
+	if err = fseInitValueDecoderTable(lzfseEncodeLStates, h.lFreq[:], lzfseLExtraBits[:], lzfseLBaseValue[:], lTable); err != nil {
+		return nil, 0, err
+	}
+	mTable := make([]fseValueDecoderEntry, lzfseEncodeMStates)
+	if err = fseInitValueDecoderTable(lzfseEncodeMStates, h.mFreq[:], lzfseMExtraBits[:], lzfseMBaseValue[:], mTable); err != nil {
+		return nil, 0, err
+	}
+	dTable := make([]fseValueDecoderEntry, lzfseEncodeDStates)
+	if err = fseInitValueDecoderTable(lzfseEncodeDStates, h.dFreq[:], lzfseDExtraBits[:], lzfseDBaseValue[:], dTable); err != nil {
+		return nil, 0, err
+	}
+
+	// literals, four interleaved FSE streams
+	literals := make([]byte, (h.nLiterals+3)&^3)
+	in := new(fseInStream)
+	if err = in.init(h.literalBits, literalPayload); err != nil {
+		return nil, 0, err
+	}
+	state := h.literalState
+	for i := 0; i < h.nLiterals; i += 4 {
+		if err = in.flush(); err != nil {
+			return nil, 0, err
+		}
+		for j := range state {
+			if literals[i+j], state[j], err = in.decode(literalTable, state[j]); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+
+	// (L, M, D) triples, copying literals then a match
+	end := len(dst) + h.nRaw
+	in = new(fseInStream)
+	if err = in.init(h.lmdBits, lmdPayload); err != nil {
+		return nil, 0, err
+	}
+	lState, mState, dState := h.lState, h.mState, h.dState
+	literalPos, distance := 0, 0
+	for i := 0; i < h.nMatches; i++ {
+		if err = in.flush(); err != nil {
+			return nil, 0, err
+		}
+		var l, m, d int
+		if l, lState, err = in.decodeValue(lTable, lState); err != nil {
+			return nil, 0, err
+		}
+		if m, mState, err = in.decodeValue(mTable, mState); err != nil {
+			return nil, 0, err
+		}
+		if d, dState, err = in.decodeValue(dTable, dState); err != nil {
+			return nil, 0, err
+		}
+		if d != 0 {
+			distance = d
+		}
+
+		if h.nLiterals-literalPos < l || end-len(dst) < l {
+			return nil, 0, fmt.Errorf("%w: lzfse literals out of range", ErrCorrupt)
+		}
+		dst = append(dst, literals[literalPos:literalPos+l]...)
+		literalPos += l
+
+		if m == 0 {
+			continue
+		}
+		if distance == 0 || distance > len(dst)-start || end-len(dst) < m {
+			return nil, 0, fmt.Errorf("%w: lzfse match out of range", ErrCorrupt)
+		}
+		dst = appendMatch(dst, distance, m)
+	}
+
+	if len(dst) != end {
+		return nil, 0, fmt.Errorf("%w: lzfse block decodes to %d of %d bytes", ErrCorrupt, h.nRaw-(end-len(dst)), h.nRaw)
+	}
+	return dst, n, nil
+}
+
+type fseDecoderEntry struct {
+	k      int
+	symbol uint8
+	delta  int
+}
+
+type fseValueDecoderEntry struct {
+	totalBits int
+	valueBits int
+	delta     int
+	vbase     int
+}
+
+// fseInitDecoderTable lays out the nstates decoder states, freq[i]
+// consecutive states for each symbol i.
+func fseInitDecoderTable(nstates int, freq []uint16, t []fseDecoderEntry) error {
+	nClz := bits.LeadingZeros32(uint32(nstates))
+	sum, pos := 0, 0
+	for i, fr := range freq {
+		f := int(fr)
+		if f == 0 {
+			continue
+		}
+		if sum += f; sum > nstates {
+			return fmt.Errorf("%w: lzfse invalid frequency table", ErrCorrupt)
+		}
+		k := bits.LeadingZeros32(uint32(f)) - nClz
+		j0 := ((2 * nstates) >> k) - f
+		for j := 0; j < f; j++ {
+			e := fseDecoderEntry{symbol: uint8(i)}
+			if j < j0 {
+				e.k = k
+				e.delta = ((f + j) << k) - nstates
+			} else {
+				e.k = k - 1
+				e.delta = (j - j0) << (k - 1)
+			}
+			t[pos] = e
+			pos++
+		}
+	}
+	return nil
+}
+
+func fseInitValueDecoderTable(nstates int, freq []uint16, valueBits []uint8, valueBase []int32, t []fseValueDecoderEntry) error {
+	nClz := bits.LeadingZeros32(uint32(nstates))
+	sum, pos := 0, 0
+	for i, fr := range freq {
+		f := int(fr)
+		if f == 0 {
+			continue
+		}
+		if sum += f; sum > nstates {
+			return fmt.Errorf("%w: lzfse invalid frequency table", ErrCorrupt)
+		}
+		k := bits.LeadingZeros32(uint32(f)) - nClz
+		j0 := ((2 * nstates) >> k) - f
+		for j := 0; j < f; j++ {
+			e := fseValueDecoderEntry{valueBits: int(valueBits[i]), vbase: int(valueBase[i])}
+			if j < j0 {
+				e.totalBits = k + e.valueBits
+				e.delta = ((f + j) << k) - nstates
+			} else {
+				e.totalBits = k - 1 + e.valueBits
+				e.delta = (j - j0) << (k - 1)
+			}
+			t[pos] = e
+			pos++
+		}
+	}
+	return nil
+}
+
+// fseInStream reads an FSE bit stream backwards from the end of src, the
+// encoder wrote the last symbol first.
+type fseInStream struct {
+	accum     uint64
+	accumBits int
+	src       []byte
+	pos       int
+}
+
+// init nbits (-7..0) is the number of bits missing from the last byte
+func (s *fseInStream) init(nbits int, src []byte) error {
+	s.src, s.pos = src, len(src)
+	if nbits != 0 {
+		if s.pos < 8 {
+			return fmt.Errorf("%w: lzfse truncated stream", ErrCorrupt)
+		}
+		s.pos -= 8
+		s.accum = binary.LittleEndian.Uint64(src[s.pos:])
+		s.accumBits = nbits + 64
+	} else {
+		if s.pos < 7 {
+			return fmt.Errorf("%w: lzfse truncated stream", ErrCorrupt)
+		}
+		s.pos -= 7
+		var buf [8]byte
+		copy(buf[:], src[s.pos:s.pos+7])
+		s.accum = binary.LittleEndian.Uint64(buf[:])
+		s.accumBits = 56
+	}
+	if s.accumBits < 56 || s.accumBits >= 64 || s.accum>>uint(s.accumBits) != 0 {
+		return fmt.Errorf("%w: lzfse invalid stream", ErrCorrupt)
+	}
+	return nil
+}
+
+// flush refills the accumulator to at least 56 bits
+func (s *fseInStream) flush() error {
+	nbits := (63 - s.accumBits) &^ 7
+	if nbits == 0 {
+		return nil
+	}
+	nbytes := nbits >> 3
+	if s.pos < nbytes {
+		return fmt.Errorf("%w: lzfse truncated stream", ErrCorrupt)
+	}
+	s.pos -= nbytes
+	incoming := binary.LittleEndian.Uint64(s.src[s.pos:])
+	s.accum = s.accum<<uint(nbits) | incoming&(1<<uint(nbits)-1)
+	s.accumBits += nbits
+	return nil
+}
+
+func (s *fseInStream) pull(nbits int) (uint64, error) {
+	if nbits > s.accumBits {
+		return 0, fmt.Errorf("%w: lzfse truncated stream", ErrCorrupt)
+	}
+	s.accumBits -= nbits
+	result := s.accum >> uint(s.accumBits)
+	s.accum &= 1<<uint(s.accumBits) - 1
+	return result, nil
+}
+
+func (s *fseInStream) decode(t []fseDecoderEntry, state int) (symbol uint8, next int, err error) {
+	e := t[state]
+	var b uint64
+	if b, err = s.pull(e.k); err != nil {
+		return 0, 0, err
+	}
+	next = e.delta + int(b)
+	if next < 0 || next >= len(t) {
+		return 0, 0, fmt.Errorf("%w: lzfse invalid state", ErrCorrupt)
+	}
+	return e.symbol, next, nil
+}
+
+func (s *fseInStream) decodeValue(t []fseValueDecoderEntry, state int) (value, next int, err error) {
+	e := t[state]
+	var b uint64
+	if b, err = s.pull(e.totalBits); err != nil {
+		return 0, 0, err
+	}
+	next = e.delta + int(b>>uint(e.valueBits))
+	if next < 0 || next >= len(t) {
+		return 0, 0, fmt.Errorf("%w: lzfse invalid state", ErrCorrupt)
+	}
+	return e.vbase + int(b&(1<<uint(e.valueBits)-1)), next, nil
+}
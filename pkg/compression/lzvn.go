@@ -0,0 +1,106 @@
+package compression
+
+import "fmt"
+
+// decodeLZVN decodes an LZVN block producing nRaw bytes.
+//
+// Each instruction copies L literal bytes following its opcode, then M bytes
+// from D bytes back ("pre_d" instructions reuse the previous D):
+//
+//	sml_d  LLMMMDDD DDDDDDDD            lrg_d  LLMMM111 DDDDDDDD DDDDDDDD
+//	med_d  101LLMMM DDDDDDMM DDDDDDDD   pre_d  LLMMM110
+//	sml_m  1111MMMM                     lrg_m  11110000 MMMMMMMM
+//	sml_l  1110LLLL                     lrg_l  11100000 LLLLLLLL
+//	eos    00000110 (+ 7 zero bytes)    nop    00001110, 00010110
+func decodeLZVN(dst []byte, start int, src []byte, nRaw int) ([]byte, error) {
+	end := len(dst) + nRaw
+	distance := 0
+	for i := 0; ; {
+		if i >= len(src) {
+			return nil, fmt.Errorf("%w: lzvn missing end of stream", ErrCorrupt)
+		}
+		opc := src[i]
+		need := func(n int) bool { return len(src)-i >= n }
+
+		var literalLen, matchLen, opcLen int
+		switch {
+		case opc == 0x06: // eos
+			if len(dst) != end {
+				return nil, fmt.Errorf("%w: lzvn block ends after %d of %d bytes", ErrCorrupt, nRaw-(end-len(dst)), nRaw)
+			}
+			return dst, nil
+
+		case opc == 0x0e || opc == 0x16: // nop
+			i++
+			continue
+
+		case opc >= 0x70 && opc < 0x80, opc < 0x40 && opc&7 == 6: // udef
+			return nil, fmt.Errorf("%w: lzvn undefined opcode 0x%02x", ErrCorrupt, opc)
+
+		case opc == 0xe0: // lrg_l
+			if !need(2) {
+				return nil, fmt.Errorf("%w: lzvn truncated opcode", ErrCorrupt)
+			}
+			opcLen, literalLen = 2, int(src[i+1])+16
+
+		case opc&0xf0 == 0xe0: // sml_l
+			opcLen, literalLen = 1, int(opc&0x0f)
+
+		case opc == 0xf0: // lrg_m
+			if !need(2) {
+				return nil, fmt.Errorf("%w: lzvn truncated opcode", ErrCorrupt)
+			}
+			opcLen, matchLen = 2, int(src[i+1])+16
+
+		case opc&0xf0 == 0xf0: // sml_m
+			opcLen, matchLen = 1, int(opc&0x0f)
+
+		case opc&0xe0 == 0xa0: // med_d
+			if !need(3) {
+				return nil, fmt.Errorf("%w: lzvn truncated opcode", ErrCorrupt)
+			}
+			opcLen = 3
+			literalLen = int(opc>>3) & 3
+			matchLen = (int(opc&7)<<2 | int(src[i+1]&3)) + 3
+			distance = int(src[i+1]>>2) | int(src[i+2])<<6
+
+		case opc&7 == 7: // lrg_d
+			if !need(3) {
+				return nil, fmt.Errorf("%w: lzvn truncated opcode", ErrCorrupt)
+			}
+			opcLen = 3
+			literalLen = int(opc >> 6)
+			matchLen = int(opc>>3)&7 + 3
+			distance = int(src[i+1]) | int(src[i+2])<<8
+
+		case opc&7 == 6: // pre_d
+			opcLen = 1
+			literalLen = int(opc >> 6)
+			matchLen = int(opc>>3)&7 + 3
+
+		default: // sml_d
+			if !need(2) {
+				return nil, fmt.Errorf("%w: lzvn truncated opcode", ErrCorrupt)
+			}
+			opcLen = 2
+			literalLen = int(opc >> 6)
+			matchLen = int(opc>>3)&7 + 3
+			distance = int(opc&7)<<8 | int(src[i+1])
+		}
+		i += opcLen
+
+		if len(src)-i < literalLen || end-len(dst) < literalLen {
+			return nil, fmt.Errorf("%w: lzvn literals out of range", ErrCorrupt)
+		}
+		dst = append(dst, src[i:i+literalLen]...)
+		i += literalLen
+
+		if matchLen == 0 {
+			continue
+		}
+		if distance == 0 || distance > len(dst)-start || end-len(dst) < matchLen {
+			return nil, fmt.Errorf("%w: lzvn match out of range", ErrCorrupt)
+		}
+		dst = appendMatch(dst, distance, matchLen)
+	}
+}
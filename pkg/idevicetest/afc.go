@@ -0,0 +1,409 @@
+package idevicetest
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/libimobiledevice"
+)
+
+var afcMagic = []byte("CFA6LPAA")
+
+// AfcHandler serves `com.apple.afc` (and the AFC part of house_arrest) from
+// the host directory root.
+func AfcHandler(root string) ServiceHandler {
+	return AfcHandlerWithout(root)
+}
+
+// AfcHandlerWithout is AfcHandler answering UnknownPacketType to the operations ops,
+// as a device predating them does.
+func AfcHandlerWithout(root string, ops ...uint64) ServiceHandler {
+	unsupported := make(map[uint64]bool, len(ops))
+	for _, op := range ops {
+		unsupported[op] = true
+	}
+	return func(conn net.Conn) {
+		s := &afcSession{root: root, conn: conn, files: make(map[uint64]*os.File), unsupported: unsupported}
+		defer s.closeAll()
+		for {
+			op, data, payload, err := s.receive()
+			if err != nil {
+				return
+			}
+			if err = s.handle(op, data, payload); err != nil {
+				return
+			}
+		}
+	}
+}
+
+type afcSession struct {
+	root        string
+	conn        net.Conn
+	unsupported map[uint64]bool
+
+	mu        sync.Mutex
+	packetNum uint64
+	nextFd    uint64
+	files     map[uint64]*os.File
+}
+
+func (s *afcSession) receive() (op uint64, data, payload []byte, err error) {
+	header := make([]byte, 40)
+	if _, err = io.ReadFull(s.conn, header); err != nil {
+		return 0, nil, nil, err
+	}
+	if !bytes.Equal(header[:8], afcMagic) {
+		return 0, nil, nil, errors.New("afc: bad magic")
+	}
+	entireLen := binary.LittleEndian.Uint64(header[8:])
+	thisLen := binary.LittleEndian.Uint64(header[16:])
+	op = binary.LittleEndian.Uint64(header[32:])
+	if thisLen < 40 || entireLen < thisLen {
+		return 0, nil, nil, errors.New("afc: bad length")
+	}
+
+	rest := make([]byte, entireLen-40)
+	if _, err = io.ReadFull(s.conn, rest); err != nil {
+		return 0, nil, nil, err
+	}
+	return op, rest[:thisLen-40], rest[thisLen-40:], nil
+}
+
+func (s *afcSession) send(op uint64, data, payload []byte) (err error) {
+	s.packetNum++
+	buf := new(bytes.Buffer)
+	buf.Write(afcMagic)
+	_ = binary.Write(buf, binary.LittleEndian, uint64(40+len(data)+len(payload)))
+	_ = binary.Write(buf, binary.LittleEndian, uint64(40+len(data)))
+	_ = binary.Write(buf, binary.LittleEndian, s.packetNum)
+	_ = binary.Write(buf, binary.LittleEndian, op)
+	buf.Write(data)
+	buf.Write(payload)
+	_, err = s.conn.Write(buf.Bytes())
+	return
+}
+
+func (s *afcSession) status(code uint64) error {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, code)
+	return s.send(libimobiledevice.AfcOperationStatus, b, nil)
+}
+
+func (s *afcSession) fail(err error) error {
+	return s.status(afcStatus(err))
+}
+
+func (s *afcSession) uint64Result(op, v uint64) error {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, v)
+	return s.send(op, b, nil)
+}
+
+// hostPath maps a device path onto root, never escaping it.
+func (s *afcSession) hostPath(name string) string {
+	return filepath.Join(s.root, filepath.FromSlash(path.Clean("/"+name)))
+}
+
+func (s *afcSession) handle(op uint64, data, payload []byte) error {
+	if s.unsupported[op] {
+		return s.status(libimobiledevice.AfcErrUnknownPacketType)
+	}
+	switch op {
+	case libimobiledevice.AfcOperationGetDeviceInfo:
+		return s.send(libimobiledevice.AfcOperationData, nil, cStrings(
+			"Model", "iPhone12,1",
+			"FSTotalBytes", "64000000000",
+			"FSFreeBytes", "32000000000",
+			"FSBlockSize", "4096",
+		))
+
+	case libimobiledevice.AfcOperationReadDir:
+		entries, err := os.ReadDir(s.hostPath(cString(data)))
+		if err != nil {
+			return s.fail(err)
+		}
+		names := []string{".", ".."}
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		return s.send(libimobiledevice.AfcOperationData, nil, cStrings(names...))
+
+	case libimobiledevice.AfcOperationGetFileInfo:
+		info, err := os.Lstat(s.hostPath(cString(data)))
+		if err != nil {
+			return s.fail(err)
+		}
+		return s.send(libimobiledevice.AfcOperationData, nil, cStrings(s.fileInfo(cString(data), info)...))
+
+	case libimobiledevice.AfcOperationMakeDir:
+		return s.fail(os.MkdirAll(s.hostPath(cString(data)), 0755))
+
+	case libimobiledevice.AfcOperationRemovePath:
+		name := s.hostPath(cString(data))
+		if name == filepath.Clean(s.root) {
+			return s.status(libimobiledevice.AfcErrPermDenied)
+		}
+		return s.fail(os.Remove(name))
+
+	case libimobiledevice.AfcOperationRemovePathAndContents:
+		name := s.hostPath(cString(data))
+		if name == filepath.Clean(s.root) {
+			return s.status(libimobiledevice.AfcErrPermDenied)
+		}
+		if _, err := os.Lstat(name); err != nil {
+			return s.fail(err)
+		}
+		return s.fail(os.RemoveAll(name))
+
+	case libimobiledevice.AfcOperationRenamePath:
+		names := splitCStrings(data)
+		if len(names) != 2 {
+			return s.status(libimobiledevice.AfcErrInvalidArgument)
+		}
+		return s.fail(os.Rename(s.hostPath(names[0]), s.hostPath(names[1])))
+
+	case libimobiledevice.AfcOperationMakeLink:
+		if len(data) < 8 {
+			return s.status(libimobiledevice.AfcErrInvalidArgument)
+		}
+		names := splitCStrings(data[8:])
+		if len(names) != 2 {
+			return s.status(libimobiledevice.AfcErrInvalidArgument)
+		}
+		if binary.LittleEndian.Uint64(data) == 2 {
+			return s.fail(os.Symlink(names[0], s.hostPath(names[1])))
+		}
+		return s.fail(os.Link(s.hostPath(names[0]), s.hostPath(names[1])))
+
+	case libimobiledevice.AfcOperationTruncateFile:
+		if len(data) < 8 {
+			return s.status(libimobiledevice.AfcErrInvalidArgument)
+		}
+		return s.fail(os.Truncate(s.hostPath(cString(data[8:])), int64(binary.LittleEndian.Uint64(data))))
+
+	case libimobiledevice.AfcOperationSetFileModTime:
+		if len(data) < 8 {
+			return s.status(libimobiledevice.AfcErrInvalidArgument)
+		}
+		mtime := time.Unix(0, int64(binary.LittleEndian.Uint64(data)))
+		return s.fail(os.Chtimes(s.hostPath(cString(data[8:])), mtime, mtime))
+
+	case libimobiledevice.AfcOperationGetFileHash:
+		return s.hash(cString(data), 0, 0, false)
+
+	case libimobiledevice.AfcOperationGetFileHashRange:
+		if len(data) < 16 {
+			return s.status(libimobiledevice.AfcErrInvalidArgument)
+		}
+		return s.hash(cString(data[16:]), binary.LittleEndian.Uint64(data), binary.LittleEndian.Uint64(data[8:]), true)
+
+	case libimobiledevice.AfcOperationFileOpen:
+		if len(data) < 8 {
+			return s.status(libimobiledevice.AfcErrInvalidArgument)
+		}
+		flag, ok := afcOpenFlags[binary.LittleEndian.Uint64(data)]
+		if !ok {
+			return s.status(libimobiledevice.AfcErrInvalidArgument)
+		}
+		f, err := os.OpenFile(s.hostPath(cString(data[8:])), flag, 0644)
+		if err != nil {
+			return s.fail(err)
+		}
+		s.mu.Lock()
+		s.nextFd++
+		fd := s.nextFd
+		s.files[fd] = f
+		s.mu.Unlock()
+		return s.uint64Result(libimobiledevice.AfcOperationFileOpenResult, fd)
+	}
+
+	return s.handleFile(op, data, payload)
+}
+
+func (s *afcSession) handleFile(op uint64, data, payload []byte) error {
+	if len(data) < 8 {
+		return s.status(libimobiledevice.AfcErrOperationNotSupported)
+	}
+	fd := binary.LittleEndian.Uint64(data)
+	args := data[8:]
+
+	s.mu.Lock()
+	f, ok := s.files[fd]
+	s.mu.Unlock()
+	if !ok {
+		return s.status(libimobiledevice.AfcErrInvalidArgument)
+	}
+
+	switch op {
+	case libimobiledevice.AfcOperationFileRead:
+		if len(args) < 8 {
+			return s.status(libimobiledevice.AfcErrInvalidArgument)
+		}
+		buf := make([]byte, binary.LittleEndian.Uint64(args))
+		n, err := io.ReadFull(f, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return s.fail(err)
+		}
+		return s.send(libimobiledevice.AfcOperationData, nil, buf[:n])
+
+	case libimobiledevice.AfcOperationFileWrite:
+		if _, err := f.Write(payload); err != nil {
+			return s.fail(err)
+		}
+		return s.status(libimobiledevice.AfcErrSuccess)
+
+	case libimobiledevice.AfcOperationFileSeek:
+		if len(args) < 16 {
+			return s.status(libimobiledevice.AfcErrInvalidArgument)
+		}
+		whence := int(binary.LittleEndian.Uint64(args))
+		offset := int64(binary.LittleEndian.Uint64(args[8:]))
+		if _, err := f.Seek(offset, whence); err != nil {
+			return s.fail(err)
+		}
+		return s.status(libimobiledevice.AfcErrSuccess)
+
+	case libimobiledevice.AfcOperationFileTell:
+		pos, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return s.fail(err)
+		}
+		return s.uint64Result(libimobiledevice.AfcOperationFileTellResult, uint64(pos))
+
+	case libimobiledevice.AfcOperationFileSetSize:
+		if len(args) < 8 {
+			return s.status(libimobiledevice.AfcErrInvalidArgument)
+		}
+		return s.fail(f.Truncate(int64(binary.LittleEndian.Uint64(args))))
+
+	case libimobiledevice.AfcOperationFileRefLock:
+		return s.status(libimobiledevice.AfcErrSuccess)
+
+	case libimobiledevice.AfcOperationFileClose:
+		s.mu.Lock()
+		delete(s.files, fd)
+		s.mu.Unlock()
+		return s.fail(f.Close())
+	}
+
+	return s.status(libimobiledevice.AfcErrOperationNotSupported)
+}
+
+func (s *afcSession) hash(name string, start, end uint64, ranged bool) error {
+	f, err := os.Open(s.hostPath(name))
+	if err != nil {
+		return s.fail(err)
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if ranged {
+		r = io.NewSectionReader(f, int64(start), int64(end-start))
+	}
+	h := sha1.New()
+	if _, err = io.Copy(h, r); err != nil {
+		return s.fail(err)
+	}
+	return s.send(libimobiledevice.AfcOperationData, nil, h.Sum(nil))
+}
+
+func (s *afcSession) fileInfo(name string, info os.FileInfo) []string {
+	ifmt := "S_IFREG"
+	switch {
+	case info.IsDir():
+		ifmt = "S_IFDIR"
+	case info.Mode()&os.ModeSymlink != 0:
+		ifmt = "S_IFLNK"
+	}
+	mtime := strconv.FormatInt(info.ModTime().UnixNano(), 10)
+	kv := []string{
+		"st_size", strconv.FormatInt(info.Size(), 10),
+		"st_blocks", strconv.FormatInt((info.Size()+511)/512, 10),
+		"st_nlink", "1",
+		"st_ifmt", ifmt,
+		"st_mtime", mtime,
+		"st_birthtime", mtime,
+	}
+	if ifmt == "S_IFLNK" {
+		if target, err := os.Readlink(s.hostPath(name)); err == nil {
+			kv = append(kv, "st_link_target", target)
+		}
+	}
+	return kv
+}
+
+func (s *afcSession) closeAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for fd, f := range s.files {
+		_ = f.Close()
+		delete(s.files, fd)
+	}
+}
+
+var afcOpenFlags = map[uint64]int{
+	1: os.O_RDONLY,
+	2: os.O_RDWR,
+	3: os.O_WRONLY | os.O_CREATE | os.O_TRUNC,
+	4: os.O_RDWR | os.O_CREATE | os.O_TRUNC,
+	5: os.O_WRONLY | os.O_CREATE | os.O_APPEND,
+	6: os.O_RDWR | os.O_CREATE | os.O_APPEND,
+}
+
+func afcStatus(err error) uint64 {
+	var errno syscall.Errno
+	switch {
+	case err == nil:
+		return libimobiledevice.AfcErrSuccess
+	case os.IsNotExist(err):
+		return libimobiledevice.AfcErrObjectNotFound
+	case os.IsPermission(err):
+		return libimobiledevice.AfcErrPermDenied
+	case os.IsExist(err):
+		return libimobiledevice.AfcErrObjectExists
+	case errors.As(err, &errno) && errno == syscall.ENOTEMPTY:
+		return libimobiledevice.AfcErrDirNotEmpty
+	case errors.As(err, &errno) && errno == syscall.EISDIR:
+		return libimobiledevice.AfcErrObjectIsDir
+	}
+	return libimobiledevice.AfcErrUnknownError
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		return string(b[:i])
+	}
+	return string(b)
+}
+
+func splitCStrings(b []byte) []string {
+	parts := bytes.Split(b, []byte{0})
+	ss := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if len(p) != 0 {
+			ss = append(ss, string(p))
+		}
+	}
+	return ss
+}
+
+func cStrings(s ...string) []byte {
+	buf := new(bytes.Buffer)
+	for _, v := range s {
+		buf.WriteString(v)
+		buf.WriteByte(0)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,310 @@
+package idevicetest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/libimobiledevice"
+)
+
+// ServiceHandler serves one connection to a service started via lockdownd.
+// The connection is closed once the handler returns.
+type ServiceHandler func(conn net.Conn)
+
+// LockdownHandler answers a single lockdownd request. A nil response falls
+// back to the built-in behaviour.
+type LockdownHandler func(req map[string]interface{}) (resp map[string]interface{})
+
+type service struct {
+	name      string
+	handler   ServiceHandler
+	enableSSL bool
+}
+
+type startedService struct {
+	service
+	pairRecord *libimobiledevice.PairRecord
+}
+
+// Device is a simulated iOS device: its usbmux properties, lockdownd values,
+// trusted hosts and the services lockdownd may start.
+type Device struct {
+	UDID           string
+	ConnectionType string
+	ProductID      int
+
+	server   *Server
+	deviceID int
+	key      *rsa.PrivateKey
+
+	mu             sync.Mutex
+	values         map[string]map[string]interface{}
+	services       map[string]service
+	requests       map[string]LockdownHandler
+	trusted        map[string]*libimobiledevice.PairRecord
+	supervisorCert *x509.Certificate
+	ports          map[int]startedService
+	nextPort       int
+	portHandlers   map[int]ServiceHandler
+	listeners      []net.Listener
+}
+
+// NewDevice creates a USB attached device answering the usual root domain
+// values (ProductVersion "16.0", DeviceName, DevicePublicKey, ...).
+func NewDevice(udid string) (dev *Device, err error) {
+	dev = &Device{
+		UDID:           udid,
+		ConnectionType: "USB",
+		ProductID:      0x12a8,
+		values:         make(map[string]map[string]interface{}),
+		services:       make(map[string]service),
+		requests:       make(map[string]LockdownHandler),
+		trusted:        make(map[string]*libimobiledevice.PairRecord),
+		ports:          make(map[int]startedService),
+		portHandlers:   make(map[int]ServiceHandler),
+		nextPort:       49152,
+	}
+	if dev.key, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+		return nil, fmt.Errorf("idevicetest device key: %w", err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err = pem.Encode(buf, &pem.Block{
+		Type:  "RSA PUBLIC KEY",
+		Bytes: x509.MarshalPKCS1PublicKey(&dev.key.PublicKey),
+	}); err != nil {
+		return nil, fmt.Errorf("idevicetest device key: %w", err)
+	}
+
+	dev.values[""] = map[string]interface{}{
+		"DeviceName":      "iPhone",
+		"DeviceClass":     "iPhone",
+		"ProductType":     "iPhone12,1",
+		"ProductVersion":  "16.0",
+		"BuildVersion":    "20A362",
+		"UniqueDeviceID":  udid,
+		"SerialNumber":    "F2LZK0ABCD12",
+		"WiFiAddress":     "a4:83:e7:00:00:01",
+		"DevicePublicKey": buf.Bytes(),
+	}
+	return dev, nil
+}
+
+// DeviceID returns the usbmux id assigned by Server.AddDevice
+func (d *Device) DeviceID() int {
+	return d.deviceID
+}
+
+// SetValue sets the lockdownd value of key in domain ("" is the root domain).
+func (d *Device) SetValue(domain, key string, value interface{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	m, ok := d.values[domain]
+	if !ok {
+		m = make(map[string]interface{})
+		d.values[domain] = m
+	}
+	m[key] = value
+}
+
+// DeleteValue removes the lockdownd value of key in domain, asking for it fails
+// with MissingValue.
+func (d *Device) DeleteValue(domain, key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.values[domain], key)
+}
+
+// Value returns the lockdownd value of key in domain, a copy of the whole
+// domain when key is empty.
+func (d *Device) Value(domain, key string) (value interface{}, ok bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	m, ok := d.values[domain]
+	if !ok {
+		return nil, false
+	}
+	if key == "" {
+		dup := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			dup[k] = v
+		}
+		return dup, true
+	}
+	value, ok = m[key]
+	return
+}
+
+// Handle registers the handler of a plain-text service.
+func (d *Device) Handle(name string, handler ServiceHandler) {
+	d.handle(service{name: name, handler: handler})
+}
+
+// HandleTLS registers the handler of a service answering `EnableServiceSSL`.
+func (d *Device) HandleTLS(name string, handler ServiceHandler) {
+	d.handle(service{name: name, handler: handler, enableSSL: true})
+}
+
+func (d *Device) handle(svc service) {
+	d.mu.Lock()
+	d.services[svc.name] = svc
+	d.mu.Unlock()
+}
+
+// HandlePort serves every usbmuxd `Connect` to port with handler, like an app
+// listening on the device (WebDriverAgent on 8100, say).
+func (d *Device) HandlePort(port int, handler ServiceHandler) {
+	d.mu.Lock()
+	d.portHandlers[port] = handler
+	d.mu.Unlock()
+}
+
+func (d *Device) portHandler(port int) (handler ServiceHandler, ok bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	handler, ok = d.portHandlers[port]
+	return
+}
+
+// HandleRequest overrides the lockdownd `Request` named request.
+func (d *Device) HandleRequest(request string, handler LockdownHandler) {
+	d.mu.Lock()
+	d.requests[request] = handler
+	d.mu.Unlock()
+}
+
+// Trust makes lockdownd accept sessions from the host of pairRecord.
+func (d *Device) Trust(pairRecord *libimobiledevice.PairRecord) {
+	d.mu.Lock()
+	d.trusted[pairRecord.HostID] = pairRecord
+	d.mu.Unlock()
+}
+
+// Supervise makes `Pair` requests carrying the supervisor certificate go through
+// the challenge a supervised device asks for, instead of trusting right away.
+func (d *Device) Supervise(supervisor *x509.Certificate) {
+	d.mu.Lock()
+	d.supervisorCert = supervisor
+	d.mu.Unlock()
+}
+
+func (d *Device) supervisor() *x509.Certificate {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.supervisorCert
+}
+
+// Untrust forgets the host hostID, as `Unpair` would.
+func (d *Device) Untrust(hostID string) {
+	d.mu.Lock()
+	delete(d.trusted, hostID)
+	d.mu.Unlock()
+}
+
+// Trusted reports the pair record lockdownd holds for hostID.
+func (d *Device) Trusted(hostID string) (pairRecord *libimobiledevice.PairRecord, ok bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	pairRecord, ok = d.trusted[hostID]
+	return
+}
+
+func (d *Device) wifiAddress() string {
+	v, _ := d.Value("", "WiFiAddress")
+	s, _ := v.(string)
+	return s
+}
+
+func (d *Device) attachedMessage() map[string]interface{} {
+	return map[string]interface{}{
+		"MessageType": string(libimobiledevice.MessageTypeDeviceAdd),
+		"DeviceID":    d.deviceID,
+		"Properties": map[string]interface{}{
+			"DeviceID":        d.deviceID,
+			"ConnectionType":  d.ConnectionType,
+			"ConnectionSpeed": 480000000,
+			"ProductID":       d.ProductID,
+			"LocationID":      d.deviceID << 20,
+			"SerialNumber":    d.UDID,
+			"UDID":            d.UDID,
+			"USBSerialNumber": d.UDID,
+		},
+	}
+}
+
+func (d *Device) openPort(svc service, pairRecord *libimobiledevice.PairRecord) (port int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.nextPort++
+	port = d.nextPort
+	d.ports[port] = startedService{service: svc, pairRecord: pairRecord}
+	return
+}
+
+func (d *Device) takePort(port int) (svc startedService, ok bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if svc, ok = d.ports[port]; ok {
+		delete(d.ports, port)
+	}
+	return
+}
+
+func (d *Device) serveService(conn net.Conn, svc startedService) {
+	if svc.enableSSL {
+		tlsConn, err := d.serverTLS(conn, svc.pairRecord)
+		if err != nil {
+			return
+		}
+		conn = tlsConn
+	}
+	svc.handler(conn)
+}
+
+// serverTLS plays the device side of `Handshake`, presenting the device
+// certificate of pairRecord and requiring one of the host's certificates.
+func (d *Device) serverTLS(conn net.Conn, pairRecord *libimobiledevice.PairRecord) (*tls.Conn, error) {
+	block, _ := pem.Decode(pairRecord.DeviceCertificate)
+	if block == nil {
+		return nil, fmt.Errorf("idevicetest tls: bad device certificate")
+	}
+
+	hostCerts := make([][]byte, 0, 2)
+	for _, p := range [][]byte{pairRecord.RootCertificate, pairRecord.HostCertificate} {
+		if b, _ := pem.Decode(p); b != nil {
+			hostCerts = append(hostCerts, b.Bytes)
+		}
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{block.Bytes},
+			PrivateKey:  d.key,
+		}},
+		ClientAuth: tls.RequireAnyClientCert,
+		MinVersion: tls.VersionTLS11,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			for _, raw := range rawCerts {
+				for _, c := range hostCerts {
+					if bytes.Equal(raw, c) {
+						return nil
+					}
+				}
+			}
+			return fmt.Errorf("idevicetest tls: unknown host certificate")
+		},
+	}
+
+	tlsConn := tls.Server(conn, config)
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, err
+	}
+	return tlsConn, nil
+}
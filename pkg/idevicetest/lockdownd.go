@@ -0,0 +1,281 @@
+package idevicetest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/libimobiledevice"
+	cms "github.com/github/ietf-cms"
+	uuid "github.com/satori/go.uuid"
+	"howett.net/plist"
+)
+
+// ReadMessage reads one length-prefixed plist, the framing shared by
+// lockdownd and most of the services it starts.
+func ReadMessage(r io.Reader, v interface{}) (err error) {
+	bufLen := make([]byte, 4)
+	if _, err = io.ReadFull(r, bufLen); err != nil {
+		return err
+	}
+	body := make([]byte, binary.BigEndian.Uint32(bufLen))
+	if _, err = io.ReadFull(r, body); err != nil {
+		return err
+	}
+	_, err = plist.Unmarshal(body, v)
+	return
+}
+
+// WriteMessage writes v as one length-prefixed XML plist.
+func WriteMessage(w io.Writer, v interface{}) (err error) {
+	var body []byte
+	if body, err = plist.Marshal(v, plist.XMLFormat); err != nil {
+		return err
+	}
+	buf := make([]byte, 4, 4+len(body))
+	binary.BigEndian.PutUint32(buf, uint32(len(body)))
+	_, err = w.Write(append(buf, body...))
+	return
+}
+
+type lockdownSession struct {
+	dev  *Device
+	raw  net.Conn
+	conn net.Conn
+
+	sessionID  string
+	pairRecord *libimobiledevice.PairRecord
+	challenge  []byte
+
+	// network services are started on ports of their own, see ListenNetwork
+	network bool
+}
+
+func (d *Device) serveLockdown(conn net.Conn) {
+	(&lockdownSession{dev: d, raw: conn, conn: conn}).serve()
+}
+
+func (s *lockdownSession) serve() {
+	d := s.dev
+	for {
+		req := make(map[string]interface{})
+		if err := ReadMessage(s.conn, &req); err != nil {
+			return
+		}
+		request, _ := req["Request"].(string)
+
+		d.mu.Lock()
+		handler, ok := d.requests[request]
+		d.mu.Unlock()
+
+		var resp map[string]interface{}
+		if ok {
+			resp = handler(req)
+		}
+		afterReply := func() error { return nil }
+		if resp == nil {
+			resp, afterReply = s.handle(request, req)
+		}
+		if _, ok := resp["Request"]; !ok {
+			resp["Request"] = request
+		}
+
+		if err := WriteMessage(s.conn, resp); err != nil {
+			return
+		}
+		if err := afterReply(); err != nil {
+			return
+		}
+	}
+}
+
+func (s *lockdownSession) handle(request string, req map[string]interface{}) (resp map[string]interface{}, afterReply func() error) {
+	afterReply = func() error { return nil }
+	fail := func(e string) map[string]interface{} {
+		return map[string]interface{}{"Error": e}
+	}
+	domain, _ := req["Domain"].(string)
+	key, _ := req["Key"].(string)
+
+	switch libimobiledevice.RequestType(request) {
+	case libimobiledevice.RequestTypeQueryType:
+		return map[string]interface{}{"Type": "com.apple.mobile.lockdown"}, afterReply
+
+	case libimobiledevice.RequestTypeGetValue:
+		v, ok := s.dev.Value(domain, key)
+		if !ok {
+			return fail("MissingValue"), afterReply
+		}
+		resp = map[string]interface{}{"Value": v}
+		if domain != "" {
+			resp["Domain"] = domain
+		}
+		if key != "" {
+			resp["Key"] = key
+		}
+		return resp, afterReply
+
+	case libimobiledevice.RequestTypeSetValue:
+		if s.sessionID == "" {
+			return fail("SessionInactive"), afterReply
+		}
+		s.dev.SetValue(domain, key, req["Value"])
+		resp = map[string]interface{}{"Key": key}
+		if domain != "" {
+			resp["Domain"] = domain
+		}
+		return resp, afterReply
+
+	case libimobiledevice.RequestTypePair:
+		pairRecord, err := decodePairRecord(req["PairRecord"])
+		if err != nil {
+			return fail("InvalidPairRecord"), afterReply
+		}
+		if supervisor := s.dev.supervisor(); supervisor != nil {
+			options, _ := req["PairingOptions"].(map[string]interface{})
+			if cert, ok := options["SupervisorCertificate"].([]byte); ok {
+				if !bytes.Equal(cert, supervisor.Raw) {
+					return fail("PairingFailed"), afterReply
+				}
+				s.challenge = make([]byte, 32)
+				_, _ = rand.Read(s.challenge)
+				resp = fail("MCChallengeRequired")
+				resp["ExtendedResponse"] = map[string]interface{}{"PairingChallenge": s.challenge}
+				return resp, afterReply
+			}
+			if signed, ok := options["ChallengeResponse"].([]byte); ok {
+				challenge := s.challenge
+				s.challenge = nil
+				if !verifyChallengeResponse(signed, challenge, supervisor) {
+					return fail("PairingFailed"), afterReply
+				}
+			}
+		}
+		s.dev.Trust(pairRecord)
+		escrowBag := make([]byte, 32)
+		_, _ = rand.Read(escrowBag)
+		return map[string]interface{}{"EscrowBag": escrowBag}, afterReply
+
+	case libimobiledevice.RequestTypeValidatePair:
+		pairRecord, err := decodePairRecord(req["PairRecord"])
+		if err != nil {
+			return fail("InvalidPairRecord"), afterReply
+		}
+		if _, ok := s.dev.Trusted(pairRecord.HostID); !ok {
+			return fail("InvalidHostID"), afterReply
+		}
+		return map[string]interface{}{}, afterReply
+
+	case libimobiledevice.RequestTypeUnpair:
+		pairRecord, err := decodePairRecord(req["PairRecord"])
+		if err != nil {
+			return fail("InvalidPairRecord"), afterReply
+		}
+		if _, ok := s.dev.Trusted(pairRecord.HostID); !ok {
+			return fail("InvalidHostID"), afterReply
+		}
+		s.dev.Untrust(pairRecord.HostID)
+		return map[string]interface{}{}, afterReply
+
+	case libimobiledevice.RequestTypeStartSession:
+		hostID, _ := req["HostID"].(string)
+		pairRecord, ok := s.dev.Trusted(hostID)
+		if !ok {
+			return fail("InvalidHostID"), afterReply
+		}
+		s.sessionID = strings.ToUpper(uuid.NewV4().String())
+		s.pairRecord = pairRecord
+		afterReply = func() error {
+			tlsConn, err := s.dev.serverTLS(s.raw, pairRecord)
+			if err != nil {
+				return err
+			}
+			s.conn = tlsConn
+			return nil
+		}
+		return map[string]interface{}{
+			"SessionID":        s.sessionID,
+			"EnableSessionSSL": true,
+		}, afterReply
+
+	case libimobiledevice.RequestTypeStopSession:
+		if sessionID, _ := req["SessionID"].(string); sessionID != s.sessionID || s.sessionID == "" {
+			return fail("InvalidSessionID"), afterReply
+		}
+		s.sessionID = ""
+		afterReply = func() error {
+			s.conn = s.raw
+			return nil
+		}
+		return map[string]interface{}{}, afterReply
+
+	case libimobiledevice.RequestTypeStartService:
+		name, _ := req["Service"].(string)
+		if s.sessionID == "" {
+			return fail("SessionInactive"), afterReply
+		}
+		s.dev.mu.Lock()
+		svc, ok := s.dev.services[name]
+		s.dev.mu.Unlock()
+		if !ok {
+			return fail("InvalidService"), afterReply
+		}
+		var port int
+		if s.network {
+			var err error
+			if port, err = s.dev.listenService(svc, s.pairRecord); err != nil {
+				return fail("ServiceLimit"), afterReply
+			}
+		} else {
+			port = s.dev.openPort(svc, s.pairRecord)
+		}
+		return map[string]interface{}{
+			"Service":          name,
+			"Port":             port,
+			"EnableServiceSSL": svc.enableSSL,
+		}, afterReply
+
+	case libimobiledevice.RequestTypeEnterRecovery:
+		return map[string]interface{}{}, afterReply
+	}
+
+	return fail(fmt.Sprintf("UnknownRequest: %s", request)), afterReply
+}
+
+// verifyChallengeResponse reports whether signed is challenge signed by supervisor
+func verifyChallengeResponse(signed, challenge []byte, supervisor *x509.Certificate) bool {
+	if challenge == nil {
+		return false
+	}
+	sd, err := cms.ParseSignedData(signed)
+	if err != nil {
+		return false
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(supervisor)
+	if _, err = sd.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}); err != nil {
+		return false
+	}
+	data, err := sd.GetData()
+	return err == nil && bytes.Equal(data, challenge)
+}
+
+func decodePairRecord(v interface{}) (pairRecord *libimobiledevice.PairRecord, err error) {
+	var data []byte
+	if data, err = plist.Marshal(v, plist.BinaryFormat); err != nil {
+		return nil, err
+	}
+	pairRecord = new(libimobiledevice.PairRecord)
+	if _, err = plist.Unmarshal(data, pairRecord); err != nil {
+		return nil, err
+	}
+	if pairRecord.HostID == "" || len(pairRecord.DeviceCertificate) == 0 {
+		return nil, fmt.Errorf("idevicetest: incomplete pair record")
+	}
+	return
+}
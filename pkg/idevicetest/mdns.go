@@ -0,0 +1,188 @@
+package idevicetest
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/mdns"
+)
+
+// MobileDeviceService the Bonjour service of Wi-Fi sync, instances are named `<WiFiAddress>@<IP>`
+const MobileDeviceService = "_apple-mobdev2._tcp.local."
+
+// RemoteService the Bonjour service of remoted (iOS 17)
+const RemoteService = "_remoted._tcp.local."
+
+// ServiceInstance a service the Responder advertises
+type ServiceInstance struct {
+	// Instance the first label of the instance name
+	Instance string
+	// Service e.g. MobileDeviceService
+	Service string
+	// Host e.g. `iPhone.local.`
+	Host string
+	IPs  []net.IP
+	Port int
+	Text []string
+}
+
+func (s *ServiceInstance) name() string {
+	return mdns.JoinName(s.Instance) + s.Service
+}
+
+// Responder stands in for the mDNS responders of the local link, answering
+// the unicast queries sent to its loopback UDP port.
+type Responder struct {
+	conn *net.UDPConn
+
+	mu        sync.Mutex
+	instances []*ServiceInstance
+	queriers  map[string]*net.UDPAddr
+}
+
+func NewResponder() (r *Responder, err error) {
+	r = &Responder{queriers: make(map[string]*net.UDPAddr)}
+	if r.conn, err = net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}); err != nil {
+		return nil, fmt.Errorf("idevicetest mdns: %w", err)
+	}
+	go r.serve()
+	return r, nil
+}
+
+// Addr where queries are answered
+func (r *Responder) Addr() *net.UDPAddr {
+	return r.conn.LocalAddr().(*net.UDPAddr)
+}
+
+func (r *Responder) Close() error {
+	return r.conn.Close()
+}
+
+// Advertise answers for instance, replacing one of the same name
+func (r *Responder) Advertise(instance ServiceInstance) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.remove(instance.name())
+	r.instances = append(r.instances, &instance)
+}
+
+// AdvertiseDevice advertises d the way a Wi-Fi device does, as
+// `<WiFiAddress>@<ip>` on MobileDeviceService
+func (r *Responder) AdvertiseDevice(d *Device, ip net.IP, port int) {
+	r.Advertise(ServiceInstance{
+		Instance: d.wifiAddress() + "@" + ip.String(),
+		Service:  MobileDeviceService,
+		Host:     d.UDID + ".local.",
+		IPs:      []net.IP{ip},
+		Port:     port,
+	})
+}
+
+// Withdraw stops advertising the instance named instance of service,
+// sending a goodbye to every host that queried so far.
+func (r *Responder) Withdraw(instance, service string) {
+	r.mu.Lock()
+	name := mdns.JoinName(instance) + service
+	found := r.remove(name)
+	queriers := make([]*net.UDPAddr, 0, len(r.queriers))
+	for _, addr := range r.queriers {
+		queriers = append(queriers, addr)
+	}
+	r.mu.Unlock()
+	if !found {
+		return
+	}
+
+	msg := &mdns.Message{Response: true, Authoritative: true, Answers: []mdns.Record{
+		{Name: service, Type: mdns.TypePTR, Target: name},
+	}}
+	b, err := msg.Pack()
+	if err != nil {
+		return
+	}
+	for _, addr := range queriers {
+		_, _ = r.conn.WriteToUDP(b, addr)
+	}
+}
+
+func (r *Responder) remove(name string) bool {
+	for i, inst := range r.instances {
+		if strings.EqualFold(inst.name(), name) {
+			r.instances = append(r.instances[:i], r.instances[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Responder) serve() {
+	buf := make([]byte, 9000)
+	for {
+		n, addr, err := r.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		var query mdns.Message
+		if query.Unpack(buf[:n]) != nil || query.Response {
+			continue
+		}
+		resp := r.answer(&query)
+		if len(resp.Answers) == 0 {
+			continue
+		}
+		r.mu.Lock()
+		r.queriers[addr.String()] = addr
+		r.mu.Unlock()
+		if b, err := resp.Pack(); err == nil {
+			_, _ = r.conn.WriteToUDP(b, addr)
+		}
+	}
+}
+
+// answer replies as to a legacy unicast query: the ID and questions echoed
+func (r *Responder) answer(query *mdns.Message) *mdns.Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	resp := &mdns.Message{ID: query.ID, Response: true, Authoritative: true, Questions: query.Questions}
+	for _, q := range query.Questions {
+		for _, inst := range r.instances {
+			switch {
+			case q.Type == mdns.TypePTR && strings.EqualFold(q.Name, inst.Service):
+				resp.Answers = append(resp.Answers, mdns.Record{Name: inst.Service, Type: mdns.TypePTR, TTL: 4500, Target: inst.name()})
+				resp.Additionals = append(resp.Additionals, inst.srv(), inst.txt())
+				resp.Additionals = append(resp.Additionals, inst.addresses(mdns.TypeANY)...)
+			case q.Type == mdns.TypeSRV && strings.EqualFold(q.Name, inst.name()):
+				resp.Answers = append(resp.Answers, inst.srv())
+			case q.Type == mdns.TypeTXT && strings.EqualFold(q.Name, inst.name()):
+				resp.Answers = append(resp.Answers, inst.txt())
+			case strings.EqualFold(q.Name, inst.Host):
+				resp.Answers = append(resp.Answers, inst.addresses(q.Type)...)
+			}
+		}
+	}
+	return resp
+}
+
+func (s *ServiceInstance) srv() mdns.Record {
+	return mdns.Record{Name: s.name(), Type: mdns.TypeSRV, TTL: 120, CacheFlush: true, Target: s.Host, Port: uint16(s.Port)}
+}
+
+func (s *ServiceInstance) txt() mdns.Record {
+	return mdns.Record{Name: s.name(), Type: mdns.TypeTXT, TTL: 4500, CacheFlush: true, Text: s.Text}
+}
+
+// addresses the A and AAAA records of Host, of typ only unless TypeANY
+func (s *ServiceInstance) addresses(typ mdns.Type) (records []mdns.Record) {
+	for _, ip := range s.IPs {
+		t := mdns.TypeAAAA
+		if ip.To4() != nil {
+			t = mdns.TypeA
+		}
+		if typ == mdns.TypeANY || typ == t {
+			records = append(records, mdns.Record{Name: s.Host, Type: t, TTL: 120, CacheFlush: true, IP: ip})
+		}
+	}
+	return
+}
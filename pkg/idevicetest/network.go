@@ -0,0 +1,79 @@
+package idevicetest
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/libimobiledevice"
+)
+
+// ListenNetwork serves lockdownd over plain TCP on a loopback port, the way a
+// Wi-Fi device does on libimobiledevice.LockdownPort. Services started over it
+// listen on loopback ports of their own. Close stops every listener.
+func (d *Device) ListenNetwork() (addr string, err error) {
+	var ln net.Listener
+	if ln, err = d.listen(); err != nil {
+		return "", err
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				(&lockdownSession{dev: d, raw: conn, conn: conn, network: true}).serve()
+			}()
+		}
+	}()
+	return ln.Addr().String(), nil
+}
+
+// NetworkAddress the sockaddr usbmuxd reports for a device at 127.0.0.1
+func (d *Device) NetworkAddress() []byte {
+	return libimobiledevice.NewNetworkAddress(net.IPv4(127, 0, 0, 1))
+}
+
+// Close stops the listeners of ListenNetwork.
+func (d *Device) Close() {
+	d.mu.Lock()
+	listeners := d.listeners
+	d.listeners = nil
+	d.mu.Unlock()
+	for _, ln := range listeners {
+		_ = ln.Close()
+	}
+}
+
+func (d *Device) listen() (ln net.Listener, err error) {
+	if ln, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		return nil, fmt.Errorf("idevicetest listen: %w", err)
+	}
+	d.mu.Lock()
+	d.listeners = append(d.listeners, ln)
+	d.mu.Unlock()
+	return ln, nil
+}
+
+// listenService accepts a single connection to svc on a port of its own
+func (d *Device) listenService(svc service, pairRecord *libimobiledevice.PairRecord) (port int, err error) {
+	var ln net.Listener
+	if ln, err = d.listen(); err != nil {
+		return 0, err
+	}
+	_, p, _ := net.SplitHostPort(ln.Addr().String())
+	port, _ = strconv.Atoi(p)
+
+	go func() {
+		conn, err := ln.Accept()
+		_ = ln.Close()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		d.serveService(conn, startedService{service: svc, pairRecord: pairRecord})
+	}()
+	return port, nil
+}
@@ -0,0 +1,74 @@
+package idevicetest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/libimobiledevice"
+	uuid "github.com/satori/go.uuid"
+)
+
+// newPairRecord issues root, host and device certificates the same way a
+// host does while pairing, so the record is usable with `Handshake`.
+func newPairRecord(deviceKey *rsa.PublicKey) (pairRecord *libimobiledevice.PairRecord, err error) {
+	var rootKey, hostKey *rsa.PrivateKey
+	if rootKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+		return nil, err
+	}
+	if hostKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+		return nil, err
+	}
+
+	notBefore := time.Now().Add(-time.Hour)
+	notAfter := notBefore.Add(time.Hour * 24 * 365)
+	rootTemplate := &x509.Certificate{
+		IsCA:                  true,
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	leafTemplate := func(serial int64) *x509.Certificate {
+		return &x509.Certificate{
+			SerialNumber:          big.NewInt(serial),
+			NotBefore:             notBefore,
+			NotAfter:              notAfter,
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		}
+	}
+
+	var rootDER, hostDER, deviceDER []byte
+	if rootDER, err = x509.CreateCertificate(rand.Reader, rootTemplate, rootTemplate, &rootKey.PublicKey, rootKey); err != nil {
+		return nil, err
+	}
+	if hostDER, err = x509.CreateCertificate(rand.Reader, leafTemplate(2), rootTemplate, &hostKey.PublicKey, rootKey); err != nil {
+		return nil, err
+	}
+	if deviceDER, err = x509.CreateCertificate(rand.Reader, leafTemplate(3), rootTemplate, deviceKey, rootKey); err != nil {
+		return nil, err
+	}
+
+	pairRecord = &libimobiledevice.PairRecord{
+		DeviceCertificate: encodePem("CERTIFICATE", deviceDER),
+		HostCertificate:   encodePem("CERTIFICATE", hostDER),
+		HostPrivateKey:    encodePem("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(hostKey)),
+		RootCertificate:   encodePem("CERTIFICATE", rootDER),
+		RootPrivateKey:    encodePem("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rootKey)),
+		HostID:            strings.ToUpper(uuid.NewV4().String()),
+	}
+	return
+}
+
+func encodePem(typ string, der []byte) []byte {
+	buf := new(bytes.Buffer)
+	_ = pem.Encode(buf, &pem.Block{Type: typ, Bytes: der})
+	return buf.Bytes()
+}
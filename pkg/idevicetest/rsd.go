@@ -0,0 +1,150 @@
+package idevicetest
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/libimobiledevice"
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/remotexpc"
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/tunnel"
+	uuid "github.com/satori/go.uuid"
+)
+
+// ListenRemoteServiceDiscovery serves RemoteServiceDiscovery over RemoteXPC on a
+// loopback port, the way an iOS 17 device does on remotexpc.RSDPort at its tunnel
+// address. Every service registered so far is listed, on a port of its own, the
+// `*.shim.remote` ones answering `RSDCheckin` first. Close stops it.
+func (d *Device) ListenRemoteServiceDiscovery() (addr string, err error) {
+	var ln net.Listener
+	if ln, err = d.serveRemoteServiceDiscovery(func(int) (net.Listener, error) {
+		return d.listen()
+	}); err != nil {
+		return "", err
+	}
+	return ln.Addr().String(), nil
+}
+
+// CoreDeviceProxyHandler serves CoreDeviceProxy, to register with HandleTLS: after
+// the CDTunnel handshake the device is at fd00::1 in the tunnel, serving
+// RemoteServiceDiscovery on remotexpc.RSDPort as ListenRemoteServiceDiscovery
+// does, the services from port 50000 on.
+func (d *Device) CoreDeviceProxyHandler() ServiceHandler {
+	return func(conn net.Conn) {
+		params := tunnel.Parameters{
+			Address:       "fd00::2",
+			Netmask:       "ffff:ffff:ffff:ffff::",
+			MTU:           tunnel.DefaultMTU,
+			ServerAddress: "fd00::1",
+			ServerRSDPort: remotexpc.RSDPort,
+		}
+		if err := tunnel.ServeHandshake(conn, params); err != nil {
+			return
+		}
+		stack := tunnel.NewStack(conn, net.ParseIP(params.ServerAddress), params.MTU)
+		defer stack.Close()
+
+		nextPort := 50000
+		if _, err := d.serveRemoteServiceDiscovery(func(port int) (net.Listener, error) {
+			if port == 0 {
+				port, nextPort = nextPort, nextPort+1
+			}
+			return stack.Listen(port)
+		}); err != nil {
+			return
+		}
+		<-stack.Done()
+	}
+}
+
+// serveRemoteServiceDiscovery serves the directory of the services registered so far,
+// on listeners from listen: port 0 for any port, remotexpc.RSDPort for the directory
+func (d *Device) serveRemoteServiceDiscovery(listen func(port int) (net.Listener, error)) (ln net.Listener, err error) {
+	d.mu.Lock()
+	services := make([]service, 0, len(d.services))
+	for _, svc := range d.services {
+		services = append(services, svc)
+	}
+	d.mu.Unlock()
+
+	sd := &remotexpc.ServiceDirectory{
+		UUID: uuid.NewV4().String(),
+		Properties: map[string]interface{}{
+			"UniqueDeviceID": d.UDID,
+		},
+		Services: make(map[string]remotexpc.Service, len(services)),
+	}
+	if v, ok := d.Value("", "ProductVersion"); ok {
+		sd.Properties["OSVersion"] = v
+	}
+	for _, svc := range services {
+		var svcLn net.Listener
+		if svcLn, err = listen(0); err != nil {
+			return nil, err
+		}
+		_, p, _ := net.SplitHostPort(svcLn.Addr().String())
+		port, _ := strconv.Atoi(p)
+		go serveRemoteService(svcLn, svc)
+		sd.Services[svc.name] = remotexpc.Service{Port: port}
+	}
+
+	if ln, err = listen(remotexpc.RSDPort); err != nil {
+		return nil, err
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				c, err := remotexpc.NewPeerConn(conn)
+				if err != nil {
+					return
+				}
+				if err = c.Send(remotexpc.HandshakeMessage(sd), false); err != nil {
+					return
+				}
+				for {
+					if _, err = c.ReceiveWrapper(); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return ln, nil
+}
+
+// serveRemoteService accepts the connections to svc, in plain text as behind a tunnel
+func serveRemoteService(ln net.Listener, svc service) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			if strings.HasSuffix(svc.name, remotexpc.ShimSuffix) && !rsdCheckin(conn) {
+				return
+			}
+			svc.handler(conn)
+		}()
+	}
+}
+
+func rsdCheckin(conn net.Conn) bool {
+	var req map[string]interface{}
+	if err := ReadMessage(conn, &req); err != nil {
+		return false
+	}
+	if req["Request"] != string(libimobiledevice.RequestTypeRSDCheckin) {
+		_ = WriteMessage(conn, map[string]interface{}{"Error": "InvalidRequest"})
+		return false
+	}
+	if err := WriteMessage(conn, map[string]interface{}{"Request": "RSDCheckin"}); err != nil {
+		return false
+	}
+	return WriteMessage(conn, map[string]interface{}{"Request": "StartService"}) == nil
+}
@@ -0,0 +1,424 @@
+// Package idevicetest provides an in-process usbmuxd and lockdownd stand-in,
+// so that the library can be exercised end-to-end without a physical device.
+//
+//	srv, _ := idevicetest.NewServer()
+//	defer srv.Close()
+//	dev, _ := idevicetest.NewDevice("00008030-001A2B3C4D5E6F70")
+//	dev.Handle(libimobiledevice.AfcServiceName, func(conn net.Conn) { ... })
+//	srv.AddDevice(dev)
+//	os.Setenv(libimobiledevice.UsbmuxdSocketAddressEnv, srv.Addr())
+package idevicetest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/libimobiledevice"
+	uuid "github.com/satori/go.uuid"
+	"howett.net/plist"
+)
+
+// Server speaks the usbmuxd plist protocol and routes `Connect` requests to
+// the lockdownd and service handlers of its devices.
+type Server struct {
+	ln   net.Listener
+	addr string
+
+	mu        sync.Mutex
+	buid      string
+	nextID    int
+	devices   map[int]*Device
+	records   map[string][]byte
+	listeners map[*usbmuxConn]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+
+	wg sync.WaitGroup
+}
+
+// NewServer starts a Server on a loopback TCP port.
+func NewServer() (*Server, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("idevicetest listen: %w", err)
+	}
+	return newServer(ln, ln.Addr().String()), nil
+}
+
+// NewUnixServer starts a Server on the unix socket at socketPath.
+func NewUnixServer(socketPath string) (*Server, error) {
+	ln, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("idevicetest listen: %w", err)
+	}
+	return newServer(ln, "UNIX:"+socketPath), nil
+}
+
+func newServer(ln net.Listener, addr string) *Server {
+	s := &Server{
+		ln:        ln,
+		addr:      addr,
+		buid:      strings.ToUpper(uuid.NewV4().String()),
+		devices:   make(map[int]*Device),
+		records:   make(map[string][]byte),
+		listeners: make(map[*usbmuxConn]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+	s.wg.Add(1)
+	go s.accept()
+	return s
+}
+
+// Addr returns the address in the format of libimobiledevice.UsbmuxdSocketAddressEnv
+func (s *Server) Addr() string {
+	return s.addr
+}
+
+// BUID returns the SystemBUID reported by `ReadBUID`
+func (s *Server) BUID() string {
+	return s.buid
+}
+
+// AddDevice attaches dev and notifies every listener.
+func (s *Server) AddDevice(dev *Device) {
+	s.mu.Lock()
+	s.nextID++
+	dev.server = s
+	dev.deviceID = s.nextID
+	s.devices[dev.deviceID] = dev
+	listeners := s.snapshotListeners()
+	s.mu.Unlock()
+
+	for _, l := range listeners {
+		_ = l.send(0, dev.attachedMessage())
+	}
+}
+
+// RemoveDevice detaches dev and notifies every listener.
+func (s *Server) RemoveDevice(dev *Device) {
+	s.mu.Lock()
+	if _, ok := s.devices[dev.deviceID]; !ok {
+		s.mu.Unlock()
+		return
+	}
+	delete(s.devices, dev.deviceID)
+	listeners := s.snapshotListeners()
+	s.mu.Unlock()
+
+	for _, l := range listeners {
+		_ = l.send(0, map[string]interface{}{
+			"MessageType": string(libimobiledevice.MessageTypeDeviceRemove),
+			"DeviceID":    dev.deviceID,
+		})
+	}
+}
+
+// Pair generates a pair record for dev, trusts it on the device side and
+// stores it like `SavePairRecord` would.
+func (s *Server) Pair(dev *Device) (pairRecord *libimobiledevice.PairRecord, err error) {
+	if pairRecord, err = newPairRecord(&dev.key.PublicKey); err != nil {
+		return nil, err
+	}
+	pairRecord.SystemBUID = s.buid
+	pairRecord.WiFiMACAddress = dev.wifiAddress()
+
+	var data []byte
+	if data, err = plist.Marshal(pairRecord, plist.XMLFormat); err != nil {
+		return nil, err
+	}
+
+	dev.Trust(pairRecord)
+
+	s.mu.Lock()
+	s.records[dev.UDID] = data
+	s.mu.Unlock()
+	return
+}
+
+// PairRecord returns the record stored for udid, if any.
+func (s *Server) PairRecord(udid string) (pairRecord *libimobiledevice.PairRecord, ok bool) {
+	s.mu.Lock()
+	data, ok := s.records[udid]
+	s.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	pairRecord = new(libimobiledevice.PairRecord)
+	if _, err := plist.Unmarshal(data, pairRecord); err != nil {
+		return nil, false
+	}
+	return pairRecord, true
+}
+
+// Close stops accepting connections and closes all of them, including the
+// ones handed over to service handlers.
+func (s *Server) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	_ = s.ln.Close()
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// notifyPaired sends `Paired` for every attachment of udid, as usbmuxd does once
+// a pair record is saved
+func (s *Server) notifyPaired(udid string) {
+	s.mu.Lock()
+	var ids []int
+	for id, dev := range s.devices {
+		if dev.UDID == udid {
+			ids = append(ids, id)
+		}
+	}
+	listeners := s.snapshotListeners()
+	s.mu.Unlock()
+
+	for _, id := range ids {
+		for _, l := range listeners {
+			_ = l.send(0, map[string]interface{}{
+				"MessageType": string(libimobiledevice.MessageTypeDevicePaired),
+				"DeviceID":    id,
+			})
+		}
+	}
+}
+
+func (s *Server) snapshotListeners() []*usbmuxConn {
+	listeners := make([]*usbmuxConn, 0, len(s.listeners))
+	for l := range s.listeners {
+		listeners = append(listeners, l)
+	}
+	return listeners
+}
+
+func (s *Server) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		_ = conn.Close()
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	return true
+}
+
+func (s *Server) untrack(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	_ = conn.Close()
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		if !s.track(conn) {
+			continue
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer s.untrack(conn)
+			s.serve(&usbmuxConn{Conn: conn})
+		}()
+	}
+}
+
+func (s *Server) serve(conn *usbmuxConn) {
+	for {
+		tag, body, err := conn.receive()
+		if err != nil {
+			return
+		}
+
+		var req struct {
+			MessageType  string `plist:"MessageType"`
+			DeviceID     int    `plist:"DeviceID"`
+			PortNumber   int    `plist:"PortNumber"`
+			PairRecordID string `plist:"PairRecordID"`
+			PairRecord   []byte `plist:"PairRecordData"`
+		}
+		if _, err = plist.Unmarshal(body, &req); err != nil {
+			return
+		}
+
+		switch libimobiledevice.MessageType(req.MessageType) {
+		case libimobiledevice.MessageTypeDeviceList:
+			s.mu.Lock()
+			list := make([]interface{}, 0, len(s.devices))
+			for id := 1; id <= s.nextID; id++ {
+				if dev, ok := s.devices[id]; ok {
+					list = append(list, dev.attachedMessage())
+				}
+			}
+			s.mu.Unlock()
+			err = conn.send(tag, map[string]interface{}{"DeviceList": list})
+		case libimobiledevice.MessageTypeReadBUID:
+			err = conn.send(tag, map[string]interface{}{"BUID": s.buid})
+		case libimobiledevice.MessageTypeReadPairRecord:
+			s.mu.Lock()
+			data, ok := s.records[req.PairRecordID]
+			s.mu.Unlock()
+			if !ok {
+				err = conn.result(tag, libimobiledevice.ReplyCodeBadDevice)
+				break
+			}
+			err = conn.send(tag, map[string]interface{}{"PairRecordData": data})
+		case libimobiledevice.MessageTypeSavePairRecord:
+			s.mu.Lock()
+			s.records[req.PairRecordID] = req.PairRecord
+			s.mu.Unlock()
+			if err = conn.result(tag, libimobiledevice.ReplyCodeOK); err == nil {
+				s.notifyPaired(req.PairRecordID)
+			}
+		case libimobiledevice.MessageTypeDeletePairRecord:
+			s.mu.Lock()
+			delete(s.records, req.PairRecordID)
+			s.mu.Unlock()
+			err = conn.result(tag, libimobiledevice.ReplyCodeOK)
+		case libimobiledevice.MessageTypeListen:
+			s.listen(tag, conn)
+			return
+		case libimobiledevice.MessageTypeConnect:
+			s.connect(tag, conn, req.DeviceID, ((req.PortNumber<<8)&0xFF00)|(req.PortNumber>>8))
+			return
+		default:
+			err = conn.result(tag, libimobiledevice.ReplyCodeBadCommand)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) listen(tag uint32, conn *usbmuxConn) {
+	if err := conn.result(tag, libimobiledevice.ReplyCodeOK); err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.listeners[conn] = struct{}{}
+	attached := make([]interface{}, 0, len(s.devices))
+	for id := 1; id <= s.nextID; id++ {
+		if dev, ok := s.devices[id]; ok {
+			attached = append(attached, dev.attachedMessage())
+		}
+	}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, conn)
+		s.mu.Unlock()
+	}()
+
+	for _, msg := range attached {
+		if err := conn.send(0, msg); err != nil {
+			return
+		}
+	}
+
+	// the client never talks again, wait until it hangs up
+	_, _ = io.Copy(io.Discard, conn)
+}
+
+func (s *Server) connect(tag uint32, conn *usbmuxConn, deviceID, port int) {
+	s.mu.Lock()
+	dev, ok := s.devices[deviceID]
+	s.mu.Unlock()
+	if !ok {
+		_ = conn.result(tag, libimobiledevice.ReplyCodeBadDevice)
+		return
+	}
+
+	if port == libimobiledevice.LockdownPort {
+		if err := conn.result(tag, libimobiledevice.ReplyCodeOK); err != nil {
+			return
+		}
+		dev.serveLockdown(conn.Conn)
+		return
+	}
+
+	if handler, ok := dev.portHandler(port); ok {
+		if err := conn.result(tag, libimobiledevice.ReplyCodeOK); err != nil {
+			return
+		}
+		handler(conn.Conn)
+		return
+	}
+
+	svc, ok := dev.takePort(port)
+	if !ok {
+		_ = conn.result(tag, libimobiledevice.ReplyCodeConnectionRefused)
+		return
+	}
+	if err := conn.result(tag, libimobiledevice.ReplyCodeOK); err != nil {
+		return
+	}
+	dev.serveService(conn.Conn, svc)
+}
+
+type usbmuxConn struct {
+	net.Conn
+	mu sync.Mutex
+}
+
+func (c *usbmuxConn) receive() (tag uint32, body []byte, err error) {
+	header := make([]byte, 16)
+	if _, err = io.ReadFull(c.Conn, header); err != nil {
+		return 0, nil, err
+	}
+	length := binary.LittleEndian.Uint32(header[0:])
+	if length < 16 {
+		return 0, nil, errors.New("usbmux packet: bad length")
+	}
+	tag = binary.LittleEndian.Uint32(header[12:])
+	body = make([]byte, length-16)
+	if _, err = io.ReadFull(c.Conn, body); err != nil {
+		return 0, nil, err
+	}
+	return
+}
+
+func (c *usbmuxConn) send(tag uint32, msg interface{}) (err error) {
+	var body []byte
+	if body, err = plist.Marshal(msg, plist.XMLFormat); err != nil {
+		return err
+	}
+
+	buf := new(bytes.Buffer)
+	_ = binary.Write(buf, binary.LittleEndian, uint32(len(body)+16))
+	_ = binary.Write(buf, binary.LittleEndian, uint32(libimobiledevice.ProtoVersionPlist))
+	_ = binary.Write(buf, binary.LittleEndian, uint32(libimobiledevice.ProtoMessageTypePlist))
+	_ = binary.Write(buf, binary.LittleEndian, tag)
+	buf.Write(body)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err = c.Conn.Write(buf.Bytes())
+	return
+}
+
+func (c *usbmuxConn) result(tag uint32, code libimobiledevice.ReplyCode) error {
+	return c.send(tag, map[string]interface{}{
+		"MessageType": string(libimobiledevice.MessageTypeResult),
+		"Number":      uint64(code),
+	})
+}
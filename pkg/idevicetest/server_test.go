@@ -0,0 +1,101 @@
+package idevicetest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/libimobiledevice"
+)
+
+func setupServer(t *testing.T) (*Server, *Device) {
+	srv, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Close)
+
+	dev, err := NewDevice("00008030-001A2B3C4D5E6F70")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.AddDevice(dev)
+	return srv, dev
+}
+
+func connect(t *testing.T, srv *Server, dev *Device, port int) (libimobiledevice.InnerConn, error) {
+	client, err := libimobiledevice.NewUsbmuxClient(srv.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkt, err := client.NewPlistPacket(client.NewConnectRequest(dev.DeviceID(), port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = client.SendPacket(pkt); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.ReceivePacket(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return client.InnerConn(), nil
+}
+
+func TestServer_Connect(t *testing.T) {
+	srv, dev := setupServer(t)
+
+	if _, err := connect(t, srv, dev, 1234); err == nil ||
+		!strings.Contains(err.Error(), libimobiledevice.ReplyCodeConnectionRefused.String()) {
+		t.Fatalf("connect to closed port: %v", err)
+	}
+}
+
+func TestServer_StartSession(t *testing.T) {
+	srv, dev := setupServer(t)
+
+	pairRecord, err := srv.Pair(dev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	innerConn, err := connect(t, srv, dev, libimobiledevice.LockdownPort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer innerConn.Close()
+	client := libimobiledevice.NewLockdownClient(innerConn)
+
+	startSession := func(hostID string) error {
+		pkt, err := client.NewXmlPacket(client.NewStartSessionRequest(pairRecord.SystemBUID, hostID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = client.SendPacket(pkt); err != nil {
+			t.Fatal(err)
+		}
+		_, err = client.ReceivePacket()
+		return err
+	}
+
+	if err = startSession("UNKNOWN-HOST"); err == nil || !strings.Contains(err.Error(), "InvalidHostID") {
+		t.Fatalf("untrusted host: %v", err)
+	}
+
+	if err = startSession(pairRecord.HostID); err != nil {
+		t.Fatal(err)
+	}
+	if err = client.EnableSSL([]int{16, 0}, pairRecord); err != nil {
+		t.Fatal(err)
+	}
+
+	pkt, err := client.NewXmlPacket(client.NewStartServiceRequest(libimobiledevice.AfcServiceName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = client.SendPacket(pkt); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.ReceivePacket(); err == nil || !strings.Contains(err.Error(), "InvalidService") {
+		t.Fatalf("unknown service: %v", err)
+	}
+}
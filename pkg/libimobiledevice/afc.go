@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/hex"
 	"fmt"
+	"sync"
 )
 
 const AfcServiceName = "com.apple.afc"
@@ -17,6 +18,12 @@ func NewAfcClient(innerConn InnerConn) *AfcClient {
 type AfcClient struct {
 	innerConn InnerConn
 	packetNum uint64
+
+	mu sync.Mutex
+}
+
+func (c *AfcClient) InnerConn() InnerConn {
+	return c.innerConn
 }
 
 func (c *AfcClient) newPacket(operation uint64, data, payload []byte) Packet {
@@ -38,6 +45,18 @@ func (c *AfcClient) newPacket(operation uint64, data, payload []byte) Packet {
 	return pkt
 }
 
+// Exchange sends a request and receives its reply, safe for concurrent use unlike
+// Send and Receive called one after another
+func (c *AfcClient) Exchange(operation uint64, data, payload []byte) (respMsg *AfcMessage, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err = c.Send(operation, data, payload); err != nil {
+		return nil, err
+	}
+	return c.Receive()
+}
+
 func (c *AfcClient) Send(operation uint64, data, payload []byte) (err error) {
 	pkt := c.newPacket(operation, data, payload)
 	var raw []byte
@@ -99,7 +118,10 @@ func (c *AfcClient) Receive() (respMsg *AfcMessage, err error) {
 	respMsg.Data = bufData
 	respMsg.Payload = buffer.Bytes()
 
-	debugLog(fmt.Sprintf("<-- %s\n%s\n%s", respPkt, hex.Dump(respMsg.Data), hex.Dump(respMsg.Payload)))
+	// dumping a payload of file data costs more than reading it
+	if debugFlag {
+		debugLog(fmt.Sprintf("<-- %s\n%s\n%s", respPkt, hex.Dump(respMsg.Data), hex.Dump(respMsg.Payload)))
+	}
 
 	return
 }
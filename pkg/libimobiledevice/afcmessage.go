@@ -3,7 +3,8 @@ package libimobiledevice
 import (
 	"bytes"
 	"encoding/binary"
-	"errors"
+	"io/fs"
+	"strconv"
 )
 
 type AfcMessage struct {
@@ -43,70 +44,89 @@ func (m *AfcMessage) Err() error {
 	if m.Operation == AfcOperationStatus {
 		status := m.Uint64()
 		if status != AfcErrSuccess {
-			return toError(status)
+			return AfcError(status)
 		}
 	}
 	return nil
 }
 
-func toError(status uint64) error {
-	switch status {
+// AfcError the status an AFC operation failed with.
+// ObjectNotFound, PermDenied, ObjectExists and InvalidArgument match
+// fs.ErrNotExist, fs.ErrPermission, fs.ErrExist and fs.ErrInvalid with errors.Is
+type AfcError uint64
+
+func (e AfcError) Error() string {
+	switch e {
 	case AfcErrUnknownError:
-		return errors.New("UnknownError")
+		return "UnknownError"
 	case AfcErrOperationHeaderInvalid:
-		return errors.New("OperationHeaderInvalid")
+		return "OperationHeaderInvalid"
 	case AfcErrNoResources:
-		return errors.New("NoResources")
+		return "NoResources"
 	case AfcErrReadError:
-		return errors.New("ReadError")
+		return "ReadError"
 	case AfcErrWriteError:
-		return errors.New("WriteError")
+		return "WriteError"
 	case AfcErrUnknownPacketType:
-		return errors.New("UnknownPacketType")
+		return "UnknownPacketType"
 	case AfcErrInvalidArgument:
-		return errors.New("InvalidArgument")
+		return "InvalidArgument"
 	case AfcErrObjectNotFound:
-		return errors.New("ObjectNotFound")
+		return "ObjectNotFound"
 	case AfcErrObjectIsDir:
-		return errors.New("ObjectIsDir")
+		return "ObjectIsDir"
 	case AfcErrPermDenied:
-		return errors.New("PermDenied")
+		return "PermDenied"
 	case AfcErrServiceNotConnected:
-		return errors.New("ServiceNotConnected")
+		return "ServiceNotConnected"
 	case AfcErrOperationTimeout:
-		return errors.New("OperationTimeout")
+		return "OperationTimeout"
 	case AfcErrTooMuchData:
-		return errors.New("TooMuchData")
+		return "TooMuchData"
 	case AfcErrEndOfData:
-		return errors.New("EndOfData")
+		return "EndOfData"
 	case AfcErrOperationNotSupported:
-		return errors.New("OperationNotSupported")
+		return "OperationNotSupported"
 	case AfcErrObjectExists:
-		return errors.New("ObjectExists")
+		return "ObjectExists"
 	case AfcErrObjectBusy:
-		return errors.New("ObjectBusy")
+		return "ObjectBusy"
 	case AfcErrNoSpaceLeft:
-		return errors.New("NoSpaceLeft")
+		return "NoSpaceLeft"
 	case AfcErrOperationWouldBlock:
-		return errors.New("OperationWouldBlock")
+		return "OperationWouldBlock"
 	case AfcErrIoError:
-		return errors.New("IoError")
+		return "IoError"
 	case AfcErrOperationInterrupted:
-		return errors.New("OperationInterrupted")
+		return "OperationInterrupted"
 	case AfcErrOperationInProgress:
-		return errors.New("OperationInProgress")
+		return "OperationInProgress"
 	case AfcErrInternalError:
-		return errors.New("InternalError")
+		return "InternalError"
 	case AfcErrMuxError:
-		return errors.New("MuxError")
+		return "MuxError"
 	case AfcErrNoMemory:
-		return errors.New("NoMemory")
+		return "NoMemory"
 	case AfcErrNotEnoughData:
-		return errors.New("NotEnoughData")
+		return "NotEnoughData"
 	case AfcErrDirNotEmpty:
-		return errors.New("DirNotEmpty")
+		return "DirNotEmpty"
 	}
-	return nil
+	return "afc status " + strconv.FormatUint(uint64(e), 10)
+}
+
+func (e AfcError) Is(target error) bool {
+	switch target {
+	case fs.ErrNotExist:
+		return e == AfcErrObjectNotFound
+	case fs.ErrPermission:
+		return e == AfcErrPermDenied
+	case fs.ErrExist:
+		return e == AfcErrObjectExists
+	case fs.ErrInvalid:
+		return e == AfcErrInvalidArgument
+	}
+	return false
 }
 
 const (
@@ -0,0 +1,45 @@
+package libimobiledevice
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"testing"
+)
+
+func Test_AfcMessage_Err(t *testing.T) {
+	status := func(code uint64) *AfcMessage {
+		data := make([]byte, 8)
+		binary.LittleEndian.PutUint64(data, code)
+		return &AfcMessage{Operation: AfcOperationStatus, Data: data}
+	}
+
+	if err := status(AfcErrSuccess).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		code   uint64
+		target error
+	}{
+		{AfcErrObjectNotFound, fs.ErrNotExist},
+		{AfcErrPermDenied, fs.ErrPermission},
+		{AfcErrObjectExists, fs.ErrExist},
+		{AfcErrInvalidArgument, fs.ErrInvalid},
+	}
+	for _, tt := range tests {
+		err := fmt.Errorf("afc 'Open': %w", status(tt.code).Err())
+		if !errors.Is(err, tt.target) {
+			t.Errorf("%v is not %v", err, tt.target)
+		}
+		if !errors.Is(err, AfcError(tt.code)) {
+			t.Errorf("%v is not %d", err, tt.code)
+		}
+	}
+
+	err := status(AfcErrDirNotEmpty).Err()
+	if err.Error() != "DirNotEmpty" || errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("DirNotEmpty: %v", err)
+	}
+}
@@ -2,7 +2,6 @@ package libimobiledevice
 
 import (
 	"net/http"
-	"strings"
 
 	"golang.org/x/xerrors"
 )
@@ -13,6 +12,9 @@ const (
 	DEV_MODE_REVEAL = 0
 	DEV_MODE_ARM    = 1
 	DEV_MODE_ENABLE = 2
+
+	// AmfiErrPasscodeSet the Error of the reply to DEV_MODE_ARM while a passcode is set
+	AmfiErrPasscodeSet = "Device has passcode set"
 )
 
 type AmfiClient struct {
@@ -31,7 +33,8 @@ func (c *AmfiClient) SendAction(action int) (int, error) {
 	if errSend := c.client.SendPacket(pktOut); errSend != nil {
 		return http.StatusInternalServerError, errSend
 	}
-	if pktIn, errRecv := c.client.ReceivePacket(); errRecv != nil {
+	// the Error of the reply is looked at here, not by ReceivePacket
+	if pktIn, errRecv := c.client.receivePacket(); errRecv != nil {
 		return http.StatusInternalServerError, errRecv
 	} else {
 		result := map[string]interface{}{}
@@ -41,7 +44,7 @@ func (c *AmfiClient) SendAction(action int) (int, error) {
 		if oErr, bHasErr := result["Error"]; bHasErr {
 			switch strErr := oErr.(type) {
 			case string:
-				if strings.Contains(strErr, "passcode") {
+				if strErr == AmfiErrPasscodeSet {
 					return http.StatusLocked, xerrors.New(strErr)
 				}
 				return http.StatusInternalServerError, xerrors.New(strErr)
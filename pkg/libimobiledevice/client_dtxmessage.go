@@ -3,11 +3,14 @@ package libimobiledevice
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/compression"
 	"github.com/SonicCloudOrg/sonic-gidevice/pkg/nskeyedarchiver"
 	"io"
-	"strings"
+	"net"
 	"sync"
 	"time"
 	"unsafe"
@@ -18,6 +21,18 @@ const (
 	_over         = "_Golang-iDevice_Over"
 )
 
+// DefaultDTXReplyTimeout how long a request waits for its reply when the
+// caller's context has no deadline
+const DefaultDTXReplyTimeout = 30 * time.Second
+
+var (
+	// ErrDTXChannelCanceled the channel a request was sent on has been
+	// canceled with `_channelCanceled:` before the reply arrived
+	ErrDTXChannelCanceled = errors.New("dtx: channel canceled")
+	// ErrDTXConnectionClosed the connection was closed before the reply arrived
+	ErrDTXConnectionClosed = errors.New("dtx: connection closed")
+)
+
 func newDtxMessageClient(innerConn InnerConn) *dtxMessageClient {
 	c := &dtxMessageClient{
 		innerConn:         innerConn,
@@ -26,10 +41,12 @@ func newDtxMessageClient(innerConn InnerConn) *dtxMessageClient {
 		openedChannels:    make(map[string]uint32),
 		toReply:           make(chan *dtxMessageHeaderPacket),
 
-		mu:        sync.Mutex{},
-		resultMap: make(map[interface{}]*DTXMessageResult),
+		timeout:      DefaultDTXReplyTimeout,
+		waiters:      make(map[uint32]*dtxReplyWaiter),
+		capabilities: make(chan *DTXMessageResult, 1),
 
-		callbackMap: make(map[string]func(m DTXMessageResult)),
+		callbackMap:        make(map[string]func(m DTXMessageResult)),
+		channelCallbackMap: make(map[uint32]func(m DTXMessageResult)),
 	}
 	c.RegisterCallback(_unregistered, func(m DTXMessageResult) {})
 	c.RegisterCallback(_over, func(m DTXMessageResult) {})
@@ -41,23 +58,64 @@ func newDtxMessageClient(innerConn InnerConn) *dtxMessageClient {
 
 type dtxMessageClient struct {
 	innerConn InnerConn
-	msgID     uint32
+	writeMu   sync.Mutex
+
+	mu      sync.Mutex
+	msgID   uint32
+	timeout time.Duration
+	closed  error
 
 	publishedChannels map[string]int32
 	openedChannels    map[string]uint32
+	lastChannelCode   uint32
+	channelMu         sync.Mutex // serializes `_requestChannelWithCode:identifier:`
 
 	toReply chan *dtxMessageHeaderPacket
 
-	mu        sync.Mutex
-	resultMap map[interface{}]*DTXMessageResult
+	waiters      map[uint32]*dtxReplyWaiter
+	capabilities chan *DTXMessageResult
 
-	callbackMap map[string]func(m DTXMessageResult)
+	callbackMap        map[string]func(m DTXMessageResult)
+	channelCallbackMap map[uint32]func(m DTXMessageResult)
 
 	ctx        context.Context
 	cancelFunc context.CancelFunc
 }
 
+// dtxReplyWaiter receives the reply to one request, or the reason it will never come
+type dtxReplyWaiter struct {
+	channelCode uint32
+	reply       chan dtxReply
+}
+
+type dtxReply struct {
+	result *DTXMessageResult
+	err    error
+}
+
+// dtxChannelCode messages the device sends on a channel opened by us carry
+// the negated channel code
+func dtxChannelCode(channelCode uint32) uint32 {
+	if code := int32(channelCode); code < 0 {
+		return uint32(-code)
+	}
+	return channelCode
+}
+
+// SetTimeout sets how long Invoke waits for a reply when its context has no deadline,
+// zero waits forever
+func (c *dtxMessageClient) SetTimeout(timeout time.Duration) {
+	c.mu.Lock()
+	c.timeout = timeout
+	c.mu.Unlock()
+}
+
 func (c *dtxMessageClient) SendDTXMessage(selector string, aux []byte, channelCode uint32, expectsReply bool) (msgID uint32, err error) {
+	msgID, _, err = c.send(selector, aux, channelCode, expectsReply, false)
+	return
+}
+
+func (c *dtxMessageClient) send(selector string, aux []byte, channelCode uint32, expectsReply, wait bool) (msgID uint32, waiter *dtxReplyWaiter, err error) {
 	payload := new(dtxMessagePayloadPacket)
 	header := &dtxMessageHeaderPacket{
 		ExpectsReply: 1,
@@ -71,7 +129,7 @@ func (c *dtxMessageClient) SendDTXMessage(selector string, aux []byte, channelCo
 
 	var sel []byte
 	if sel, err = nskeyedarchiver.Marshal(selector); err != nil {
-		return 0, err
+		return 0, nil, err
 	}
 
 	if aux == nil {
@@ -87,11 +145,24 @@ func (c *dtxMessageClient) SendDTXMessage(selector string, aux []byte, channelCo
 	header.FragmentId = 0
 	header.FragmentCount = 1
 	header.Length = uint32(unsafe.Sizeof(*payload)) + uint32(payload.TotalLength)
-	c.msgID++
-	header.Identifier = c.msgID
 	header.ConversationIndex = 0
 	header.ChannelCode = channelCode
 
+	c.mu.Lock()
+	if c.closed != nil {
+		err = c.closed
+		c.mu.Unlock()
+		return 0, nil, err
+	}
+	c.msgID++
+	msgID = c.msgID
+	if expectsReply && wait {
+		waiter = &dtxReplyWaiter{channelCode: dtxChannelCode(channelCode), reply: make(chan dtxReply, 1)}
+		c.waiters[msgID] = waiter
+	}
+	c.mu.Unlock()
+	header.Identifier = msgID
+
 	msgPkt := new(dtxMessagePacket)
 	msgPkt.Header = header
 	msgPkt.Payload = payload
@@ -99,21 +170,69 @@ func (c *dtxMessageClient) SendDTXMessage(selector string, aux []byte, channelCo
 	msgPkt.Sel = sel
 
 	raw, err := msgPkt.Pack()
+	if err == nil {
+		debugLog(fmt.Sprintf("--> %s\n", msgPkt))
+		c.writeMu.Lock()
+		err = c.innerConn.Write(raw)
+		c.writeMu.Unlock()
+	}
 	if err != nil {
-		return 0, err
+		if waiter != nil {
+			c.mu.Lock()
+			delete(c.waiters, msgID)
+			c.mu.Unlock()
+		}
+		return 0, nil, err
 	}
-
-	debugLog(fmt.Sprintf("--> %s\n", msgPkt))
-	msgID = header.Identifier
-	err = c.innerConn.Write(raw)
 	return
 }
 
+// Invoke sends selector on the channel and, if expectsReply, waits for the reply
+// until ctx is done. Without a deadline on ctx the client timeout applies.
+func (c *dtxMessageClient) Invoke(ctx context.Context, selector string, aux []byte, channelCode uint32, expectsReply bool) (result *DTXMessageResult, err error) {
+	var msgID uint32
+	var waiter *dtxReplyWaiter
+	if msgID, waiter, err = c.send(selector, aux, channelCode, expectsReply, true); err != nil || !expectsReply {
+		return nil, err
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		c.mu.Lock()
+		timeout := c.timeout
+		c.mu.Unlock()
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+	}
+
+	select {
+	case reply := <-waiter.reply:
+		if reply.err != nil {
+			return nil, fmt.Errorf("dtx: %s: %w", selector, reply.err)
+		}
+		reply.result.Header = []byte{0x6F, 0x53, 0x45, 0x45, 0x73, 0x2F}
+		return reply.result, nil
+	case <-ctx.Done():
+		c.mu.Lock()
+		delete(c.waiters, msgID)
+		c.mu.Unlock()
+		return nil, fmt.Errorf("dtx: %s: wait for reply %d: %w", selector, msgID, ctx.Err())
+	}
+}
+
 func (c *dtxMessageClient) ReceiveDTXMessage() (result *DTXMessageResult, err error) {
-	bufPayload := new(bytes.Buffer)
+	var header *dtxMessageHeaderPacket
+	if header, result, err = c.readDTXMessage(); err != nil {
+		return nil, err
+	}
+	c.dispatch(header, result)
+	return
+}
 
-	header := new(dtxMessageHeaderPacket)
-	var needToReply *dtxMessageHeaderPacket = nil
+func (c *dtxMessageClient) readDTXMessage() (header *dtxMessageHeaderPacket, result *DTXMessageResult, err error) {
+	bufPayload := new(bytes.Buffer)
 
 	for {
 		header = new(dtxMessageHeaderPacket)
@@ -121,31 +240,19 @@ func (c *dtxMessageClient) ReceiveDTXMessage() (result *DTXMessageResult, err er
 		lenHeader := int(unsafe.Sizeof(*header))
 		var bufHeader []byte
 		if bufHeader, err = c.innerConn.Read(lenHeader); err != nil {
-			return nil, fmt.Errorf("receive: length of DTXMessageHeader: %w", err)
+			return nil, nil, fmt.Errorf("receive: length of DTXMessageHeader: %w", err)
 		}
 
 		if header, err = header.unpack(bytes.NewBuffer(bufHeader)); err != nil {
-			return nil, fmt.Errorf("receive: DTXMessageHeader unpack: %w", err)
-		}
-
-		if header.ExpectsReply == 1 {
-			needToReply = header
+			return nil, nil, fmt.Errorf("receive: DTXMessageHeader unpack: %w", err)
 		}
 
 		if header.Magic != 0x1F3D5B79 {
-			return nil, fmt.Errorf("receive: bad magic %x", header.Magic)
+			return nil, nil, fmt.Errorf("receive: bad magic %x", header.Magic)
 		}
 
-		if header.ConversationIndex == 1 {
-			if header.Identifier != c.msgID {
-				return nil, fmt.Errorf("receive: except identifier %d new identifier %d", c.msgID, header.Identifier)
-			}
-		} else if header.ConversationIndex == 0 {
-			if header.Identifier > c.msgID {
-				c.msgID = header.Identifier
-			}
-		} else {
-			return nil, fmt.Errorf("receive: invalid conversationIndex %d", header.ConversationIndex)
+		if header.ConversationIndex > 1 {
+			return nil, nil, fmt.Errorf("receive: invalid conversationIndex %d", header.ConversationIndex)
 		}
 
 		if header.FragmentId == 0 && header.FragmentCount > 1 {
@@ -154,7 +261,7 @@ func (c *dtxMessageClient) ReceiveDTXMessage() (result *DTXMessageResult, err er
 
 		var data []byte
 		if data, err = c.innerConn.Read(int(header.Length)); err != nil {
-			return nil, fmt.Errorf("receive: length of DTXMessageHeader: %w", err)
+			return nil, nil, fmt.Errorf("receive: length of DTXMessageHeader: %w", err)
 		}
 		bufPayload.Write(data)
 
@@ -166,15 +273,20 @@ func (c *dtxMessageClient) ReceiveDTXMessage() (result *DTXMessageResult, err er
 	rawPayload := bufPayload.Bytes()
 	payload := new(dtxMessagePayloadPacket)
 	if payload, err = payload.unpack(bufPayload); err != nil {
-		return nil, fmt.Errorf("receive: unpack DTXMessagePayload: %w", err)
+		return nil, nil, fmt.Errorf("receive: unpack DTXMessagePayload: %w", err)
 	}
 
+	payloadSize := uint32(unsafe.Sizeof(*payload))
+
 	compress := (payload.Flags & 0xff000) >> 12
 	if compress != 0 {
-		return nil, fmt.Errorf("receive: message is compressed type %d", compress)
+		var data []byte
+		if data, err = decompressDTXPayload(payload, rawPayload[payloadSize:]); err != nil {
+			return nil, nil, fmt.Errorf("receive: message is compressed type %d: %w", compress, err)
+		}
+		rawPayload = append(rawPayload[:payloadSize:payloadSize], data...)
 	}
 
-	payloadSize := uint32(unsafe.Sizeof(*payload))
 	objOffset := uint64(payloadSize + payload.AuxiliaryLength)
 
 	var aux, obj []byte
@@ -209,7 +321,7 @@ func (c *dtxMessageClient) ReceiveDTXMessage() (result *DTXMessageResult, err er
 
 	if len(aux) > 0 {
 		if aux, err := UnmarshalAuxBuffer(aux); err != nil {
-			return nil, fmt.Errorf("receive: unpack AUX: %w", err)
+			return header, nil, fmt.Errorf("receive: unpack AUX: %w", err)
 		} else {
 			result.Aux = aux
 		}
@@ -217,37 +329,106 @@ func (c *dtxMessageClient) ReceiveDTXMessage() (result *DTXMessageResult, err er
 
 	if len(obj) > 0 {
 		if obj, err := NewNSKeyedArchiver().Unmarshal(obj); err != nil {
-			return nil, fmt.Errorf("receive: unpack NSKeyedArchiver: %w", err)
+			return header, nil, fmt.Errorf("receive: unpack NSKeyedArchiver: %w", err)
 		} else {
 			result.Obj = obj
 		}
 	}
 
-	sObj, ok := result.Obj.(string)
-	if fn, do := c.callbackMap[sObj]; do {
-		fn(*result)
-	} else {
-		c.callbackMap[_unregistered](*result)
+	return
+}
+
+// dispatch hands a reply to the request waiting for it, and any other message
+// to the callback of its channel, of its selector, or `_unregistered`
+func (c *dtxMessageClient) dispatch(header *dtxMessageHeaderPacket, result *DTXMessageResult) {
+	if header.ConversationIndex == 1 {
+		c.mu.Lock()
+		waiter, ok := c.waiters[header.Identifier]
+		delete(c.waiters, header.Identifier)
+		c.mu.Unlock()
+		if ok {
+			waiter.reply <- dtxReply{result: result}
+		} else {
+			debugLog(fmt.Sprintf("dtx: unexpected reply %d on channel %d", header.Identifier, header.ChannelCode))
+		}
+		return
 	}
 
-	if needToReply != nil {
-		go func() { c.toReply <- needToReply }()
-	} else {
-		var sk interface{} = header.Identifier
+	c.mu.Lock()
+	if header.Identifier > c.msgID {
+		c.msgID = header.Identifier
+	}
+	c.mu.Unlock()
+
+	if header.ExpectsReply == 1 {
+		go func() {
+			select {
+			case c.toReply <- header:
+			case <-c.ctx.Done():
+			}
+		}()
+	}
 
-		if ok && sObj == "_notifyOfPublishedCapabilities:" {
-			sk = "_notifyOfPublishedCapabilities:"
+	sObj, _ := result.Obj.(string)
+	switch sObj {
+	case "_notifyOfPublishedCapabilities:":
+		select {
+		case c.capabilities <- result:
+		default:
 		}
-		c.mu.Lock()
-		c.resultMap[sk] = result
-		c.mu.Unlock()
+	case "_channelCanceled:":
+		channelCode := dtxChannelCode(header.ChannelCode)
+		if len(result.Aux) > 0 {
+			if code, ok := result.Aux[0].(int32); ok {
+				channelCode = dtxChannelCode(uint32(code))
+			}
+		}
+		c.channelCanceled(channelCode)
+	}
 
+	c.mu.Lock()
+	fn, ok := c.channelCallbackMap[dtxChannelCode(header.ChannelCode)]
+	if !ok {
+		if fn, ok = c.callbackMap[sObj]; !ok {
+			fn = c.callbackMap[_unregistered]
+		}
 	}
+	c.mu.Unlock()
+	fn(*result)
+}
 
-	return
+// channelCanceled forgets the channel and fails the requests still waiting on it
+func (c *dtxMessageClient) channelCanceled(channelCode uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.channelCallbackMap, channelCode)
+	for channel, code := range c.openedChannels {
+		if code == channelCode {
+			delete(c.openedChannels, channel)
+		}
+	}
+	for msgID, waiter := range c.waiters {
+		if waiter.channelCode == channelCode {
+			delete(c.waiters, msgID)
+			waiter.reply <- dtxReply{err: ErrDTXChannelCanceled}
+		}
+	}
+}
+
+// closeWaiters fails every pending and future request with err
+func (c *dtxMessageClient) closeWaiters(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed == nil {
+		c.closed = err
+	}
+	for msgID, waiter := range c.waiters {
+		delete(c.waiters, msgID)
+		waiter.reply <- dtxReply{err: err}
+	}
 }
 
-func (c *dtxMessageClient) Connection() (publishedChannels map[string]int32, err error) {
+func (c *dtxMessageClient) Connection(ctx context.Context) (publishedChannels map[string]int32, err error) {
 	args := NewAuxBuffer()
 	if err = args.AppendObject(map[string]interface{}{
 		"com.apple.private.DTXBlockCompression": uint64(2),
@@ -261,30 +442,64 @@ func (c *dtxMessageClient) Connection() (publishedChannels map[string]int32, err
 		return nil, fmt.Errorf("connection send: %w", err)
 	}
 
+	if _, ok := ctx.Deadline(); !ok {
+		c.mu.Lock()
+		timeout := c.timeout
+		c.mu.Unlock()
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+	}
+
 	var result *DTXMessageResult
-	if result, err = c.GetResult(selector); err != nil {
-		return nil, fmt.Errorf("connection receive: %w", err)
+	select {
+	case result = <-c.capabilities:
+	case <-c.ctx.Done():
+		return nil, fmt.Errorf("connection receive: %w", ErrDTXConnectionClosed)
+	case <-ctx.Done():
+		return nil, fmt.Errorf("connection receive: %w", ctx.Err())
 	}
 
-	if result.Obj.(string) != "_notifyOfPublishedCapabilities:" {
-		return nil, fmt.Errorf("connection: response mismatch: %s", result.Obj)
+	if len(result.Aux) == 0 {
+		return nil, fmt.Errorf("connection: response without capabilities")
+	}
+	aux, ok := result.Aux[0].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("connection: unexpected capabilities %v", result.Aux[0])
 	}
 
-	aux := result.Aux[0].(map[string]interface{})
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	for k, v := range aux {
-		c.publishedChannels[k] = int32(v.(uint64))
+		if n, ok := v.(uint64); ok {
+			c.publishedChannels[k] = int32(n)
+		}
 	}
 
-	return c.publishedChannels, nil
+	publishedChannels = make(map[string]int32, len(c.publishedChannels))
+	for k, v := range c.publishedChannels {
+		publishedChannels[k] = v
+	}
+	return
 }
 
-func (c *dtxMessageClient) MakeChannel(channel string) (id uint32, err error) {
-	var ok bool
-	if id, ok = c.openedChannels[channel]; ok {
+func (c *dtxMessageClient) MakeChannel(ctx context.Context, channel string) (id uint32, err error) {
+	c.channelMu.Lock()
+	defer c.channelMu.Unlock()
+
+	c.mu.Lock()
+	id, ok := c.openedChannels[channel]
+	if !ok {
+		c.lastChannelCode++
+		id = c.lastChannelCode
+	}
+	c.mu.Unlock()
+	if ok {
 		return id, nil
 	}
 
-	id = uint32(len(c.openedChannels) + 1)
 	args := NewAuxBuffer()
 	args.AppendInt32(int32(id))
 	if err = args.AppendObject(channel); err != nil {
@@ -292,46 +507,49 @@ func (c *dtxMessageClient) MakeChannel(channel string) (id uint32, err error) {
 	}
 
 	selector := "_requestChannelWithCode:identifier:"
-
-	var msgID uint32
-	if msgID, err = c.SendDTXMessage(selector, args.Bytes(), 0, true); err != nil {
-		return 0, fmt.Errorf("make channel send: %w", err)
-	}
-
-	if _, err = c.GetResult(msgID); err != nil {
-		return 0, fmt.Errorf("make channel receive: %w", err)
+	if _, err = c.Invoke(ctx, selector, args.Bytes(), 0, true); err != nil {
+		return 0, fmt.Errorf("make channel: %w", err)
 	}
 
+	c.mu.Lock()
 	c.openedChannels[channel] = id
+	c.mu.Unlock()
+
+	return
+}
 
+// CancelChannel tells the device to tear the channel down and forgets it locally
+func (c *dtxMessageClient) CancelChannel(channelCode uint32) (err error) {
+	args := NewAuxBuffer()
+	args.AppendInt32(int32(channelCode))
+	_, err = c.SendDTXMessage("_channelCanceled:", args.Bytes(), 0, false)
+	c.channelCanceled(dtxChannelCode(channelCode))
 	return
 }
 
 func (c *dtxMessageClient) RegisterCallback(obj string, cb func(m DTXMessageResult)) {
+	c.mu.Lock()
 	c.callbackMap[obj] = cb
+	c.mu.Unlock()
 }
 
-func (c *dtxMessageClient) GetResult(key interface{}) (*DTXMessageResult, error) {
-	startTime := time.Now()
-	for {
-		time.Sleep(100 * time.Millisecond)
-		c.mu.Lock()
-		if v, ok := c.resultMap[key]; ok {
-			delete(c.resultMap, key)
-			c.mu.Unlock()
-			v.Header = []byte{0x6F, 0x53, 0x45, 0x45, 0x73, 0x2F}
-			return v, nil
-		} else {
-			c.mu.Unlock()
-		}
-		if elapsed := time.Since(startTime); elapsed > 30*time.Second {
-			return nil, fmt.Errorf("dtx: get result: timeout after %v", elapsed)
-		}
-	}
+// RegisterChannelCallback receives every message the device sends on the
+// channel, in place of the selector callbacks
+func (c *dtxMessageClient) RegisterChannelCallback(channelCode uint32, cb func(m DTXMessageResult)) {
+	c.mu.Lock()
+	c.channelCallbackMap[dtxChannelCode(channelCode)] = cb
+	c.mu.Unlock()
+}
+
+func (c *dtxMessageClient) UnregisterChannelCallback(channelCode uint32) {
+	c.mu.Lock()
+	delete(c.channelCallbackMap, dtxChannelCode(channelCode))
+	c.mu.Unlock()
 }
 
 func (c *dtxMessageClient) Close() {
 	c.cancelFunc()
+	c.closeWaiters(ErrDTXConnectionClosed)
 	c.innerConn.Close()
 }
 
@@ -344,10 +562,14 @@ func (c *dtxMessageClient) startReceive() {
 			default:
 				if _, err := c.ReceiveDTXMessage(); err != nil {
 					debugLog(fmt.Sprintf("dtx: receive: %s", err))
-					if strings.Contains(err.Error(), io.EOF.Error()) {
+					if errors.Is(err, io.EOF) || errors.Is(err, io.ErrClosedPipe) || errors.Is(err, net.ErrClosed) {
 						c.cancelFunc()
-						c.callbackMap[_over](DTXMessageResult{})
-						break
+						c.closeWaiters(fmt.Errorf("%w: %s", ErrDTXConnectionClosed, err))
+						c.mu.Lock()
+						fn := c.callbackMap[_over]
+						c.mu.Unlock()
+						fn(DTXMessageResult{})
+						return
 					}
 				}
 			}
@@ -391,7 +613,10 @@ func (c *dtxMessageClient) startWaitingForReply() {
 					continue
 				}
 
-				if err = c.innerConn.Write(raw); err != nil {
+				c.writeMu.Lock()
+				err = c.innerConn.Write(raw)
+				c.writeMu.Unlock()
+				if err != nil {
 					debugLog(fmt.Sprintf("send: reply DTXMessage: %s", err))
 					continue
 				}
@@ -400,8 +625,36 @@ func (c *dtxMessageClient) startWaitingForReply() {
 	}()
 }
 
+// decompressDTXPayload the auxiliary and object sections of a compressed
+// message form one libcompression stream (LZ4 or LZFSE), which may be
+// preceded by its uncompressed length. Which of the two framings devices send
+// is not settled by a captured frame yet, see Test_dtxMessageClient_ReceiveCaptured
+func decompressDTXPayload(payload *dtxMessagePayloadPacket, data []byte) (raw []byte, err error) {
+	if !compression.IsStream(data) && len(data) > 4 && compression.IsStream(data[4:]) {
+		if n := binary.LittleEndian.Uint32(data); uint64(n) != payload.TotalLength {
+			return nil, fmt.Errorf("uncompressed length %d, expected %d", n, payload.TotalLength)
+		}
+		data = data[4:]
+	}
+	if raw, err = compression.Decode(data); err != nil {
+		return nil, err
+	}
+	if uint64(len(raw)) != payload.TotalLength {
+		return nil, fmt.Errorf("decompressed %d bytes, expected %d", len(raw), payload.TotalLength)
+	}
+	return
+}
+
 type DTXMessageResult struct {
 	Obj    interface{}
 	Aux    []interface{}
 	Header []byte
 }
+
+// Err the NSError the remote method replied with, if any
+func (r *DTXMessageResult) Err() error {
+	if nsErr, ok := r.Obj.(NSError); ok {
+		return nsErr
+	}
+	return nil
+}
@@ -0,0 +1,359 @@
+package libimobiledevice
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/nskeyedarchiver"
+)
+
+// dtxCompressedSysmontap the AuxBuffer and selector of a "sysmontapData:"
+// message, compressed as one LZ4 block (427 bytes uncompressed)
+const (
+	dtxCompressedSysmontapAuxLength = 275
+	dtxCompressedSysmontapLength    = 427
+	dtxCompressedSysmontapLZ4       = "" +
+		"31f00100010013030800f33a0a00000002000000f700000062706c6973743030d4010203040506161959246172636869" +
+		"76657258246f626a656374735424746f70582476657273696f6e5f100f4e534b65796564412900f219a70708090f1213" +
+		"14546e616d655b537072696e67426f617264d20a0b0c0d5824636c61737365735a0900002500f105a20d0e5c4e534469" +
+		"6374696f6e617279584e534f6c00f239d20a0b1011a2110e574e53417272617954547970651007d20a0b150da20d0ed1" +
+		"171854726f6f74800112000186a008111b242932444c515d626b7679868f94979fa4a6abaeb1b6b8e9001301f1003a00" +
+		"1a0001001bbdf7002f090cf70020ff0ca2070855246e756c6c5e7379736d6f6e746170446174613ad10a0ba500006b47" +
+		"4d5c5f64669800170d940050000000006b"
+)
+
+func newDtxFrame(t *testing.T, flags uint32, data []byte) []byte {
+	payload := &dtxMessagePayloadPacket{
+		Flags:           flags,
+		AuxiliaryLength: dtxCompressedSysmontapAuxLength,
+		TotalLength:     dtxCompressedSysmontapLength,
+	}
+	header := &dtxMessageHeaderPacket{
+		Magic:         0x1F3D5B79,
+		FragmentCount: 1,
+		Length:        uint32(unsafe.Sizeof(*payload)) + uint32(len(data)),
+		Identifier:    7,
+	}
+	header.CB = uint32(unsafe.Sizeof(*header))
+
+	raw, err := (&dtxMessagePacket{Header: header, Payload: payload, Sel: data}).Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func receiveDtxFrame(t *testing.T, frame []byte) (*DTXMessageResult, error) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	go func() { _, _ = remote.Write(frame) }()
+
+	c := &dtxMessageClient{innerConn: newInnerConn(local, 0)}
+	_, result, err := c.readDTXMessage()
+	return result, err
+}
+
+func Test_dtxMessageClient_ReceiveCompressed(t *testing.T) {
+	block, err := hex.DecodeString(dtxCompressedSysmontapLZ4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := new(bytes.Buffer)
+	stream.WriteString("bv41")
+	_ = binary.Write(stream, binary.LittleEndian, uint32(dtxCompressedSysmontapLength))
+	_ = binary.Write(stream, binary.LittleEndian, uint32(len(block)))
+	stream.Write(block)
+	stream.WriteString("bv4$")
+
+	prefixed := make([]byte, 4, 4+stream.Len())
+	binary.LittleEndian.PutUint32(prefixed, dtxCompressedSysmontapLength)
+	prefixed = append(prefixed, stream.Bytes()...)
+
+	for name, data := range map[string][]byte{"stream": stream.Bytes(), "length prefixed": prefixed} {
+		result, err := receiveDtxFrame(t, newDtxFrame(t, 0x2002, data))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if result.Obj != "sysmontapData:" {
+			t.Fatalf("%s: obj %v", name, result.Obj)
+		}
+		if len(result.Aux) != 1 {
+			t.Fatalf("%s: aux %v", name, result.Aux)
+		}
+	}
+
+	if _, err = receiveDtxFrame(t, newDtxFrame(t, 0x2002, block)); err == nil {
+		t.Fatal("decoded a block without stream header")
+	}
+}
+
+// Test_dtxMessageClient_ReceiveCaptured reads the compressed frames kept in testdata,
+// `dtx_compressed_*.bin`, each a whole message as a device sent it to instruments
+func Test_dtxMessageClient_ReceiveCaptured(t *testing.T) {
+	frames, err := filepath.Glob(filepath.Join("testdata", "dtx_compressed_*.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) == 0 {
+		t.Skip("no captured frames in testdata")
+	}
+	for _, name := range frames {
+		frame, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		header, err := new(dtxMessageHeaderPacket).unpack(bytes.NewBuffer(frame))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		payload, err := new(dtxMessagePayloadPacket).unpack(bytes.NewBuffer(frame[header.CB:]))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if payload.Flags&0xff000 == 0 {
+			t.Fatalf("%s: not compressed, flags %#x", name, payload.Flags)
+		}
+		result, err := receiveDtxFrame(t, frame)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if result.Obj == nil {
+			t.Fatalf("%s: no object", name)
+		}
+	}
+}
+
+// dtxTestDevice plays the device end of a dtxMessageClient
+type dtxTestDevice struct {
+	t    *testing.T
+	conn net.Conn
+}
+
+func newDtxTestClient(t *testing.T) (*dtxMessageClient, *dtxTestDevice) {
+	local, remote := net.Pipe()
+	c := newDtxMessageClient(newInnerConn(local, 0))
+	t.Cleanup(func() {
+		c.Close()
+		_ = remote.Close()
+	})
+	return c, &dtxTestDevice{t: t, conn: remote}
+}
+
+func (d *dtxTestDevice) read() (header *dtxMessageHeaderPacket, selector string, aux []interface{}) {
+	d.t.Helper()
+	buf := make([]byte, unsafe.Sizeof(dtxMessageHeaderPacket{}))
+	if _, err := io.ReadFull(d.conn, buf); err != nil {
+		d.t.Fatal(err)
+	}
+	header, err := new(dtxMessageHeaderPacket).unpack(bytes.NewBuffer(buf))
+	if err != nil {
+		d.t.Fatal(err)
+	}
+	data := make([]byte, header.Length)
+	if _, err = io.ReadFull(d.conn, data); err != nil {
+		d.t.Fatal(err)
+	}
+	payload, err := new(dtxMessagePayloadPacket).unpack(bytes.NewBuffer(data))
+	if err != nil {
+		d.t.Fatal(err)
+	}
+	data = data[unsafe.Sizeof(*payload):]
+	if payload.AuxiliaryLength > 0 {
+		if aux, err = UnmarshalAuxBuffer(data[:payload.AuxiliaryLength]); err != nil {
+			d.t.Fatal(err)
+		}
+	}
+	obj, err := NewNSKeyedArchiver().Unmarshal(data[payload.AuxiliaryLength:])
+	if err != nil {
+		d.t.Fatal(err)
+	}
+	selector, _ = obj.(string)
+	return
+}
+
+func (d *dtxTestDevice) write(identifier, conversationIndex, channelCode uint32, obj interface{}, aux *AuxBuffer) {
+	d.t.Helper()
+	sel, err := nskeyedarchiver.Marshal(obj)
+	if err != nil {
+		d.t.Fatal(err)
+	}
+	var bAux []byte
+	if aux != nil {
+		bAux = aux.Bytes()
+	}
+	payload := &dtxMessagePayloadPacket{
+		Flags:           0x2,
+		AuxiliaryLength: uint32(len(bAux)),
+		TotalLength:     uint64(len(bAux) + len(sel)),
+	}
+	header := &dtxMessageHeaderPacket{
+		Magic:             0x1F3D5B79,
+		FragmentCount:     1,
+		Length:            uint32(unsafe.Sizeof(*payload)) + uint32(payload.TotalLength),
+		Identifier:        identifier,
+		ConversationIndex: conversationIndex,
+		ChannelCode:       channelCode,
+	}
+	header.CB = uint32(unsafe.Sizeof(*header))
+	raw, err := (&dtxMessagePacket{Header: header, Payload: payload, Aux: bAux, Sel: sel}).Pack()
+	if err != nil {
+		d.t.Fatal(err)
+	}
+	if _, err = d.conn.Write(raw); err != nil {
+		d.t.Fatal(err)
+	}
+}
+
+type dtxTestReply struct {
+	selector string
+	result   *DTXMessageResult
+	err      error
+}
+
+func invokeAsync(c *dtxMessageClient, ctx context.Context, selector string, channelCode uint32) <-chan dtxTestReply {
+	done := make(chan dtxTestReply, 1)
+	go func() {
+		result, err := c.Invoke(ctx, selector, nil, channelCode, true)
+		done <- dtxTestReply{selector: selector, result: result, err: err}
+	}()
+	return done
+}
+
+func Test_dtxMessageClient_Invoke(t *testing.T) {
+	c, d := newDtxTestClient(t)
+
+	first := invokeAsync(c, context.Background(), "first", 1)
+	header1, sel1, _ := d.read()
+	second := invokeAsync(c, context.Background(), "second", 2)
+	header2, sel2, _ := d.read()
+	if sel1 != "first" || sel2 != "second" || header1.Identifier == header2.Identifier {
+		t.Fatalf("requests %d %q, %d %q", header1.Identifier, sel1, header2.Identifier, sel2)
+	}
+
+	// out of order
+	d.write(header2.Identifier, 1, header2.ChannelCode, "reply to second", nil)
+	d.write(header1.Identifier, 1, header1.ChannelCode, "reply to first", nil)
+
+	for _, done := range []<-chan dtxTestReply{first, second} {
+		reply := <-done
+		if reply.err != nil {
+			t.Fatal(reply.err)
+		}
+		if reply.result.Obj != "reply to "+reply.selector {
+			t.Fatalf("%s: got %v", reply.selector, reply.result.Obj)
+		}
+	}
+}
+
+func Test_dtxMessageClient_InvokeTimeout(t *testing.T) {
+	c, d := newDtxTestClient(t)
+	c.SetTimeout(50 * time.Millisecond)
+
+	done := invokeAsync(c, context.Background(), "slow", 1)
+	header, _, _ := d.read()
+	if reply := <-done; !errors.Is(reply.err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", reply.err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done = invokeAsync(c, ctx, "canceled", 1)
+	d.read()
+	cancel()
+	if reply := <-done; !errors.Is(reply.err, context.Canceled) {
+		t.Fatalf("expected canceled, got %v", reply.err)
+	}
+
+	// a late reply is dropped
+	d.write(header.Identifier, 1, header.ChannelCode, "late", nil)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.waiters) != 0 {
+		t.Fatalf("%d waiters left", len(c.waiters))
+	}
+}
+
+func Test_dtxMessageClient_ChannelCallback(t *testing.T) {
+	c, d := newDtxTestClient(t)
+
+	received := make(chan string, 3)
+	c.RegisterChannelCallback(1, func(m DTXMessageResult) { received <- "channel 1: " + m.Obj.(string) })
+	c.RegisterChannelCallback(2, func(m DTXMessageResult) { received <- "channel 2: " + m.Obj.(string) })
+	c.RegisterCallback("selector", func(m DTXMessageResult) { received <- "selector" })
+
+	// the device sends on our channels with negated codes
+	d.write(10, 0, 0xFFFFFFFE, "data", nil)
+	d.write(11, 0, 0xFFFFFFFF, "data", nil)
+	d.write(12, 0, 3, "selector", nil)
+
+	for _, expected := range []string{"channel 2: data", "channel 1: data", "selector"} {
+		select {
+		case got := <-received:
+			if got != expected {
+				t.Fatalf("expected %q, got %q", expected, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%q not dispatched", expected)
+		}
+	}
+}
+
+func Test_dtxMessageClient_ChannelCanceled(t *testing.T) {
+	c, d := newDtxTestClient(t)
+
+	made := make(chan error, 1)
+	go func() {
+		_, err := c.MakeChannel(context.Background(), "com.apple.instruments.server.services.sysmontap")
+		made <- err
+	}()
+	header, sel, aux := d.read()
+	if sel != "_requestChannelWithCode:identifier:" || len(aux) != 2 || aux[0] != int32(1) {
+		t.Fatalf("request channel: %q %v", sel, aux)
+	}
+	d.write(header.Identifier, 1, 0, "ok", nil)
+	if err := <-made; err != nil {
+		t.Fatal(err)
+	}
+	c.RegisterChannelCallback(1, func(m DTXMessageResult) {})
+
+	done := invokeAsync(c, context.Background(), "start", 1)
+	d.read()
+
+	args := NewAuxBuffer()
+	args.AppendInt32(1)
+	d.write(20, 0, 0, "_channelCanceled:", args)
+
+	if reply := <-done; !errors.Is(reply.err, ErrDTXChannelCanceled) {
+		t.Fatalf("expected channel canceled, got %v", reply.err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.openedChannels) != 0 || len(c.channelCallbackMap) != 0 {
+		t.Fatalf("channel kept: %v, %d callbacks", c.openedChannels, len(c.channelCallbackMap))
+	}
+}
+
+func Test_dtxMessageClient_ConnectionClosed(t *testing.T) {
+	c, d := newDtxTestClient(t)
+
+	done := invokeAsync(c, context.Background(), "pending", 1)
+	d.read()
+	_ = d.conn.Close()
+
+	if reply := <-done; !errors.Is(reply.err, ErrDTXConnectionClosed) {
+		t.Fatalf("expected connection closed, got %v", reply.err)
+	}
+	if _, err := c.Invoke(context.Background(), "after", nil, 1, true); !errors.Is(err, ErrDTXConnectionClosed) {
+		t.Fatalf("expected connection closed, got %v", err)
+	}
+}
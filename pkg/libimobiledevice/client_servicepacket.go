@@ -46,6 +46,24 @@ func (c *servicePacketClient) SendPacket(pkt Packet) (err error) {
 }
 
 func (c *servicePacketClient) ReceivePacket() (respPkt Packet, err error) {
+	if respPkt, err = c.receivePacket(); err != nil {
+		return nil, err
+	}
+
+	var reply LockdownBasicResponse
+	if err = respPkt.Unmarshal(&reply); err != nil {
+		return nil, fmt.Errorf("receive packet: %w", err)
+	}
+
+	if reply.Error != "" {
+		return nil, fmt.Errorf("receive packet: %w", LockdownError(reply.Error))
+	}
+
+	return
+}
+
+// receivePacket reads the next packet without looking at its `Error`
+func (c *servicePacketClient) receivePacket() (respPkt Packet, err error) {
 	var bufLen []byte
 	if bufLen, err = c.innerConn.Read(4); err != nil {
 		return nil, fmt.Errorf("receive packet: %w", err)
@@ -67,14 +85,5 @@ func (c *servicePacketClient) ReceivePacket() (respPkt Packet, err error) {
 
 	debugLog(fmt.Sprintf("<-- %s\n", respPkt))
 
-	var reply LockdownBasicResponse
-	if err = respPkt.Unmarshal(&reply); err != nil {
-		return nil, fmt.Errorf("receive packet: %w", err)
-	}
-
-	if reply.Error != "" {
-		return nil, fmt.Errorf("receive packet: %s", reply.Error)
-	}
-
 	return
 }
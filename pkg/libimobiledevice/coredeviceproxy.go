@@ -0,0 +1,5 @@
+package libimobiledevice
+
+// CoreDeviceProxyServiceName the lockdown service of the tunnel to the device (iOS 17.4+),
+// see pkg/tunnel
+const CoreDeviceProxyServiceName = "com.apple.internal.devicecompute.CoreDeviceProxy"
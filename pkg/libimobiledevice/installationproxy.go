@@ -1,7 +1,51 @@
 package libimobiledevice
 
+import "fmt"
+
 const InstallationProxyServiceName = "com.apple.mobile.installation_proxy"
 
+// InstallationProxyErrorCode the `Error` installation_proxy replied with,
+// e.g. errors.Is(err, ErrApplicationVerificationFailed)
+type InstallationProxyErrorCode string
+
+func (c InstallationProxyErrorCode) Error() string {
+	return string(c)
+}
+
+const (
+	ErrAPIInternalError              InstallationProxyErrorCode = "APIInternalError"
+	ErrAlreadyArchived               InstallationProxyErrorCode = "AlreadyArchived"
+	ErrApplicationAlreadyInstalled   InstallationProxyErrorCode = "ApplicationAlreadyInstalled"
+	ErrApplicationVerificationFailed InstallationProxyErrorCode = "ApplicationVerificationFailed"
+	ErrDeviceOSVersionTooLow         InstallationProxyErrorCode = "DeviceOSVersionTooLow"
+	ErrDeviceFamilyNotSupported      InstallationProxyErrorCode = "DeviceFamilyNotSupported"
+	ErrIncorrectArchitecture         InstallationProxyErrorCode = "IncorrectArchitecture"
+	ErrInstallProhibited             InstallationProxyErrorCode = "InstallProhibited"
+	ErrMissingBundleIdentifier       InstallationProxyErrorCode = "MissingBundleIdentifier"
+	ErrPackageExtractionFailed       InstallationProxyErrorCode = "PackageExtractionFailed"
+	ErrPackageInspectionFailed       InstallationProxyErrorCode = "PackageInspectionFailed"
+	ErrUninstallProhibited           InstallationProxyErrorCode = "UninstallProhibited"
+	ErrUnknownCommand                InstallationProxyErrorCode = "UnknownCommand"
+)
+
+// InstallationProxyError unwraps to its Code
+type InstallationProxyError struct {
+	Code        InstallationProxyErrorCode
+	Description string
+	Detail      uint64
+}
+
+func (e *InstallationProxyError) Error() string {
+	if e.Description == "" {
+		return "installation proxy: " + string(e.Code)
+	}
+	return fmt.Sprintf("installation proxy: %s: %s", e.Code, e.Description)
+}
+
+func (e *InstallationProxyError) Unwrap() error {
+	return e.Code
+}
+
 const (
 	CommandTypeBrowse    CommandType = "Browse"
 	CommandTypeLookup    CommandType = "Lookup"
@@ -65,7 +109,28 @@ func (c *InstallationProxyClient) SendPacket(pkt Packet) (err error) {
 }
 
 func (c *InstallationProxyClient) ReceivePacket() (respPkt Packet, err error) {
-	return c.client.ReceivePacket()
+	if respPkt, err = c.client.receivePacket(); err != nil {
+		return nil, err
+	}
+
+	var reply InstallationProxyErrorResponse
+	if err = respPkt.Unmarshal(&reply); err != nil {
+		return nil, fmt.Errorf("receive packet: %w", err)
+	}
+
+	if reply.Error != "" {
+		return nil, &InstallationProxyError{
+			Code:        InstallationProxyErrorCode(reply.Error),
+			Description: reply.ErrorDescription,
+			Detail:      reply.ErrorDetail,
+		}
+	}
+
+	return
+}
+
+func (c *InstallationProxyClient) InnerConn() InnerConn {
+	return c.client.innerConn
 }
 
 type InstallationProxyOption struct {
@@ -111,6 +176,12 @@ type (
 		CurrentList   []interface{} `plist:"CurrentList"`
 	}
 
+	InstallationProxyErrorResponse struct {
+		Error            string `plist:"Error"`
+		ErrorDescription string `plist:"ErrorDescription"`
+		ErrorDetail      uint64 `plist:"ErrorDetail"`
+	}
+
 	InstallationProxyInstallResponse struct {
 		InstallationProxyBasicResponse
 		Error            string `plist:"Error"`
@@ -1,8 +1,15 @@
 package libimobiledevice
 
+import (
+	"context"
+	"time"
+)
+
 const (
 	InstrumentsServiceName            = "com.apple.instruments.remoteserver"
 	InstrumentsSecureProxyServiceName = "com.apple.instruments.remoteserver.DVTSecureSocketProxy"
+	// InstrumentsRemoteServiceName over RemoteServiceDiscovery (iOS 17+)
+	InstrumentsRemoteServiceName = "com.apple.instruments.dtservicehub"
 )
 
 func NewInstrumentsClient(innerConn InnerConn) *InstrumentsClient {
@@ -16,26 +23,47 @@ type InstrumentsClient struct {
 }
 
 func (c *InstrumentsClient) NotifyOfPublishedCapabilities() (publishedChannels map[string]int32, err error) {
-	return c.client.Connection()
+	return c.NotifyOfPublishedCapabilitiesContext(context.Background())
+}
+
+func (c *InstrumentsClient) NotifyOfPublishedCapabilitiesContext(ctx context.Context) (publishedChannels map[string]int32, err error) {
+	return c.client.Connection(ctx)
 }
 
 func (c *InstrumentsClient) RequestChannel(channel string) (id uint32, err error) {
-	return c.client.MakeChannel(channel)
+	return c.RequestChannelContext(context.Background(), channel)
+}
+
+func (c *InstrumentsClient) RequestChannelContext(ctx context.Context, channel string) (id uint32, err error) {
+	return c.client.MakeChannel(ctx, channel)
 }
 
 func (c *InstrumentsClient) Invoke(selector string, args *AuxBuffer, channelCode uint32, expectsReply bool) (result *DTXMessageResult, err error) {
-	var msgID uint32
-	if msgID, err = c.client.SendDTXMessage(selector, args.Bytes(), channelCode, expectsReply); err != nil {
-		return nil, err
-	}
-	if expectsReply {
-		if result, err = c.client.GetResult(msgID); err != nil {
-			return nil, err
-		}
-	}
-	return
+	return c.InvokeContext(context.Background(), selector, args, channelCode, expectsReply)
+}
+
+// InvokeContext is Invoke waiting for the reply until ctx is done
+func (c *InstrumentsClient) InvokeContext(ctx context.Context, selector string, args *AuxBuffer, channelCode uint32, expectsReply bool) (result *DTXMessageResult, err error) {
+	return c.client.Invoke(ctx, selector, args.Bytes(), channelCode, expectsReply)
+}
+
+// SetTimeout sets how long Invoke waits for a reply, defaults to DefaultDTXReplyTimeout
+func (c *InstrumentsClient) SetTimeout(timeout time.Duration) {
+	c.client.SetTimeout(timeout)
+}
+
+func (c *InstrumentsClient) CancelChannel(channelCode uint32) (err error) {
+	return c.client.CancelChannel(channelCode)
 }
 
 func (c *InstrumentsClient) RegisterCallback(obj string, cb func(m DTXMessageResult)) {
 	c.client.RegisterCallback(obj, cb)
 }
+
+func (c *InstrumentsClient) RegisterChannelCallback(channelCode uint32, cb func(m DTXMessageResult)) {
+	c.client.RegisterChannelCallback(channelCode, cb)
+}
+
+func (c *InstrumentsClient) UnregisterChannelCallback(channelCode uint32) {
+	c.client.UnregisterChannelCallback(channelCode)
+}
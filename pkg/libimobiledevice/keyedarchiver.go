@@ -1,6 +1,7 @@
 package libimobiledevice
 
 import (
+	"fmt"
 	"reflect"
 	"strconv"
 	"time"
@@ -97,6 +98,23 @@ type NSError struct {
 	NSUserInfo interface{}
 }
 
+// LocalizedDescription the `NSLocalizedDescription` of NSUserInfo, if any
+func (e NSError) LocalizedDescription() string {
+	if userInfo, ok := e.NSUserInfo.(map[string]interface{}); ok {
+		if desc, ok := userInfo["NSLocalizedDescription"].(string); ok {
+			return desc
+		}
+	}
+	return ""
+}
+
+func (e NSError) Error() string {
+	if desc := e.LocalizedDescription(); desc != "" {
+		return desc
+	}
+	return fmt.Sprintf("%s code %d", e.NSDomain, e.NSCode)
+}
+
 type NSKeyedArchiver struct {
 	objRefVal []interface{}
 	objRef    map[interface{}]plist.UID
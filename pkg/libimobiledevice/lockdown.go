@@ -11,10 +11,44 @@ const (
 	RequestTypeSetValue      RequestType = "SetValue"
 	RequestTypeGetValue      RequestType = "GetValue"
 	RequestTypePair          RequestType = "Pair"
+	RequestTypeValidatePair  RequestType = "ValidatePair"
+	RequestTypeUnpair        RequestType = "Unpair"
 	RequestTypeEnterRecovery RequestType = "EnterRecovery"
 	RequestTypeStartSession  RequestType = "StartSession"
 	RequestTypeStopSession   RequestType = "StopSession"
 	RequestTypeStartService  RequestType = "StartService"
+	// RequestTypeRSDCheckin opens the lockdown services reached through RemoteServiceDiscovery
+	RequestTypeRSDCheckin RequestType = "RSDCheckin"
+)
+
+// LockdownError the `Error` of a lockdownd (or lockdown service) reply
+type LockdownError string
+
+func (e LockdownError) Error() string {
+	return string(e)
+}
+
+const (
+	ErrPasswordProtected             LockdownError = "PasswordProtected"
+	ErrInvalidHostID                 LockdownError = "InvalidHostID"
+	ErrPairingDialogResponsePending  LockdownError = "PairingDialogResponsePending"
+	ErrUserDeniedPairing             LockdownError = "UserDeniedPairing"
+	ErrPairingProhibited             LockdownError = "PairingProhibitedOverThisConnection"
+	ErrSessionInactive               LockdownError = "SessionInactive"
+	ErrInvalidSessionID              LockdownError = "InvalidSessionID"
+	ErrNoRunningSession              LockdownError = "NoRunningSession"
+	ErrInvalidService                LockdownError = "InvalidService"
+	ErrServiceProhibited             LockdownError = "ServiceProhibited"
+	ErrMissingValue                  LockdownError = "MissingValue"
+	ErrGetProhibited                 LockdownError = "GetProhibited"
+	ErrSetProhibited                 LockdownError = "SetProhibited"
+	ErrEscrowLocked                  LockdownError = "EscrowLocked"
+	ErrInvalidPairRecord             LockdownError = "InvalidPairRecord"
+	ErrDeviceNotActivated            LockdownError = "DeviceNotActivated"
+	ErrInvalidActivationRecord       LockdownError = "InvalidActivationRecord"
+	ErrPairingFailed                 LockdownError = "PairingFailed"
+	ErrMCChallengeRequired           LockdownError = "MCChallengeRequired"
+	ErrUnsupportedPairingProtocolVer LockdownError = "UnsupportedPairingProtocolVersion"
 )
 
 type LockdownType struct {
@@ -70,6 +104,36 @@ func (c *LockdownClient) NewPairRequest(pairRecord *PairRecord) *LockdownPairReq
 	}
 }
 
+// NewSupervisedPairRequest asks a supervised device to pair without the Trust dialog,
+// supervisorCert is the DER encoded supervision certificate
+func (c *LockdownClient) NewSupervisedPairRequest(pairRecord *PairRecord, supervisorCert []byte) *LockdownPairRequest {
+	req := c.NewPairRequest(pairRecord)
+	req.PairingOptions["SupervisorCertificate"] = supervisorCert
+	return req
+}
+
+// NewPairChallengeResponseRequest answers the `PairingChallenge` of a supervised device
+// with the challenge signed (CMS) by the supervision identity
+func (c *LockdownClient) NewPairChallengeResponseRequest(pairRecord *PairRecord, challengeResponse []byte) *LockdownPairRequest {
+	req := c.NewPairRequest(pairRecord)
+	req.PairingOptions["ChallengeResponse"] = challengeResponse
+	return req
+}
+
+func (c *LockdownClient) NewValidatePairRequest(pairRecord *PairRecord) *LockdownPairRequest {
+	return &LockdownPairRequest{
+		LockdownBasicRequest: *c.NewBasicRequest(RequestTypeValidatePair),
+		PairRecord:           pairRecord,
+	}
+}
+
+func (c *LockdownClient) NewUnpairRequest(pairRecord *PairRecord) *LockdownPairRequest {
+	return &LockdownPairRequest{
+		LockdownBasicRequest: *c.NewBasicRequest(RequestTypeUnpair),
+		PairRecord:           pairRecord,
+	}
+}
+
 func (c *LockdownClient) NewStartSessionRequest(buid, hostID string) *LockdownStartSessionRequest {
 	return &LockdownStartSessionRequest{
 		LockdownBasicRequest: *c.NewBasicRequest(RequestTypeStartSession),
@@ -104,10 +168,19 @@ func (c *LockdownClient) ReceivePacket() (respPkt Packet, err error) {
 	return c.client.ReceivePacket()
 }
 
+// ReceiveRawPacket is ReceivePacket leaving the `Error` of the reply to the caller
+func (c *LockdownClient) ReceiveRawPacket() (respPkt Packet, err error) {
+	return c.client.receivePacket()
+}
+
 func (c *LockdownClient) EnableSSL(version []int, pairRecord *PairRecord) (err error) {
 	return c.client.innerConn.Handshake(version, pairRecord)
 }
 
+func (c *LockdownClient) InnerConn() InnerConn {
+	return c.client.innerConn
+}
+
 type (
 	LockdownBasicRequest struct {
 		Label           string      `plist:"Label"`
@@ -125,7 +198,7 @@ type (
 	LockdownPairRequest struct {
 		LockdownBasicRequest
 		PairRecord     *PairRecord            `plist:"PairRecord"`
-		PairingOptions map[string]interface{} `plist:"PairingOptions"`
+		PairingOptions map[string]interface{} `plist:"PairingOptions,omitempty"`
 	}
 
 	LockdownStartSessionRequest struct {
@@ -165,7 +238,10 @@ type (
 
 	LockdownPairResponse struct {
 		LockdownBasicResponse
-		EscrowBag []byte `plist:"EscrowBag"`
+		EscrowBag        []byte `plist:"EscrowBag"`
+		ExtendedResponse struct {
+			PairingChallenge []byte `plist:"PairingChallenge"`
+		} `plist:"ExtendedResponse"`
 	}
 
 	LockdownStartSessionResponse struct {
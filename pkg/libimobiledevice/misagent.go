@@ -0,0 +1,145 @@
+package libimobiledevice
+
+import (
+	"fmt"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+const MisagentServiceName = "com.apple.misagent"
+
+type MisagentMessageType string
+
+const (
+	MisagentMessageTypeInstall MisagentMessageType = "Install"
+	MisagentMessageTypeRemove  MisagentMessageType = "Remove"
+	MisagentMessageTypeCopy    MisagentMessageType = "Copy"
+	MisagentMessageTypeCopyAll MisagentMessageType = "CopyAll"
+)
+
+const MisagentProfileTypeProvisioning = "Provisioning"
+
+// misagentCopyAllConstraint `Copy` only returns the profiles of installed apps since iOS 9.3
+var misagentCopyAllConstraint, _ = semver.NewConstraint(">= 9.3")
+
+// NewMisagentClient productVersion selects the request shapes, an unparsable
+// version is treated as a recent iOS
+func NewMisagentClient(innerConn InnerConn, productVersion string) *MisagentClient {
+	c := &MisagentClient{
+		client:  newServicePacketClient(innerConn),
+		copyAll: true,
+	}
+	if v, err := semver.NewVersion(productVersion); err == nil {
+		c.copyAll = misagentCopyAllConstraint.Check(v)
+	}
+	return c
+}
+
+type MisagentClient struct {
+	client  *servicePacketClient
+	copyAll bool
+}
+
+func (c *MisagentClient) NewBasicRequest(msgType MisagentMessageType) *MisagentBasicRequest {
+	return &MisagentBasicRequest{
+		MessageType: msgType,
+		ProfileType: MisagentProfileTypeProvisioning,
+	}
+}
+
+func (c *MisagentClient) NewInstallRequest(profile []byte) *MisagentInstallRequest {
+	return &MisagentInstallRequest{
+		MisagentBasicRequest: *c.NewBasicRequest(MisagentMessageTypeInstall),
+		Profile:              profile,
+	}
+}
+
+func (c *MisagentClient) NewRemoveRequest(profileID string) *MisagentRemoveRequest {
+	return &MisagentRemoveRequest{
+		MisagentBasicRequest: *c.NewBasicRequest(MisagentMessageTypeRemove),
+		ProfileID:            profileID,
+	}
+}
+
+// NewCopyRequest `CopyAll` on iOS 9.3 and later, `Copy` before
+func (c *MisagentClient) NewCopyRequest() *MisagentBasicRequest {
+	if c.copyAll {
+		return c.NewBasicRequest(MisagentMessageTypeCopyAll)
+	}
+	return c.NewBasicRequest(MisagentMessageTypeCopy)
+}
+
+func (c *MisagentClient) NewXmlPacket(req interface{}) (Packet, error) {
+	return c.client.NewXmlPacket(req)
+}
+
+func (c *MisagentClient) SendPacket(pkt Packet) (err error) {
+	return c.client.SendPacket(pkt)
+}
+
+func (c *MisagentClient) ReceivePacket() (respPkt Packet, err error) {
+	return c.client.ReceivePacket()
+}
+
+func (c *MisagentClient) InnerConn() InnerConn {
+	return c.client.innerConn
+}
+
+type (
+	MisagentBasicRequest struct {
+		MessageType MisagentMessageType `plist:"MessageType"`
+		ProfileType string              `plist:"ProfileType"`
+	}
+
+	MisagentInstallRequest struct {
+		MisagentBasicRequest
+		Profile []byte `plist:"Profile"`
+	}
+
+	MisagentRemoveRequest struct {
+		MisagentBasicRequest
+		ProfileID string `plist:"ProfileID"`
+	}
+)
+
+type (
+	MisagentBasicResponse struct {
+		LockdownBasicResponse
+		Status uint64 `plist:"Status"`
+	}
+
+	MisagentCopyResponse struct {
+		MisagentBasicResponse
+		Payload [][]byte `plist:"Payload"`
+	}
+)
+
+// Err the error reported by `Status`, nil on success
+func (r MisagentBasicResponse) Err() error {
+	if r.Status == 0 {
+		return nil
+	}
+	return fmt.Errorf("misagent status: 0x%x", r.Status)
+}
+
+// ProvisionProfileOperationResults the outcome of an operation applied to
+// several profiles, keyed by profile UUID
+type ProvisionProfileOperationResults struct {
+	Succeeded []string
+	Failed    map[string]error
+}
+
+func NewProvisionProfileOperationResults() *ProvisionProfileOperationResults {
+	return &ProvisionProfileOperationResults{
+		Succeeded: make([]string, 0),
+		Failed:    make(map[string]error),
+	}
+}
+
+// Err nil when every profile succeeded
+func (r *ProvisionProfileOperationResults) Err() error {
+	if len(r.Failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("provision profiles: %d of %d failed", len(r.Failed), len(r.Failed)+len(r.Succeeded))
+}
@@ -0,0 +1,67 @@
+package libimobiledevice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// address families found in `NetworkAddress`, a BSD sockaddr: sa_len, sa_family, ...
+const (
+	sockaddrFamilyINET       = 0x02
+	sockaddrFamilyINET6Linux = 0x0A
+	sockaddrFamilyINET6      = 0x1E
+)
+
+var ErrNetworkAddressInvalid = errors.New("invalid network address")
+
+// ParseNetworkAddress decodes the `NetworkAddress` sockaddr of a network device
+func ParseNetworkAddress(sockaddr []byte) (ip net.IP, err error) {
+	if len(sockaddr) < 2 {
+		return nil, ErrNetworkAddressInvalid
+	}
+	switch sockaddr[1] {
+	case sockaddrFamilyINET:
+		if len(sockaddr) < 8 {
+			return nil, ErrNetworkAddressInvalid
+		}
+		return net.IPv4(sockaddr[4], sockaddr[5], sockaddr[6], sockaddr[7]), nil
+	case sockaddrFamilyINET6, sockaddrFamilyINET6Linux:
+		if len(sockaddr) < 24 {
+			return nil, ErrNetworkAddressInvalid
+		}
+		ip = make(net.IP, net.IPv6len)
+		copy(ip, sockaddr[8:24])
+		return ip, nil
+	}
+	return nil, fmt.Errorf("%w: address family %d", ErrNetworkAddressInvalid, sockaddr[1])
+}
+
+// NewNetworkAddress encodes ip the way usbmuxd reports `NetworkAddress`
+func NewNetworkAddress(ip net.IP) []byte {
+	if ip4 := ip.To4(); ip4 != nil {
+		sockaddr := make([]byte, 16)
+		sockaddr[0], sockaddr[1] = 16, sockaddrFamilyINET
+		copy(sockaddr[4:], ip4)
+		return sockaddr
+	}
+	sockaddr := make([]byte, 28)
+	sockaddr[0], sockaddr[1] = 28, sockaddrFamilyINET6
+	copy(sockaddr[8:], ip.To16())
+	return sockaddr
+}
+
+// NewNetworkConnContext connects to addr directly over TCP, the transport of
+// network devices when there is no usbmuxd to go through
+func NewNetworkConnContext(ctx context.Context, addr string, timeout ...time.Duration) (InnerConn, error) {
+	if len(timeout) == 0 {
+		timeout = []time.Duration{DefaultDeadlineTimeout}
+	}
+	conn, err := rawDial(ctx, addr, timeout[0])
+	if err != nil {
+		return nil, fmt.Errorf("network connect: %w", err)
+	}
+	return newInnerConn(conn, timeout[0]), nil
+}
@@ -0,0 +1,31 @@
+package libimobiledevice
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func Test_ParseNetworkAddress(t *testing.T) {
+	for _, ip := range []net.IP{net.IPv4(192, 168, 1, 23), net.ParseIP("fe80::1c2b:3aff:fe4d:5e6f")} {
+		got, err := ParseNetworkAddress(NewNetworkAddress(ip))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equal(ip) {
+			t.Errorf("got %s, want %s", got, ip)
+		}
+	}
+
+	// sockaddr_in6 as reported by a Linux usbmuxd
+	linux := make([]byte, 28)
+	linux[1] = 0x0A
+	copy(linux[8:], net.ParseIP("fd00::2"))
+	if got, err := ParseNetworkAddress(linux); err != nil || !got.Equal(net.ParseIP("fd00::2")) {
+		t.Errorf("linux sockaddr: %s %v", got, err)
+	}
+
+	if _, err := ParseNetworkAddress([]byte{16, 0x02, 0}); !errors.Is(err, ErrNetworkAddressInvalid) {
+		t.Errorf("expected invalid, got %v", err)
+	}
+}
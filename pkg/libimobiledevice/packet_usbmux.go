@@ -96,6 +96,11 @@ type (
 		BasicRequest
 		PairRecordID string `plist:"PairRecordID"`
 	}
+
+	AuthenticateRequest struct {
+		BasicRequest
+		Token string `plist:"Token"`
+	}
 )
 
 type PairRecord struct {
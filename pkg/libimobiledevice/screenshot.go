@@ -50,3 +50,7 @@ func (c *ScreenshotClient) ReceivePacket() (respPkt Packet, err error) {
 
 	return
 }
+
+func (c *ScreenshotClient) InnerConn() InnerConn {
+	return c.client.innerConn
+}
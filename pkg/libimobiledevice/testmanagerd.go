@@ -1,8 +1,15 @@
 package libimobiledevice
 
+import (
+	"context"
+	"time"
+)
+
 const (
 	TestmanagerdSecureServiceName = "com.apple.testmanagerd.lockdown.secure"
 	TestmanagerdServiceName       = "com.apple.testmanagerd.lockdown"
+	// TestmanagerdRemoteServiceName over RemoteServiceDiscovery (iOS 17+)
+	TestmanagerdRemoteServiceName = "com.apple.dt.testmanagerd.remote"
 )
 
 func NewTestmanagerdClient(innerConn InnerConn) *TestmanagerdClient {
@@ -16,30 +23,51 @@ type TestmanagerdClient struct {
 }
 
 func (t *TestmanagerdClient) Connection() (publishedChannels map[string]int32, err error) {
-	return t.client.Connection()
+	return t.ConnectionContext(context.Background())
+}
+
+func (t *TestmanagerdClient) ConnectionContext(ctx context.Context) (publishedChannels map[string]int32, err error) {
+	return t.client.Connection(ctx)
 }
 
 func (t *TestmanagerdClient) MakeChannel(channel string) (id uint32, err error) {
-	return t.client.MakeChannel(channel)
+	return t.MakeChannelContext(context.Background(), channel)
+}
+
+func (t *TestmanagerdClient) MakeChannelContext(ctx context.Context, channel string) (id uint32, err error) {
+	return t.client.MakeChannel(ctx, channel)
 }
 
 func (t *TestmanagerdClient) Invoke(selector string, args *AuxBuffer, channelCode uint32, expectsReply bool) (result *DTXMessageResult, err error) {
-	var msgID uint32
-	if msgID, err = t.client.SendDTXMessage(selector, args.Bytes(), channelCode, expectsReply); err != nil {
-		return nil, err
-	}
-	if expectsReply {
-		if result, err = t.client.GetResult(msgID); err != nil {
-			return nil, err
-		}
-	}
-	return
+	return t.InvokeContext(context.Background(), selector, args, channelCode, expectsReply)
+}
+
+// InvokeContext is Invoke waiting for the reply until ctx is done
+func (t *TestmanagerdClient) InvokeContext(ctx context.Context, selector string, args *AuxBuffer, channelCode uint32, expectsReply bool) (result *DTXMessageResult, err error) {
+	return t.client.Invoke(ctx, selector, args.Bytes(), channelCode, expectsReply)
+}
+
+// SetTimeout sets how long Invoke waits for a reply, defaults to DefaultDTXReplyTimeout
+func (t *TestmanagerdClient) SetTimeout(timeout time.Duration) {
+	t.client.SetTimeout(timeout)
+}
+
+func (t *TestmanagerdClient) CancelChannel(channelCode uint32) (err error) {
+	return t.client.CancelChannel(channelCode)
 }
 
 func (t *TestmanagerdClient) RegisterCallback(obj string, cb func(m DTXMessageResult)) {
 	t.client.RegisterCallback(obj, cb)
 }
 
+func (t *TestmanagerdClient) RegisterChannelCallback(channelCode uint32, cb func(m DTXMessageResult)) {
+	t.client.RegisterChannelCallback(channelCode, cb)
+}
+
+func (t *TestmanagerdClient) UnregisterChannelCallback(channelCode uint32) {
+	t.client.UnregisterChannelCallback(channelCode)
+}
+
 func (t *TestmanagerdClient) Close() {
 	t.client.Close()
 }
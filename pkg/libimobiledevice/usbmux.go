@@ -19,18 +19,26 @@ package libimobiledevice
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/binary"
 	"fmt"
 	"howett.net/plist"
 	"net"
+	"os"
 	"runtime"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 var DefaultDeadlineTimeout = 30 * time.Second
 
+// UsbmuxdSocketAddressEnv overrides the default usbmuxd location,
+// either "UNIX:/path/to/socket" or "host:port" (same as libusbmuxd)
+const UsbmuxdSocketAddressEnv = "USBMUXD_SOCKET_ADDRESS"
+
 const (
 	BundleID         = "org.cloud.sonic.gidevice"
 	ProgramName      = "libimobiledevice"
@@ -50,6 +58,10 @@ const (
 	ReplyCodeBadVersion
 )
 
+// ReplyCodePermissionDenied is not from usbmuxd, a sharing server refuses a client
+// or a device with it
+const ReplyCodePermissionDenied ReplyCode = 0x100
+
 func (rc ReplyCode) String() string {
 	switch rc {
 	case ReplyCodeOK:
@@ -62,11 +74,19 @@ func (rc ReplyCode) String() string {
 		return "connection refused"
 	case ReplyCodeBadVersion:
 		return "bad version"
+	case ReplyCodePermissionDenied:
+		return "permission denied"
 	default:
 		return "unknown reply code: " + strconv.Itoa(int(rc))
 	}
 }
 
+// Error a ReplyCode other than ReplyCodeOK is the error usbmuxd replied with,
+// e.g. errors.Is(err, ReplyCodeBadDevice)
+func (rc ReplyCode) Error() string {
+	return rc.String()
+}
+
 type ProtoVersion uint32
 
 // proto_version == 1
@@ -106,6 +126,9 @@ const (
 	MessageTypeSavePairRecord   MessageType = "SavePairRecord"
 	MessageTypeDeletePairRecord MessageType = "DeletePairRecord"
 	MessageTypeDeviceList       MessageType = "ListDevices"
+	MessageTypeDevicePaired     MessageType = "Paired"
+	// MessageTypeAuthenticate presents a token to a sharing server, usbmuxd has no such thing
+	MessageTypeAuthenticate MessageType = "Authenticate"
 )
 
 type BaseDevice struct {
@@ -130,12 +153,17 @@ type DeviceProperties struct {
 }
 
 func NewUsbmuxClient(addr string, timeout ...time.Duration) (c *UsbmuxClient, err error) {
+	return NewUsbmuxClientContext(context.Background(), addr, timeout...)
+}
+
+// NewUsbmuxClientContext is NewUsbmuxClient giving up dialing when ctx is done
+func NewUsbmuxClientContext(ctx context.Context, addr string, timeout ...time.Duration) (c *UsbmuxClient, err error) {
 	if len(timeout) == 0 {
 		timeout = []time.Duration{DefaultDeadlineTimeout}
 	}
 	c = &UsbmuxClient{version: ProtoVersionPlist}
 	var conn net.Conn
-	if conn, err = rawDial(addr, timeout[0]); err != nil {
+	if conn, err = rawDial(ctx, addr, timeout[0]); err != nil {
 		return nil, fmt.Errorf("usbmux connect: %w", err)
 	}
 
@@ -143,6 +171,17 @@ func NewUsbmuxClient(addr string, timeout ...time.Duration) (c *UsbmuxClient, er
 	return
 }
 
+// NewUsbmuxClientConn speaks the usbmuxd protocol over conn, made some other way
+// than rawDial: to a sharing server, over TLS say
+func NewUsbmuxClientConn(conn net.Conn, timeout ...time.Duration) (c *UsbmuxClient) {
+	if len(timeout) == 0 {
+		timeout = []time.Duration{DefaultDeadlineTimeout}
+	}
+	c = &UsbmuxClient{version: ProtoVersionPlist}
+	c.innerConn = newInnerConn(conn, timeout[0])
+	return
+}
+
 func NewRemoteUsbmuxConn(conn net.Conn) (c *UsbmuxClient) {
 	c = &UsbmuxClient{version: ProtoVersionPlist}
 	c.innerConn = newInnerConn(conn, 0)
@@ -153,6 +192,25 @@ type UsbmuxClient struct {
 	innerConn InnerConn
 	version   ProtoVersion
 	tag       uint32
+
+	// mu one Exchange at a time
+	mu sync.Mutex
+}
+
+// Exchange sends req and receives its reply, safe for concurrent use unlike
+// NewPlistPacket, SendPacket and ReceivePacket called one after another
+func (c *UsbmuxClient) Exchange(req interface{}) (respPkt Packet, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var pkt Packet
+	if pkt, err = c.NewPlistPacket(req); err != nil {
+		return nil, err
+	}
+	if err = c.SendPacket(pkt); err != nil {
+		return nil, err
+	}
+	return c.ReceivePacket()
 }
 
 func (c *UsbmuxClient) NewBasicRequest(msgType MessageType) *BasicRequest {
@@ -263,7 +321,7 @@ func (c *UsbmuxClient) ReceivePacket() (respPkt Packet, err error) {
 	}
 
 	if reply.Number != ReplyCodeOK {
-		return nil, fmt.Errorf("usbmux receive: %s", reply.Number.String())
+		return nil, fmt.Errorf("usbmux receive: %w", reply.Number)
 	}
 
 	return
@@ -282,14 +340,22 @@ func (c *UsbmuxClient) InnerConn() InnerConn {
 	return c.innerConn
 }
 
-func rawDial(addr string, timeout time.Duration) (net.Conn, error) {
+func rawDial(ctx context.Context, addr string, timeout time.Duration) (net.Conn, error) {
 	dialer := net.Dialer{
 		Timeout: timeout,
 	}
-	if addr != "" {
-		return dialer.Dial("tcp", addr)
+	if addr == "" {
+		addr = os.Getenv(UsbmuxdSocketAddressEnv)
 	}
 	var network, address string
+	if addr != "" {
+		if strings.HasPrefix(addr, "UNIX:") {
+			network, address = "unix", strings.TrimPrefix(addr, "UNIX:")
+		} else {
+			network, address = "tcp", addr
+		}
+		return dialer.DialContext(ctx, network, address)
+	}
 	switch runtime.GOOS {
 	case "darwin", "android", "linux":
 		network, address = "unix", "/var/run/usbmuxd"
@@ -299,7 +365,7 @@ func rawDial(addr string, timeout time.Duration) (net.Conn, error) {
 		return nil, fmt.Errorf("raw dial: unsupported system: %s", runtime.GOOS)
 	}
 
-	return dialer.Dial(network, address)
+	return dialer.DialContext(ctx, network, address)
 }
 
 type InnerConn interface {
@@ -310,6 +376,22 @@ type InnerConn interface {
 	Close()
 	RawConn() net.Conn
 	Timeout(time.Duration)
+	// BindContext makes reads and writes fail with ctx.Err() once ctx is done,
+	// until release is called. An interrupted connection should be closed.
+	// Bindings may overlap and be released in any order, each applies until released.
+	BindContext(ctx context.Context) (release func())
+	// Err why the connection is no longer usable: the first failed read or write,
+	// but for a timeout before any byte is read, or Close. nil while it is fine
+	Err() error
+}
+
+// NewInnerConn wraps conn, a connection to the device made some other way
+// than through usbmuxd, a tunnel say
+func NewInnerConn(conn net.Conn, timeout ...time.Duration) InnerConn {
+	if len(timeout) == 0 {
+		timeout = []time.Duration{DefaultDeadlineTimeout}
+	}
+	return newInnerConn(conn, timeout[0])
 }
 
 func newInnerConn(conn net.Conn, timeout time.Duration) InnerConn {
@@ -323,23 +405,95 @@ type safeConn struct {
 	conn    net.Conn
 	sslConn *tls.Conn
 	timeout time.Duration
+
+	ctxMu    sync.Mutex
+	ctxs     map[int]context.Context
+	bindings int
+
+	errMu sync.Mutex
+	err   error
+}
+
+// aLongTimeAgo a deadline in the past, which interrupts pending I/O
+var aLongTimeAgo = time.Unix(1, 0)
+
+func (c *safeConn) BindContext(ctx context.Context) (release func()) {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+	c.ctxMu.Lock()
+	if c.ctxs == nil {
+		c.ctxs = make(map[int]context.Context)
+	}
+	c.bindings++
+	binding := c.bindings
+	c.ctxs[binding] = ctx
+	c.ctxMu.Unlock()
+
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			// `sslConn` shares the deadline of `conn`
+			_ = c.conn.SetDeadline(aLongTimeAgo)
+		case <-stop:
+		}
+	}()
+	return func() {
+		close(stop)
+		<-stopped
+		c.ctxMu.Lock()
+		delete(c.ctxs, binding)
+		c.ctxMu.Unlock()
+	}
+}
+
+// deadline the earliest of the timeout and the deadlines of the bound contexts
+func (c *safeConn) deadline() (ctxs []context.Context, deadline time.Time) {
+	c.ctxMu.Lock()
+	for _, ctx := range c.ctxs {
+		ctxs = append(ctxs, ctx)
+	}
+	c.ctxMu.Unlock()
+	if c.timeout > 0 {
+		deadline = time.Now().Add(c.timeout)
+	}
+	for _, ctx := range ctxs {
+		if d, ok := ctx.Deadline(); ok && (deadline.IsZero() || d.Before(deadline)) {
+			deadline = d
+		}
+	}
+	return
+}
+
+// contextErr replaces err by the error of the bound context which caused it
+func contextErr(ctxs []context.Context, err error) error {
+	for _, ctx := range ctxs {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if d, ok := ctx.Deadline(); ok && !time.Now().Before(d) {
+			return context.DeadlineExceeded
+		}
+	}
+	return err
 }
 
 func (c *safeConn) Write(data []byte) (err error) {
 	conn := c.RawConn()
-	if c.timeout <= 0 {
-		err = conn.SetWriteDeadline(time.Time{})
-	} else {
-		err = conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	ctxs, deadline := c.deadline()
+	if err = conn.SetWriteDeadline(deadline); err != nil {
+		return contextErr(ctxs, err)
 	}
-	if err != nil {
+	if err = contextErr(ctxs, nil); err != nil {
 		return err
 	}
 
 	for totalSent := 0; totalSent < len(data); {
 		var sent int
 		if sent, err = conn.Write(data[totalSent:]); err != nil {
-			return err
+			return c.fail(contextErr(ctxs, err))
 		}
 		if sent == 0 {
 			return err
@@ -351,12 +505,11 @@ func (c *safeConn) Write(data []byte) (err error) {
 
 func (c *safeConn) Read(length int) (data []byte, err error) {
 	conn := c.RawConn()
-	if c.timeout <= 0 {
-		err = conn.SetReadDeadline(time.Time{})
-	} else {
-		err = conn.SetReadDeadline(time.Now().Add(c.timeout))
+	ctxs, deadline := c.deadline()
+	if err = conn.SetReadDeadline(deadline); err != nil {
+		return nil, contextErr(ctxs, err)
 	}
-	if err != nil {
+	if err = contextErr(ctxs, nil); err != nil {
 		return nil, err
 	}
 
@@ -365,7 +518,11 @@ func (c *safeConn) Read(length int) (data []byte, err error) {
 		buf := make([]byte, length-len(data))
 		_n, _err := 0, error(nil)
 		if _n, _err = conn.Read(buf); _err != nil && _n == 0 {
-			return nil, _err
+			// nothing read when the timeout hits, the next read starts where this one would have
+			if netErr, ok := _err.(net.Error); ok && netErr.Timeout() && len(data) == 0 && contextErr(ctxs, _err) == _err {
+				return nil, _err
+			}
+			return nil, c.fail(contextErr(ctxs, _err))
 		}
 		data = append(data, buf[:_n]...)
 	}
@@ -395,8 +552,12 @@ func (c *safeConn) Handshake(version []int, pairRecord *PairRecord) (err error)
 
 	c.sslConn = tls.Client(c.conn, config)
 
+	ctxs, deadline := c.deadline()
+	if err = c.conn.SetDeadline(deadline); err != nil {
+		return contextErr(ctxs, err)
+	}
 	if err = c.sslConn.Handshake(); err != nil {
-		return err
+		return contextErr(ctxs, err)
 	}
 
 	return
@@ -414,6 +575,7 @@ func (c *safeConn) DismissSSL() (err error) {
 }
 
 func (c *safeConn) Close() {
+	c.fail(net.ErrClosed)
 	if c.sslConn != nil {
 		if err := c.sslConn.Close(); err != nil {
 			debugLog(fmt.Sprintf("close: %s", err))
@@ -426,6 +588,22 @@ func (c *safeConn) Close() {
 	}
 }
 
+// fail records err as the reason the connection is unusable, unless there is one already
+func (c *safeConn) fail(err error) error {
+	c.errMu.Lock()
+	if c.err == nil {
+		c.err = err
+	}
+	c.errMu.Unlock()
+	return err
+}
+
+func (c *safeConn) Err() error {
+	c.errMu.Lock()
+	defer c.errMu.Unlock()
+	return c.err
+}
+
 // RawConn `sslConn` first
 func (c *safeConn) RawConn() net.Conn {
 	if c.sslConn != nil {
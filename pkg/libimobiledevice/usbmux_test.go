@@ -0,0 +1,74 @@
+package libimobiledevice
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_safeConn_BindContext(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	conn := newInnerConn(local, time.Minute)
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	release := conn.BindContext(ctx)
+	done := make(chan error, 1)
+	go func() {
+		_, err := conn.Read(4)
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled, got %v", err)
+	}
+	if err := conn.Write([]byte("ping")); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled, got %v", err)
+	}
+	release()
+
+	// released, the timeout applies again
+	go func() { _, _ = remote.Write([]byte("pong")) }()
+	if data, err := conn.Read(4); err != nil || string(data) != "pong" {
+		t.Fatalf("read after release: %q %v", data, err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	release = conn.BindContext(ctx)
+	defer release()
+	if _, err := conn.Read(4); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func Test_safeConn_BindContext_overlapping(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	conn := newInnerConn(local, time.Minute)
+	defer conn.Close()
+
+	outer, cancelOuter := context.WithCancel(context.Background())
+	releaseOuter := conn.BindContext(outer)
+	inner, cancelInner := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelInner()
+	releaseInner := conn.BindContext(inner)
+
+	// released out of order, the inner binding still applies
+	releaseOuter()
+	if _, err := conn.Read(4); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	// and neither does once both are released
+	releaseInner()
+	cancelOuter()
+	go func() { _, _ = remote.Write([]byte("pong")) }()
+	if data, err := conn.Read(4); err != nil || string(data) != "pong" {
+		t.Fatalf("read after release: %q %v", data, err)
+	}
+}
@@ -0,0 +1,72 @@
+package libimobiledevice
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"howett.net/plist"
+)
+
+// maxUsbmuxPacket bounds the requests a server reads, pair records are the largest
+const maxUsbmuxPacket = 1 << 20
+
+var ErrUsbmuxPacketInvalid = errors.New("usbmux packet: invalid")
+
+// UsbmuxServerConn is the usbmuxd end of conn, for serving the plist protocol
+type UsbmuxServerConn struct {
+	conn net.Conn
+}
+
+func NewUsbmuxServerConn(conn net.Conn) *UsbmuxServerConn {
+	return &UsbmuxServerConn{conn: conn}
+}
+
+// ReceiveRequest reads the next request, the reply goes back with its tag
+func (c *UsbmuxServerConn) ReceiveRequest() (tag uint32, req Packet, err error) {
+	header := make([]byte, 16)
+	if _, err = io.ReadFull(c.conn, header); err != nil {
+		return 0, nil, fmt.Errorf("usbmux receive: %w", err)
+	}
+	length := binary.LittleEndian.Uint32(header)
+	if length < 16 || length > maxUsbmuxPacket {
+		return 0, nil, fmt.Errorf("%w: length %d", ErrUsbmuxPacketInvalid, length)
+	}
+	if ProtoVersion(binary.LittleEndian.Uint32(header[4:])) != ProtoVersionPlist {
+		return 0, nil, fmt.Errorf("%w: %s", ErrUsbmuxPacketInvalid, ReplyCodeBadVersion)
+	}
+	buffer := bytes.NewBuffer(make([]byte, 0, length))
+	buffer.Write(header)
+	if _, err = io.CopyN(buffer, c.conn, int64(length-16)); err != nil {
+		return 0, nil, fmt.Errorf("usbmux receive: %w", err)
+	}
+	if req, err = new(packet).Unpack(buffer); err != nil {
+		return 0, nil, err
+	}
+	return binary.LittleEndian.Uint32(header[12:]), req, nil
+}
+
+// Send msg in reply to the request of tag, 0 for notifications
+func (c *UsbmuxServerConn) Send(tag uint32, msg interface{}) (err error) {
+	pkt := &packet{version: ProtoVersionPlist, msgType: ProtoMessageTypePlist, tag: tag}
+	if pkt.body, err = plist.Marshal(msg, plist.XMLFormat); err != nil {
+		return fmt.Errorf("plist packet marshal: %w", err)
+	}
+	pkt.length = uint32(len(pkt.body) + 4*4)
+	raw, _ := pkt.Pack()
+	if _, err = c.conn.Write(raw); err != nil {
+		return fmt.Errorf("usbmux send: %w", err)
+	}
+	return nil
+}
+
+// SendResult replies to the request of tag with code
+func (c *UsbmuxServerConn) SendResult(tag uint32, code ReplyCode) error {
+	return c.Send(tag, map[string]interface{}{
+		"MessageType": MessageTypeResult,
+		"Number":      uint64(code),
+	})
+}
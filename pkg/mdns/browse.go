@@ -0,0 +1,352 @@
+package mdns
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultAddr the IPv4 mDNS group
+var DefaultAddr = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+const DefaultInterval = 10 * time.Second
+
+// Entry a resolved service instance
+type Entry struct {
+	// Name the full instance name, e.g. `a4:83:e7:00:00:01@fe80::1._apple-mobdev2._tcp.local.`
+	Name string
+	// Instance the first label of Name, unescaped
+	Instance string
+	// Service e.g. `_apple-mobdev2._tcp.local.`
+	Service string
+	Host    string
+	Port    int
+	IPs     []net.IP
+	Text    []string
+	// Removed the instance said goodbye (TTL 0), only Name, Instance and Service are set
+	Removed bool
+}
+
+// TextValue the value of key in the `key=value` TXT entries
+func (e *Entry) TextValue(key string) (value string, ok bool) {
+	for _, s := range e.Text {
+		if k, v := splitText(s); strings.EqualFold(k, key) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+func splitText(s string) (key, value string) {
+	if i := strings.IndexByte(s, '='); i >= 0 {
+		return s[:i], s[i+1:]
+	}
+	return s, ""
+}
+
+// Browser queries for services and resolves the instances answering.
+// Queries go out from an ephemeral port, so responders answer by unicast
+// (RFC 6762 section 6.7) and nothing needs to bind port 5353.
+type Browser struct {
+	// Addr where queries are sent, DefaultAddr when nil
+	Addr *net.UDPAddr
+	// Interval between repeated queries, DefaultInterval when zero
+	Interval time.Duration
+}
+
+// Browse queries for services (e.g. `_apple-mobdev2._tcp.local.`) until ctx is done,
+// sending an Entry when an instance is resolved, changes or is removed.
+// The channel is closed once ctx is done.
+func (b *Browser) Browse(ctx context.Context, services ...string) (<-chan *Entry, error) {
+	addr := b.Addr
+	if addr == nil {
+		addr = DefaultAddr
+	}
+	interval := b.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+
+	network := "udp4"
+	if addr.IP.To4() == nil {
+		network = "udp6"
+	}
+	conn, err := net.ListenUDP(network, nil)
+	if err != nil {
+		return nil, fmt.Errorf("mdns browse: %w", err)
+	}
+
+	r := &resolver{
+		conn:      conn,
+		addr:      addr,
+		services:  make(map[string]bool, len(services)),
+		instances: make(map[string]*instance),
+		hosts:     make(map[string][]net.IP),
+	}
+	for _, s := range services {
+		r.services[strings.ToLower(s)] = true
+	}
+
+	entries := make(chan *Entry)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+	go func() {
+		defer close(entries)
+		defer wg.Wait()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		packets := make(chan []byte)
+		go func() {
+			defer close(packets)
+			buf := make([]byte, 9000)
+			for {
+				n, _, err := conn.ReadFromUDP(buf)
+				if err != nil {
+					return
+				}
+				select {
+				case packets <- append([]byte(nil), buf[:n]...):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+
+		r.query(r.browseQuestions())
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				r.query(r.browseQuestions())
+			case p, ok := <-packets:
+				if !ok {
+					return
+				}
+				var msg Message
+				if msg.Unpack(p) != nil || !msg.Response {
+					continue
+				}
+				for _, e := range r.handle(&msg) {
+					select {
+					case entries <- e:
+					case <-ctx.Done():
+						return
+					}
+				}
+				r.query(r.pendingQuestions())
+			}
+		}
+	}()
+	return entries, nil
+}
+
+type instance struct {
+	service string
+	host    string
+	port    int
+	text    []string
+	hasSRV  bool
+	hasTXT  bool
+	sent    *Entry
+}
+
+// resolver the state of a Browse, used by its goroutine only
+type resolver struct {
+	conn      *net.UDPConn
+	addr      *net.UDPAddr
+	services  map[string]bool
+	instances map[string]*instance
+	hosts     map[string][]net.IP
+}
+
+func (r *resolver) browseQuestions() (questions []Question) {
+	for s := range r.services {
+		questions = append(questions, Question{Name: s, Type: TypePTR, Unicast: true})
+	}
+	sort.Slice(questions, func(i, j int) bool { return questions[i].Name < questions[j].Name })
+	return
+}
+
+// pendingQuestions asks for what the answers so far left out
+func (r *resolver) pendingQuestions() (questions []Question) {
+	for name, inst := range r.instances {
+		if !inst.hasSRV {
+			questions = append(questions, Question{Name: name, Type: TypeSRV, Unicast: true})
+		}
+		if !inst.hasTXT {
+			questions = append(questions, Question{Name: name, Type: TypeTXT, Unicast: true})
+		}
+		if inst.hasSRV && len(r.hosts[inst.host]) == 0 {
+			questions = append(questions,
+				Question{Name: inst.host, Type: TypeA, Unicast: true},
+				Question{Name: inst.host, Type: TypeAAAA, Unicast: true},
+			)
+		}
+	}
+	return
+}
+
+func (r *resolver) query(questions []Question) {
+	if len(questions) == 0 {
+		return
+	}
+	msg := &Message{ID: uint16(rand.Intn(1 << 16)), Questions: questions}
+	if b, err := msg.Pack(); err == nil {
+		_, _ = r.conn.WriteToUDP(b, r.addr)
+	}
+}
+
+func (r *resolver) handle(msg *Message) (changed []*Entry) {
+	records := append(append([]Record(nil), msg.Answers...), msg.Additionals...)
+
+	// PTR first, the other records are only kept for known instances
+	for _, rr := range records {
+		if rr.Type != TypePTR || !r.services[strings.ToLower(rr.Name)] {
+			continue
+		}
+		key := strings.ToLower(rr.Target)
+		if rr.TTL == 0 {
+			if inst, ok := r.instances[key]; ok {
+				delete(r.instances, key)
+				if inst.sent != nil {
+					changed = append(changed, &Entry{Name: rr.Target, Instance: firstLabel(rr.Target), Service: inst.service, Removed: true})
+				}
+			}
+			continue
+		}
+		if _, ok := r.instances[key]; !ok {
+			r.instances[key] = &instance{service: rr.Name}
+		}
+	}
+
+	flushed := make(map[string]bool)
+	for _, rr := range records {
+		name := strings.ToLower(rr.Name)
+		switch rr.Type {
+		case TypeSRV:
+			if inst, ok := r.instances[name]; ok {
+				inst.host, inst.port, inst.hasSRV = strings.ToLower(rr.Target), int(rr.Port), true
+			}
+		case TypeTXT:
+			if inst, ok := r.instances[name]; ok {
+				inst.text, inst.hasTXT = rr.Text, true
+			}
+		case TypeA, TypeAAAA:
+			// a cache-flush set replaces the addresses known so far
+			if rr.CacheFlush && !flushed[name] {
+				r.hosts[name], flushed[name] = nil, true
+			}
+			if rr.TTL == 0 {
+				r.hosts[name] = removeIP(r.hosts[name], rr.IP)
+			} else if !containsIP(r.hosts[name], rr.IP) {
+				r.hosts[name] = append(r.hosts[name], rr.IP)
+			}
+		}
+	}
+
+	for _, name := range sortedKeys(r.instances) {
+		inst := r.instances[name]
+		ips := r.hosts[inst.host]
+		if !inst.hasSRV || len(ips) == 0 {
+			continue
+		}
+		e := &Entry{
+			Name:    findName(records, name),
+			Service: inst.service,
+			Host:    inst.host,
+			Port:    inst.port,
+			IPs:     append([]net.IP(nil), ips...),
+			Text:    inst.text,
+		}
+		if e.Name == "" && inst.sent != nil {
+			e.Name = inst.sent.Name
+		}
+		if e.Name == "" {
+			e.Name = name
+		}
+		e.Instance = firstLabel(e.Name)
+		if inst.sent != nil && sameEntry(inst.sent, e) {
+			continue
+		}
+		inst.sent = e
+		changed = append(changed, e)
+	}
+	return
+}
+
+// findName the name of key as the responder spelled it
+func findName(records []Record, key string) string {
+	for _, rr := range records {
+		if rr.Type == TypePTR && strings.EqualFold(rr.Target, key) {
+			return rr.Target
+		}
+		if strings.EqualFold(rr.Name, key) {
+			return rr.Name
+		}
+	}
+	return ""
+}
+
+func firstLabel(name string) string {
+	if labels := SplitName(name); len(labels) > 0 {
+		return labels[0]
+	}
+	return ""
+}
+
+func sortedKeys(m map[string]*instance) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, v := range ips {
+		if v.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func removeIP(ips []net.IP, ip net.IP) []net.IP {
+	out := ips[:0]
+	for _, v := range ips {
+		if !v.Equal(ip) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func sameEntry(a, b *Entry) bool {
+	if a.Host != b.Host || a.Port != b.Port || len(a.IPs) != len(b.IPs) || len(a.Text) != len(b.Text) {
+		return false
+	}
+	for i := range a.IPs {
+		if !a.IPs[i].Equal(b.IPs[i]) {
+			return false
+		}
+	}
+	for i := range a.Text {
+		if a.Text[i] != b.Text[i] {
+			return false
+		}
+	}
+	return true
+}
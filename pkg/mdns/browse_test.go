@@ -0,0 +1,62 @@
+package mdns_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/idevicetest"
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/mdns"
+)
+
+func Test_Browser_Browse(t *testing.T) {
+	responder, err := idevicetest.NewResponder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer responder.Close()
+	responder.Advertise(idevicetest.ServiceInstance{
+		Instance: "a4:83:e7:00:00:01@192.168.1.2",
+		Service:  idevicetest.MobileDeviceService,
+		Host:     "iPhone.local.",
+		IPs:      []net.IP{net.IPv4(192, 168, 1, 2), net.ParseIP("fe80::1")},
+		Port:     32498,
+		Text:     []string{"identifier=00008030-001"},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	browser := &mdns.Browser{Addr: responder.Addr(), Interval: 100 * time.Millisecond}
+	entries, err := browser.Browse(ctx, idevicetest.MobileDeviceService)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next := func() *mdns.Entry {
+		select {
+		case e := <-entries:
+			return e
+		case <-time.After(5 * time.Second):
+			t.Fatal("no entry")
+		}
+		return nil
+	}
+
+	e := next()
+	if e.Instance != "a4:83:e7:00:00:01@192.168.1.2" || e.Host != "iphone.local." || e.Port != 32498 || len(e.IPs) != 2 {
+		t.Fatalf("entry: %#v", e)
+	}
+	if v, _ := e.TextValue("identifier"); v != "00008030-001" {
+		t.Fatalf("identifier: %q", v)
+	}
+
+	responder.Withdraw(e.Instance, idevicetest.MobileDeviceService)
+	if e = next(); !e.Removed {
+		t.Fatalf("entry: %#v", e)
+	}
+
+	cancel()
+	for range entries {
+	}
+}
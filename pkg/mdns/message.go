@@ -0,0 +1,368 @@
+// Package mdns browses DNS-SD services over multicast DNS (RFC 6762, RFC 6763),
+// enough to find the network devices advertising `_apple-mobdev2._tcp` and
+// `_remoted._tcp` on the local link.
+//
+// Names are in presentation format: labels separated by '.', with '.' and '\'
+// inside a label escaped by '\', e.g. `a4:83:e7:00:00:01@fe80::1._apple-mobdev2._tcp.local.`
+package mdns
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+var ErrMalformed = errors.New("mdns: malformed message")
+
+type Type uint16
+
+const (
+	TypeA    Type = 1
+	TypePTR  Type = 12
+	TypeTXT  Type = 16
+	TypeAAAA Type = 28
+	TypeSRV  Type = 33
+	TypeANY  Type = 255
+)
+
+const (
+	classINET = 1
+	// the top bit of the class: unicast-response in questions, cache-flush in records
+	classTopBit = 0x8000
+)
+
+type Question struct {
+	Name string
+	Type Type
+	// Unicast asks responders to answer to the source address (QU question)
+	Unicast bool
+}
+
+// Record a resource record, the fields used depend on Type
+type Record struct {
+	Name       string
+	Type       Type
+	TTL        uint32
+	CacheFlush bool
+
+	// Target of PTR and SRV
+	Target   string
+	Priority uint16
+	Weight   uint16
+	Port     uint16
+	// IP of A and AAAA
+	IP net.IP
+	// Text of TXT, one string per entry
+	Text []string
+	// Data of other types
+	Data []byte
+}
+
+type Message struct {
+	ID            uint16
+	Response      bool
+	Authoritative bool
+	Questions     []Question
+	Answers       []Record
+	Additionals   []Record
+}
+
+func (m *Message) Pack() ([]byte, error) {
+	b := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(b[0:], m.ID)
+	var flags uint16
+	if m.Response {
+		flags |= 1 << 15
+	}
+	if m.Authoritative {
+		flags |= 1 << 10
+	}
+	binary.BigEndian.PutUint16(b[2:], flags)
+	binary.BigEndian.PutUint16(b[4:], uint16(len(m.Questions)))
+	binary.BigEndian.PutUint16(b[6:], uint16(len(m.Answers)))
+	binary.BigEndian.PutUint16(b[10:], uint16(len(m.Additionals)))
+
+	var err error
+	for _, q := range m.Questions {
+		if b, err = appendName(b, q.Name); err != nil {
+			return nil, err
+		}
+		class := uint16(classINET)
+		if q.Unicast {
+			class |= classTopBit
+		}
+		b = appendUint16(b, uint16(q.Type))
+		b = appendUint16(b, class)
+	}
+	for _, records := range [][]Record{m.Answers, m.Additionals} {
+		for i := range records {
+			if b, err = appendRecord(b, &records[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return b, nil
+}
+
+func appendRecord(b []byte, r *Record) (_ []byte, err error) {
+	if b, err = appendName(b, r.Name); err != nil {
+		return nil, err
+	}
+	class := uint16(classINET)
+	if r.CacheFlush {
+		class |= classTopBit
+	}
+	b = appendUint16(b, uint16(r.Type))
+	b = appendUint16(b, class)
+	b = appendUint32(b, r.TTL)
+
+	lengthAt := len(b)
+	b = appendUint16(b, 0)
+	switch r.Type {
+	case TypePTR:
+		if b, err = appendName(b, r.Target); err != nil {
+			return nil, err
+		}
+	case TypeSRV:
+		b = appendUint16(b, r.Priority)
+		b = appendUint16(b, r.Weight)
+		b = appendUint16(b, r.Port)
+		if b, err = appendName(b, r.Target); err != nil {
+			return nil, err
+		}
+	case TypeA:
+		ip4 := r.IP.To4()
+		if ip4 == nil {
+			return nil, fmt.Errorf("mdns: A record of %s", r.IP)
+		}
+		b = append(b, ip4...)
+	case TypeAAAA:
+		b = append(b, r.IP.To16()...)
+	case TypeTXT:
+		if len(r.Text) == 0 {
+			b = append(b, 0)
+		}
+		for _, s := range r.Text {
+			if len(s) > 255 {
+				return nil, fmt.Errorf("mdns: TXT entry longer than 255 bytes")
+			}
+			b = append(b, byte(len(s)))
+			b = append(b, s...)
+		}
+	default:
+		b = append(b, r.Data...)
+	}
+	binary.BigEndian.PutUint16(b[lengthAt:], uint16(len(b)-lengthAt-2))
+	return b, nil
+}
+
+func (m *Message) Unpack(b []byte) (err error) {
+	if len(b) < 12 {
+		return ErrMalformed
+	}
+	m.ID = binary.BigEndian.Uint16(b[0:])
+	flags := binary.BigEndian.Uint16(b[2:])
+	m.Response = flags&(1<<15) != 0
+	m.Authoritative = flags&(1<<10) != 0
+	qdCount := int(binary.BigEndian.Uint16(b[4:]))
+	anCount := int(binary.BigEndian.Uint16(b[6:]))
+	nsCount := int(binary.BigEndian.Uint16(b[8:]))
+	arCount := int(binary.BigEndian.Uint16(b[10:]))
+
+	off := 12
+	m.Questions = make([]Question, 0, qdCount)
+	for i := 0; i < qdCount; i++ {
+		var q Question
+		if q.Name, off, err = readName(b, off); err != nil {
+			return err
+		}
+		if off+4 > len(b) {
+			return ErrMalformed
+		}
+		q.Type = Type(binary.BigEndian.Uint16(b[off:]))
+		q.Unicast = binary.BigEndian.Uint16(b[off+2:])&classTopBit != 0
+		off += 4
+		m.Questions = append(m.Questions, q)
+	}
+
+	m.Answers, m.Additionals = nil, nil
+	for i := 0; i < anCount+nsCount+arCount; i++ {
+		var r Record
+		if r, off, err = readRecord(b, off); err != nil {
+			return err
+		}
+		switch {
+		case i < anCount:
+			m.Answers = append(m.Answers, r)
+		case i >= anCount+nsCount:
+			m.Additionals = append(m.Additionals, r)
+		}
+	}
+	return nil
+}
+
+func readRecord(b []byte, off int) (r Record, _ int, err error) {
+	if r.Name, off, err = readName(b, off); err != nil {
+		return r, 0, err
+	}
+	if off+10 > len(b) {
+		return r, 0, ErrMalformed
+	}
+	r.Type = Type(binary.BigEndian.Uint16(b[off:]))
+	r.CacheFlush = binary.BigEndian.Uint16(b[off+2:])&classTopBit != 0
+	r.TTL = binary.BigEndian.Uint32(b[off+4:])
+	length := int(binary.BigEndian.Uint16(b[off+8:]))
+	off += 10
+	end := off + length
+	if end > len(b) {
+		return r, 0, ErrMalformed
+	}
+	data := b[off:end]
+
+	switch r.Type {
+	case TypePTR:
+		if r.Target, _, err = readName(b, off); err != nil {
+			return r, 0, err
+		}
+	case TypeSRV:
+		if length < 7 {
+			return r, 0, ErrMalformed
+		}
+		r.Priority = binary.BigEndian.Uint16(data[0:])
+		r.Weight = binary.BigEndian.Uint16(data[2:])
+		r.Port = binary.BigEndian.Uint16(data[4:])
+		if r.Target, _, err = readName(b, off+6); err != nil {
+			return r, 0, err
+		}
+	case TypeA:
+		if length != net.IPv4len {
+			return r, 0, ErrMalformed
+		}
+		r.IP = net.IPv4(data[0], data[1], data[2], data[3])
+	case TypeAAAA:
+		if length != net.IPv6len {
+			return r, 0, ErrMalformed
+		}
+		r.IP = append(net.IP(nil), data...)
+	case TypeTXT:
+		for i := 0; i < len(data); {
+			n := int(data[i])
+			if i+1+n > len(data) {
+				return r, 0, ErrMalformed
+			}
+			if n > 0 {
+				r.Text = append(r.Text, string(data[i+1:i+1+n]))
+			}
+			i += 1 + n
+		}
+	default:
+		r.Data = append([]byte(nil), data...)
+	}
+	return r, end, nil
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// appendName writes name uncompressed
+func appendName(b []byte, name string) ([]byte, error) {
+	for _, label := range SplitName(name) {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, fmt.Errorf("mdns: bad label in %q", name)
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0), nil
+}
+
+// readName reads the possibly compressed name at off, returning the offset after it
+func readName(b []byte, off int) (name string, next int, err error) {
+	var sb strings.Builder
+	next = -1
+	for hops := 0; ; hops++ {
+		if off >= len(b) || hops > 128 {
+			return "", 0, ErrMalformed
+		}
+		n := int(b[off])
+		switch {
+		case n == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			if sb.Len() == 0 {
+				return ".", next, nil
+			}
+			return sb.String(), next, nil
+		case n&0xC0 == 0xC0:
+			if off+1 >= len(b) {
+				return "", 0, ErrMalformed
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(b[off:]) & 0x3FFF)
+		case n&0xC0 == 0:
+			if off+1+n > len(b) {
+				return "", 0, ErrMalformed
+			}
+			sb.WriteString(escapeLabel(string(b[off+1 : off+1+n])))
+			sb.WriteByte('.')
+			off += 1 + n
+		default:
+			return "", 0, ErrMalformed
+		}
+	}
+}
+
+func escapeLabel(label string) string {
+	if !strings.ContainsAny(label, `.\`) {
+		return label
+	}
+	var sb strings.Builder
+	for i := 0; i < len(label); i++ {
+		if label[i] == '.' || label[i] == '\\' {
+			sb.WriteByte('\\')
+		}
+		sb.WriteByte(label[i])
+	}
+	return sb.String()
+}
+
+// JoinName builds a name from raw labels, escaping them
+func JoinName(labels ...string) string {
+	var sb strings.Builder
+	for _, label := range labels {
+		sb.WriteString(escapeLabel(label))
+		sb.WriteByte('.')
+	}
+	return sb.String()
+}
+
+// SplitName the raw labels of name
+func SplitName(name string) (labels []string) {
+	var sb strings.Builder
+	for i := 0; i < len(name); i++ {
+		switch c := name[i]; {
+		case c == '\\' && i+1 < len(name):
+			i++
+			sb.WriteByte(name[i])
+		case c == '.':
+			labels = append(labels, sb.String())
+			sb.Reset()
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	if sb.Len() > 0 {
+		labels = append(labels, sb.String())
+	}
+	return
+}
@@ -0,0 +1,73 @@
+package mdns
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func Test_Message_Pack(t *testing.T) {
+	instance := JoinName("a4:83:e7:00:00:01@fe80::1", "_apple-mobdev2", "_tcp", "local")
+	msg := &Message{
+		ID:            7,
+		Response:      true,
+		Authoritative: true,
+		Questions:     []Question{{Name: "_apple-mobdev2._tcp.local.", Type: TypePTR, Unicast: true}},
+		Answers: []Record{
+			{Name: "_apple-mobdev2._tcp.local.", Type: TypePTR, TTL: 4500, Target: instance},
+		},
+		Additionals: []Record{
+			{Name: instance, Type: TypeSRV, TTL: 120, CacheFlush: true, Port: 32498, Target: "iPhone.local."},
+			{Name: instance, Type: TypeTXT, TTL: 4500, Text: []string{"identifier=00008030-001"}},
+			{Name: "iPhone.local.", Type: TypeA, TTL: 120, IP: net.IPv4(192, 168, 1, 2)},
+			{Name: "iPhone.local.", Type: TypeAAAA, TTL: 120, IP: net.ParseIP("fe80::1")},
+		},
+	}
+	b, err := msg.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got Message
+	if err = got.Unpack(b); err != nil {
+		t.Fatal(err)
+	}
+	if got.Answers[0].Target != instance {
+		t.Fatalf("PTR target: %q", got.Answers[0].Target)
+	}
+	if labels := SplitName(got.Answers[0].Target); labels[0] != "a4:83:e7:00:00:01@fe80::1" {
+		t.Fatalf("labels: %q", labels)
+	}
+	for i := range got.Additionals {
+		got.Additionals[i].IP = got.Additionals[i].IP.To16()
+		msg.Additionals[i].IP = msg.Additionals[i].IP.To16()
+	}
+	if !reflect.DeepEqual(msg, &got) {
+		t.Fatalf("\n%#v\n%#v", msg, &got)
+	}
+}
+
+func Test_Message_Unpack(t *testing.T) {
+	// a PTR answer whose target points back into the question name
+	b := []byte{
+		0, 0, 0x84, 0, 0, 1, 0, 1, 0, 0, 0, 0,
+		5, '_', 't', 'e', 's', 't', 4, '_', 't', 'c', 'p', 5, 'l', 'o', 'c', 'a', 'l', 0,
+		0, 12, 0, 1,
+		0xC0, 12, 0, 12, 0, 1, 0, 0, 0, 10, 0, 5,
+		2, 'd', '.', 0xC0, 12,
+	}
+	var msg Message
+	if err := msg.Unpack(b); err != nil {
+		t.Fatal(err)
+	}
+	if !msg.Response || !msg.Authoritative {
+		t.Fatalf("flags: %#v", msg)
+	}
+	if msg.Answers[0].Name != "_test._tcp.local." || msg.Answers[0].Target != `d\.._test._tcp.local.` {
+		t.Fatalf("answer: %#v", msg.Answers[0])
+	}
+
+	if err := msg.Unpack(b[:len(b)-1]); err != ErrMalformed {
+		t.Fatalf("expected ErrMalformed, got %v", err)
+	}
+}
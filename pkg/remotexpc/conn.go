@@ -0,0 +1,216 @@
+package remotexpc
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// the HTTP/2 streams of a RemoteXPC connection
+const (
+	RootChannel  uint32 = 1
+	ReplyChannel uint32 = 3
+)
+
+var ErrGoAway = errors.New("remotexpc: connection closed by peer (GOAWAY)")
+
+// Conn a RemoteXPC connection. Send may be called alongside Receive,
+// but Receive is not safe for concurrent use.
+type Conn struct {
+	conn net.Conn
+	r    *bufio.Reader
+
+	mu     sync.Mutex
+	nextID map[uint32]uint64
+
+	buffers map[uint32][]byte
+	pending []*Wrapper
+}
+
+func newConn(conn net.Conn) *Conn {
+	return &Conn{
+		conn:    conn,
+		r:       bufio.NewReader(conn),
+		nextID:  make(map[uint32]uint64),
+		buffers: make(map[uint32][]byte),
+	}
+}
+
+// NewConn opens RemoteXPC over conn as a host does: the HTTP/2 preface and
+// settings, then the root and reply channels.
+func NewConn(conn net.Conn) (c *Conn, err error) {
+	c = newConn(conn)
+	if _, err = io.WriteString(conn, http2Preface); err != nil {
+		return nil, fmt.Errorf("remotexpc: %w", err)
+	}
+	if err = c.writeFrames(
+		settingsFrame(settingMaxConcurrentStreams, 100, settingInitialWindowSize, initialWindowSize),
+		windowUpdateFrame(0, connWindowIncrement),
+	); err != nil {
+		return nil, err
+	}
+
+	if err = c.openChannel(RootChannel, &Wrapper{Flags: FlagAlwaysSet, Payload: map[string]interface{}{}}); err != nil {
+		return nil, err
+	}
+	if err = c.send(RootChannel, &Wrapper{Flags: FlagAlwaysSet | flagRootChannelOpen}); err != nil {
+		return nil, err
+	}
+	if err = c.openChannel(ReplyChannel, &Wrapper{Flags: FlagAlwaysSet | FlagInitHandshake}); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewPeerConn accepts RemoteXPC over conn as a device does, for stand-ins of one
+func NewPeerConn(conn net.Conn) (c *Conn, err error) {
+	c = newConn(conn)
+	preface := make([]byte, len(http2Preface))
+	if _, err = io.ReadFull(c.r, preface); err != nil {
+		return nil, fmt.Errorf("remotexpc: %w", err)
+	}
+	if string(preface) != http2Preface {
+		return nil, fmt.Errorf("%w: http2 preface", ErrMalformed)
+	}
+	if err = c.writeFrames(
+		settingsFrame(settingMaxConcurrentStreams, 100, settingInitialWindowSize, initialWindowSize),
+		windowUpdateFrame(0, connWindowIncrement),
+	); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// Send msg on the root channel
+func (c *Conn) Send(msg map[string]interface{}, wantingReply bool) error {
+	flags := FlagAlwaysSet
+	if len(msg) != 0 {
+		flags |= FlagDataPresent
+	}
+	if wantingReply {
+		flags |= FlagWantingReply
+	}
+	return c.send(RootChannel, &Wrapper{Flags: flags, Payload: msg})
+}
+
+// Receive the next message carrying a non-empty dictionary, whatever its channel
+func (c *Conn) Receive() (msg map[string]interface{}, err error) {
+	for {
+		var w *Wrapper
+		if w, err = c.ReceiveWrapper(); err != nil {
+			return nil, err
+		}
+		if m, ok := w.Payload.(map[string]interface{}); ok && len(m) != 0 {
+			return m, nil
+		}
+	}
+}
+
+// ReceiveWrapper the next XPC message, handling the HTTP/2 frames in between
+func (c *Conn) ReceiveWrapper() (w *Wrapper, err error) {
+	for len(c.pending) == 0 {
+		var f frame
+		if f, err = readFrame(c.r); err != nil {
+			return nil, fmt.Errorf("remotexpc: %w", err)
+		}
+		if err = c.handleFrame(f); err != nil {
+			return nil, err
+		}
+	}
+	w, c.pending = c.pending[0], c.pending[1:]
+	return w, nil
+}
+
+func (c *Conn) handleFrame(f frame) (err error) {
+	switch f.typ {
+	case frameSettings:
+		if f.flags&flagAck == 0 {
+			return c.writeFrames(frame{typ: frameSettings, flags: flagAck})
+		}
+	case framePing:
+		if f.flags&flagAck == 0 {
+			return c.writeFrames(frame{typ: framePing, flags: flagAck, payload: f.payload})
+		}
+	case frameGoAway:
+		return ErrGoAway
+	case frameData:
+		var data []byte
+		if data, err = f.data(); err != nil {
+			return err
+		}
+		if len(f.payload) != 0 {
+			// keep the windows open, messages are consumed as they arrive
+			if err = c.writeFrames(
+				windowUpdateFrame(0, uint32(len(f.payload))),
+				windowUpdateFrame(f.streamID, uint32(len(f.payload))),
+			); err != nil {
+				return err
+			}
+		}
+		buf := append(c.buffers[f.streamID], data...)
+		for {
+			w, n, err := parseWrapper(buf)
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				break
+			}
+			buf = buf[n:]
+			c.pending = append(c.pending, w)
+			c.mu.Lock()
+			if w.MessageID >= c.nextID[f.streamID] {
+				c.nextID[f.streamID] = w.MessageID + 1
+			}
+			c.mu.Unlock()
+		}
+		c.buffers[f.streamID] = buf
+	}
+	// HEADERS carry no fields worth decoding, the rest needs no answer
+	return nil
+}
+
+func (c *Conn) openChannel(streamID uint32, w *Wrapper) error {
+	if err := c.writeFrames(frame{typ: frameHeaders, flags: flagEndHeaders, streamID: streamID}); err != nil {
+		return err
+	}
+	return c.send(streamID, w)
+}
+
+// send w on streamID with the next message id of the stream
+func (c *Conn) send(streamID uint32, w *Wrapper) error {
+	c.mu.Lock()
+	w.MessageID = c.nextID[streamID]
+	c.nextID[streamID]++
+	c.mu.Unlock()
+
+	data, err := w.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	frames := make([]frame, 0, len(data)/maxFrameSize+1)
+	for len(data) > maxFrameSize {
+		frames = append(frames, frame{typ: frameData, streamID: streamID, payload: data[:maxFrameSize]})
+		data = data[maxFrameSize:]
+	}
+	frames = append(frames, frame{typ: frameData, streamID: streamID, payload: data})
+	return c.writeFrames(frames...)
+}
+
+func (c *Conn) writeFrames(frames ...frame) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, f := range frames {
+		if err := writeFrame(c.conn, f); err != nil {
+			return fmt.Errorf("remotexpc: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package remotexpc
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// servePeer accepts one connection, answering like RemoteServiceDiscovery
+func servePeer(t *testing.T, sd *ServiceDirectory, then func(c *Conn)) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		c, err := NewPeerConn(conn)
+		if err != nil {
+			return
+		}
+		if err = c.Send(HandshakeMessage(sd), false); err != nil {
+			return
+		}
+		if then != nil {
+			then(c)
+		}
+		// until the host hangs up
+		for {
+			if _, err = c.ReceiveWrapper(); err != nil {
+				return
+			}
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func Test_Discover(t *testing.T) {
+	addr := servePeer(t, &ServiceDirectory{
+		UUID:       "4B7F4F8E-0000-0000-0000-000000000000",
+		Properties: map[string]interface{}{"UniqueDeviceID": "00008030-001", "ProductVersion": "17.0"},
+		Services: map[string]Service{
+			"com.apple.instruments.dtservicehub": {Port: 50001},
+			"com.apple.afc.shim.remote":          {Port: 50002, Properties: map[string]interface{}{"UsesRemoteXPC": false}},
+		},
+	}, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	sd, err := Discover(ctx, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sd.UDID() != "00008030-001" || sd.UUID == "" {
+		t.Fatalf("directory: %#v", sd)
+	}
+	if port, ok := sd.Port("com.apple.afc" + ShimSuffix); !ok || port != 50002 {
+		t.Fatalf("port: %d %v", port, ok)
+	}
+	if _, ok := sd.Port("com.apple.afc"); ok {
+		t.Fatal("unexpected service")
+	}
+}
+
+func Test_Conn_Send(t *testing.T) {
+	received := make(chan map[string]interface{}, 1)
+	addr := servePeer(t, &ServiceDirectory{}, func(c *Conn) {
+		msg, err := c.Receive()
+		if err != nil {
+			return
+		}
+		received <- msg
+		// larger than a frame
+		_ = c.Send(map[string]interface{}{"Reply": strings.Repeat("x", 3*maxFrameSize)}, false)
+	})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c, err := NewConn(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Receive(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = c.Send(map[string]interface{}{"Request": "Ping"}, true); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-received:
+		if msg["Request"] != "Ping" {
+			t.Fatalf("peer received %v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("peer received nothing")
+	}
+
+	msg, err := c.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := msg["Reply"].(string); len(s) != 3*maxFrameSize {
+		t.Fatalf("reply of %d bytes", len(s))
+	}
+}
@@ -0,0 +1,110 @@
+package remotexpc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// just enough HTTP/2 (RFC 7540) to carry RemoteXPC: its streams have no
+// header fields and DATA frames hold the XPC wrappers
+
+const http2Preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+type frameType uint8
+
+const (
+	frameData         frameType = 0x0
+	frameHeaders      frameType = 0x1
+	frameRSTStream    frameType = 0x3
+	frameSettings     frameType = 0x4
+	framePing         frameType = 0x6
+	frameGoAway       frameType = 0x7
+	frameWindowUpdate frameType = 0x8
+)
+
+const (
+	flagEndStream  = 0x1
+	flagAck        = 0x1
+	flagEndHeaders = 0x4
+	flagPadded     = 0x8
+	flagPriority   = 0x20
+)
+
+const (
+	settingMaxConcurrentStreams = 0x3
+	settingInitialWindowSize    = 0x4
+)
+
+const (
+	maxFrameSize      = 16384
+	initialWindowSize = 1048576
+	// the connection window starts at 65535 whatever the settings say
+	connWindowIncrement = initialWindowSize - 65535
+)
+
+type frame struct {
+	typ      frameType
+	flags    uint8
+	streamID uint32
+	payload  []byte
+}
+
+func writeFrame(w io.Writer, f frame) error {
+	b := make([]byte, 9, 9+len(f.payload))
+	b[0], b[1], b[2] = byte(len(f.payload)>>16), byte(len(f.payload)>>8), byte(len(f.payload))
+	b[3], b[4] = byte(f.typ), f.flags
+	binary.BigEndian.PutUint32(b[5:], f.streamID&0x7fffffff)
+	_, err := w.Write(append(b, f.payload...))
+	return err
+}
+
+func readFrame(r io.Reader) (f frame, err error) {
+	var head [9]byte
+	if _, err = io.ReadFull(r, head[:]); err != nil {
+		return f, err
+	}
+	length := int(head[0])<<16 | int(head[1])<<8 | int(head[2])
+	f.typ, f.flags = frameType(head[3]), head[4]
+	f.streamID = binary.BigEndian.Uint32(head[5:]) & 0x7fffffff
+	f.payload = make([]byte, length)
+	if _, err = io.ReadFull(r, f.payload); err != nil {
+		return f, err
+	}
+	return f, nil
+}
+
+// data the content of a DATA or HEADERS frame, without padding and priority
+func (f *frame) data() ([]byte, error) {
+	p := f.payload
+	if f.flags&flagPadded != 0 {
+		if len(p) < 1 || int(p[0]) >= len(p) {
+			return nil, fmt.Errorf("%w: http2 padding", ErrMalformed)
+		}
+		p = p[1 : len(p)-int(p[0])]
+	}
+	if f.typ == frameHeaders && f.flags&flagPriority != 0 {
+		if len(p) < 5 {
+			return nil, fmt.Errorf("%w: http2 priority", ErrMalformed)
+		}
+		p = p[5:]
+	}
+	return p, nil
+}
+
+func settingsFrame(settings ...uint32) frame {
+	payload := make([]byte, 0, len(settings)*3)
+	for i := 0; i+1 < len(settings); i += 2 {
+		var b [6]byte
+		binary.BigEndian.PutUint16(b[0:], uint16(settings[i]))
+		binary.BigEndian.PutUint32(b[2:], settings[i+1])
+		payload = append(payload, b[:]...)
+	}
+	return frame{typ: frameSettings, payload: payload}
+}
+
+func windowUpdateFrame(streamID, increment uint32) frame {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, increment&0x7fffffff)
+	return frame{typ: frameWindowUpdate, streamID: streamID, payload: payload}
+}
@@ -0,0 +1,144 @@
+package remotexpc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// RSDPort where RemoteServiceDiscovery listens on the device end of a tunnel
+const RSDPort = 58783
+
+// ShimSuffix turns a lockdown service name into the RSD one serving the same protocol,
+// e.g. `com.apple.afc.shim.remote`. Those want an `RSDCheckin` first.
+const ShimSuffix = ".shim.remote"
+
+// Service an entry of the ServiceDirectory
+type Service struct {
+	Port       int
+	Properties map[string]interface{}
+}
+
+// ServiceDirectory what RemoteServiceDiscovery tells in its handshake
+type ServiceDirectory struct {
+	UUID       string
+	Properties map[string]interface{}
+	Services   map[string]Service
+}
+
+// Port the port service listens on
+func (sd *ServiceDirectory) Port(service string) (port int, ok bool) {
+	svc, ok := sd.Services[service]
+	return svc.Port, ok
+}
+
+// UDID the `UniqueDeviceID` of the device
+func (sd *ServiceDirectory) UDID() string {
+	s, _ := sd.Properties["UniqueDeviceID"].(string)
+	return s
+}
+
+// Handshake reads the service directory RemoteServiceDiscovery sends over conn
+func Handshake(conn net.Conn) (sd *ServiceDirectory, err error) {
+	var c *Conn
+	if c, err = NewConn(conn); err != nil {
+		return nil, err
+	}
+	var msg map[string]interface{}
+	if msg, err = c.Receive(); err != nil {
+		return nil, err
+	}
+	return parseHandshake(msg)
+}
+
+// Discover connects to RemoteServiceDiscovery at addr for its service directory
+func Discover(ctx context.Context, addr string) (sd *ServiceDirectory, err error) {
+	var conn net.Conn
+	if conn, err = (&net.Dialer{Timeout: 30 * time.Second}).DialContext(ctx, "tcp", addr); err != nil {
+		return nil, fmt.Errorf("remotexpc: %w", err)
+	}
+	defer conn.Close()
+	return DiscoverConn(ctx, conn)
+}
+
+// DiscoverConn is Discover over conn, connected to RemoteServiceDiscovery already
+func DiscoverConn(ctx context.Context, conn net.Conn) (sd *ServiceDirectory, err error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.SetDeadline(time.Unix(1, 0))
+		case <-stop:
+		}
+	}()
+
+	if sd, err = Handshake(conn); err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return
+}
+
+func parseHandshake(msg map[string]interface{}) (sd *ServiceDirectory, err error) {
+	if t, _ := msg["MessageType"].(string); t != "Handshake" {
+		return nil, fmt.Errorf("%w: unexpected message %q", ErrMalformed, t)
+	}
+	sd = &ServiceDirectory{Services: make(map[string]Service)}
+	sd.Properties, _ = msg["Properties"].(map[string]interface{})
+	switch u := msg["UUID"].(type) {
+	case string:
+		sd.UUID = u
+	case fmt.Stringer:
+		sd.UUID = u.String()
+	}
+
+	services, _ := msg["Services"].(map[string]interface{})
+	for name, v := range services {
+		entry, _ := v.(map[string]interface{})
+		svc := Service{}
+		svc.Properties, _ = entry["Properties"].(map[string]interface{})
+		switch port := entry["Port"].(type) {
+		case string:
+			if svc.Port, err = strconv.Atoi(port); err != nil {
+				return nil, fmt.Errorf("%w: port of %s", ErrMalformed, name)
+			}
+		case int64:
+			svc.Port = int(port)
+		case uint64:
+			svc.Port = int(port)
+		}
+		sd.Services[name] = svc
+	}
+	return sd, nil
+}
+
+// HandshakeMessage the message RemoteServiceDiscovery answers with, for stand-ins of one
+func HandshakeMessage(sd *ServiceDirectory) map[string]interface{} {
+	services := make(map[string]interface{}, len(sd.Services))
+	for name, svc := range sd.Services {
+		properties := svc.Properties
+		if properties == nil {
+			properties = map[string]interface{}{}
+		}
+		services[name] = map[string]interface{}{
+			"Port":       strconv.Itoa(svc.Port),
+			"Properties": properties,
+		}
+	}
+	properties := sd.Properties
+	if properties == nil {
+		properties = map[string]interface{}{}
+	}
+	return map[string]interface{}{
+		"MessageType":              "Handshake",
+		"MessagingProtocolVersion": uint64(3),
+		"UUID":                     sd.UUID,
+		"Properties":               properties,
+		"Services":                 services,
+	}
+}
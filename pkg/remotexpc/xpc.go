@@ -0,0 +1,373 @@
+// Package remotexpc speaks RemoteXPC, the XPC messages over HTTP/2 that iOS 17
+// moved the developer services to, and RemoteServiceDiscovery, the directory
+// of those services.
+package remotexpc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	wrapperMagic    = 0x29b00b92
+	payloadMagic    = 0x42133742
+	payloadVersion  = 0x00000005
+	wrapperHeadSize = 24
+)
+
+// Flags of an XPC wrapper
+type Flags uint32
+
+const (
+	FlagAlwaysSet            Flags = 0x00000001
+	FlagPing                 Flags = 0x00000002
+	FlagDataPresent          Flags = 0x00000100
+	FlagWantingReply         Flags = 0x00010000
+	FlagReply                Flags = 0x00020000
+	FlagFileTxStreamRequest  Flags = 0x00100000
+	FlagFileTxStreamResponse Flags = 0x00200000
+	FlagInitHandshake        Flags = 0x00400000
+	// the flag of the second, empty, message opening the root channel
+	flagRootChannelOpen Flags = 0x00000200
+)
+
+type objectType uint32
+
+const (
+	typeNull       objectType = 0x1000
+	typeBool       objectType = 0x2000
+	typeInt64      objectType = 0x3000
+	typeUint64     objectType = 0x4000
+	typeDouble     objectType = 0x5000
+	typeDate       objectType = 0x7000
+	typeData       objectType = 0x8000
+	typeString     objectType = 0x9000
+	typeUUID       objectType = 0xa000
+	typeArray      objectType = 0xe000
+	typeDictionary objectType = 0xf000
+)
+
+var ErrMalformed = errors.New("remotexpc: malformed message")
+
+// Wrapper an XPC message on the wire. Payload is nil (none) or one of bool, int64, uint64,
+// float64, time.Time, []byte, string, uuid.UUID, []interface{}, map[string]interface{}
+type Wrapper struct {
+	Flags     Flags
+	MessageID uint64
+	Payload   interface{}
+}
+
+func (w *Wrapper) MarshalBinary() (data []byte, err error) {
+	var payload []byte
+	if w.Payload != nil {
+		buf := new(bytes.Buffer)
+		writeUint32(buf, payloadMagic)
+		writeUint32(buf, payloadVersion)
+		if err = encodeObject(buf, w.Payload); err != nil {
+			return nil, err
+		}
+		payload = buf.Bytes()
+	}
+
+	data = make([]byte, wrapperHeadSize, wrapperHeadSize+len(payload))
+	binary.LittleEndian.PutUint32(data[0:], wrapperMagic)
+	binary.LittleEndian.PutUint32(data[4:], uint32(w.Flags))
+	binary.LittleEndian.PutUint64(data[8:], uint64(len(payload)))
+	binary.LittleEndian.PutUint64(data[16:], w.MessageID)
+	return append(data, payload...), nil
+}
+
+// parseWrapper the wrapper at the start of data, n is 0 while data is incomplete
+func parseWrapper(data []byte) (w *Wrapper, n int, err error) {
+	if len(data) < wrapperHeadSize {
+		return nil, 0, nil
+	}
+	if binary.LittleEndian.Uint32(data) != wrapperMagic {
+		return nil, 0, fmt.Errorf("%w: wrapper magic %#x", ErrMalformed, binary.LittleEndian.Uint32(data))
+	}
+	size := binary.LittleEndian.Uint64(data[8:])
+	if size > math.MaxInt32 {
+		return nil, 0, fmt.Errorf("%w: wrapper size %d", ErrMalformed, size)
+	}
+	if uint64(len(data)-wrapperHeadSize) < size {
+		return nil, 0, nil
+	}
+	w = &Wrapper{
+		Flags:     Flags(binary.LittleEndian.Uint32(data[4:])),
+		MessageID: binary.LittleEndian.Uint64(data[16:]),
+	}
+	n = wrapperHeadSize + int(size)
+	if size == 0 {
+		return w, n, nil
+	}
+
+	payload := data[wrapperHeadSize:n]
+	if len(payload) < 8 || binary.LittleEndian.Uint32(payload) != payloadMagic {
+		return nil, 0, fmt.Errorf("%w: payload magic", ErrMalformed)
+	}
+	r := bytes.NewReader(payload[8:])
+	if w.Payload, err = decodeObject(r); err != nil {
+		return nil, 0, err
+	}
+	return w, n, nil
+}
+
+// Marshal encodes v as an XPC object
+func Marshal(v interface{}) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := encodeObject(buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal decodes the XPC object of data
+func Unmarshal(data []byte) (v interface{}, err error) {
+	return decodeObject(bytes.NewReader(data))
+}
+
+func encodeObject(buf *bytes.Buffer, v interface{}) (err error) {
+	switch v := v.(type) {
+	case nil:
+		writeUint32(buf, uint32(typeNull))
+	case bool:
+		writeUint32(buf, uint32(typeBool))
+		if v {
+			writeUint32(buf, 1)
+		} else {
+			writeUint32(buf, 0)
+		}
+	case int:
+		writeUint32(buf, uint32(typeInt64))
+		writeUint64(buf, uint64(v))
+	case int64:
+		writeUint32(buf, uint32(typeInt64))
+		writeUint64(buf, uint64(v))
+	case uint64:
+		writeUint32(buf, uint32(typeUint64))
+		writeUint64(buf, v)
+	case float64:
+		writeUint32(buf, uint32(typeDouble))
+		writeUint64(buf, math.Float64bits(v))
+	case time.Time:
+		writeUint32(buf, uint32(typeDate))
+		writeUint64(buf, uint64(v.UnixNano()))
+	case []byte:
+		writeUint32(buf, uint32(typeData))
+		writeUint32(buf, uint32(len(v)))
+		buf.Write(v)
+		pad(buf, len(v))
+	case string:
+		writeUint32(buf, uint32(typeString))
+		writeString(buf, v, true)
+	case uuid.UUID:
+		writeUint32(buf, uint32(typeUUID))
+		buf.Write(v.Bytes())
+	case []interface{}:
+		writeUint32(buf, uint32(typeArray))
+		body := new(bytes.Buffer)
+		writeUint32(body, uint32(len(v)))
+		for _, e := range v {
+			if err = encodeObject(body, e); err != nil {
+				return err
+			}
+		}
+		writeUint32(buf, uint32(body.Len()))
+		buf.Write(body.Bytes())
+	case map[string]interface{}:
+		writeUint32(buf, uint32(typeDictionary))
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		body := new(bytes.Buffer)
+		writeUint32(body, uint32(len(v)))
+		for _, k := range keys {
+			writeString(body, k, false)
+			if err = encodeObject(body, v[k]); err != nil {
+				return err
+			}
+		}
+		writeUint32(buf, uint32(body.Len()))
+		buf.Write(body.Bytes())
+	default:
+		return fmt.Errorf("remotexpc: unsupported type %T", v)
+	}
+	return nil
+}
+
+func decodeObject(r *bytes.Reader) (v interface{}, err error) {
+	var t uint32
+	if t, err = readUint32(r); err != nil {
+		return nil, err
+	}
+	switch objectType(t) {
+	case typeNull:
+		return nil, nil
+	case typeBool:
+		var b uint32
+		b, err = readUint32(r)
+		return b != 0, err
+	case typeInt64:
+		var i uint64
+		i, err = readUint64(r)
+		return int64(i), err
+	case typeUint64:
+		return readUint64(r)
+	case typeDouble:
+		var f uint64
+		f, err = readUint64(r)
+		return math.Float64frombits(f), err
+	case typeDate:
+		var ns uint64
+		ns, err = readUint64(r)
+		return time.Unix(0, int64(ns)), err
+	case typeData:
+		var b []byte
+		if b, err = readPadded(r); err != nil {
+			return nil, err
+		}
+		return b, nil
+	case typeString:
+		var b []byte
+		if b, err = readPadded(r); err != nil {
+			return nil, err
+		}
+		return string(bytes.TrimRight(b, "\x00")), nil
+	case typeUUID:
+		var u uuid.UUID
+		if _, err = io.ReadFull(r, u[:]); err != nil {
+			return nil, ErrMalformed
+		}
+		return u, nil
+	case typeArray:
+		var count uint32
+		if _, err = readUint32(r); err != nil {
+			return nil, err
+		}
+		if count, err = readUint32(r); err != nil {
+			return nil, err
+		}
+		a := make([]interface{}, 0, minInt(int(count), r.Len()))
+		for i := uint32(0); i < count; i++ {
+			var e interface{}
+			if e, err = decodeObject(r); err != nil {
+				return nil, err
+			}
+			a = append(a, e)
+		}
+		return a, nil
+	case typeDictionary:
+		var count uint32
+		if _, err = readUint32(r); err != nil {
+			return nil, err
+		}
+		if count, err = readUint32(r); err != nil {
+			return nil, err
+		}
+		m := make(map[string]interface{}, minInt(int(count), r.Len()))
+		for i := uint32(0); i < count; i++ {
+			var k string
+			if k, err = readKey(r); err != nil {
+				return nil, err
+			}
+			if m[k], err = decodeObject(r); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("%w: object type %#x", ErrMalformed, t)
+}
+
+func writeUint32(buf *bytes.Buffer, v uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	buf.Write(b[:])
+}
+
+func writeUint64(buf *bytes.Buffer, v uint64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	buf.Write(b[:])
+}
+
+// writeString a NUL terminated string padded to 4 bytes, its length first unless a dictionary key
+func writeString(buf *bytes.Buffer, s string, withLength bool) {
+	if withLength {
+		writeUint32(buf, uint32(len(s)+1))
+	}
+	buf.WriteString(s)
+	buf.WriteByte(0)
+	pad(buf, len(s)+1)
+}
+
+func pad(buf *bytes.Buffer, n int) {
+	for ; n%4 != 0; n++ {
+		buf.WriteByte(0)
+	}
+}
+
+func readUint32(r *bytes.Reader) (uint32, error) {
+	var b [4]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, ErrMalformed
+	}
+	return binary.LittleEndian.Uint32(b[:]), nil
+}
+
+func readUint64(r *bytes.Reader) (uint64, error) {
+	var b [8]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, ErrMalformed
+	}
+	return binary.LittleEndian.Uint64(b[:]), nil
+}
+
+// readPadded the length prefixed bytes, skipping the padding after them
+func readPadded(r *bytes.Reader) ([]byte, error) {
+	n, err := readUint32(r)
+	if err != nil {
+		return nil, err
+	}
+	if int64(n) > int64(r.Len()) {
+		return nil, ErrMalformed
+	}
+	b := make([]byte, n)
+	_, _ = io.ReadFull(r, b)
+	_, _ = r.Seek(int64((4-n%4)%4), io.SeekCurrent)
+	return b, nil
+}
+
+// readKey the NUL terminated key of a dictionary entry, padded to 4 bytes
+func readKey(r *bytes.Reader) (string, error) {
+	var key []byte
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return "", ErrMalformed
+		}
+		if c == 0 {
+			break
+		}
+		key = append(key, c)
+	}
+	_, _ = r.Seek(int64((4-(len(key)+1)%4)%4), io.SeekCurrent)
+	return string(key), nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,78 @@
+package remotexpc
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+func Test_Marshal(t *testing.T) {
+	u := uuid.NewV4()
+	v := map[string]interface{}{
+		"MessageType": "Handshake",
+		"Bool":        true,
+		"Int":         int64(-1),
+		"Uint":        uint64(3),
+		"Double":      1.5,
+		"Date":        time.Unix(1700000000, 5),
+		"Data":        []byte{1, 2, 3, 4, 5},
+		"UUID":        u,
+		"Null":        nil,
+		"Array":       []interface{}{"a", uint64(1), map[string]interface{}{}},
+	}
+	b, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b)%4 != 0 {
+		t.Fatalf("not aligned: %d", len(b))
+	}
+	got, err := Unmarshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := got.(map[string]interface{})
+	if !m["Date"].(time.Time).Equal(v["Date"].(time.Time)) {
+		t.Fatalf("Date: %v", m["Date"])
+	}
+	m["Date"] = v["Date"]
+	if !reflect.DeepEqual(v, m) {
+		t.Fatalf("\n%#v\n%#v", v, m)
+	}
+
+	// a dictionary of one string, as pymobiledevice3 and the devices write it
+	want := []byte{
+		0x00, 0xf0, 0x00, 0x00, 0x14, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00,
+		'k', 0x00, 0x00, 0x00,
+		0x00, 0x90, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 'v', 0x00, 0x00, 0x00,
+	}
+	if b, _ = Marshal(map[string]interface{}{"k": "v"}); !bytes.Equal(b, want) {
+		t.Fatalf("% x", b)
+	}
+}
+
+func Test_Wrapper_MarshalBinary(t *testing.T) {
+	w := &Wrapper{Flags: FlagAlwaysSet | FlagDataPresent, MessageID: 7, Payload: map[string]interface{}{"k": "v"}}
+	b, err := w.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, n, err := parseWrapper(b[:len(b)-1]); got != nil || n != 0 || err != nil {
+		t.Fatalf("partial: %v %d %v", got, n, err)
+	}
+	got, n, err := parseWrapper(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(b) || !reflect.DeepEqual(w, got) {
+		t.Fatalf("%d %#v", n, got)
+	}
+
+	b[0] = 0
+	if _, _, err = parseWrapper(b); err == nil {
+		t.Fatal("expected a magic error")
+	}
+}
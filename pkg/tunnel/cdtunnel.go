@@ -0,0 +1,127 @@
+package tunnel
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+)
+
+// DefaultMTU what the host asks CoreDeviceProxy for
+const DefaultMTU = 16000
+
+const cdtunnelMagic = "CDTunnel"
+
+// Parameters of a tunnel, as CoreDeviceProxy hands them out
+type Parameters struct {
+	// Address of the host in the tunnel
+	Address string
+	Netmask string
+	MTU     int
+	// ServerAddress of the device, RemoteServiceDiscovery listens at ServerRSDPort
+	ServerAddress string
+	ServerRSDPort int
+}
+
+type handshakeRequest struct {
+	Type string `json:"type"`
+	MTU  int    `json:"mtu"`
+}
+
+type clientParameters struct {
+	Address string `json:"address"`
+	Netmask string `json:"netmask"`
+	MTU     int    `json:"mtu"`
+}
+
+type handshakeResponse struct {
+	Type             string           `json:"type"`
+	ClientParameters clientParameters `json:"clientParameters"`
+	ServerAddress    string           `json:"serverAddress"`
+	ServerRSDPort    int              `json:"serverRSDPort"`
+}
+
+// Handshake asks CoreDeviceProxy at the other end of rw for a tunnel,
+// the IPv6 packets follow right after on rw
+func Handshake(rw io.ReadWriter, mtu int) (params *Parameters, err error) {
+	if err = writeFrame(rw, handshakeRequest{Type: "clientHandshakeRequest", MTU: mtu}); err != nil {
+		return nil, err
+	}
+	var resp handshakeResponse
+	if err = readFrame(rw, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Type != "serverHandshakeResponse" {
+		return nil, fmt.Errorf("tunnel: unexpected handshake %q", resp.Type)
+	}
+	params = &Parameters{
+		Address:       resp.ClientParameters.Address,
+		Netmask:       resp.ClientParameters.Netmask,
+		MTU:           resp.ClientParameters.MTU,
+		ServerAddress: resp.ServerAddress,
+		ServerRSDPort: resp.ServerRSDPort,
+	}
+	if net.ParseIP(params.Address) == nil || net.ParseIP(params.ServerAddress) == nil {
+		return nil, fmt.Errorf("tunnel: bad addresses %q, %q", params.Address, params.ServerAddress)
+	}
+	if params.MTU <= ipv6HeaderLen+tcpHeaderLen {
+		params.MTU = mtu
+	}
+	return params, nil
+}
+
+// ServeHandshake is the CoreDeviceProxy side of Handshake, it answers with params
+func ServeHandshake(rw io.ReadWriter, params Parameters) (err error) {
+	var req handshakeRequest
+	if err = readFrame(rw, &req); err != nil {
+		return err
+	}
+	if req.Type != "clientHandshakeRequest" {
+		return fmt.Errorf("tunnel: unexpected handshake %q", req.Type)
+	}
+	return writeFrame(rw, handshakeResponse{
+		Type: "serverHandshakeResponse",
+		ClientParameters: clientParameters{
+			Address: params.Address,
+			Netmask: params.Netmask,
+			MTU:     params.MTU,
+		},
+		ServerAddress: params.ServerAddress,
+		ServerRSDPort: params.ServerRSDPort,
+	})
+}
+
+// writeFrame `CDTunnel`, the big endian length, then the JSON
+func writeFrame(w io.Writer, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("tunnel: %w", err)
+	}
+	frame := make([]byte, len(cdtunnelMagic)+2+len(body))
+	copy(frame, cdtunnelMagic)
+	binary.BigEndian.PutUint16(frame[len(cdtunnelMagic):], uint16(len(body)))
+	copy(frame[len(cdtunnelMagic)+2:], body)
+	if _, err = w.Write(frame); err != nil {
+		return fmt.Errorf("tunnel: %w", err)
+	}
+	return nil
+}
+
+func readFrame(r io.Reader, v interface{}) error {
+	head := make([]byte, len(cdtunnelMagic)+2)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return fmt.Errorf("tunnel: %w", err)
+	}
+	if string(head[:len(cdtunnelMagic)]) != cdtunnelMagic {
+		return fmt.Errorf("%w: no CDTunnel magic", ErrMalformed)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(head[len(cdtunnelMagic):]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return fmt.Errorf("tunnel: %w", err)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package tunnel
+
+import (
+	"net"
+	"testing"
+)
+
+func Test_Handshake(t *testing.T) {
+	host, device := net.Pipe()
+	defer host.Close()
+	defer device.Close()
+
+	want := Parameters{
+		Address:       "fd00::2",
+		Netmask:       "ffff:ffff:ffff:ffff::",
+		MTU:           1280,
+		ServerAddress: "fd00::1",
+		ServerRSDPort: 58783,
+	}
+	served := make(chan error, 1)
+	go func() { served <- ServeHandshake(device, want) }()
+
+	params, err := Handshake(host, DefaultMTU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = <-served; err != nil {
+		t.Fatal(err)
+	}
+	if *params != want {
+		t.Fatalf("parameters: %+v", params)
+	}
+}
@@ -0,0 +1,146 @@
+package tunnel
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+const (
+	ipv6HeaderLen = 40
+	tcpHeaderLen  = 20
+	protocolTCP   = 6
+	hopLimit      = 64
+)
+
+const (
+	flagFIN = 0x01
+	flagSYN = 0x02
+	flagRST = 0x04
+	flagPSH = 0x08
+	flagACK = 0x10
+)
+
+var ErrMalformed = errors.New("tunnel: malformed packet")
+
+// readPacket one IPv6 packet of the stream, they follow each other unframed
+func readPacket(r io.Reader) (pkt []byte, err error) {
+	head := make([]byte, ipv6HeaderLen)
+	if _, err = io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+	if head[0]>>4 != 6 {
+		return nil, fmt.Errorf("%w: IP version %d", ErrMalformed, head[0]>>4)
+	}
+	pkt = make([]byte, ipv6HeaderLen+int(binary.BigEndian.Uint16(head[4:])))
+	copy(pkt, head)
+	if _, err = io.ReadFull(r, pkt[ipv6HeaderLen:]); err != nil {
+		return nil, err
+	}
+	return pkt, nil
+}
+
+func buildPacket(src, dst net.IP, protocol uint8, payload []byte) []byte {
+	pkt := make([]byte, ipv6HeaderLen+len(payload))
+	pkt[0] = 6 << 4
+	binary.BigEndian.PutUint16(pkt[4:], uint16(len(payload)))
+	pkt[6], pkt[7] = protocol, hopLimit
+	copy(pkt[8:24], src.To16())
+	copy(pkt[24:40], dst.To16())
+	copy(pkt[ipv6HeaderLen:], payload)
+	return pkt
+}
+
+// checksum of an upper layer payload, over the IPv6 pseudo header (RFC 8200 section 8.1)
+func checksum(src, dst net.IP, protocol uint8, payload []byte) uint16 {
+	var sum uint32
+	add := func(b []byte) {
+		for i := 0; i+1 < len(b); i += 2 {
+			sum += uint32(b[i])<<8 | uint32(b[i+1])
+		}
+		if len(b)%2 == 1 {
+			sum += uint32(b[len(b)-1]) << 8
+		}
+	}
+	add(src.To16())
+	add(dst.To16())
+	var pseudo [8]byte
+	binary.BigEndian.PutUint32(pseudo[0:], uint32(len(payload)))
+	pseudo[7] = protocol
+	add(pseudo[:])
+	add(payload)
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+type segment struct {
+	srcPort, dstPort uint16
+	seq, ack         uint32
+	flags            uint8
+	window           uint16
+	// mss the option of SYN segments, 0 when absent
+	mss     uint16
+	payload []byte
+}
+
+func (s *segment) marshal(src, dst net.IP) []byte {
+	headerLen := tcpHeaderLen
+	if s.mss != 0 {
+		headerLen += 4
+	}
+	b := make([]byte, headerLen+len(s.payload))
+	binary.BigEndian.PutUint16(b[0:], s.srcPort)
+	binary.BigEndian.PutUint16(b[2:], s.dstPort)
+	binary.BigEndian.PutUint32(b[4:], s.seq)
+	binary.BigEndian.PutUint32(b[8:], s.ack)
+	b[12] = byte(headerLen/4) << 4
+	b[13] = s.flags
+	binary.BigEndian.PutUint16(b[14:], s.window)
+	if s.mss != 0 {
+		b[20], b[21] = 2, 4
+		binary.BigEndian.PutUint16(b[22:], s.mss)
+	}
+	copy(b[headerLen:], s.payload)
+	binary.BigEndian.PutUint16(b[16:], checksum(src, dst, protocolTCP, b))
+	return b
+}
+
+func parseSegment(b []byte) (s *segment, err error) {
+	if len(b) < tcpHeaderLen {
+		return nil, ErrMalformed
+	}
+	headerLen := int(b[12]>>4) * 4
+	if headerLen < tcpHeaderLen || headerLen > len(b) {
+		return nil, ErrMalformed
+	}
+	s = &segment{
+		srcPort: binary.BigEndian.Uint16(b[0:]),
+		dstPort: binary.BigEndian.Uint16(b[2:]),
+		seq:     binary.BigEndian.Uint32(b[4:]),
+		ack:     binary.BigEndian.Uint32(b[8:]),
+		flags:   b[13],
+		window:  binary.BigEndian.Uint16(b[14:]),
+		payload: b[headerLen:],
+	}
+	for opts := b[tcpHeaderLen:headerLen]; len(opts) > 0; {
+		switch opts[0] {
+		case 0:
+			opts = nil
+		case 1:
+			opts = opts[1:]
+		default:
+			if len(opts) < 2 || int(opts[1]) < 2 || int(opts[1]) > len(opts) {
+				return nil, ErrMalformed
+			}
+			if opts[0] == 2 && opts[1] == 4 {
+				s.mss = binary.BigEndian.Uint16(opts[2:])
+			}
+			opts = opts[opts[1]:]
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,389 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	firstEphemeralPort = 49152
+	defaultDialTimeout = 30 * time.Second
+)
+
+var ErrStackClosed = errors.New("tunnel: closed")
+
+type connID struct {
+	localPort, remotePort uint16
+	remote                [16]byte
+}
+
+// Stack a userspace TCP over IPv6, the packets going through link. It holds just
+// what a link that neither loses nor reorders packets needs, like the TLS
+// connection of a CoreDeviceProxy tunnel: nothing is retransmitted.
+type Stack struct {
+	link  io.ReadWriteCloser
+	local net.IP
+	mss   int
+
+	mu        sync.Mutex
+	conns     map[connID]*tcpConn
+	listeners map[uint16]*listener
+	nextPort  uint16
+
+	out *packetQueue
+
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error
+}
+
+// NewStack runs TCP at local over link, with packets of mtu bytes at most
+func NewStack(link io.ReadWriteCloser, local net.IP, mtu int) *Stack {
+	s := &Stack{
+		link:      link,
+		local:     local.To16(),
+		mss:       mtu - ipv6HeaderLen - tcpHeaderLen,
+		conns:     make(map[connID]*tcpConn),
+		listeners: make(map[uint16]*listener),
+		nextPort:  firstEphemeralPort,
+		out:       newPacketQueue(),
+		done:      make(chan struct{}),
+	}
+	go s.readLoop()
+	go s.writeLoop()
+	return s
+}
+
+// LocalAddr the address of this end
+func (s *Stack) LocalAddr() net.IP {
+	return s.local
+}
+
+// Done is closed once the stack stops, Err tells why
+func (s *Stack) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *Stack) Err() error {
+	select {
+	case <-s.done:
+		return s.err
+	default:
+		return nil
+	}
+}
+
+// Close stops the stack and closes link, every connection fails with ErrStackClosed
+func (s *Stack) Close() error {
+	s.fail(ErrStackClosed)
+	return nil
+}
+
+func (s *Stack) fail(err error) {
+	s.closeOnce.Do(func() {
+		s.err = err
+		close(s.done)
+		_ = s.link.Close()
+		s.out.close()
+
+		s.mu.Lock()
+		conns := make([]*tcpConn, 0, len(s.conns))
+		for _, c := range s.conns {
+			conns = append(conns, c)
+		}
+		listeners := make([]*listener, 0, len(s.listeners))
+		for _, ln := range s.listeners {
+			listeners = append(listeners, ln)
+		}
+		s.mu.Unlock()
+		for _, c := range conns {
+			c.abort(err, false)
+		}
+		for _, ln := range listeners {
+			_ = ln.Close()
+		}
+	})
+}
+
+// Dial connects to address, `[ip]:port`
+func (s *Stack) Dial(network, address string) (net.Conn, error) {
+	return s.DialContext(context.Background(), network, address)
+}
+
+// DialContext connects to address, `[ip]:port`, giving up when ctx is done
+// (or after 30 seconds without a deadline)
+func (s *Stack) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp6":
+	default:
+		return nil, &net.OpError{Op: "dial", Net: network, Err: net.UnknownNetworkError(network)}
+	}
+	host, p, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+	}
+	ip := net.ParseIP(host)
+	port, err := strconv.Atoi(p)
+	if ip == nil || ip.To4() != nil || err != nil || port <= 0 || port > 0xffff {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: fmt.Errorf("tunnel: bad IPv6 address %q", address)}
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultDialTimeout)
+		defer cancel()
+	}
+
+	s.mu.Lock()
+	if s.Err() != nil {
+		s.mu.Unlock()
+		return nil, s.err
+	}
+	id := connID{remotePort: uint16(port)}
+	copy(id.remote[:], ip.To16())
+	if id.localPort, err = s.allocPort(id); err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	c := newConn(s, id)
+	s.conns[id] = c
+	s.mu.Unlock()
+
+	c.connect()
+	select {
+	case <-c.ready:
+	case <-ctx.Done():
+		c.abort(ctx.Err(), true)
+		return nil, &net.OpError{Op: "dial", Net: network, Addr: c.remoteAddr, Err: ctx.Err()}
+	}
+	if err = c.readyErr(); err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Addr: c.remoteAddr, Err: err}
+	}
+	return c, nil
+}
+
+// allocPort an ephemeral port free towards id.remote
+func (s *Stack) allocPort(id connID) (uint16, error) {
+	for i := 0; i < 0x10000-firstEphemeralPort; i++ {
+		port := s.nextPort
+		if s.nextPort++; s.nextPort == 0 {
+			s.nextPort = firstEphemeralPort
+		}
+		id.localPort = port
+		if _, ok := s.conns[id]; !ok {
+			return port, nil
+		}
+	}
+	return 0, errors.New("tunnel: no free port")
+}
+
+// Listen accepts connections to port
+func (s *Stack) Listen(port int) (net.Listener, error) {
+	if port <= 0 || port > 0xffff {
+		return nil, fmt.Errorf("tunnel: bad port %d", port)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Err() != nil {
+		return nil, s.err
+	}
+	if _, ok := s.listeners[uint16(port)]; ok {
+		return nil, fmt.Errorf("tunnel: port %d in use", port)
+	}
+	ln := &listener{
+		stack:  s,
+		port:   uint16(port),
+		accept: make(chan *tcpConn, 16),
+		done:   make(chan struct{}),
+	}
+	s.listeners[ln.port] = ln
+	return ln, nil
+}
+
+func (s *Stack) remove(c *tcpConn) {
+	s.mu.Lock()
+	if s.conns[c.id] == c {
+		delete(s.conns, c.id)
+	}
+	s.mu.Unlock()
+}
+
+func (s *Stack) send(remote net.IP, seg *segment) {
+	s.out.push(buildPacket(s.local, remote, protocolTCP, seg.marshal(s.local, remote)))
+}
+
+func (s *Stack) readLoop() {
+	for {
+		pkt, err := readPacket(s.link)
+		if err != nil {
+			s.fail(fmt.Errorf("tunnel: %w", err))
+			return
+		}
+		// extension headers and ICMPv6 are of no use here
+		if pkt[6] != protocolTCP {
+			continue
+		}
+		remote := net.IP(pkt[8:24])
+		// damaged on the way, summing to 0 with the checksum otherwise
+		if checksum(remote, net.IP(pkt[24:40]), protocolTCP, pkt[ipv6HeaderLen:]) != 0 {
+			continue
+		}
+		seg, err := parseSegment(pkt[ipv6HeaderLen:])
+		if err != nil {
+			continue
+		}
+		id := connID{localPort: seg.dstPort, remotePort: seg.srcPort}
+		copy(id.remote[:], remote)
+
+		s.mu.Lock()
+		c, ok := s.conns[id]
+		ln := s.listeners[seg.dstPort]
+		if !ok && ln != nil && seg.flags&(flagSYN|flagACK|flagRST) == flagSYN {
+			c = newConn(s, id)
+			c.listener = ln
+			s.conns[id] = c
+			s.mu.Unlock()
+			c.accepted(seg)
+			continue
+		}
+		s.mu.Unlock()
+
+		if !ok {
+			s.reset(remote, seg)
+			continue
+		}
+		c.handle(seg)
+	}
+}
+
+// reset answers a segment of no connection (RFC 793 page 36)
+func (s *Stack) reset(remote net.IP, seg *segment) {
+	if seg.flags&flagRST != 0 {
+		return
+	}
+	rst := &segment{srcPort: seg.dstPort, dstPort: seg.srcPort}
+	if seg.flags&flagACK != 0 {
+		rst.seq, rst.flags = seg.ack, flagRST
+	} else {
+		rst.ack, rst.flags = seg.seq+uint32(len(seg.payload)), flagRST|flagACK
+		if seg.flags&flagSYN != 0 {
+			rst.ack++
+		}
+		if seg.flags&flagFIN != 0 {
+			rst.ack++
+		}
+	}
+	s.send(remote, rst)
+}
+
+func (s *Stack) writeLoop() {
+	for {
+		pkt, ok := s.out.pop()
+		if !ok {
+			return
+		}
+		if _, err := s.link.Write(pkt); err != nil {
+			s.fail(fmt.Errorf("tunnel: %w", err))
+			return
+		}
+	}
+}
+
+func randomISN() uint32 {
+	return rand.Uint32()
+}
+
+// packetQueue the packets waiting for link, the reading side never blocks on writes
+type packetQueue struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	pkts   [][]byte
+	closed bool
+}
+
+func newPacketQueue() *packetQueue {
+	q := &packetQueue{}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+func (q *packetQueue) push(pkt []byte) {
+	q.mu.Lock()
+	if !q.closed {
+		q.pkts = append(q.pkts, pkt)
+		q.cond.Signal()
+	}
+	q.mu.Unlock()
+}
+
+func (q *packetQueue) pop() (pkt []byte, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.pkts) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if q.closed {
+		return nil, false
+	}
+	pkt, q.pkts[0] = q.pkts[0], nil
+	q.pkts = q.pkts[1:]
+	return pkt, true
+}
+
+func (q *packetQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.pkts = nil
+	q.cond.Broadcast()
+	q.mu.Unlock()
+}
+
+type listener struct {
+	stack     *Stack
+	port      uint16
+	accept    chan *tcpConn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (ln *listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-ln.accept:
+		return c, nil
+	case <-ln.done:
+		return nil, &net.OpError{Op: "accept", Net: "tcp", Addr: ln.Addr(), Err: net.ErrClosed}
+	}
+}
+
+func (ln *listener) Close() error {
+	ln.closeOnce.Do(func() {
+		ln.stack.mu.Lock()
+		if ln.stack.listeners[ln.port] == ln {
+			delete(ln.stack.listeners, ln.port)
+		}
+		ln.stack.mu.Unlock()
+		close(ln.done)
+	})
+	return nil
+}
+
+func (ln *listener) Addr() net.Addr {
+	return &net.TCPAddr{IP: ln.stack.local, Port: int(ln.port)}
+}
+
+// established hands c over to Accept, resetting it when nobody keeps up
+func (ln *listener) established(c *tcpConn) bool {
+	select {
+	case ln.accept <- c:
+		return true
+	case <-ln.done:
+	default:
+	}
+	return false
+}
@@ -0,0 +1,231 @@
+package tunnel
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+)
+
+// linkedStacks two stacks, the host at fd00::2 and the device at fd00::1,
+// their packets going over a loopback TCP connection
+func linkedStacks(t *testing.T, mtu int) (host, device *Stack) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := ln.Accept()
+		accepted <- conn
+	}()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer := <-accepted
+	if peer == nil {
+		t.Fatal("no connection")
+	}
+
+	host = NewStack(conn, net.ParseIP("fd00::2"), mtu)
+	device = NewStack(peer, net.ParseIP("fd00::1"), mtu)
+	t.Cleanup(func() {
+		_ = host.Close()
+		_ = device.Close()
+	})
+	return host, device
+}
+
+func Test_Stack_Dial(t *testing.T) {
+	host, device := linkedStacks(t, 1280)
+	ln, err := device.Listen(8080)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	conn, err := host.Dial("tcp", "[fd00::1]:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.RemoteAddr().String() != "[fd00::1]:8080" {
+		t.Fatalf("remote address %s", conn.RemoteAddr())
+	}
+
+	// well over the window and the MSS
+	data := make([]byte, 1<<20)
+	rand.Read(data)
+	written := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(data)
+		written <- err
+	}()
+	_ = conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	echo := make([]byte, len(data))
+	if _, err = io.ReadFull(conn, echo); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-written; err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(echo, data) {
+		t.Fatal("echo differs")
+	}
+	_ = conn.Close()
+	if _, err = conn.Read(echo); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected closed, got %v", err)
+	}
+}
+
+func Test_Stack_DialEOF(t *testing.T) {
+	host, device := linkedStacks(t, DefaultMTU)
+	ln, err := device.Listen(9000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		_, _ = conn.Write([]byte("hello"))
+		_ = conn.Close()
+	}()
+
+	conn, err := host.Dial("tcp", "[fd00::1]:9000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	b, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello" {
+		t.Fatalf("read %q", b)
+	}
+}
+
+func Test_Stack_DialRefused(t *testing.T) {
+	host, _ := linkedStacks(t, DefaultMTU)
+	if _, err := host.Dial("tcp", "[fd00::1]:1234"); !errors.Is(err, ErrConnectionRefused) {
+		t.Fatalf("expected refused, got %v", err)
+	}
+
+	_ = host.Close()
+	if _, err := host.Dial("tcp", "[fd00::1]:1234"); !errors.Is(err, ErrStackClosed) {
+		t.Fatalf("expected closed, got %v", err)
+	}
+}
+
+func Test_Stack_Checksum(t *testing.T) {
+	link, peer := net.Pipe()
+	device := NewStack(link, net.ParseIP("fd00::1"), DefaultMTU)
+	t.Cleanup(func() {
+		_ = device.Close()
+		_ = peer.Close()
+	})
+	if _, err := device.Listen(8080); err != nil {
+		t.Fatal(err)
+	}
+
+	src, dst := net.ParseIP("fd00::2"), net.ParseIP("fd00::1")
+	syn := (&segment{srcPort: 50000, dstPort: 8080, seq: 1, flags: flagSYN, window: 0xffff}).marshal(src, dst)
+	damaged := append([]byte(nil), syn...)
+	damaged[len(damaged)-1] ^= 0xff
+	if _, err := peer.Write(buildPacket(src, dst, protocolTCP, damaged)); err != nil {
+		t.Fatal(err)
+	}
+	_ = peer.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if pkt, err := readPacket(peer); err == nil {
+		t.Fatalf("answered a damaged segment: %x", pkt)
+	}
+
+	if _, err := peer.Write(buildPacket(src, dst, protocolTCP, syn)); err != nil {
+		t.Fatal(err)
+	}
+	_ = peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	pkt, err := readPacket(peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seg, err := parseSegment(pkt[ipv6HeaderLen:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seg.flags&(flagSYN|flagACK) != flagSYN|flagACK || seg.ack != 2 {
+		t.Fatalf("answer: %+v", seg)
+	}
+}
+
+func Test_Stack_FinWaitTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { finWaitTimeout = timeout }(finWaitTimeout)
+	finWaitTimeout = 100 * time.Millisecond
+
+	host, device := linkedStacks(t, DefaultMTU)
+	ln, err := device.Listen(9000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := ln.Accept()
+		accepted <- conn
+	}()
+
+	conn, err := host.Dial("tcp", "[fd00::1]:9000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the device reads the FIN but never closes
+	peer := <-accepted
+	if peer == nil {
+		t.Fatal("not accepted")
+	}
+	_ = conn.Close()
+
+	_ = peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err = peer.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(20 * time.Millisecond) {
+		host.mu.Lock()
+		n := len(host.conns)
+		host.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("closed connection kept")
+		}
+	}
+	// reset along with it
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(20 * time.Millisecond) {
+		if _, err = peer.Write([]byte("late")); err != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("device end not reset")
+		}
+	}
+	if !errors.Is(err, ErrConnectionReset) {
+		t.Fatalf("expected reset, got %v", err)
+	}
+}
@@ -0,0 +1,406 @@
+package tunnel
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// receiveWindow what a connection buffers before the peer has to wait for Read
+const receiveWindow = 0xffff
+
+// finWaitTimeout how long a connection closed by Close waits for the FIN of the peer,
+// before it is reset and forgotten
+var finWaitTimeout = 60 * time.Second
+
+var (
+	ErrConnectionRefused = errors.New("tunnel: connection refused")
+	ErrConnectionReset   = errors.New("tunnel: connection reset by peer")
+)
+
+type connState int
+
+const (
+	stateSynSent connState = iota
+	stateSynReceived
+	stateEstablished
+	stateClosed
+)
+
+var _ net.Conn = (*tcpConn)(nil)
+
+type tcpConn struct {
+	stack    *Stack
+	id       connID
+	remote   net.IP
+	listener *listener
+
+	localAddr, remoteAddr *net.TCPAddr
+
+	mu    sync.Mutex
+	cond  *sync.Cond
+	state connState
+	// ready is closed once established, or failing to
+	ready     chan struct{}
+	readyOnce sync.Once
+
+	sndUna, sndNxt uint32
+	sndWnd         uint32
+	peerMSS        int
+
+	rcvNxt     uint32
+	rcvBuf     []byte
+	rcvWndSent uint32
+
+	finSent, finAcked, finReceived bool
+	// finWait resets the connection once finWaitTimeout passed after the FIN was sent
+	finWait *time.Timer
+	// closed by Close, err once the connection failed
+	closed bool
+	err    error
+
+	readDeadline, writeDeadline deadline
+}
+
+func newConn(s *Stack, id connID) *tcpConn {
+	remote := make(net.IP, net.IPv6len)
+	copy(remote, id.remote[:])
+	c := &tcpConn{
+		stack:      s,
+		id:         id,
+		remote:     remote,
+		localAddr:  &net.TCPAddr{IP: s.local, Port: int(id.localPort)},
+		remoteAddr: &net.TCPAddr{IP: remote, Port: int(id.remotePort)},
+		ready:      make(chan struct{}),
+		peerMSS:    1220,
+	}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// connect sends the SYN of an active open
+func (c *tcpConn) connect() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	iss := randomISN()
+	c.state = stateSynSent
+	c.sndUna, c.sndNxt = iss, iss+1
+	c.sendLocked(&segment{seq: iss, flags: flagSYN, mss: uint16(c.stack.mss)})
+}
+
+func (c *tcpConn) readyErr() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func (c *tcpConn) markReady() {
+	c.readyOnce.Do(func() { close(c.ready) })
+}
+
+// sendLocked fills in the ports, ACK and window of seg
+func (c *tcpConn) sendLocked(seg *segment) {
+	seg.srcPort, seg.dstPort = c.id.localPort, c.id.remotePort
+	if c.state != stateSynSent {
+		seg.flags |= flagACK
+		seg.ack = c.rcvNxt
+	}
+	c.rcvWndSent = c.window()
+	seg.window = uint16(c.rcvWndSent)
+	c.stack.send(c.remote, seg)
+}
+
+func (c *tcpConn) window() uint32 {
+	if len(c.rcvBuf) >= receiveWindow {
+		return 0
+	}
+	return uint32(receiveWindow - len(c.rcvBuf))
+}
+
+// abort fails the connection with err, resetting the peer if asked
+func (c *tcpConn) abort(err error, reset bool) {
+	c.mu.Lock()
+	if c.err == nil && c.state != stateClosed {
+		c.err = err
+		if reset {
+			c.sendLocked(&segment{seq: c.sndNxt, flags: flagRST})
+		}
+	}
+	c.state = stateClosed
+	if c.finWait != nil {
+		c.finWait.Stop()
+	}
+	c.cond.Broadcast()
+	c.mu.Unlock()
+	c.markReady()
+	c.stack.remove(c)
+}
+
+func (c *tcpConn) handle(seg *segment) {
+	if seg.flags&flagRST != 0 {
+		c.mu.Lock()
+		refused := c.state == stateSynSent
+		c.mu.Unlock()
+		if refused {
+			c.abort(ErrConnectionRefused, false)
+		} else {
+			c.abort(ErrConnectionReset, false)
+		}
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer c.cond.Broadcast()
+
+	switch c.state {
+	case stateClosed:
+		return
+	case stateSynSent:
+		if seg.flags&(flagSYN|flagACK) != flagSYN|flagACK || seg.ack != c.sndNxt {
+			return
+		}
+		c.rcvNxt = seg.seq + 1
+		c.sndUna, c.sndWnd = seg.ack, uint32(seg.window)
+		c.setPeerMSS(seg.mss)
+		c.state = stateEstablished
+		c.sendLocked(&segment{seq: c.sndNxt})
+		c.markReady()
+		return
+	case stateSynReceived:
+		if seg.flags&flagSYN != 0 {
+			// the SYN again, the SYN-ACK went missing
+			c.sendLocked(&segment{seq: c.sndUna, flags: flagSYN, mss: uint16(c.stack.mss)})
+			return
+		}
+		if seg.flags&flagACK == 0 || seg.ack != c.sndNxt {
+			return
+		}
+		c.state = stateEstablished
+		if !c.listener.established(c) {
+			c.err = ErrConnectionReset
+			c.state = stateClosed
+			c.sendLocked(&segment{seq: c.sndNxt, flags: flagRST})
+			go c.stack.remove(c)
+			return
+		}
+	default:
+		if c.listener == nil && c.sndNxt == c.sndUna+1 && seg.flags&flagSYN != 0 {
+			// the SYN-ACK again, our ACK went missing
+			c.sendLocked(&segment{seq: c.sndNxt})
+			return
+		}
+	}
+	if c.state == stateSynReceived {
+		return
+	}
+
+	if seg.flags&flagACK != 0 {
+		if seqAfter(seg.ack, c.sndUna) && !seqAfter(seg.ack, c.sndNxt) {
+			c.sndUna = seg.ack
+		}
+		c.sndWnd = uint32(seg.window)
+		if c.finSent && c.sndUna == c.sndNxt {
+			c.finAcked = true
+		}
+	}
+
+	if len(seg.payload) == 0 && seg.flags&flagFIN == 0 {
+		return
+	}
+	if seg.seq == c.rcvNxt && !c.finReceived {
+		if !c.closed {
+			c.rcvBuf = append(c.rcvBuf, seg.payload...)
+		}
+		c.rcvNxt += uint32(len(seg.payload))
+		if seg.flags&flagFIN != 0 {
+			c.rcvNxt++
+			c.finReceived = true
+		}
+	}
+	// everything gets acknowledged right away, duplicates too
+	c.sendLocked(&segment{seq: c.sndNxt})
+
+	if c.finReceived && c.finAcked {
+		c.state = stateClosed
+		if c.finWait != nil {
+			c.finWait.Stop()
+		}
+		go c.stack.remove(c)
+	}
+}
+
+// accepted answers the SYN of a passive open
+func (c *tcpConn) accepted(seg *segment) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	iss := randomISN()
+	c.state = stateSynReceived
+	c.rcvNxt = seg.seq + 1
+	c.sndUna, c.sndNxt, c.sndWnd = iss, iss+1, uint32(seg.window)
+	c.setPeerMSS(seg.mss)
+	c.sendLocked(&segment{seq: iss, flags: flagSYN, mss: uint16(c.stack.mss)})
+	c.markReady()
+}
+
+func (c *tcpConn) setPeerMSS(mss uint16) {
+	if mss != 0 {
+		c.peerMSS = int(mss)
+	}
+	if c.peerMSS > c.stack.mss {
+		c.peerMSS = c.stack.mss
+	}
+}
+
+func (c *tcpConn) Read(b []byte) (n int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.rcvBuf) == 0 {
+		switch {
+		case c.closed:
+			return 0, net.ErrClosed
+		case c.finReceived:
+			return 0, io.EOF
+		case c.err != nil:
+			return 0, c.err
+		case c.readDeadline.exceeded():
+			return 0, os.ErrDeadlineExceeded
+		}
+		if len(b) == 0 {
+			return 0, nil
+		}
+		c.cond.Wait()
+	}
+
+	n = copy(b, c.rcvBuf)
+	if c.rcvBuf = c.rcvBuf[n:]; len(c.rcvBuf) == 0 {
+		c.rcvBuf = nil
+	}
+	// the window opened enough for the peer to go on
+	if c.err == nil && !c.finReceived && c.window()-c.rcvWndSent >= receiveWindow/2 {
+		c.sendLocked(&segment{seq: c.sndNxt})
+	}
+	return n, nil
+}
+
+func (c *tcpConn) Write(b []byte) (n int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for n < len(b) {
+		switch {
+		case c.closed || c.finSent:
+			return n, net.ErrClosed
+		case c.err != nil:
+			return n, c.err
+		case c.writeDeadline.exceeded():
+			return n, os.ErrDeadlineExceeded
+		}
+
+		avail := int(c.sndWnd) - int(c.sndNxt-c.sndUna)
+		if avail <= 0 {
+			c.cond.Wait()
+			continue
+		}
+		size := len(b) - n
+		if size > c.peerMSS {
+			size = c.peerMSS
+		}
+		if size > avail {
+			size = avail
+		}
+		payload := make([]byte, size)
+		copy(payload, b[n:])
+		c.sendLocked(&segment{seq: c.sndNxt, flags: flagPSH, payload: payload})
+		c.sndNxt += uint32(size)
+		n += size
+	}
+	return n, nil
+}
+
+// Close sends a FIN, the peer may still send until it closes too
+func (c *tcpConn) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.rcvBuf = nil
+	remove := false
+	if c.err == nil && c.state == stateEstablished && !c.finSent {
+		c.sendLocked(&segment{seq: c.sndNxt, flags: flagFIN})
+		c.sndNxt++
+		c.finSent = true
+		c.finWait = time.AfterFunc(finWaitTimeout, func() {
+			c.abort(net.ErrClosed, true)
+		})
+	} else if c.state != stateEstablished {
+		c.state = stateClosed
+		remove = true
+	}
+	c.cond.Broadcast()
+	c.mu.Unlock()
+	if remove {
+		c.stack.remove(c)
+	}
+	return nil
+}
+
+func (c *tcpConn) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+func (c *tcpConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+func (c *tcpConn) SetDeadline(t time.Time) error {
+	c.setDeadline(&c.readDeadline, t)
+	c.setDeadline(&c.writeDeadline, t)
+	return nil
+}
+
+func (c *tcpConn) SetReadDeadline(t time.Time) error {
+	c.setDeadline(&c.readDeadline, t)
+	return nil
+}
+
+func (c *tcpConn) SetWriteDeadline(t time.Time) error {
+	c.setDeadline(&c.writeDeadline, t)
+	return nil
+}
+
+func (c *tcpConn) setDeadline(d *deadline, t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	d.t = t
+	if !t.IsZero() {
+		d.timer = time.AfterFunc(time.Until(t), func() {
+			c.mu.Lock()
+			c.cond.Broadcast()
+			c.mu.Unlock()
+		})
+	}
+	c.cond.Broadcast()
+}
+
+type deadline struct {
+	t     time.Time
+	timer *time.Timer
+}
+
+func (d *deadline) exceeded() bool {
+	return !d.t.IsZero() && !time.Now().Before(d.t)
+}
+
+// seqAfter a > b in sequence space
+func seqAfter(a, b uint32) bool {
+	return int32(a-b) > 0
+}
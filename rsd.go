@@ -0,0 +1,119 @@
+package giDevice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/libimobiledevice"
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/remotexpc"
+)
+
+func (d *device) UseRemoteServiceDiscovery(ctx context.Context, addr string) (err error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("remote service discovery: %w", err)
+	}
+	var innerConn InnerConn
+	if innerConn, err = d.dialRemote(ctx, addr); err != nil {
+		return fmt.Errorf("remote service discovery: %w", err)
+	}
+	defer innerConn.Close()
+	var sd *remotexpc.ServiceDirectory
+	if sd, err = remotexpc.DiscoverConn(ctx, innerConn.RawConn()); err != nil {
+		return fmt.Errorf("remote service discovery: %w", err)
+	}
+	if udid := sd.UDID(); udid != "" && d.properties.SerialNumber != "" && udid != d.properties.SerialNumber {
+		return fmt.Errorf("remote service discovery: %s is another device (%s)", addr, udid)
+	}
+	d.mu.Lock()
+	d.rsd, d.rsdHost = sd, host
+	d.mu.Unlock()
+	return nil
+}
+
+// remoteServiceError lockdownd refused a service and RemoteServiceDiscovery could not
+// start it either. It unwraps to the latter and matches with errors.Is the former too
+type remoteServiceError struct {
+	lockdownErr error
+	rsdErr      error
+}
+
+func (e *remoteServiceError) Error() string {
+	return fmt.Sprintf("%s; %s", e.lockdownErr, e.rsdErr)
+}
+
+func (e *remoteServiceError) Unwrap() error {
+	return e.rsdErr
+}
+
+func (e *remoteServiceError) Is(target error) bool {
+	return errors.Is(e.lockdownErr, target)
+}
+
+// remoteServiceDirectory the services found by UseRemoteServiceDiscovery on host, if any
+func (d *device) remoteServiceDirectory() (sd *remotexpc.ServiceDirectory, host string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.rsd, d.rsdHost
+}
+
+// remoteServiceName the RemoteServiceDiscovery name of a lockdown service,
+// and whether it wants an `RSDCheckin`
+func remoteServiceName(service string) (name string, checkin bool) {
+	switch service {
+	case libimobiledevice.InstrumentsServiceName, libimobiledevice.InstrumentsSecureProxyServiceName:
+		return libimobiledevice.InstrumentsRemoteServiceName, false
+	case libimobiledevice.TestmanagerdServiceName, libimobiledevice.TestmanagerdSecureServiceName:
+		return libimobiledevice.TestmanagerdRemoteServiceName, false
+	}
+	return service + remotexpc.ShimSuffix, true
+}
+
+// startRemoteService connects to service through RemoteServiceDiscovery,
+// in plain text: the tunnel there is encrypted already
+func (d *device) startRemoteService(ctx context.Context, service string) (innerConn InnerConn, err error) {
+	sd, host := d.remoteServiceDirectory()
+	if sd == nil {
+		return nil, fmt.Errorf("remote service discovery: not in use")
+	}
+	name, checkin := remoteServiceName(service)
+	port, ok := sd.Port(name)
+	if !ok {
+		return nil, fmt.Errorf("remote service discovery: no service %s", name)
+	}
+
+	if innerConn, err = d.dialRemote(ctx, net.JoinHostPort(host, strconv.Itoa(port))); err != nil {
+		return nil, err
+	}
+	if !checkin {
+		innerConn.Timeout(0)
+		return innerConn, nil
+	}
+
+	release := innerConn.BindContext(ctx)
+	defer release()
+	client := libimobiledevice.NewLockdownClient(innerConn)
+	var pkt libimobiledevice.Packet
+	if pkt, err = client.NewXmlPacket(
+		client.NewBasicRequest(libimobiledevice.RequestTypeRSDCheckin),
+	); err != nil {
+		innerConn.Close()
+		return nil, err
+	}
+	if err = client.SendPacket(pkt); err != nil {
+		innerConn.Close()
+		return nil, err
+	}
+	// `RSDCheckin` acknowledged, then `StartService`
+	for i := 0; i < 2; i++ {
+		if _, err = client.ReceivePacket(); err != nil {
+			innerConn.Close()
+			return nil, fmt.Errorf("remote service discovery: %s checkin: %w", name, err)
+		}
+	}
+	innerConn.Timeout(0)
+	return innerConn, nil
+}
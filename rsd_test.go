@@ -0,0 +1,59 @@
+package giDevice
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/idevicetest"
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/libimobiledevice"
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/remotexpc"
+)
+
+func Test_device_simulated_RemoteServiceDiscovery(t *testing.T) {
+	setupSimulatedDevice(t)
+	// only reachable through RemoteServiceDiscovery, as on iOS 17
+	simDev.Handle(libimobiledevice.InstallationProxyServiceName+remotexpc.ShimSuffix, func(conn net.Conn) {
+		var req map[string]interface{}
+		if err := idevicetest.ReadMessage(conn, &req); err != nil {
+			return
+		}
+		_ = idevicetest.WriteMessage(conn, map[string]interface{}{
+			"Status":      "Complete",
+			"CurrentList": []interface{}{map[string]interface{}{"CFBundleIdentifier": "com.example.app"}},
+		})
+	})
+
+	if _, err := dev.InstallationProxyBrowse(); !errors.Is(err, libimobiledevice.ErrInvalidService) {
+		t.Fatalf("expected InvalidService, got %v", err)
+	}
+
+	addr, err := simDev.ListenRemoteServiceDiscovery()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(simDev.Close)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = dev.UseRemoteServiceDiscovery(ctx, addr); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := dev.InstallationProxyBrowse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].(map[string]interface{})["CFBundleIdentifier"] != "com.example.app" {
+		t.Fatalf("list: %v", list)
+	}
+
+	// lockdownd refuses it and RemoteServiceDiscovery does not have it either
+	if _, err = dev.Screenshot(); !errors.Is(err, libimobiledevice.ErrInvalidService) {
+		t.Fatalf("expected InvalidService, got %v", err)
+	} else if !strings.Contains(err.Error(), "no service") {
+		t.Fatalf("RemoteServiceDiscovery error dropped: %v", err)
+	}
+}
@@ -2,6 +2,7 @@ package giDevice
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/SonicCloudOrg/sonic-gidevice/pkg/libimobiledevice"
@@ -22,6 +23,14 @@ type screenshot struct {
 }
 
 func (s *screenshot) Take() (raw *bytes.Buffer, err error) {
+	return s.TakeContext(context.Background())
+}
+
+// TakeContext is Take giving up when ctx is done
+func (s *screenshot) TakeContext(ctx context.Context) (raw *bytes.Buffer, err error) {
+	release := s.client.InnerConn().BindContext(ctx)
+	defer release()
+
 	if err = s.exchange(); err != nil {
 		return nil, err
 	}
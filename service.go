@@ -0,0 +1,202 @@
+package giDevice
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrDeviceClosed the device was closed with Device.Close
+var ErrDeviceClosed = errors.New("device closed")
+
+// maxIdleServices the connections kept per service for the next call to borrow
+const maxIdleServices = 2
+
+// service a started service along with the connections it runs over
+type service struct {
+	name  string
+	svc   interface{}
+	conns []InnerConn
+}
+
+// healthy whether none of the connections has failed or been closed
+func (s *service) healthy() bool {
+	for _, conn := range s.conns {
+		if conn.Err() != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *service) close() {
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// servicePool keeps the services of a device: one shared per name, handed out
+// to whoever asks, and idle ones lent to a single call at a time
+type servicePool struct {
+	mu     sync.Mutex
+	closed bool
+	shared map[string]*service
+	idle   map[string][]*service
+}
+
+// share returns the shared service name, started with open unless it is still healthy
+func (p *servicePool) share(name string, open func() (*service, error)) (s *service, err error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrDeviceClosed
+	}
+	if s = p.shared[name]; s != nil && s.healthy() {
+		p.mu.Unlock()
+		return s, nil
+	}
+	p.mu.Unlock()
+
+	if s, err = open(); err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		s.close()
+		return nil, ErrDeviceClosed
+	}
+	// started at the same time by someone else
+	if prev := p.shared[name]; prev != nil && prev.healthy() {
+		s.close()
+		return prev, nil
+	}
+	if p.shared == nil {
+		p.shared = make(map[string]*service)
+	}
+	p.shared[name] = s
+	return s, nil
+}
+
+// borrow lends an idle healthy service name, or one started with open, until put back
+func (p *servicePool) borrow(name string, open func() (*service, error)) (s *service, err error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrDeviceClosed
+	}
+	for idle := p.idle[name]; len(idle) != 0; idle = p.idle[name] {
+		s, p.idle[name] = idle[len(idle)-1], idle[:len(idle)-1]
+		if s.healthy() {
+			p.mu.Unlock()
+			return s, nil
+		}
+		s.close()
+	}
+	p.mu.Unlock()
+	return open()
+}
+
+// put gives back a borrowed service, which is closed unless it is kept for the next call
+func (p *servicePool) put(s *service) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed || !s.healthy() || len(p.idle[s.name]) >= maxIdleServices {
+		s.close()
+		return
+	}
+	if p.idle == nil {
+		p.idle = make(map[string][]*service)
+	}
+	p.idle[s.name] = append(p.idle[s.name], s)
+}
+
+// close closes the services kept, the borrowed ones are closed as they are put back
+func (p *servicePool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for _, s := range p.shared {
+		s.close()
+	}
+	for _, idle := range p.idle {
+		for _, s := range idle {
+			s.close()
+		}
+	}
+	p.shared, p.idle = nil, nil
+}
+
+// startServiceContext starts a service over a lockdownd connection of its own,
+// with start given the lockdown to start it through
+func (d *device) startServiceContext(ctx context.Context, name string, start func(lockdown *lockdown) (interface{}, error)) (s *service, err error) {
+	var lockdown *lockdown
+	if lockdown, err = d.newLockdownContext(ctx); err != nil {
+		return nil, err
+	}
+	defer lockdown.close()
+
+	s = &service{name: name}
+	s.svc, err = start(lockdown)
+	s.conns = lockdown.started
+	if err != nil {
+		s.close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// shareService the service shared by everyone, see servicePool.share
+func (d *device) shareService(ctx context.Context, name string, start func(lockdown *lockdown) (interface{}, error)) (svc interface{}, err error) {
+	var s *service
+	if s, err = d.services.share(name, func() (*service, error) {
+		return d.startServiceContext(ctx, name, start)
+	}); err != nil {
+		return nil, err
+	}
+	return s.svc, nil
+}
+
+// borrowService a service to use for a call, given back with d.services.put
+func (d *device) borrowService(ctx context.Context, name string, start func(lockdown *lockdown) (interface{}, error)) (s *service, err error) {
+	return d.services.borrow(name, func() (*service, error) {
+		return d.startServiceContext(ctx, name, start)
+	})
+}
+
+func (d *device) StartService(ctx context.Context, name string) (innerConn InnerConn, err error) {
+	var lockdown *lockdown
+	if lockdown, err = d.newLockdownContext(ctx); err != nil {
+		return nil, err
+	}
+	defer lockdown.close()
+	return lockdown._startService(name, nil)
+}
+
+func (d *device) Close() (err error) {
+	d.services.close()
+
+	d.mu.Lock()
+	perfd, tunnel := d.perfd, d.tunnel
+	syslogRelay, pcapd := d.syslogRelay, d.pcapd
+	d.perfd, d.tunnel, d.syslogRelay, d.pcapd = nil, nil, nil, nil
+	d.closed = true
+	d.mu.Unlock()
+
+	for _, p := range perfd {
+		p.Stop()
+	}
+	if syslogRelay != nil {
+		// the reading stops on the closed connection
+		syslogRelay.close()
+	}
+	if pcapd != nil {
+		pcapd.svc.(Pcapd).Stop()
+		pcapd.close()
+	}
+	if tunnel != nil {
+		err = tunnel.Close()
+	}
+	return
+}
@@ -0,0 +1,98 @@
+package giDevice
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/idevicetest"
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/libimobiledevice"
+)
+
+func Test_device_simulated_Services(t *testing.T) {
+	setupSimulatedDevice(t)
+	var opened int32
+	simDev.Handle(libimobiledevice.SpringBoardServiceName, func(conn net.Conn) {
+		atomic.AddInt32(&opened, 1)
+		for {
+			var req map[string]interface{}
+			if err := idevicetest.ReadMessage(conn, &req); err != nil {
+				return
+			}
+			_ = idevicetest.WriteMessage(conn, map[string]interface{}{"interfaceOrientation": 1})
+		}
+	})
+
+	const workers = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				if _, err := dev.GetInterfaceOrientation(); err != nil {
+					errs <- err
+					return
+				}
+				if _, err := dev.GetValue("", "ProductVersion"); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	if n := len(dev.(*device).services.idle[libimobiledevice.SpringBoardServiceName]); n == 0 || n > maxIdleServices {
+		t.Fatalf("%d idle connections kept", n)
+	}
+	// one after the other, the kept connections do
+	before := atomic.LoadInt32(&opened)
+	for j := 0; j < 5; j++ {
+		if _, err := dev.GetInterfaceOrientation(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt32(&opened); n != before {
+		t.Fatalf("%d connections opened for calls one after the other", n-before)
+	}
+
+	// the shared service is started again once its connection fails
+	springBoard, err := dev.(*device).springBoardService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := dev.(*device).springBoardService(); again != springBoard {
+		t.Fatal("shared service not kept")
+	}
+	dev.(*device).services.shared[libimobiledevice.SpringBoardServiceName].conns[0].Close()
+	if again, err := dev.(*device).springBoardService(); err != nil || again == springBoard {
+		t.Fatalf("broken shared service kept: %v", err)
+	}
+
+	innerConn, err := dev.StartService(context.Background(), libimobiledevice.SpringBoardServiceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = newSpringBoard(libimobiledevice.NewSpringBoardClient(innerConn)).GetInterfaceOrientation(); err != nil {
+		t.Fatal(err)
+	}
+	innerConn.Close()
+
+	if err = dev.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = dev.GetInterfaceOrientation(); !errors.Is(err, ErrDeviceClosed) {
+		t.Fatalf("expected ErrDeviceClosed, got %v", err)
+	}
+	if _, err = dev.GetValue("", "ProductVersion"); !errors.Is(err, ErrDeviceClosed) {
+		t.Fatalf("expected ErrDeviceClosed, got %v", err)
+	}
+}
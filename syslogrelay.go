@@ -2,8 +2,10 @@ package giDevice
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"strings"
 
 	"github.com/SonicCloudOrg/sonic-gidevice/pkg/libimobiledevice"
@@ -45,7 +47,7 @@ func (r *syslogRelay) Lines() <-chan string {
 			default:
 				bs, err := r.readLine()
 				if err != nil {
-					if strings.Contains(err.Error(), io.EOF.Error()) {
+					if strings.Contains(err.Error(), io.EOF.Error()) || errors.Is(err, net.ErrClosed) {
 						return
 					}
 					debugLog(fmt.Sprintf("syslog: %s", err))
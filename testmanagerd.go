@@ -1,6 +1,7 @@
 package giDevice
 
 import (
+	"context"
 	"github.com/SonicCloudOrg/sonic-gidevice/pkg/libimobiledevice"
 )
 
@@ -18,8 +19,8 @@ type testmanagerd struct {
 	iOSVersion []int
 }
 
-func (t *testmanagerd) notifyOfPublishedCapabilities() (err error) {
-	_, err = t.client.Connection()
+func (t *testmanagerd) notifyOfPublishedCapabilities(ctx context.Context) (err error) {
+	_, err = t.client.ConnectionContext(ctx)
 	return
 }
 
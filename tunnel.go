@@ -0,0 +1,104 @@
+package giDevice
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/libimobiledevice"
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/tunnel"
+)
+
+var _ Tunnel = (*deviceTunnel)(nil)
+
+type deviceTunnel struct {
+	innerConn InnerConn
+	stack     *tunnel.Stack
+	address   net.IP
+	rsdPort   int
+}
+
+func (d *device) StartTunnel(ctx context.Context) (t Tunnel, err error) {
+	var innerConn InnerConn
+	if innerConn, err = d.StartService(ctx, libimobiledevice.CoreDeviceProxyServiceName); err != nil {
+		return nil, err
+	}
+
+	// the IPv6 packets go right over the TLS connection
+	raw := innerConn.RawConn()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = raw.SetDeadline(deadline)
+	}
+	release := innerConn.BindContext(ctx)
+	params, err := tunnel.Handshake(raw, tunnel.DefaultMTU)
+	release()
+	if err != nil {
+		innerConn.Close()
+		if interrupted(ctx, err) {
+			return nil, fmt.Errorf("tunnel handshake: %w", ctx.Err())
+		}
+		return nil, fmt.Errorf("tunnel handshake: %w", err)
+	}
+	_ = raw.SetDeadline(time.Time{})
+
+	dt := &deviceTunnel{
+		innerConn: innerConn,
+		stack:     tunnel.NewStack(raw, net.ParseIP(params.Address), params.MTU),
+		address:   net.ParseIP(params.ServerAddress),
+		rsdPort:   params.ServerRSDPort,
+	}
+	d.mu.Lock()
+	prev := d.tunnel
+	d.tunnel = dt
+	d.mu.Unlock()
+	if err = d.UseRemoteServiceDiscovery(ctx, net.JoinHostPort(params.ServerAddress, strconv.Itoa(params.ServerRSDPort))); err != nil {
+		d.mu.Lock()
+		d.tunnel = prev
+		d.mu.Unlock()
+		_ = dt.Close()
+		return nil, err
+	}
+	return dt, nil
+}
+
+// dialRemote connects to addr, through the tunnel when addr is at its end
+func (d *device) dialRemote(ctx context.Context, addr string) (innerConn InnerConn, err error) {
+	d.mu.Lock()
+	t := d.tunnel
+	d.mu.Unlock()
+	if t == nil {
+		return libimobiledevice.NewNetworkConnContext(ctx, addr)
+	}
+	if host, _, _ := net.SplitHostPort(addr); !t.address.Equal(net.ParseIP(host)) {
+		return libimobiledevice.NewNetworkConnContext(ctx, addr)
+	}
+	var conn net.Conn
+	if conn, err = t.DialContext(ctx, "tcp", addr); err != nil {
+		return nil, fmt.Errorf("tunnel connect: %w", err)
+	}
+	return libimobiledevice.NewInnerConn(conn), nil
+}
+
+func (t *deviceTunnel) Address() net.IP {
+	return t.address
+}
+
+func (t *deviceTunnel) RSDPort() int {
+	return t.rsdPort
+}
+
+func (t *deviceTunnel) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return t.stack.DialContext(ctx, network, addr)
+}
+
+func (t *deviceTunnel) Dial(port int) (net.Conn, error) {
+	return t.stack.Dial("tcp", net.JoinHostPort(t.address.String(), strconv.Itoa(port)))
+}
+
+func (t *deviceTunnel) Close() error {
+	err := t.stack.Close()
+	t.innerConn.Close()
+	return err
+}
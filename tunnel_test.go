@@ -0,0 +1,55 @@
+package giDevice
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/idevicetest"
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/libimobiledevice"
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/remotexpc"
+)
+
+func Test_device_simulated_Tunnel(t *testing.T) {
+	setupSimulatedDevice(t)
+	simDev.Handle(libimobiledevice.InstallationProxyServiceName+remotexpc.ShimSuffix, func(conn net.Conn) {
+		var req map[string]interface{}
+		if err := idevicetest.ReadMessage(conn, &req); err != nil {
+			return
+		}
+		_ = idevicetest.WriteMessage(conn, map[string]interface{}{
+			"Status":      "Complete",
+			"CurrentList": []interface{}{map[string]interface{}{"CFBundleIdentifier": "com.example.app"}},
+		})
+	})
+	simDev.HandleTLS(libimobiledevice.CoreDeviceProxyServiceName, simDev.CoreDeviceProxyHandler())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	tun, err := dev.StartTunnel(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tun.Close()
+	if !tun.Address().Equal(net.ParseIP("fd00::1")) || tun.RSDPort() != remotexpc.RSDPort {
+		t.Fatalf("tunnel to [%s]:%d", tun.Address(), tun.RSDPort())
+	}
+
+	list, err := dev.InstallationProxyBrowse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].(map[string]interface{})["CFBundleIdentifier"] != "com.example.app" {
+		t.Fatalf("list: %v", list)
+	}
+
+	conn, err := tun.Dial(remotexpc.RSDPort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err = remotexpc.DiscoverConn(ctx, conn); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -2,6 +2,10 @@ package giDevice
 
 import (
 	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"time"
 
 	"github.com/SonicCloudOrg/sonic-gidevice/pkg/libimobiledevice"
 )
@@ -20,24 +24,89 @@ func newUsbmux(client *libimobiledevice.UsbmuxClient) *usbmux {
 	return &usbmux{client: client}
 }
 
-type usbmux struct {
-	client *libimobiledevice.UsbmuxClient
+// NewRemoteUsbmux talks to the UsbmuxServer at addr, its devices work as if attached here
+func NewRemoteUsbmux(addr string, opts ...RemoteUsbmuxOption) (Usbmux, error) {
+	opt := defaultRemoteUsbmuxOption()
+	for _, fn := range opts {
+		fn(opt)
+	}
+	dial := func(ctx context.Context, timeout ...time.Duration) (*libimobiledevice.UsbmuxClient, error) {
+		return dialRemoteUsbmux(ctx, addr, opt, timeout...)
+	}
+	umClient, err := dial(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return &usbmux{client: umClient, dial: dial}, nil
 }
 
-func (um *usbmux) Devices() (devices []Device, err error) {
+func dialRemoteUsbmux(ctx context.Context, addr string, opt *remoteUsbmuxOption, timeout ...time.Duration) (client *libimobiledevice.UsbmuxClient, err error) {
+	if len(timeout) == 0 {
+		timeout = []time.Duration{opt.timeout}
+	}
+	var conn net.Conn
+	if conn, err = (&net.Dialer{Timeout: opt.timeout}).DialContext(ctx, "tcp", addr); err != nil {
+		return nil, fmt.Errorf("remote usbmux connect: %w", err)
+	}
+	if opt.tlsConfig != nil {
+		config := opt.tlsConfig
+		// as tls.Dial does
+		if config.ServerName == "" {
+			config = config.Clone()
+			config.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+		tlsConn := tls.Client(conn, config)
+		_ = conn.SetDeadline(time.Now().Add(opt.timeout))
+		if err = tlsConn.Handshake(); err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("remote usbmux connect: %w", err)
+		}
+		_ = conn.SetDeadline(time.Time{})
+		conn = tlsConn
+	}
+	client = libimobiledevice.NewUsbmuxClientConn(conn, timeout[0])
+	if opt.token == "" {
+		return client, nil
+	}
+
+	release := client.InnerConn().BindContext(ctx)
+	defer release()
 	var pkt libimobiledevice.Packet
-	if pkt, err = um.client.NewPlistPacket(
-		um.client.NewBasicRequest(libimobiledevice.MessageTypeDeviceList),
-	); err != nil {
+	if pkt, err = client.NewPlistPacket(&libimobiledevice.AuthenticateRequest{
+		BasicRequest: *client.NewBasicRequest(libimobiledevice.MessageTypeAuthenticate),
+		Token:        opt.token,
+	}); err != nil {
+		client.Close()
 		return nil, err
 	}
-
-	if err = um.client.SendPacket(pkt); err != nil {
+	if err = client.SendPacket(pkt); err != nil {
+		client.Close()
 		return nil, err
 	}
+	if _, err = client.ReceivePacket(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("remote usbmux authenticate: %w", err)
+	}
+	return client, nil
+}
+
+type usbmux struct {
+	client *libimobiledevice.UsbmuxClient
+	// dial connects to usbmuxd when it is not the local one, see NewRemoteUsbmux
+	dial func(ctx context.Context, timeout ...time.Duration) (*libimobiledevice.UsbmuxClient, error)
+}
+
+func (um *usbmux) newDevice(client *libimobiledevice.UsbmuxClient, properties DeviceProperties) *device {
+	dev := newDevice(client, properties)
+	dev.dialUsbmux = um.dial
+	return dev
+}
 
+func (um *usbmux) Devices() (devices []Device, err error) {
 	var respPkt libimobiledevice.Packet
-	if respPkt, err = um.client.ReceivePacket(); err != nil {
+	if respPkt, err = um.client.Exchange(
+		um.client.NewBasicRequest(libimobiledevice.MessageTypeDeviceList),
+	); err != nil {
 		return nil, err
 	}
 
@@ -51,25 +120,16 @@ func (um *usbmux) Devices() (devices []Device, err error) {
 	devices = make([]Device, len(reply.DeviceList))
 	for i := range reply.DeviceList {
 		dev := reply.DeviceList[i]
-		devices[i] = newDevice(um.client, dev.Properties)
+		devices[i] = um.newDevice(um.client, dev.Properties)
 	}
 
 	return
 }
 
 func (um *usbmux) ReadBUID() (buid string, err error) {
-	var pktReadBUID libimobiledevice.Packet
-	if pktReadBUID, err = um.client.NewPlistPacket(
+	respPkt, err := um.client.Exchange(
 		um.client.NewBasicRequest(libimobiledevice.MessageTypeReadBUID),
-	); err != nil {
-		return "", err
-	}
-
-	if err = um.client.SendPacket(pktReadBUID); err != nil {
-		return "", err
-	}
-
-	respPkt, err := um.client.ReceivePacket()
+	)
 	if err != nil {
 		return "", err
 	}
@@ -102,11 +162,17 @@ func (um *usbmux) Listen(devNotifier chan Device) (context.CancelFunc, error) {
 				if baseDev.MessageType != libimobiledevice.MessageTypeDeviceAdd {
 					baseDev.Properties.DeviceID = baseDev.DeviceID
 				}
-				client, err := libimobiledevice.NewUsbmuxClient("")
+				var client *libimobiledevice.UsbmuxClient
+				var err error
+				if um.dial != nil {
+					client, err = um.dial(context.Background())
+				} else {
+					client, err = libimobiledevice.NewUsbmuxClient("")
+				}
 				if err != nil {
 					continue
 				}
-				devNotifier <- newDevice(client, baseDev.Properties)
+				devNotifier <- um.newDevice(client, baseDev.Properties)
 			}
 		}
 	}(ctx)
@@ -134,8 +200,8 @@ func (um *usbmux) listen(devNotifier chan libimobiledevice.BaseDevice) (ctx cont
 			case <-ctx.Done():
 				return
 			default:
-				var respPkt libimobiledevice.Packet
-				if respPkt, err = um.client.ReceivePacket(); err != nil {
+				respPkt, err := um.client.ReceivePacket()
+				if err != nil {
 					return
 				}
 
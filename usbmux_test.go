@@ -1,9 +1,12 @@
 package giDevice
 
 import (
-	"github.com/SonicCloudOrg/sonic-gidevice/pkg/libimobiledevice"
+	"os"
 	"testing"
 	"time"
+
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/idevicetest"
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/libimobiledevice"
 )
 
 var um Usbmux
@@ -16,6 +19,39 @@ func setupUsbmux(t *testing.T) {
 	}
 }
 
+var (
+	simSrv *idevicetest.Server
+	simDev *idevicetest.Device
+)
+
+// setupSimulator points usbmux at an in-process usbmuxd with one unpaired device
+func setupSimulator(t *testing.T) {
+	var err error
+	if simSrv, err = idevicetest.NewServer(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(simSrv.Close)
+
+	if simDev, err = idevicetest.NewDevice("00008030-001A2B3C4D5E6F70"); err != nil {
+		t.Fatal(err)
+	}
+	simSrv.AddDevice(simDev)
+
+	prev, ok := os.LookupEnv(libimobiledevice.UsbmuxdSocketAddressEnv)
+	if err = os.Setenv(libimobiledevice.UsbmuxdSocketAddressEnv, simSrv.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if ok {
+			_ = os.Setenv(libimobiledevice.UsbmuxdSocketAddressEnv, prev)
+		} else {
+			_ = os.Unsetenv(libimobiledevice.UsbmuxdSocketAddressEnv)
+		}
+	})
+
+	setupUsbmux(t)
+}
+
 func Test_usbmux_Devices(t *testing.T) {
 	setupUsbmux(t)
 
@@ -65,3 +101,43 @@ func Test_usbmux_Listen(t *testing.T) {
 	time.Sleep(5 * time.Second)
 	t.Log("Done")
 }
+
+func Test_usbmux_simulated_Devices(t *testing.T) {
+	setupSimulator(t)
+
+	devices, err := um.Devices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 1 || devices[0].Properties().UDID != simDev.UDID {
+		t.Fatalf("devices: %v", devices)
+	}
+
+	buid, err := um.ReadBUID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buid != simSrv.BUID() {
+		t.Fatalf("buid: %s, want %s", buid, simSrv.BUID())
+	}
+}
+
+func Test_usbmux_simulated_Listen(t *testing.T) {
+	setupSimulator(t)
+
+	devNotifier := make(chan Device)
+	cancelFunc, err := um.Listen(devNotifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancelFunc()
+
+	if d := <-devNotifier; d.Properties().UDID != simDev.UDID {
+		t.Fatalf("attached: %#v", d.Properties())
+	}
+
+	simSrv.RemoveDevice(simDev)
+	if d := <-devNotifier; d.Properties().ConnectionType != "" || d.Properties().DeviceID != simDev.DeviceID() {
+		t.Fatalf("detached: %#v", d.Properties())
+	}
+}
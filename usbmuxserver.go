@@ -0,0 +1,461 @@
+package giDevice
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/libimobiledevice"
+)
+
+const usbmuxServerHandshakeTimeout = 10 * time.Second
+
+var _ UsbmuxServer = (*usbmuxServer)(nil)
+
+type usbmuxServer struct {
+	// first in the struct for 64-bit atomic access on 32-bit platforms
+	clients, accepted, rejected, connections, sent, received int64
+
+	opt *usbmuxServerOption
+}
+
+// NewUsbmuxServer shares the devices of usbmuxd: its clients speak the usbmuxd
+// protocol, NewRemoteUsbmux connects to it. Every client is served on its own.
+// Tokens and certificates need WithUsbmuxServerTLS.
+func NewUsbmuxServer(opts ...UsbmuxServerOption) (UsbmuxServer, error) {
+	opt := defaultUsbmuxServerOption()
+	for _, fn := range opts {
+		fn(opt)
+	}
+	if opt.tlsConfig == nil && len(opt.tokens) != 0 {
+		return nil, errors.New("usbmux server: tokens without TLS would go in cleartext")
+	}
+	if opt.tlsConfig == nil && len(opt.certificates) != 0 {
+		return nil, errors.New("usbmux server: certificates without TLS")
+	}
+	if opt.tlsConfig != nil && len(opt.certificates) != 0 {
+		opt.tlsConfig = opt.tlsConfig.Clone()
+		opt.tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		if len(opt.tokens) != 0 {
+			// the clients presenting a token have no certificate
+			opt.tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+	return &usbmuxServer{opt: opt}, nil
+}
+
+func (s *usbmuxServer) ListenAndServe(ctx context.Context, addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("usbmux server: %w", err)
+	}
+	return s.Serve(ctx, ln)
+}
+
+func (s *usbmuxServer) Serve(ctx context.Context, ln net.Listener) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu    sync.Mutex
+		conns = make(map[net.Conn]struct{})
+		wg    sync.WaitGroup
+	)
+	go func() {
+		<-ctx.Done()
+		_ = ln.Close()
+		mu.Lock()
+		for conn := range conns {
+			_ = conn.Close()
+		}
+		mu.Unlock()
+	}()
+
+	var err error
+	for {
+		var conn net.Conn
+		if conn, err = ln.Accept(); err != nil {
+			break
+		}
+		mu.Lock()
+		if ctx.Err() != nil {
+			mu.Unlock()
+			_ = conn.Close()
+			break
+		}
+		conns[conn] = struct{}{}
+		mu.Unlock()
+
+		atomic.AddInt64(&s.accepted, 1)
+		atomic.AddInt64(&s.clients, 1)
+		wg.Add(1)
+		go func() {
+			defer func() {
+				mu.Lock()
+				delete(conns, conn)
+				mu.Unlock()
+				_ = conn.Close()
+				atomic.AddInt64(&s.clients, -1)
+				wg.Done()
+			}()
+			if err := s.serveClient(ctx, conn); err != nil && ctx.Err() == nil && debugFlag {
+				log.Printf("usbmux server: %s: %s", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+
+	cancel()
+	wg.Wait()
+	if ctx.Err() != nil && errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return fmt.Errorf("usbmux server: %w", err)
+}
+
+func (s *usbmuxServer) Stats() UsbmuxServerStats {
+	return UsbmuxServerStats{
+		Clients:       int(atomic.LoadInt64(&s.clients)),
+		Accepted:      atomic.LoadInt64(&s.accepted),
+		Rejected:      atomic.LoadInt64(&s.rejected),
+		Connections:   int(atomic.LoadInt64(&s.connections)),
+		BytesSent:     atomic.LoadInt64(&s.sent),
+		BytesReceived: atomic.LoadInt64(&s.received),
+	}
+}
+
+// usbmuxAccess the devices a client may see
+type usbmuxAccess struct {
+	granted bool
+	// udids nil for every device
+	udids map[string]bool
+}
+
+func newUsbmuxAccess(udids []string) *usbmuxAccess {
+	a := &usbmuxAccess{granted: true}
+	if len(udids) != 0 {
+		a.udids = make(map[string]bool, len(udids))
+		for _, udid := range udids {
+			a.udids[udid] = true
+		}
+	}
+	return a
+}
+
+func (a *usbmuxAccess) allowed(udid string) bool {
+	return a.granted && (a.udids == nil || a.udids[udid])
+}
+
+func (s *usbmuxServer) serveClient(ctx context.Context, conn net.Conn) (err error) {
+	access := &usbmuxAccess{}
+	if len(s.opt.tokens) == 0 && len(s.opt.certificates) == 0 {
+		access = newUsbmuxAccess(nil)
+	}
+	if s.opt.tlsConfig != nil {
+		tlsConn := tls.Server(conn, s.opt.tlsConfig)
+		_ = conn.SetDeadline(time.Now().Add(usbmuxServerHandshakeTimeout))
+		if err = tlsConn.Handshake(); err != nil {
+			atomic.AddInt64(&s.rejected, 1)
+			return err
+		}
+		_ = conn.SetDeadline(time.Time{})
+		conn = tlsConn
+
+		if state := tlsConn.ConnectionState(); len(state.VerifiedChains) != 0 {
+			if udids, ok := s.opt.certificates[state.PeerCertificates[0].Subject.CommonName]; ok {
+				access = newUsbmuxAccess(udids)
+			}
+		}
+	}
+	if !access.granted {
+		// a client has as long to authenticate as to handshake
+		_ = conn.SetReadDeadline(time.Now().Add(usbmuxServerHandshakeTimeout))
+	}
+
+	serverConn := libimobiledevice.NewUsbmuxServerConn(conn)
+	for {
+		tag, pkt, err := serverConn.ReceiveRequest()
+		if err != nil {
+			return err
+		}
+		var req struct {
+			MessageType  libimobiledevice.MessageType `plist:"MessageType"`
+			DeviceID     int                          `plist:"DeviceID"`
+			PairRecordID string                       `plist:"PairRecordID"`
+			Token        string                       `plist:"Token"`
+		}
+		if err = pkt.Unmarshal(&req); err != nil {
+			return err
+		}
+
+		if req.MessageType == libimobiledevice.MessageTypeAuthenticate {
+			udids, ok := s.token(req.Token)
+			if !ok {
+				atomic.AddInt64(&s.rejected, 1)
+				_ = serverConn.SendResult(tag, libimobiledevice.ReplyCodePermissionDenied)
+				return errors.New("bad token")
+			}
+			access = newUsbmuxAccess(udids)
+			_ = conn.SetReadDeadline(time.Time{})
+			if err = serverConn.SendResult(tag, libimobiledevice.ReplyCodeOK); err != nil {
+				return err
+			}
+			continue
+		}
+		if !access.granted {
+			atomic.AddInt64(&s.rejected, 1)
+			_ = serverConn.SendResult(tag, libimobiledevice.ReplyCodePermissionDenied)
+			return errors.New("not authenticated")
+		}
+
+		switch req.MessageType {
+		case libimobiledevice.MessageTypeDeviceList:
+			err = s.listDevices(ctx, serverConn, tag, access)
+		case libimobiledevice.MessageTypeListen:
+			return s.listen(ctx, serverConn, tag, access)
+		case libimobiledevice.MessageTypeConnect:
+			return s.connect(ctx, conn, serverConn, tag, pkt, req.DeviceID, access)
+		case libimobiledevice.MessageTypeReadPairRecord,
+			libimobiledevice.MessageTypeSavePairRecord,
+			libimobiledevice.MessageTypeDeletePairRecord:
+			if !access.allowed(req.PairRecordID) {
+				err = serverConn.SendResult(tag, libimobiledevice.ReplyCodeBadDevice)
+				break
+			}
+			err = s.relay(ctx, serverConn, tag, pkt)
+		case libimobiledevice.MessageTypeReadBUID:
+			err = s.relay(ctx, serverConn, tag, pkt)
+		default:
+			err = serverConn.SendResult(tag, libimobiledevice.ReplyCodeBadCommand)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// token the udids token may see, compared in constant time not to tell how
+// close a guess is
+func (s *usbmuxServer) token(token string) (udids []string, ok bool) {
+	for known, knownUDIDs := range s.opt.tokens {
+		if subtle.ConstantTimeCompare([]byte(known), []byte(token)) == 1 {
+			udids, ok = knownUDIDs, true
+		}
+	}
+	return
+}
+
+func (s *usbmuxServer) usbmuxd(ctx context.Context) (*libimobiledevice.UsbmuxClient, error) {
+	return libimobiledevice.NewUsbmuxClientContext(ctx, s.opt.usbmuxdAddr)
+}
+
+// request sends req to usbmuxd on a connection of its own
+func (s *usbmuxServer) request(ctx context.Context, req interface{}) (client *libimobiledevice.UsbmuxClient, resp libimobiledevice.Packet, err error) {
+	if client, err = s.usbmuxd(ctx); err != nil {
+		return nil, nil, err
+	}
+	release := client.InnerConn().BindContext(ctx)
+	defer release()
+	var pkt libimobiledevice.Packet
+	if pkt, err = client.NewPlistPacket(req); err == nil {
+		if err = client.SendPacket(pkt); err == nil {
+			resp, err = client.ReceivePacket()
+		}
+	}
+	if err != nil {
+		client.Close()
+		return nil, nil, err
+	}
+	return client, resp, nil
+}
+
+// replyError answers with the code usbmuxd refused with, other errors end the client
+func replyError(serverConn *libimobiledevice.UsbmuxServerConn, tag uint32, err error) error {
+	var code libimobiledevice.ReplyCode
+	if errors.As(err, &code) {
+		return serverConn.SendResult(tag, code)
+	}
+	return err
+}
+
+// relay passes the request on to usbmuxd, and its reply back
+func (s *usbmuxServer) relay(ctx context.Context, serverConn *libimobiledevice.UsbmuxServerConn, tag uint32, pkt libimobiledevice.Packet) error {
+	var req map[string]interface{}
+	if err := pkt.Unmarshal(&req); err != nil {
+		return err
+	}
+	client, resp, err := s.request(ctx, req)
+	if err != nil {
+		return replyError(serverConn, tag, err)
+	}
+	client.Close()
+	var reply map[string]interface{}
+	if err = resp.Unmarshal(&reply); err != nil {
+		return err
+	}
+	return serverConn.Send(tag, reply)
+}
+
+func (s *usbmuxServer) deviceList(ctx context.Context) (list []map[string]interface{}, err error) {
+	client, resp, err := s.request(ctx, libimobiledevice.BasicRequest{
+		MessageType:         libimobiledevice.MessageTypeDeviceList,
+		BundleID:            libimobiledevice.BundleID,
+		ProgramName:         libimobiledevice.ProgramName,
+		ClientVersionString: libimobiledevice.ClientVersion,
+		LibUSBMuxVersion:    libimobiledevice.LibUSBMuxVersion,
+	})
+	if err != nil {
+		return nil, err
+	}
+	client.Close()
+	var reply struct {
+		DeviceList []map[string]interface{} `plist:"DeviceList"`
+	}
+	if err = resp.Unmarshal(&reply); err != nil {
+		return nil, err
+	}
+	return reply.DeviceList, nil
+}
+
+// attachedUDID the serial number of an `Attached` message or `DeviceList` entry
+func attachedUDID(msg map[string]interface{}) string {
+	properties, _ := msg["Properties"].(map[string]interface{})
+	udid, _ := properties["SerialNumber"].(string)
+	return udid
+}
+
+func messageDeviceID(msg map[string]interface{}) int {
+	switch id := msg["DeviceID"].(type) {
+	case uint64:
+		return int(id)
+	case int64:
+		return int(id)
+	}
+	return -1
+}
+
+func (s *usbmuxServer) listDevices(ctx context.Context, serverConn *libimobiledevice.UsbmuxServerConn, tag uint32, access *usbmuxAccess) error {
+	list, err := s.deviceList(ctx)
+	if err != nil {
+		return replyError(serverConn, tag, err)
+	}
+	allowed := make([]interface{}, 0, len(list))
+	for _, dev := range list {
+		if access.allowed(attachedUDID(dev)) {
+			allowed = append(allowed, dev)
+		}
+	}
+	return serverConn.Send(tag, map[string]interface{}{"DeviceList": allowed})
+}
+
+func (s *usbmuxServer) listen(ctx context.Context, serverConn *libimobiledevice.UsbmuxServerConn, tag uint32, access *usbmuxAccess) error {
+	client, _, err := s.request(ctx, libimobiledevice.BasicRequest{
+		MessageType:         libimobiledevice.MessageTypeListen,
+		BundleID:            libimobiledevice.BundleID,
+		ProgramName:         libimobiledevice.ProgramName,
+		ClientVersionString: libimobiledevice.ClientVersion,
+		LibUSBMuxVersion:    libimobiledevice.LibUSBMuxVersion,
+	})
+	if err != nil {
+		return replyError(serverConn, tag, err)
+	}
+	defer client.Close()
+	if err = serverConn.SendResult(tag, libimobiledevice.ReplyCodeOK); err != nil {
+		return err
+	}
+
+	client.InnerConn().Timeout(0)
+	release := client.InnerConn().BindContext(ctx)
+	defer release()
+	// `Detached` and `Paired` only carry the DeviceID of an earlier `Attached`
+	attached := make(map[int]bool)
+	for {
+		var pkt libimobiledevice.Packet
+		if pkt, err = client.ReceivePacket(); err != nil {
+			return err
+		}
+		var msg map[string]interface{}
+		if err = pkt.Unmarshal(&msg); err != nil {
+			return err
+		}
+		id := messageDeviceID(msg)
+		if msg["MessageType"] == string(libimobiledevice.MessageTypeDeviceAdd) {
+			if !access.allowed(attachedUDID(msg)) {
+				continue
+			}
+			attached[id] = true
+		} else if !attached[id] {
+			continue
+		}
+		if msg["MessageType"] == string(libimobiledevice.MessageTypeDeviceRemove) {
+			delete(attached, id)
+		}
+		if err = serverConn.Send(0, msg); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *usbmuxServer) connect(ctx context.Context, conn net.Conn, serverConn *libimobiledevice.UsbmuxServerConn,
+	tag uint32, pkt libimobiledevice.Packet, deviceID int, access *usbmuxAccess) error {
+	list, err := s.deviceList(ctx)
+	if err != nil {
+		return replyError(serverConn, tag, err)
+	}
+	found := false
+	for _, dev := range list {
+		if messageDeviceID(dev) == deviceID {
+			found = access.allowed(attachedUDID(dev))
+			break
+		}
+	}
+	if !found {
+		return serverConn.SendResult(tag, libimobiledevice.ReplyCodeBadDevice)
+	}
+
+	var req map[string]interface{}
+	if err = pkt.Unmarshal(&req); err != nil {
+		return err
+	}
+	client, _, err := s.request(ctx, req)
+	if err != nil {
+		return replyError(serverConn, tag, err)
+	}
+	defer client.Close()
+	if err = serverConn.SendResult(tag, libimobiledevice.ReplyCodeOK); err != nil {
+		return err
+	}
+
+	devConn := client.RawConn()
+	_ = devConn.SetDeadline(time.Time{})
+	atomic.AddInt64(&s.connections, 1)
+	defer atomic.AddInt64(&s.connections, -1)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = devConn.Close()
+		case <-stop:
+		}
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		pipe(devConn, conn, &s.sent)
+	}()
+	go func() {
+		defer wg.Done()
+		pipe(conn, devConn, &s.received)
+	}()
+	wg.Wait()
+	return nil
+}
@@ -0,0 +1,236 @@
+package giDevice
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/idevicetest"
+	"github.com/SonicCloudOrg/sonic-gidevice/pkg/libimobiledevice"
+)
+
+// testCA issues the certificates of the sharing server and its clients
+type testCA struct {
+	t    *testing.T
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	ca := &testCA{t: t, pool: x509.NewCertPool()}
+	var err error
+	if ca.key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &ca.key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ca.cert, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	ca.pool.AddCert(ca.cert)
+	return ca
+}
+
+func (ca *testCA) issue(commonName string, usage x509.ExtKeyUsage) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		ca.t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		ca.t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// serveUsbmux shares the simulator with a second device attached, until the test ends
+func serveUsbmux(t *testing.T, opts ...UsbmuxServerOption) (UsbmuxServer, string) {
+	other, err := idevicetest.NewDevice("00008101-000A1B2C3D4E5F60")
+	if err != nil {
+		t.Fatal(err)
+	}
+	simSrv.AddDevice(other)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	server, err := NewUsbmuxServer(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(ctx, ln) }()
+	t.Cleanup(func() {
+		cancel()
+		select {
+		case err := <-served:
+			if err != nil {
+				t.Error(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("still serving")
+		}
+	})
+	return server, ln.Addr().String()
+}
+
+func Test_usbmuxServer_Options(t *testing.T) {
+	if _, err := NewUsbmuxServer(WithUsbmuxServerToken("secret")); err == nil {
+		t.Fatal("token without TLS accepted")
+	}
+	if _, err := NewUsbmuxServer(WithUsbmuxServerCertificate("ci")); err == nil {
+		t.Fatal("certificate without TLS accepted")
+	}
+	if _, err := NewUsbmuxServer(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_usbmuxServer_simulated_Token(t *testing.T) {
+	setupSimulator(t)
+	ca := newTestCA(t)
+	server, addr := serveUsbmux(t,
+		WithUsbmuxServerTLS(&tls.Config{Certificates: []tls.Certificate{ca.issue("server", x509.ExtKeyUsageServerAuth)}}),
+		WithUsbmuxServerToken("secret", simDev.UDID),
+	)
+	clientTLS := &tls.Config{RootCAs: ca.pool}
+
+	if _, err := NewRemoteUsbmux(addr, WithRemoteUsbmuxTLS(clientTLS), WithRemoteUsbmuxToken("wrong")); !errors.Is(err, libimobiledevice.ReplyCodePermissionDenied) {
+		t.Fatalf("expected permission denied, got %v", err)
+	}
+	anonymous, err := NewRemoteUsbmux(addr, WithRemoteUsbmuxTLS(clientTLS))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = anonymous.Devices(); !errors.Is(err, libimobiledevice.ReplyCodePermissionDenied) {
+		t.Fatalf("expected permission denied, got %v", err)
+	}
+
+	remote, err := NewRemoteUsbmux(addr, WithRemoteUsbmuxTLS(clientTLS), WithRemoteUsbmuxToken("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices, err := remote.Devices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the other device is not for this token
+	if len(devices) != 1 || devices[0].Properties().SerialNumber != simDev.UDID {
+		t.Fatalf("devices: %v", devices)
+	}
+
+	// pairing, lockdownd and its TLS all through the server
+	v, err := devices[0].GetValue("", "ProductVersion")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != "16.0" {
+		t.Fatalf("ProductVersion: %v", v)
+	}
+
+	stats := server.Stats()
+	if stats.Rejected != 2 || stats.BytesSent == 0 || stats.BytesReceived == 0 {
+		t.Fatalf("stats: %+v", stats)
+	}
+}
+
+func Test_usbmuxServer_simulated_Certificate(t *testing.T) {
+	setupSimulator(t)
+	ca := newTestCA(t)
+	_, addr := serveUsbmux(t,
+		WithUsbmuxServerTLS(&tls.Config{
+			Certificates: []tls.Certificate{ca.issue("server", x509.ExtKeyUsageServerAuth)},
+			ClientCAs:    ca.pool,
+		}),
+		WithUsbmuxServerCertificate("ci"),
+	)
+
+	remote, err := NewRemoteUsbmux(addr, WithRemoteUsbmuxTLS(&tls.Config{
+		RootCAs:      ca.pool,
+		Certificates: []tls.Certificate{ca.issue("ci", x509.ExtKeyUsageClientAuth)},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices, err := remote.Devices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 2 {
+		t.Fatalf("devices: %v", devices)
+	}
+
+	listener, err := NewRemoteUsbmux(addr, WithRemoteUsbmuxTLS(&tls.Config{
+		RootCAs:      ca.pool,
+		Certificates: []tls.Certificate{ca.issue("ci", x509.ExtKeyUsageClientAuth)},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	attached := make(chan Device)
+	cancel, err := listener.Listen(attached)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+	for i := 0; i < 2; i++ {
+		select {
+		case <-attached:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%d devices attached", i)
+		}
+	}
+
+	// a certificate of the CA, but not let in
+	other, err := NewRemoteUsbmux(addr, WithRemoteUsbmuxTLS(&tls.Config{
+		RootCAs:      ca.pool,
+		Certificates: []tls.Certificate{ca.issue("someone", x509.ExtKeyUsageClientAuth)},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = other.Devices(); !errors.Is(err, libimobiledevice.ReplyCodePermissionDenied) {
+		t.Fatalf("expected permission denied, got %v", err)
+	}
+
+	// no certificate, turned away in the handshake
+	anonymous, err := NewRemoteUsbmux(addr, WithRemoteUsbmuxTLS(&tls.Config{RootCAs: ca.pool}))
+	if err == nil {
+		_, err = anonymous.Devices()
+	}
+	if err == nil || errors.Is(err, libimobiledevice.ReplyCodePermissionDenied) {
+		t.Fatalf("expected the handshake to fail, got %v", err)
+	}
+}
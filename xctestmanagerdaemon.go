@@ -1,7 +1,6 @@
 package giDevice
 
 import (
-	"fmt"
 	"github.com/SonicCloudOrg/sonic-gidevice/pkg/libimobiledevice"
 	"github.com/SonicCloudOrg/sonic-gidevice/pkg/nskeyedarchiver"
 )
@@ -34,8 +33,8 @@ func (d *xcTestManagerDaemon) initiateControlSession(XcodeVersion uint64) (err e
 	}
 
 	// Some device should ignore it.
-	// if nsErr, ok := ret.Obj.(libimobiledevice.NSError); ok {
-	// 	return fmt.Errorf("%s", nsErr.NSUserInfo.(map[string]interface{})["NSLocalizedDescription"])
+	// if err = ret.Err(); err != nil {
+	// 	return err
 	// }
 	return
 }
@@ -77,8 +76,8 @@ func (d *xcTestManagerDaemon) initiateSession(XcodeVersion uint64, nsUUID *nskey
 		return err
 	}
 
-	if nsErr, ok := ret.Obj.(libimobiledevice.NSError); ok {
-		return fmt.Errorf("%s", nsErr.NSUserInfo.(map[string]interface{})["NSLocalizedDescription"])
+	if err = ret.Err(); err != nil {
+		return err
 	}
 
 	return
@@ -97,8 +96,8 @@ func (d *xcTestManagerDaemon) authorizeTestSession(pid int) (err error) {
 		return err
 	}
 
-	if nsErr, ok := ret.Obj.(libimobiledevice.NSError); ok {
-		return fmt.Errorf("%s", nsErr.NSUserInfo.(map[string]interface{})["NSLocalizedDescription"])
+	if err = ret.Err(); err != nil {
+		return err
 	}
 	return
 }
@@ -116,8 +115,8 @@ func (d *xcTestManagerDaemon) initiateControlSessionForTestProcessID(pid int) (e
 		return err
 	}
 
-	if nsErr, ok := ret.Obj.(libimobiledevice.NSError); ok {
-		return fmt.Errorf("%s", nsErr.NSUserInfo.(map[string]interface{})["NSLocalizedDescription"])
+	if err = ret.Err(); err != nil {
+		return err
 	}
 	return
 }
@@ -138,8 +137,8 @@ func (d *xcTestManagerDaemon) initiateControlSessionForTestProcessIDProtocolVers
 		return err
 	}
 
-	if nsErr, ok := ret.Obj.(libimobiledevice.NSError); ok {
-		return fmt.Errorf("%s", nsErr.NSUserInfo.(map[string]interface{})["NSLocalizedDescription"])
+	if err = ret.Err(); err != nil {
+		return err
 	}
 	return
 }